@@ -0,0 +1,23 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package apierror
+
+import "net/http"
+
+/**
+General errors used across all of the packages.  Each package defines its own specific errors next to its code.
+*/
+var (
+	ErrMalformedRequest = New(http.StatusBadRequest, "malformed_request")
+	ErrRequestTooLarge  = New(http.StatusRequestEntityTooLarge, "request_too_large")
+	ErrUnsupportedMedia = New(http.StatusUnsupportedMediaType, "unsupported_media_type")
+	ErrValidation       = New(http.StatusUnprocessableEntity, "validation_failed")
+	ErrNotFound         = New(http.StatusNotFound, "not_found")
+	ErrUnauthorized     = New(http.StatusUnauthorized, "unauthorized")
+	ErrForbidden        = New(http.StatusForbidden, "forbidden")
+	ErrTooManyRequests  = New(http.StatusTooManyRequests, "rate_limited")
+	ErrPrecondition     = New(http.StatusPreconditionFailed, "precondition_failed")
+	ErrTimeout          = New(http.StatusGatewayTimeout, "timeout")
+	ErrInternal         = New(http.StatusInternalServerError, "internal_error")
+)
@@ -0,0 +1,151 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package apierror
+
+import (
+	"errors"
+	"net/http"
+)
+
+/**
+Define a typed error that carries a machine readable code and the http status it maps to
+*/
+type Error struct {
+	//The code clients can branch on, i.e. auth_malformed_token
+	Code string
+
+	//The http status used when the error is returned
+	Status int
+
+	//Optional human readable detail for this occurrence
+	Detail string
+
+	//Optional list of the fields that caused the error
+	Fields []FieldError
+
+	//The underlying cause, if any
+	cause error
+}
+
+/**
+Describe a problem with a single field in the request
+*/
+type FieldError struct {
+	//The path to the field, i.e. settings.view.darkMode
+	Field string `json:"field"`
+
+	//The rule that failed, i.e. required, email, min
+	Code string `json:"code"`
+
+	//Human readable message
+	Message string `json:"message"`
+}
+
+/**
+Create a new error with a code and status
+*/
+func New(status int, code string) *Error {
+	return &Error{
+		Code:   code,
+		Status: status,
+	}
+}
+
+/**
+Wrap an existing error with a code and status
+*/
+func Wrap(err error, status int, code string) *Error {
+	return &Error{
+		Code:   code,
+		Status: status,
+		cause:  err,
+	}
+}
+
+/**
+The error string is the code so existing clients that compare messages still work
+*/
+func (err *Error) Error() string {
+	if err.cause != nil {
+		return err.Code + ": " + err.cause.Error()
+	}
+	return err.Code
+}
+
+/**
+Allow errors.Is and errors.As to see the cause
+*/
+func (err *Error) Unwrap() error {
+	return err.cause
+}
+
+/**
+Two errors are the same if they share a code, so errors.Is works on copies and wrapped versions
+*/
+func (err *Error) Is(target error) bool {
+	targetErr, ok := target.(*Error)
+	return ok && targetErr.Code == err.Code
+}
+
+/**
+Return a copy of the error that wraps the cause
+*/
+func (err *Error) Wrap(cause error) *Error {
+	newErr := *err
+	newErr.cause = cause
+	return &newErr
+}
+
+/**
+Return a copy of the error with the detail set
+*/
+func (err *Error) WithDetail(detail string) *Error {
+	newErr := *err
+	newErr.Detail = detail
+	return &newErr
+}
+
+/**
+Return a copy of the error with the field errors set
+*/
+func (err *Error) WithFields(fields []FieldError) *Error {
+	newErr := *err
+	newErr.Fields = fields
+	return &newErr
+}
+
+/**
+The title is the standard status text
+*/
+func (err *Error) Title() string {
+	return http.StatusText(err.Status)
+}
+
+/**
+Convert any error into a typed error.  Errors that were never typed are treated as internal errors.
+*/
+func From(err error) *Error {
+	//If it is already typed use it
+	var typed *Error
+	if errors.As(err, &typed) {
+		return typed
+	}
+
+	return ErrInternal.Wrap(err)
+}
+
+/**
+Get the http status for any error
+*/
+func StatusOf(err error) int {
+	return From(err).Status
+}
+
+/**
+Check to see if the error has the code
+*/
+func HasCode(err error, code string) bool {
+	var typed *Error
+	return errors.As(err, &typed) && typed.Code == code
+}
@@ -0,0 +1,89 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package apierror_test
+
+import (
+	"errors"
+	"fmt"
+	"github.com/reaction-eng/restlib/apierror"
+	"net/http"
+	"testing"
+)
+
+/**
+Perform the testing
+*/
+func TestErrorIs(t *testing.T) {
+	errThing := apierror.New(http.StatusNotFound, "thing_not_found")
+	cause := errors.New("no rows")
+
+	//Define the list of errors we are testing against errThing
+	var tests = []struct {
+		err      error
+		expected bool
+	}{
+		{errThing, true},
+		{errThing.WithDetail("thing 3"), true},
+		{errThing.Wrap(cause), true},
+		{fmt.Errorf("getting the thing: %w", errThing), true},
+		{apierror.New(http.StatusGone, "thing_not_found"), true},
+		{apierror.New(http.StatusNotFound, "other_not_found"), false},
+		{cause, false},
+	}
+
+	for _, test := range tests {
+		if matched := errors.Is(test.err, errThing); matched != test.expected {
+			t.Errorf("recived %t for %v, expected %t", matched, test.err, test.expected)
+		}
+	}
+
+	//The cause can still be found
+	if !errors.Is(errThing.Wrap(cause), cause) {
+		t.Errorf("expected the cause to be found")
+	}
+
+	//Copies should not change the original
+	errThing.WithDetail("detail").WithFields([]apierror.FieldError{{Field: "name"}})
+	if len(errThing.Detail) != 0 || errThing.Fields != nil {
+		t.Errorf("recived %+v, expected the original to be unchanged", errThing)
+	}
+}
+
+/**
+Perform the testing
+*/
+func TestErrorStatus(t *testing.T) {
+	//Define the list of errors and the status they map to
+	var tests = []struct {
+		err      error
+		status   int
+		code     string
+		expected string
+	}{
+		{apierror.ErrNotFound, http.StatusNotFound, "not_found", "not_found"},
+		{apierror.ErrValidation.Wrap(errors.New("bad name")), http.StatusUnprocessableEntity, "validation_failed", "validation_failed: bad name"},
+		{fmt.Errorf("wrapped: %w", apierror.ErrForbidden), http.StatusForbidden, "forbidden", "wrapped: forbidden"},
+		{errors.New("database down"), http.StatusInternalServerError, "internal_error", "database down"},
+	}
+
+	for _, test := range tests {
+		if status := apierror.StatusOf(test.err); status != test.status {
+			t.Errorf("recived %d for %v, expected %d", status, test.err, test.status)
+		}
+		if !apierror.HasCode(apierror.From(test.err), test.code) {
+			t.Errorf("recived %s for %v, expected %s", apierror.From(test.err).Code, test.err, test.code)
+		}
+		if test.err.Error() != test.expected {
+			t.Errorf("recived %s, expected %s", test.err.Error(), test.expected)
+		}
+	}
+
+	//Untyped errors have no code
+	if apierror.HasCode(errors.New("internal_error"), "internal_error") {
+		t.Errorf("expected an untyped error to have no code")
+	}
+	if title := apierror.ErrTooManyRequests.Title(); title != "Too Many Requests" {
+		t.Errorf("recived %s, expected Too Many Requests", title)
+	}
+}
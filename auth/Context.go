@@ -0,0 +1,35 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package auth
+
+import "context"
+
+//Used to store the identity in the context
+type identityKey struct{}
+
+/**
+Get a copy of the context with the authenticated caller
+*/
+func WithIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+/**
+Get the authenticated caller.  Returns false if the auth middleware did not run or the route is public
+*/
+func Principal(ctx context.Context) (*Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(*Identity)
+	return identity, ok && identity != nil
+}
+
+/**
+Get the id of the authenticated user.  Returns false if there is no authenticated user
+*/
+func UserId(ctx context.Context) (int, bool) {
+	identity, ok := Principal(ctx)
+	if !ok {
+		return -1, false
+	}
+	return identity.UserId, true
+}
@@ -0,0 +1,60 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package auth_test
+
+import (
+	"context"
+	"github.com/reaction-eng/restlib/auth"
+	"testing"
+)
+
+/**
+Perform the testing
+*/
+func TestContext(t *testing.T) {
+	testCases := []struct {
+		ctx            context.Context
+		expectedUserId int
+		expectedOk     bool
+	}{
+		{context.Background(), -1, false},
+		{context.WithValue(context.Background(), "user", 10), -1, false},
+		{auth.WithIdentity(context.Background(), nil), -1, false},
+		{auth.WithIdentity(context.Background(), &auth.Identity{UserId: 10, Method: auth.MethodCookie}), 10, true},
+	}
+
+	for _, testCase := range testCases {
+		userId, ok := auth.UserId(testCase.ctx)
+
+		if userId != testCase.expectedUserId {
+			t.Errorf("recived user id %d, expected %d", userId, testCase.expectedUserId)
+		}
+		if ok != testCase.expectedOk {
+			t.Errorf("recived ok %v, expected %v", ok, testCase.expectedOk)
+		}
+	}
+}
+
+/**
+Perform the testing
+*/
+func TestIdentityAllowedTo(t *testing.T) {
+	identity := &auth.Identity{Permissions: []string{"read", "write"}}
+
+	testCases := []struct {
+		permissions []string
+		expected    bool
+	}{
+		{[]string{}, true},
+		{[]string{"read"}, true},
+		{[]string{"read", "write"}, true},
+		{[]string{"read", "delete"}, false},
+	}
+
+	for _, testCase := range testCases {
+		if allowed := identity.AllowedTo(testCase.permissions...); allowed != testCase.expected {
+			t.Errorf("recived %v for %v, expected %v", allowed, testCase.permissions, testCase.expected)
+		}
+	}
+}
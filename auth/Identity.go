@@ -0,0 +1,68 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package auth
+
+/**
+How the caller proved who they are
+*/
+type Method int
+
+const (
+	MethodNone Method = iota
+	MethodJwt
+	MethodApiKey
+	MethodCookie
+)
+
+/**
+Get a readable name for the method
+*/
+func (method Method) String() string {
+	switch method {
+	case MethodJwt:
+		return "jwt"
+	case MethodApiKey:
+		return "api_key"
+	case MethodCookie:
+		return "cookie"
+	default:
+		return "none"
+	}
+}
+
+/**
+The authenticated caller of a request.  It is built once by the auth middleware and must not be changed after
+*/
+type Identity struct {
+	UserId int
+	Email  string
+
+	//The permissions the user had when the request was made, nil if no permission repo is used
+	Permissions []string
+
+	//How the user was authenticated
+	Method Method
+
+	//The id of the token used, empty if the token does not have one
+	TokenId string
+}
+
+/**
+Check to see if the user has every one of the permissions
+*/
+func (identity *Identity) AllowedTo(permissions ...string) bool {
+	for _, permission := range permissions {
+		found := false
+		for _, held := range identity.Permissions {
+			if held == permission {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
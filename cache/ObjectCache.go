@@ -3,6 +3,8 @@
 
 package cache
 
+import "context"
+
 type ObjectCache interface {
 	Get(key string, item interface{})
 
@@ -11,4 +13,12 @@ type ObjectCache interface {
 	GetString(key string) (string, bool)
 
 	SetString(key string, value string)
+
+	/**
+	The same as each method above but traced as part of the context
+	*/
+	GetContext(ctx context.Context, key string, item interface{})
+	SetContext(ctx context.Context, key string, item interface{}) error
+	GetStringContext(ctx context.Context, key string) (string, bool)
+	SetStringContext(ctx context.Context, key string, value string)
 }
@@ -4,6 +4,7 @@
 package cache
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/patrickmn/go-cache"
 
@@ -102,3 +103,31 @@ func (repo *ObjectMemCache) SetString(key string, value string) {
 	repo.cache.SetDefault(key, value)
 
 }
+
+/**
+The same as Get, there is nothing to trace in memory
+*/
+func (repo *ObjectMemCache) GetContext(ctx context.Context, key string, returnItem interface{}) {
+	repo.Get(key, returnItem)
+}
+
+/**
+The same as Set, there is nothing to trace in memory
+*/
+func (repo *ObjectMemCache) SetContext(ctx context.Context, key string, item interface{}) error {
+	return repo.Set(key, item)
+}
+
+/**
+The same as GetString, there is nothing to trace in memory
+*/
+func (repo *ObjectMemCache) GetStringContext(ctx context.Context, key string) (string, bool) {
+	return repo.GetString(key)
+}
+
+/**
+The same as SetString, there is nothing to trace in memory
+*/
+func (repo *ObjectMemCache) SetStringContext(ctx context.Context, key string, value string) {
+	repo.SetString(key, value)
+}
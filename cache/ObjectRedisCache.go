@@ -4,9 +4,12 @@
 package cache
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/go-redis/cache"
 	"github.com/go-redis/redis"
+	"github.com/reaction-eng/restlib/tracing"
+	"go.opentelemetry.io/otel/attribute"
 	"time"
 )
 
@@ -46,10 +49,24 @@ func NewObjectRedisCache(redis *redis.Ring) *ObjectRedisCache {
 Get all of the news
 */
 func (repo *ObjectRedisCache) Get(key string, item interface{}) {
+	repo.GetContext(context.Background(), key, item)
+}
+
+/**
+The same as Get but traced as part of the context
+*/
+func (repo *ObjectRedisCache) GetContext(ctx context.Context, key string, item interface{}) {
+	//Trace the call to redis
+	_, span := tracing.StartClientSpan(ctx, "cache.ObjectRedisCache.Get", "redis", attribute.String("cache.key", key))
+	defer span.End()
 
 	//Get the summary
 	err := repo.codec.Get(key, &item)
 	if err != nil {
+		//A miss is not really an error
+		if err != cache.ErrCacheMiss {
+			tracing.RecordError(span, err)
+		}
 		item = nil
 	}
 
@@ -59,17 +76,37 @@ func (repo *ObjectRedisCache) Get(key string, item interface{}) {
 Get all of the news
 */
 func (repo *ObjectRedisCache) Set(key string, item interface{}) error {
+	return repo.SetContext(context.Background(), key, item)
+}
+
+/**
+The same as Set but traced as part of the context
+*/
+func (repo *ObjectRedisCache) SetContext(ctx context.Context, key string, item interface{}) error {
+	//Trace the call to redis
+	_, span := tracing.StartClientSpan(ctx, "cache.ObjectRedisCache.Set", "redis", attribute.String("cache.key", key))
+	defer span.End()
 
 	//Now save it
-	return repo.codec.Set(&cache.Item{
+	return tracing.RecordError(span, repo.codec.Set(&cache.Item{
 		Key:        key,
 		Object:     item,
 		Expiration: time.Hour,
-	})
+	}))
 
 }
 
 func (repo *ObjectRedisCache) GetString(key string) (string, bool) {
+	return repo.GetStringContext(context.Background(), key)
+}
+
+/**
+The same as GetString but traced as part of the context
+*/
+func (repo *ObjectRedisCache) GetStringContext(ctx context.Context, key string) (string, bool) {
+	//Trace the call to redis
+	_, span := tracing.StartClientSpan(ctx, "cache.ObjectRedisCache.GetString", "redis", attribute.String("cache.key", key))
+	defer span.End()
 
 	//Get the google item
 	var item string
@@ -89,6 +126,16 @@ func (repo *ObjectRedisCache) GetString(key string) (string, bool) {
 Get all of the news
 */
 func (repo *ObjectRedisCache) SetString(key string, value string) {
+	repo.SetStringContext(context.Background(), key, value)
+}
+
+/**
+The same as SetString but traced as part of the context
+*/
+func (repo *ObjectRedisCache) SetStringContext(ctx context.Context, key string, value string) {
+	//Trace the call to redis
+	_, span := tracing.StartClientSpan(ctx, "cache.ObjectRedisCache.SetString", "redis", attribute.String("cache.key", key))
+	defer span.End()
 
 	//Now save it
 	repo.codec.Set(&cache.Item{
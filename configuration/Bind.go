@@ -0,0 +1,222 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package configuration
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+/**
+A single problem found while binding
+*/
+type FieldError struct {
+	//The dotted path to the value, i.e. db.address
+	Field   string
+	Message string
+}
+
+/**
+Holds every problem found while binding so they can all be fixed at once
+*/
+type BindError struct {
+	Errors []FieldError
+}
+
+/**
+List each of the problems
+*/
+func (err *BindError) Error() string {
+	problems := make([]string, 0, len(err.Errors))
+	for _, fieldErr := range err.Errors {
+		problems = append(problems, fieldErr.Field+" "+fieldErr.Message)
+	}
+	return "invalid configuration: " + strings.Join(problems, "; ")
+}
+
+//Used to check for duration fields
+var durationType = reflect.TypeOf(time.Duration(0))
+
+/**
+Bind the value at the key into the struct, or the whole config if the key is empty.  The json tag sets the name of
+each field, the default tag sets the value when it is missing and the config tag marks fields as required and/or
+secret, i.e.
+	type DbConfig struct {
+		Address  string        `json:"address" config:"required"`
+		Password string        `json:"password" config:"required,secret"`
+		Timeout  time.Duration `json:"timeout" default:"5s"`
+	}
+Durations can be given as strings like 5s.  Every problem is returned together in a *BindError.
+*/
+func (config *Configuration) Bind(key string, object interface{}) error {
+	//Make sure we can set the object
+	value := reflect.ValueOf(object)
+	if value.Kind() != reflect.Ptr || value.Elem().Kind() != reflect.Struct {
+		return errors.New("config can only be bound to a pointer to a struct")
+	}
+
+	if config.secrets == nil {
+		config.secrets = make(map[string]bool)
+	}
+
+	//Get the params to bind
+	params := config.Params
+	if len(key) > 0 {
+		child := config.Get(key)
+		if child != nil {
+			childParams, ok := child.(map[string]interface{})
+			if !ok {
+				return &BindError{Errors: []FieldError{{Field: key, Message: "must be an object"}}}
+			}
+			params = childParams
+		} else {
+			params = nil
+		}
+	}
+
+	//Now bind each field
+	fieldErrors := make([]FieldError, 0)
+	config.bindStruct(key, params, value.Elem(), &fieldErrors)
+	if len(fieldErrors) > 0 {
+		return &BindError{Errors: fieldErrors}
+	}
+	return nil
+}
+
+/**
+Bind each of the exported fields in the struct
+*/
+func (config *Configuration) bindStruct(path string, params map[string]interface{}, value reflect.Value, fieldErrors *[]FieldError) {
+	valueType := value.Type()
+
+	for i := 0; i < valueType.NumField(); i++ {
+		field := valueType.Field(i)
+		if len(field.PkgPath) > 0 {
+			continue
+		}
+
+		//Get the name from the json tag
+		name := field.Name
+		if jsonName := strings.Split(field.Tag.Get("json"), ",")[0]; jsonName == "-" {
+			continue
+		} else if len(jsonName) > 0 {
+			name = jsonName
+		}
+		fieldPath := joinPath(path, name)
+
+		//Get the options
+		required := false
+		for _, option := range strings.Split(field.Tag.Get("config"), ",") {
+			switch strings.TrimSpace(option) {
+			case "required":
+				required = true
+			case "secret":
+				config.secrets[fieldPath] = true
+			}
+		}
+
+		//Find the value ignoring case like json
+		raw, found := lookupParam(params, name)
+		if rawString, isString := raw.(string); found && (raw == nil || (isString && len(rawString) == 0)) {
+			found = false
+		}
+
+		//Nested structs are always walked so their defaults are set
+		fieldValue := value.Field(i)
+		if field.Type.Kind() == reflect.Struct && field.Type.NumField() > 0 && field.Type.PkgPath() != "time" {
+			childParams, isMap := raw.(map[string]interface{})
+			if found && !isMap {
+				*fieldErrors = append(*fieldErrors, FieldError{Field: fieldPath, Message: "must be an object"})
+				continue
+			}
+			if !found && required {
+				*fieldErrors = append(*fieldErrors, FieldError{Field: fieldPath, Message: "is required"})
+			}
+			config.bindStruct(fieldPath, childParams, fieldValue, fieldErrors)
+			continue
+		}
+
+		//Use the default if it is missing
+		if !found {
+			if defaultValue, hasDefault := field.Tag.Lookup("default"); hasDefault {
+				if err := setFromString(fieldValue, defaultValue); err != nil {
+					*fieldErrors = append(*fieldErrors, FieldError{Field: fieldPath, Message: "has an invalid default: " + err.Error()})
+				}
+			} else if required {
+				*fieldErrors = append(*fieldErrors, FieldError{Field: fieldPath, Message: "is required"})
+			}
+			continue
+		}
+
+		//Now set the value
+		if err := setFromParam(fieldValue, raw); err != nil {
+			*fieldErrors = append(*fieldErrors, FieldError{Field: fieldPath, Message: fmt.Sprintf("must be a %s", field.Type)})
+		}
+	}
+}
+
+/**
+Find the param ignoring case
+*/
+func lookupParam(params map[string]interface{}, name string) (interface{}, bool) {
+	if raw, found := params[name]; found {
+		return raw, true
+	}
+	for key, raw := range params {
+		if strings.EqualFold(key, name) {
+			return raw, true
+		}
+	}
+	return nil, false
+}
+
+/**
+Set the field from a default tag.  Strings and durations are used as is, everything else is read as json
+*/
+func setFromString(fieldValue reflect.Value, value string) error {
+	switch {
+	case fieldValue.Type() == durationType:
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		fieldValue.SetInt(int64(duration))
+		return nil
+	case fieldValue.Kind() == reflect.String:
+		fieldValue.SetString(value)
+		return nil
+	default:
+		return json.Unmarshal([]byte(value), fieldValue.Addr().Interface())
+	}
+}
+
+/**
+Set the field from the config value
+*/
+func setFromParam(fieldValue reflect.Value, raw interface{}) error {
+	//Durations and numbers from the env can be strings
+	if rawString, isString := raw.(string); isString && fieldValue.Kind() != reflect.String {
+		return setFromString(fieldValue, rawString)
+	}
+
+	asJson, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(asJson, fieldValue.Addr().Interface())
+}
+
+/**
+Join the config path
+*/
+func joinPath(path string, name string) string {
+	if len(path) == 0 {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,165 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package configuration_test
+
+import (
+	"github.com/reaction-eng/restlib/configuration"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+/**
+Simple config used for the tests
+*/
+type testDbConfig struct {
+	Address  string        `json:"address" config:"required"`
+	Password string        `json:"password" config:"required,secret"`
+	Port     int           `json:"port" default:"3306"`
+	Timeout  time.Duration `json:"timeout" default:"5s"`
+	Tables   []string      `json:"tables"`
+	Pool     struct {
+		Size int `json:"size" default:"10"`
+	} `json:"pool"`
+}
+
+/**
+Write a config file for the test
+*/
+func writeConfigFile(t *testing.T, dir string, name string, contents string) string {
+	fileName := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(fileName, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+/**
+Perform the testing
+*/
+func TestBindFormats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	testCases := []struct {
+		fileName string
+		contents string
+	}{
+		{"config.json", `{"db": {"address": "localhost", "password": "abc", "port": 1234, "timeout": "1m", "tables": ["a", "b"]}}`},
+		{"config.yaml", "db:\n  address: localhost\n  password: abc\n  port: 1234\n  timeout: 1m\n  tables: [a, b]\n"},
+		{"config.toml", "[db]\naddress = \"localhost\"\npassword = \"abc\"\nport = 1234\ntimeout = \"1m\"\ntables = [\"a\", \"b\"]\n"},
+	}
+
+	for _, testCase := range testCases {
+		config, err := configuration.NewConfiguration(writeConfigFile(t, dir, testCase.fileName, testCase.contents))
+		if err != nil {
+			t.Fatalf("recived error %v for %s", err, testCase.fileName)
+		}
+
+		db := testDbConfig{}
+		if err := config.Bind("db", &db); err != nil {
+			t.Fatalf("recived error %v for %s", err, testCase.fileName)
+		}
+
+		if db.Address != "localhost" || db.Password != "abc" || db.Port != 1234 || db.Timeout != time.Minute || len(db.Tables) != 2 {
+			t.Errorf("recived %+v for %s", db, testCase.fileName)
+		}
+		if db.Pool.Size != 10 {
+			t.Errorf("recived pool size %d for %s, expected the default", db.Pool.Size, testCase.fileName)
+		}
+	}
+}
+
+/**
+Perform the testing
+*/
+func TestBindErrors(t *testing.T) {
+	config, err := configuration.NewConfiguration(`{"db": {"port": "abc", "pool": 4}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := testDbConfig{}
+	err = config.Bind("db", &db)
+	bindErr, ok := err.(*configuration.BindError)
+	if !ok {
+		t.Fatalf("recived error %v, expected a BindError", err)
+	}
+
+	//Every problem should be reported
+	expected := []string{"db.address", "db.password", "db.port", "db.pool"}
+	if len(bindErr.Errors) != len(expected) {
+		t.Fatalf("recived errors %v, expected %v", bindErr.Errors, expected)
+	}
+	for i, field := range expected {
+		if bindErr.Errors[i].Field != field {
+			t.Errorf("recived field %s, expected %s", bindErr.Errors[i].Field, field)
+		}
+	}
+}
+
+/**
+Perform the testing
+*/
+func TestConfigurationFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	//Missing and broken files should fail
+	if _, err := configuration.NewConfiguration(filepath.Join(dir, "missing.json")); err == nil {
+		t.Errorf("expected an error for a missing file")
+	}
+	if _, err := configuration.NewConfiguration(writeConfigFile(t, dir, "broken.json", "{")); err == nil {
+		t.Errorf("expected an error for a broken file")
+	}
+}
+
+/**
+Perform the testing
+*/
+func TestEnvOverridesAndDescribe(t *testing.T) {
+	os.Setenv("RESTLIB_DB__ADDRESS", "remote")
+	os.Setenv("RESTLIB_DB__POOL__SIZE", "20")
+	os.Setenv("DB__PASSWORD", "unprefixed")
+	defer os.Unsetenv("RESTLIB_DB__ADDRESS")
+	defer os.Unsetenv("RESTLIB_DB__POOL__SIZE")
+	defer os.Unsetenv("DB__PASSWORD")
+
+	config, err := configuration.NewConfiguration(`{"db": {"address": "localhost", "password": "abc", "apiToken": "xyz"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := testDbConfig{}
+	if err := config.Bind("db", &db); err != nil {
+		t.Fatal(err)
+	}
+	if db.Address != "remote" {
+		t.Errorf("recived address %s, expected remote", db.Address)
+	}
+	if db.Pool.Size != 20 {
+		t.Errorf("recived pool size %d, expected 20", db.Pool.Size)
+	}
+	if db.Password != "abc" {
+		t.Errorf("recived password %s, expected the env variable without the prefix to be ignored", db.Password)
+	}
+
+	//The secrets should be hidden
+	described := config.Describe()
+	if strings.Contains(described, "abc") || strings.Contains(described, "xyz") {
+		t.Errorf("recived secrets in %s", described)
+	}
+	if !strings.Contains(described, "remote") {
+		t.Errorf("recived %s, expected the address", described)
+	}
+}
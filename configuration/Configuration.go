@@ -19,17 +19,32 @@ Define a simple database configuration
 type Configuration struct {
 	//Load in the Params from json
 	Params map[string]interface{}
+
+	//The dotted paths of any secret values found while binding, used to redact them
+	secrets map[string]bool
 }
 
-//Provide a function to create a new one
+//The secrets file is read last if it exists
+const secretConfigFile = "config.secret.json"
+
+/**
+Provide a function to create a new one.  Each config is either a json string or a json, yaml or toml file based upon
+the extension.  Later configs replace the top level keys of earlier ones, then any nested env variables are applied
+and secret references are resolved.  Only env variables starting with EnvPrefix and using __ between levels are
+applied, i.e. RESTLIB_DB__ADDRESS sets address inside of db.  An error is returned if a file can not be read or parsed
+or a secret is missing.
+*/
 func NewConfiguration(configFiles ...string) (*Configuration, error) {
 	//Define a Configuration
 	config := Configuration{
-		Params: make(map[string]interface{}, 0),
+		Params:  make(map[string]interface{}, 0),
+		secrets: make(map[string]bool),
 	}
 
 	// Read secrets last which will overwrite any existing keys
-	configFiles = append(configFiles, "config.secret.json")
+	if _, err := os.Stat(secretConfigFile); err == nil {
+		configFiles = append(configFiles, secretConfigFile)
+	}
 
 	//Now march over each file
 	for _, configFile := range configFiles {
@@ -46,19 +61,27 @@ func NewConfiguration(configFiles ...string) (*Configuration, error) {
 
 		} else {
 			//Parse as file
-			//Load in the file
-			configFileStream, err := os.Open(configFile)
-
-			if err == nil {
-				//Get the json and add to the Params
-				jsonParser := json.NewDecoder(configFileStream)
-				jsonParser.Decode(&config.Params)
-				configFileStream.Close()
+			fileParams, err := readConfigFile(configFile)
+			if err != nil {
+				return nil, err
+			}
+			for k, v := range fileParams {
+				config.Params[k] = v
 			}
 		}
 
 	}
 
+	//Apply any nested overrides from the env
+	applyEnvOverrides(config.Params, os.Environ())
+
+	//Replace any secret references, i.e. file:///run/secrets/db_password
+	resolveErrors := make([]string, 0)
+	config.resolveSecrets("", config.Params, &resolveErrors)
+	if len(resolveErrors) > 0 {
+		return nil, errors.New("could not resolve secrets: " + strings.Join(resolveErrors, "; "))
+	}
+
 	//Return it
 	return &config, nil
 }
@@ -212,7 +235,7 @@ func (config *Configuration) GetConfig(key string) *Configuration {
 	//Now cast it
 	childConfig := childConfigInterface.(map[string]interface{})
 
-	return &Configuration{childConfig}
+	return &Configuration{Params: childConfig, secrets: make(map[string]bool)}
 
 }
 
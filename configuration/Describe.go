@@ -0,0 +1,79 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package configuration
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+//Shown in place of each secret
+const redacted = "******"
+
+//Keys that look like they hold a secret are always hidden
+var secretNames = []string{"password", "secret", "token", "apikey", "api_key", "privatekey", "private_key", "credentials"}
+
+/**
+Get the effective config, including env overrides, as indented json.  Any value marked secret while binding or with a
+name like password or token is redacted so the output is safe to log.
+*/
+func (config *Configuration) Describe() string {
+	//Get the effective top level values
+	effective := make(map[string]interface{}, len(config.Params))
+	for key := range config.Params {
+		effective[key] = config.Get(key)
+	}
+
+	described, err := json.MarshalIndent(config.redact("", effective), "", "  ")
+	if err != nil {
+		return err.Error()
+	}
+	return string(described)
+}
+
+/**
+Copy the value with each of the secrets hidden
+*/
+func (config *Configuration) redact(path string, value interface{}) interface{} {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(typed))
+		for key, child := range typed {
+			childPath := joinPath(path, key)
+			if config.isSecret(childPath, key) && child != nil {
+				copied[key] = redacted
+			} else {
+				copied[key] = config.redact(childPath, child)
+			}
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(typed))
+		for i, child := range typed {
+			copied[i] = config.redact(path, child)
+		}
+		return copied
+	default:
+		return value
+	}
+}
+
+/**
+Check to see if the value at the path should be hidden
+*/
+func (config *Configuration) isSecret(path string, key string) bool {
+	for secretPath := range config.secrets {
+		if strings.EqualFold(secretPath, path) {
+			return true
+		}
+	}
+
+	lowerKey := strings.ToLower(key)
+	for _, name := range secretNames {
+		if strings.Contains(lowerKey, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package configuration
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+//Only env variables with the prefix are applied so unrelated variables are never merged into the config
+const EnvPrefix = "RESTLIB_"
+
+//Separates each level of a nested env variable, i.e. RESTLIB_DB__ADDRESS sets address inside of db
+const envNestingSeparator = "__"
+
+/**
+Apply each env variable with the EnvPrefix and a nesting separator to the params.  The names are matched to the
+existing keys ignoring case and missing levels are created.  Values are kept as strings when replacing a string, otherwise they are read as
+json if possible so numbers, bools and lists can be set.
+*/
+func applyEnvOverrides(params map[string]interface{}, environ []string) {
+	for _, entry := range environ {
+		//Split up the name and value
+		equals := strings.Index(entry, "=")
+		if equals < 0 || !strings.HasPrefix(entry[:equals], EnvPrefix) {
+			continue
+		}
+		name := strings.TrimPrefix(entry[:equals], EnvPrefix)
+		if !strings.Contains(name, envNestingSeparator) {
+			continue
+		}
+		path := strings.Split(name, envNestingSeparator)
+		value := entry[equals+1:]
+
+		//Skip names like RESTLIB___ADDRESS
+		valid := true
+		for _, part := range path {
+			valid = valid && len(part) > 0
+		}
+		if !valid {
+			continue
+		}
+
+		//Walk down to the parent of the value
+		current := params
+		for _, part := range path[:len(path)-1] {
+			key := matchKey(current, part)
+			child, ok := current[key].(map[string]interface{})
+			if !ok {
+				child = make(map[string]interface{})
+				current[key] = child
+			}
+			current = child
+		}
+
+		//Now set the value
+		key := matchKey(current, path[len(path)-1])
+		current[key] = envValue(current[key], value)
+	}
+}
+
+/**
+Find the existing key ignoring case, or the lower case name if it is new
+*/
+func matchKey(params map[string]interface{}, name string) string {
+	for key := range params {
+		if strings.EqualFold(key, name) {
+			return key
+		}
+	}
+	return strings.ToLower(name)
+}
+
+/**
+Convert the env value to match the value it replaces
+*/
+func envValue(existing interface{}, value string) interface{} {
+	if _, isString := existing.(string); isString {
+		return value
+	}
+
+	var parsed interface{}
+	if err := json.Unmarshal([]byte(value), &parsed); err == nil {
+		return parsed
+	}
+	return value
+}
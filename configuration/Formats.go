@@ -0,0 +1,58 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package configuration
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+/**
+Read a json, yaml or toml file into a map.  The yaml and toml values are converted so they look the same as json
+values, i.e. every number is a float64.
+*/
+func readConfigFile(configFile string) (map[string]interface{}, error) {
+	contents, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read config %s: %w", configFile, err)
+	}
+
+	//Decode based upon the extension
+	params := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(configFile)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(contents, &params)
+	case ".toml":
+		err = toml.Unmarshal(contents, &params)
+	default:
+		return params, wrapParseError(configFile, json.Unmarshal(contents, &params))
+	}
+	if err != nil {
+		return nil, wrapParseError(configFile, err)
+	}
+
+	//Round trip through json so the values match a json config
+	asJson, err := json.Marshal(params)
+	if err != nil {
+		return nil, wrapParseError(configFile, err)
+	}
+	normalized := make(map[string]interface{})
+	return normalized, wrapParseError(configFile, json.Unmarshal(asJson, &normalized))
+}
+
+/**
+Add the file name to any parse error
+*/
+func wrapParseError(configFile string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("could not parse config %s: %w", configFile, err)
+}
@@ -0,0 +1,170 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package configuration
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+//Returned when the master key can not open a secret
+var ErrKeystoreMasterKey = errors.New("the master key can not decrypt the keystore")
+
+/**
+The keystore file.  Each secret is encrypted with AES-GCM using a key derived from the master key and salt
+*/
+type keystoreFile struct {
+	Salt    string            `json:"salt"`
+	Secrets map[string]string `json:"secrets"`
+}
+
+/**
+A local file of encrypted secrets, referenced with keystore:name.  Only the master key needs to be kept out of the
+repo, i.e. in the RESTLIB_MASTER_KEY env variable.
+*/
+type Keystore struct {
+	fileName string
+	key      []byte
+	file     keystoreFile
+
+	lock sync.RWMutex
+}
+
+/**
+Open the keystore, a new one is created if the file does not exist.  Register it with RegisterSecretProvider to
+resolve keystore references
+*/
+func NewKeystore(fileName string, masterKey string) (*Keystore, error) {
+	if len(masterKey) == 0 {
+		return nil, errors.New("the keystore master key is empty")
+	}
+
+	keystore := &Keystore{
+		fileName: fileName,
+		file:     keystoreFile{Secrets: make(map[string]string)},
+	}
+
+	//Load the file if it exists
+	contents, err := ioutil.ReadFile(fileName)
+	switch {
+	case os.IsNotExist(err):
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		keystore.file.Salt = base64.StdEncoding.EncodeToString(salt)
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(contents, &keystore.file); err != nil {
+			return nil, fmt.Errorf("could not parse keystore %s: %w", fileName, err)
+		}
+		if keystore.file.Secrets == nil {
+			keystore.file.Secrets = make(map[string]string)
+		}
+	}
+
+	//Derive the key from the master key
+	salt, err := base64.StdEncoding.DecodeString(keystore.file.Salt)
+	if err != nil {
+		return nil, err
+	}
+	keystore.key, err = scrypt.Key([]byte(masterKey), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	//Make sure the key works on the secrets already there
+	for name := range keystore.file.Secrets {
+		if _, err := keystore.Resolve(name); err != nil {
+			return nil, err
+		}
+	}
+
+	return keystore, nil
+}
+
+func (keystore *Keystore) Scheme() string {
+	return "keystore"
+}
+
+/**
+Decrypt the named secret
+*/
+func (keystore *Keystore) Resolve(name string) (string, error) {
+	keystore.lock.RLock()
+	encoded, found := keystore.file.Secrets[name]
+	keystore.lock.RUnlock()
+	if !found {
+		return "", fmt.Errorf("%w: keystore %s", ErrSecretNotFound, name)
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := keystore.cipher()
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", ErrKeystoreMasterKey
+	}
+
+	//The name is authenticated so secrets can't be swapped
+	secret, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], []byte(name))
+	if err != nil {
+		return "", ErrKeystoreMasterKey
+	}
+	return string(secret), nil
+}
+
+/**
+Encrypt and store the secret, then save the file
+*/
+func (keystore *Keystore) Set(name string, secret string) error {
+	gcm, err := keystore.cipher()
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(secret), []byte(name))
+
+	keystore.lock.Lock()
+	defer keystore.lock.Unlock()
+	keystore.file.Secrets[name] = base64.StdEncoding.EncodeToString(sealed)
+
+	//Save it so only the owner can read it
+	contents, err := json.MarshalIndent(keystore.file, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(keystore.fileName, contents, 0600)
+}
+
+/**
+Build the cipher from the derived key
+*/
+func (keystore *Keystore) cipher() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(keystore.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
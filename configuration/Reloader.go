@@ -0,0 +1,292 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package configuration
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+/**
+Checks a newly loaded config before it is used
+*/
+type Validator func(config *Configuration) error
+
+/**
+Called with the new config when one of the subscribed keys changes.  Returning an error rolls back the reload
+*/
+type ChangeFunc func(config *Configuration) error
+
+/**
+A single subscriber and the keys it uses
+*/
+type subscription struct {
+	keys     []string
+	onChange ChangeFunc
+}
+
+/**
+Holds a configuration that can be reloaded while the server runs.  Each reload builds a new Configuration, runs the
+validators and subscribers and only then swaps it in.  If anything fails the old config stays and any subscribers
+that were already told are told again with the old config.
+*/
+type Reloader struct {
+	configFiles []string
+
+	//The current *Configuration
+	current atomic.Value
+
+	//Only one reload at a time
+	lock          sync.Mutex
+	validators    []Validator
+	subscriptions []*subscription
+
+	//Used to see if the files changed
+	modTimes map[string]time.Time
+
+	//Stops the watcher
+	stop     chan bool
+	stopOnce sync.Once
+}
+
+/**
+Load the config files and get ready to reload them
+*/
+func NewReloader(configFiles ...string) (*Reloader, error) {
+	config, err := NewConfiguration(configFiles...)
+	if err != nil {
+		return nil, err
+	}
+
+	reloader := &Reloader{
+		configFiles: configFiles,
+		modTimes:    make(map[string]time.Time),
+		stop:        make(chan bool),
+	}
+	reloader.current.Store(config)
+	reloader.filesChanged()
+
+	return reloader, nil
+}
+
+/**
+Get the current config.  Hold on to the result for a single request, not for the life of the server
+*/
+func (reloader *Reloader) Current() *Configuration {
+	return reloader.current.Load().(*Configuration)
+}
+
+/**
+Add a check that every new config must pass.  The current config is checked right away
+*/
+func (reloader *Reloader) AddValidator(validator Validator) error {
+	reloader.lock.Lock()
+	defer reloader.lock.Unlock()
+
+	if err := validator(reloader.Current()); err != nil {
+		return err
+	}
+	reloader.validators = append(reloader.validators, validator)
+	return nil
+}
+
+/**
+Call onChange each time the value at any of the keys changes.  An empty key watches the whole config.  It is not
+called for the current config.
+*/
+func (reloader *Reloader) Subscribe(keys []string, onChange ChangeFunc) {
+	reloader.lock.Lock()
+	defer reloader.lock.Unlock()
+
+	if len(keys) == 0 {
+		keys = []string{""}
+	}
+	reloader.subscriptions = append(reloader.subscriptions, &subscription{keys: keys, onChange: onChange})
+}
+
+/**
+Bind the value at the key into a new copy of the prototype each time it changes and pass it to onChange.  The
+prototype must be a pointer, i.e. &SmtpConfig{}.  Structs are bound with Bind so defaults and required fields are
+checked, anything else is read with GetStruct.  The current value is bound and passed right away.
+*/
+func (reloader *Reloader) Watch(key string, prototype interface{}, onChange func(value interface{}) error) error {
+	prototypeType := reflect.TypeOf(prototype)
+	if prototypeType == nil || prototypeType.Kind() != reflect.Ptr {
+		return errors.New("the prototype must be a pointer")
+	}
+
+	//Build the typed value from the config
+	typedChange := func(config *Configuration) error {
+		value := reflect.New(prototypeType.Elem()).Interface()
+
+		var err error
+		if prototypeType.Elem().Kind() == reflect.Struct {
+			err = config.Bind(key, value)
+		} else {
+			err = config.GetStruct(key, value)
+		}
+		if err != nil {
+			return err
+		}
+
+		return onChange(value)
+	}
+
+	//Start with the current value
+	if err := typedChange(reloader.Current()); err != nil {
+		return err
+	}
+
+	reloader.Subscribe([]string{key}, typedChange)
+	return nil
+}
+
+/**
+Load the config files again and swap them in if everything accepts the change
+*/
+func (reloader *Reloader) Reload() error {
+	reloader.lock.Lock()
+	defer reloader.lock.Unlock()
+
+	//Load the new config
+	old := reloader.Current()
+	next, err := NewConfiguration(reloader.configFiles...)
+	if err != nil {
+		return err
+	}
+
+	//Check it
+	for _, validator := range reloader.validators {
+		if err := validator(next); err != nil {
+			return fmt.Errorf("the new config was rejected: %w", err)
+		}
+	}
+
+	//Let everyone know, rolling back if anyone can't take it
+	told := make([]*subscription, 0)
+	for _, sub := range reloader.subscriptions {
+		if !keysChanged(old, next, sub.keys) {
+			continue
+		}
+
+		if err := sub.onChange(next); err != nil {
+			for i := len(told) - 1; i >= 0; i-- {
+				if rollbackErr := told[i].onChange(old); rollbackErr != nil {
+					log.Printf("could not roll back the config: %v", rollbackErr)
+				}
+			}
+			return fmt.Errorf("the new config was rejected: %w", err)
+		}
+		told = append(told, sub)
+	}
+
+	//Now use it
+	reloader.current.Store(next)
+	return nil
+}
+
+/**
+Reload when SIGHUP is received or any of the files change.  The files are checked at each interval, zero only
+reloads on SIGHUP.
+*/
+func (reloader *Reloader) Start(interval time.Duration) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(signals)
+
+		//Only poll if asked to
+		var ticks <-chan time.Time
+		if interval > 0 {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			ticks = ticker.C
+		}
+
+		for {
+			select {
+			case <-reloader.stop:
+				return
+			case <-signals:
+				reloader.reloadAndLog("SIGHUP")
+			case <-ticks:
+				if reloader.filesChanged() {
+					reloader.reloadAndLog("a changed file")
+				}
+			}
+		}
+	}()
+}
+
+/**
+Stop watching for changes
+*/
+func (reloader *Reloader) Stop() {
+	reloader.stopOnce.Do(func() {
+		close(reloader.stop)
+	})
+}
+
+/**
+Allow the reloader to be cleaned up with the rest of the server
+*/
+func (reloader *Reloader) CleanUp() {
+	reloader.Stop()
+}
+
+/**
+Reload and log the result
+*/
+func (reloader *Reloader) reloadAndLog(reason string) {
+	if err := reloader.Reload(); err != nil {
+		log.Printf("could not reload the config after %s: %v", reason, err)
+		return
+	}
+	log.Printf("reloaded the config after %s", reason)
+}
+
+/**
+Check to see if any of the files were changed since the last check
+*/
+func (reloader *Reloader) filesChanged() bool {
+	changed := false
+	for _, configFile := range append(append([]string{}, reloader.configFiles...), secretConfigFile) {
+		//Json strings and missing files are skipped
+		info, err := os.Stat(configFile)
+		if err != nil {
+			continue
+		}
+
+		if last, found := reloader.modTimes[configFile]; !found || !last.Equal(info.ModTime()) {
+			changed = true
+			reloader.modTimes[configFile] = info.ModTime()
+		}
+	}
+	return changed
+}
+
+/**
+Check to see if the value at any of the keys is different
+*/
+func keysChanged(old *Configuration, next *Configuration, keys []string) bool {
+	for _, key := range keys {
+		if len(key) == 0 {
+			if !reflect.DeepEqual(old.Params, next.Params) {
+				return true
+			}
+		} else if !reflect.DeepEqual(old.Get(key), next.Get(key)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,160 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package configuration_test
+
+import (
+	"errors"
+	"github.com/reaction-eng/restlib/configuration"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+/**
+Simple typed config for the reload tests
+*/
+type testSmtpConfig struct {
+	Server   string `json:"server" config:"required"`
+	Password string `json:"password" config:"secret"`
+}
+
+/**
+Perform the testing
+*/
+func TestReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := writeConfigFile(t, dir, "config.json", `{"smtp": {"server": "a", "password": "1"}, "other": 1}`)
+
+	reloader, err := configuration.NewReloader(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//Watch the typed value
+	var lock sync.Mutex
+	servers := make([]string, 0)
+	err = reloader.Watch("smtp", &testSmtpConfig{}, func(value interface{}) error {
+		lock.Lock()
+		defer lock.Unlock()
+		servers = append(servers, value.(*testSmtpConfig).Server)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//Add a second subscriber that refuses a server named bad
+	otherCalls := 0
+	reloader.Subscribe([]string{"smtp", "other"}, func(config *configuration.Configuration) error {
+		otherCalls++
+		if config.GetConfig("smtp").GetString("server") == "bad" {
+			return errors.New("bad server")
+		}
+		return nil
+	})
+
+	//The validator refuses a missing other
+	if err := reloader.AddValidator(func(config *configuration.Configuration) error {
+		if config.Get("other") == nil {
+			return errors.New("other is required")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		contents           string
+		expectError        bool
+		expectedServers    []string
+		expectedOtherCalls int
+		expectedServer     string
+	}{
+		//Nothing changed
+		{`{"smtp": {"server": "a", "password": "1"}, "other": 1}`, false, []string{"a"}, 0, "a"},
+		//Only other changed
+		{`{"smtp": {"server": "a", "password": "1"}, "other": 2}`, false, []string{"a"}, 1, "a"},
+		//The server changed
+		{`{"smtp": {"server": "b", "password": "1"}, "other": 2}`, false, []string{"a", "b"}, 2, "b"},
+		//Fails the validator
+		{`{"smtp": {"server": "c", "password": "1"}}`, true, []string{"a", "b"}, 2, "b"},
+		//Fails the bind
+		{`{"smtp": {"password": "1"}, "other": 2}`, true, []string{"a", "b"}, 2, "b"},
+		//Fails the second subscriber so the first is rolled back
+		{`{"smtp": {"server": "bad", "password": "1"}, "other": 2}`, true, []string{"a", "b", "bad", "b"}, 3, "b"},
+		//Broken file
+		{`{"smtp": `, true, []string{"a", "b", "bad", "b"}, 3, "b"},
+	}
+
+	for i, testCase := range testCases {
+		writeConfigFile(t, dir, "config.json", testCase.contents)
+
+		err := reloader.Reload()
+		if (err != nil) != testCase.expectError {
+			t.Errorf("recived error %v for case %d", err, i)
+		}
+
+		lock.Lock()
+		if len(servers) != len(testCase.expectedServers) {
+			t.Errorf("recived servers %v for case %d, expected %v", servers, i, testCase.expectedServers)
+		} else {
+			for j := range servers {
+				if servers[j] != testCase.expectedServers[j] {
+					t.Errorf("recived servers %v for case %d, expected %v", servers, i, testCase.expectedServers)
+					break
+				}
+			}
+		}
+		lock.Unlock()
+
+		if otherCalls != testCase.expectedOtherCalls {
+			t.Errorf("recived %d calls for case %d, expected %d", otherCalls, i, testCase.expectedOtherCalls)
+		}
+		if server := reloader.Current().GetConfig("smtp").GetString("server"); server != testCase.expectedServer {
+			t.Errorf("recived server %s for case %d, expected %s", server, i, testCase.expectedServer)
+		}
+	}
+}
+
+/**
+Perform the testing
+*/
+func TestReloaderWatchFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := writeConfigFile(t, dir, "config.yaml", "name: first\n")
+
+	reloader, err := configuration.NewReloader(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloader.Start(10 * time.Millisecond)
+	defer reloader.Stop()
+
+	//Change the file and move the time forward so the change is seen
+	writeConfigFile(t, dir, "config.yaml", "name: second\n")
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(dir, "config.yaml"), future, future); err != nil {
+		t.Fatal(err)
+	}
+
+	//Wait for it to be reloaded
+	deadline := time.Now().Add(2 * time.Second)
+	for reloader.Current().GetString("name") != "second" && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if name := reloader.Current().GetString("name"); name != "second" {
+		t.Errorf("recived name %s, expected second", name)
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package configuration
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+/**
+Looks up the secret behind a reference.  A config value of scheme:reference, i.e. file:///run/secrets/db or
+env:DB_PASSWORD, is replaced with the secret when the config is loaded.
+*/
+type SecretProvider interface {
+	//The scheme this provider handles, without the colon
+	Scheme() string
+
+	//Get the secret for everything after the scheme and colon
+	Resolve(reference string) (string, error)
+}
+
+//Returned when a provider does not have the secret
+var ErrSecretNotFound = errors.New("secret not found")
+
+//The registered providers by scheme
+var secretProviders = map[string]SecretProvider{
+	"file": &FileSecretProvider{},
+	"env":  &EnvSecretProvider{},
+}
+var secretProvidersLock sync.RWMutex
+
+/**
+Add a provider so its references are resolved in every config loaded after this.  A provider with the same scheme
+is replaced.
+*/
+func RegisterSecretProvider(provider SecretProvider) {
+	secretProvidersLock.Lock()
+	defer secretProvidersLock.Unlock()
+	secretProviders[provider.Scheme()] = provider
+}
+
+/**
+Get the provider for the value if it is a reference
+*/
+func secretProviderFor(value string) (SecretProvider, string) {
+	colon := strings.Index(value, ":")
+	if colon <= 0 {
+		return nil, ""
+	}
+
+	secretProvidersLock.RLock()
+	defer secretProvidersLock.RUnlock()
+	return secretProviders[value[:colon]], value[colon+1:]
+}
+
+/**
+Replace every reference in the params with its secret.  Each resolved value is marked secret so Describe hides it.
+*/
+func (config *Configuration) resolveSecrets(path string, value interface{}, resolveErrors *[]string) interface{} {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		for key, child := range typed {
+			typed[key] = config.resolveSecrets(joinPath(path, key), child, resolveErrors)
+		}
+		return typed
+	case []interface{}:
+		for i, child := range typed {
+			typed[i] = config.resolveSecrets(path, child, resolveErrors)
+		}
+		return typed
+	case string:
+		provider, reference := secretProviderFor(typed)
+		if provider == nil {
+			return typed
+		}
+
+		secret, err := provider.Resolve(reference)
+		if err != nil {
+			*resolveErrors = append(*resolveErrors, fmt.Sprintf("%s: %v", path, err))
+			return typed
+		}
+		config.secrets[path] = true
+		return secret
+	default:
+		return value
+	}
+}
+
+/**
+Read secrets from files, i.e. file:///run/secrets/db_password.  Trailing new lines are removed
+*/
+type FileSecretProvider struct{}
+
+func (provider *FileSecretProvider) Scheme() string {
+	return "file"
+}
+
+func (provider *FileSecretProvider) Resolve(reference string) (string, error) {
+	contents, err := ioutil.ReadFile(strings.TrimPrefix(reference, "//"))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(contents), "\r\n"), nil
+}
+
+/**
+Read secrets from env variables, i.e. env:DB_PASSWORD
+*/
+type EnvSecretProvider struct{}
+
+func (provider *EnvSecretProvider) Scheme() string {
+	return "env"
+}
+
+func (provider *EnvSecretProvider) Resolve(reference string) (string, error) {
+	secret, found := os.LookupEnv(reference)
+	if !found {
+		return "", fmt.Errorf("%w: env variable %s", ErrSecretNotFound, reference)
+	}
+	return secret, nil
+}
+
+/**
+Hold secrets in memory.  Use it in tests in place of a real backend
+*/
+type MapSecretProvider struct {
+	scheme  string
+	secrets map[string]string
+}
+
+//Provide a method to make a new MapSecretProvider
+func NewMapSecretProvider(scheme string, secrets map[string]string) *MapSecretProvider {
+	return &MapSecretProvider{
+		scheme:  scheme,
+		secrets: secrets,
+	}
+}
+
+func (provider *MapSecretProvider) Scheme() string {
+	return provider.scheme
+}
+
+func (provider *MapSecretProvider) Resolve(reference string) (string, error) {
+	secret, found := provider.secrets[reference]
+	if !found {
+		return "", fmt.Errorf("%w: %s", ErrSecretNotFound, reference)
+	}
+	return secret, nil
+}
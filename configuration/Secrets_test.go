@@ -0,0 +1,109 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package configuration_test
+
+import (
+	"github.com/reaction-eng/restlib/configuration"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/**
+Perform the testing
+*/
+func TestSecretReferences(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	secretFile := writeConfigFile(t, dir, "db_password", "from-file\n")
+
+	os.Setenv("TEST_SMTP_PASSWORD", "from-env")
+	defer os.Unsetenv("TEST_SMTP_PASSWORD")
+	configuration.RegisterSecretProvider(configuration.NewMapSecretProvider("fake", map[string]string{"token": "from-fake"}))
+
+	testCases := []struct {
+		value       string
+		expected    string
+		expectError bool
+	}{
+		{"file://" + secretFile, "from-file", false},
+		{"env:TEST_SMTP_PASSWORD", "from-env", false},
+		{"fake:token", "from-fake", false},
+		{"https://example.com", "https://example.com", false},
+		{"plain", "plain", false},
+		{"env:TEST_MISSING_VALUE", "", true},
+		{"fake:missing", "", true},
+		{"file://" + filepath.Join(dir, "missing"), "", true},
+	}
+
+	for _, testCase := range testCases {
+		config, err := configuration.NewConfiguration(`{"nested": {"value": "` + testCase.value + `"}}`)
+		if (err != nil) != testCase.expectError {
+			t.Errorf("recived error %v for %s", err, testCase.value)
+		}
+		if err != nil {
+			continue
+		}
+
+		if value := config.GetConfig("nested").GetString("value"); value != testCase.expected {
+			t.Errorf("recived %s for %s, expected %s", value, testCase.value, testCase.expected)
+		}
+
+		//Resolved secrets should never be described
+		if testCase.expected != testCase.value && strings.Contains(config.Describe(), testCase.expected) {
+			t.Errorf("recived the secret in %s", config.Describe())
+		}
+	}
+}
+
+/**
+Perform the testing
+*/
+func TestKeystore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "keystore.json")
+
+	//Add a secret
+	keystore, err := configuration.NewKeystore(fileName, "master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keystore.Set("db_password", "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+
+	//It should not be stored in plain text
+	contents, _ := ioutil.ReadFile(fileName)
+	if strings.Contains(string(contents), "hunter2") {
+		t.Errorf("recived a plain text secret in %s", contents)
+	}
+
+	//Open it again and use it
+	reopened, err := configuration.NewKeystore(fileName, "master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	configuration.RegisterSecretProvider(reopened)
+	config, err := configuration.NewConfiguration(`{"db_password": "keystore:db_password"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if password := config.GetString("db_password"); password != "hunter2" {
+		t.Errorf("recived %s, expected hunter2", password)
+	}
+
+	//The wrong key should fail
+	if _, err := configuration.NewKeystore(fileName, "wrong"); err != configuration.ErrKeystoreMasterKey {
+		t.Errorf("recived %v, expected %v", err, configuration.ErrKeystoreMasterKey)
+	}
+}
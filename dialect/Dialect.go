@@ -0,0 +1,176 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package dialect
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/**
+The sql flavors supported by the sql repos.  Statements are written once with ? placeholders and rebound for the
+dialect, so adding a dialect only needs the few statements that really differ.
+*/
+type Dialect string
+
+const (
+	MySql    Dialect = "mysql"
+	Postgres Dialect = "postgres"
+	Sqlite   Dialect = "sqlite3"
+)
+
+/**
+Get the dialect from its name, i.e. the db_dialect in the config.  An empty name is mysql
+*/
+func Parse(name string) (Dialect, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "mysql":
+		return MySql, nil
+	case "postgres", "postgresql":
+		return Postgres, nil
+	case "sqlite", "sqlite3":
+		return Sqlite, nil
+	default:
+		return "", fmt.Errorf("unknown sql dialect %s", name)
+	}
+}
+
+/**
+Get the name of the database/sql driver for the dialect
+*/
+func (dialect Dialect) DriverName() string {
+	return string(dialect)
+}
+
+/**
+Get the placeholder for the nth, starting at 1, argument
+*/
+func (dialect Dialect) Placeholder(n int) string {
+	if dialect == Postgres {
+		return "$" + strconv.Itoa(n)
+	}
+	return "?"
+}
+
+/**
+Replace each ? in the query with the placeholder for the dialect.  Anything inside quotes is left alone
+*/
+func (dialect Dialect) Rebind(query string) string {
+	if dialect != Postgres {
+		return query
+	}
+
+	var builder strings.Builder
+	var quote rune
+	n := 0
+	for _, char := range query {
+		switch {
+		case quote != 0:
+			//Wait for the end of the quote
+			if char == quote {
+				quote = 0
+			}
+		case char == '\'' || char == '"' || char == '`':
+			quote = char
+		case char == '?':
+			n++
+			builder.WriteString(dialect.Placeholder(n))
+			continue
+		}
+		builder.WriteRune(char)
+	}
+
+	return builder.String()
+}
+
+/**
+Build an insert that updates the columns when a row with the same key is already there, i.e.
+	Upsert("prefs", []string{"userId"}, "userId", "settings")
+Key columns are not updated.
+*/
+func (dialect Dialect) Upsert(tableName string, keys []string, columns ...string) string {
+	return dialect.upsert(tableName, keys, "", columns)
+}
+
+/**
+Build an upsert that also keeps a version column, i.e.
+	UpsertVersion("prefs", []string{"userId"}, "version", "userId", "settings")
+New rows start at version 1 and each update adds one.  The version is not one of the arguments.
+*/
+func (dialect Dialect) UpsertVersion(tableName string, keys []string, versionColumn string, columns ...string) string {
+	return dialect.upsert(tableName, keys, versionColumn, columns)
+}
+
+/**
+Build the upsert, with a version column if it is not empty
+*/
+func (dialect Dialect) upsert(tableName string, keys []string, versionColumn string, columns []string) string {
+	insert := insertInto(tableName, versionColumn, columns)
+
+	//Get the columns to update
+	isKey := make(map[string]bool)
+	for _, key := range keys {
+		isKey[key] = true
+	}
+	updates := make([]string, 0)
+	for _, column := range columns {
+		if isKey[column] {
+			continue
+		}
+		if dialect == MySql {
+			updates = append(updates, column+" = VALUES("+column+")")
+		} else {
+			updates = append(updates, column+" = excluded."+column)
+		}
+	}
+	if len(versionColumn) > 0 {
+		updates = append(updates, versionColumn+" = "+tableName+"."+versionColumn+" + 1")
+	}
+
+	if dialect == MySql {
+		return insert + " ON DUPLICATE KEY UPDATE " + strings.Join(updates, ", ")
+	}
+	return dialect.Rebind(insert + " ON CONFLICT (" + strings.Join(keys, ", ") + ") DO UPDATE SET " + strings.Join(updates, ", "))
+}
+
+/**
+Build an insert that does nothing when a row with the same key is already there, i.e.
+	InsertIfMissing("prefs", []string{"userId"}, "userId", "settings")
+Check the rows affected to see if it was added.
+*/
+func (dialect Dialect) InsertIfMissing(tableName string, keys []string, columns ...string) string {
+	insert := insertInto(tableName, "", columns)
+
+	//MySql only counts rows that change, so setting the key to itself is not counted
+	if dialect == MySql {
+		return insert + " ON DUPLICATE KEY UPDATE " + keys[0] + " = " + keys[0]
+	}
+	return dialect.Rebind(insert + " ON CONFLICT (" + strings.Join(keys, ", ") + ") DO NOTHING")
+}
+
+/**
+Build the insert with a placeholder for each column.  The version column, if any, starts at 1
+*/
+func insertInto(tableName string, versionColumn string, columns []string) string {
+	names := append([]string{}, columns...)
+	values := make([]string, len(columns))
+	for i := range columns {
+		values[i] = "?"
+	}
+	if len(versionColumn) > 0 {
+		names = append(names, versionColumn)
+		values = append(values, "1")
+	}
+
+	return "INSERT INTO " + tableName + "(" + strings.Join(names, ", ") + ") VALUES (" + strings.Join(values, ", ") + ")"
+}
+
+/**
+Prepare the query after rebinding it for the dialect
+*/
+func (dialect Dialect) Prepare(db *sql.DB, query string) (*sql.Stmt, error) {
+	return db.Prepare(dialect.Rebind(query))
+}
@@ -0,0 +1,139 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package dialect_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/reaction-eng/restlib/apierror"
+	"github.com/reaction-eng/restlib/dialect"
+	"testing"
+)
+
+/**
+Perform the testing
+*/
+func TestRebind(t *testing.T) {
+
+	//Define the list of queries we are testing
+	var queries = []struct {
+		dialect  dialect.Dialect
+		query    string
+		expected string
+	}{
+		{dialect.MySql, "SELECT * FROM users WHERE id = ? AND email = ?", "SELECT * FROM users WHERE id = ? AND email = ?"},
+		{dialect.Sqlite, "SELECT * FROM users WHERE id = ?", "SELECT * FROM users WHERE id = ?"},
+		{dialect.Postgres, "SELECT * FROM users WHERE id = ? AND email = ?", "SELECT * FROM users WHERE id = $1 AND email = $2"},
+		{dialect.Postgres, "SELECT '?' FROM users WHERE id = ?", "SELECT '?' FROM users WHERE id = $1"},
+	}
+
+	for _, qq := range queries {
+		if result := qq.dialect.Rebind(qq.query); result != qq.expected {
+			t.Errorf("recived %s, expected %s", result, qq.expected)
+		}
+	}
+}
+
+/**
+Perform the testing
+*/
+func TestUpsert(t *testing.T) {
+
+	//Define the list of dialects we are testing
+	var upserts = []struct {
+		dialect  dialect.Dialect
+		expected string
+	}{
+		{dialect.MySql, "INSERT INTO prefs(userId, settings) VALUES (?, ?) ON DUPLICATE KEY UPDATE settings = VALUES(settings)"},
+		{dialect.Postgres, "INSERT INTO prefs(userId, settings) VALUES ($1, $2) ON CONFLICT (userId) DO UPDATE SET settings = excluded.settings"},
+		{dialect.Sqlite, "INSERT INTO prefs(userId, settings) VALUES (?, ?) ON CONFLICT (userId) DO UPDATE SET settings = excluded.settings"},
+	}
+
+	for _, uu := range upserts {
+		if result := uu.dialect.Upsert("prefs", []string{"userId"}, "userId", "settings"); result != uu.expected {
+			t.Errorf("recived %s, expected %s", result, uu.expected)
+		}
+	}
+}
+
+/**
+Perform the testing
+*/
+func TestUpsertVersion(t *testing.T) {
+
+	//Define the list of dialects we are testing
+	var upserts = []struct {
+		dialect  dialect.Dialect
+		expected string
+		missing  string
+	}{
+		{
+			dialect.MySql,
+			"INSERT INTO prefs(userId, settings, version) VALUES (?, ?, 1) ON DUPLICATE KEY UPDATE settings = VALUES(settings), version = prefs.version + 1",
+			"INSERT INTO prefs(userId, settings) VALUES (?, ?) ON DUPLICATE KEY UPDATE userId = userId",
+		},
+		{
+			dialect.Postgres,
+			"INSERT INTO prefs(userId, settings, version) VALUES ($1, $2, 1) ON CONFLICT (userId) DO UPDATE SET settings = excluded.settings, version = prefs.version + 1",
+			"INSERT INTO prefs(userId, settings) VALUES ($1, $2) ON CONFLICT (userId) DO NOTHING",
+		},
+		{
+			dialect.Sqlite,
+			"INSERT INTO prefs(userId, settings, version) VALUES (?, ?, 1) ON CONFLICT (userId) DO UPDATE SET settings = excluded.settings, version = prefs.version + 1",
+			"INSERT INTO prefs(userId, settings) VALUES (?, ?) ON CONFLICT (userId) DO NOTHING",
+		},
+	}
+
+	for _, uu := range upserts {
+		if result := uu.dialect.UpsertVersion("prefs", []string{"userId"}, "version", "userId", "settings"); result != uu.expected {
+			t.Errorf("recived %s, expected %s", result, uu.expected)
+		}
+		if result := uu.dialect.InsertIfMissing("prefs", []string{"userId"}, "userId", "settings"); result != uu.missing {
+			t.Errorf("recived %s, expected %s", result, uu.missing)
+		}
+	}
+}
+
+/**
+Perform the testing
+*/
+func TestParse(t *testing.T) {
+
+	//Define the list of names we are testing
+	var names = []struct {
+		name     string
+		expected dialect.Dialect
+		err      bool
+	}{
+		{"", dialect.MySql, false},
+		{"MySql", dialect.MySql, false},
+		{"postgresql", dialect.Postgres, false},
+		{"sqlite", dialect.Sqlite, false},
+		{"oracle", "", true},
+	}
+
+	for _, nn := range names {
+		result, err := dialect.Parse(nn.name)
+		if result != nn.expected || (err != nil) != nn.err {
+			t.Errorf("recived %s %v, expected %s", result, err, nn.expected)
+		}
+	}
+}
+
+/**
+Perform the testing
+*/
+func TestQueryError(t *testing.T) {
+	if err := dialect.QueryError(fmt.Errorf("query: %w", context.DeadlineExceeded)); !errors.Is(err, apierror.ErrTimeout) {
+		t.Errorf("recived %v, expected %v", err, apierror.ErrTimeout)
+	}
+	if err := dialect.QueryError(sql.ErrNoRows); err != sql.ErrNoRows {
+		t.Errorf("recived %v, expected %v", err, sql.ErrNoRows)
+	}
+	if err := dialect.QueryError(nil); err != nil {
+		t.Errorf("recived %v, expected nil", err)
+	}
+}
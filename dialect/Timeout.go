@@ -0,0 +1,33 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package dialect
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/reaction-eng/restlib/apierror"
+)
+
+/**
+Limit a single query to the timeout.  A zero timeout leaves the context alone, so the query still stops if the
+client goes away.
+*/
+func WithTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+/**
+Replace a deadline error with apierror.ErrTimeout so the handler returns a 504.  Every other error is returned as is
+*/
+func QueryError(err error) error {
+	if err != nil && errors.Is(err, context.DeadlineExceeded) {
+		return apierror.ErrTimeout.Wrap(err)
+	}
+	return err
+}
@@ -3,7 +3,10 @@
 
 package email
 
-import "github.com/reaction-eng/restlib/utils"
+import (
+	"context"
+	"github.com/reaction-eng/restlib/utils"
+)
 
 /**
 Simple struct for email
@@ -35,6 +38,14 @@ type Interface interface {
 	Send html email
 	*/
 	SendEmailTable(email *HeaderInfo, tableData TableInfo, attachments map[string][]*utils.Base64File) error
+
+	/**
+	The same as each method above but traced as part of the context
+	*/
+	SendEmailContext(ctx context.Context, email *HeaderInfo, body string, attachments map[string][]*utils.Base64File) error
+	SendEmailTemplateStringContext(ctx context.Context, email *HeaderInfo, templateString string, data interface{}, attachments map[string][]*utils.Base64File) error
+	SendEmailTemplateFileContext(ctx context.Context, email *HeaderInfo, templateString string, data interface{}, attachments map[string][]*utils.Base64File) error
+	SendEmailTableContext(ctx context.Context, email *HeaderInfo, tableData TableInfo, attachments map[string][]*utils.Base64File) error
 }
 
 /**
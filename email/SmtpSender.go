@@ -4,21 +4,29 @@
 package email
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/domodwyer/mailyak"
 	"github.com/reaction-eng/restlib/configuration"
+	"github.com/reaction-eng/restlib/tracing"
 	"github.com/reaction-eng/restlib/utils"
+	"go.opentelemetry.io/otel/attribute"
 	"html/template"
 	"log"
+	"net"
 	"net/smtp"
 	"path/filepath"
+	"sync"
 	"time"
 )
 
+//The config keys used by the sender
+var smtpConfigKeys = []string{"smtp_server", "smtp_port", "smtp_user", "smtp_password", "smtp_from"}
+
 /**
-Simple struct for email
+The smtp server settings, they are swapped as a whole when the config is reloaded
 */
-type SmtpSender struct {
+type smtpSettings struct {
 	smtpServer   string
 	smtpUser     string
 	smtpPassword string
@@ -26,6 +34,16 @@ type SmtpSender struct {
 	smtpPort     string
 }
 
+/**
+Simple struct for email
+*/
+type SmtpSender struct {
+	settings smtpSettings
+
+	//Protect the settings while they are reloaded
+	lock sync.RWMutex
+}
+
 //Provide a method to make a new AnimalRepoSql
 func NewSmtpSender(configFile ...string) *SmtpSender {
 
@@ -36,26 +54,93 @@ func NewSmtpSender(configFile ...string) *SmtpSender {
 		log.Fatal(err)
 	}
 
-	sender := SmtpSender{
-		smtpServer:   config.GetStringFatal("smtp_server"),
-		smtpPort:     config.GetStringFatal("smtp_port"),
-		smtpUser:     config.GetStringFatal("smtp_user"),
-		smtpPassword: config.GetStringFatal("smtp_password"),
-		smtpFrom:     config.GetStringFatal("smtp_from"),
+	settings, err := newSmtpSettings(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return &SmtpSender{
+		settings: settings,
+	}
+
+}
+
+/**
+Get the settings from the config.  Every key is required
+*/
+func newSmtpSettings(config *configuration.Configuration) (smtpSettings, error) {
+	values := make([]string, len(smtpConfigKeys))
+	for i, key := range smtpConfigKeys {
+		value, err := config.GetStringError(key)
+		if err != nil {
+			return smtpSettings{}, err
+		}
+		values[i] = value
 	}
 
-	return &sender
+	return smtpSettings{
+		smtpServer:   values[0],
+		smtpPort:     values[1],
+		smtpUser:     values[2],
+		smtpPassword: values[3],
+		smtpFrom:     values[4],
+	}, nil
+}
+
+/**
+Use the new smtp settings each time they change in the reloader, i.e. to rotate the password
+*/
+func (repo *SmtpSender) Subscribe(reloader *configuration.Reloader) {
+	reloader.Subscribe(smtpConfigKeys, func(config *configuration.Configuration) error {
+		settings, err := newSmtpSettings(config)
+		if err != nil {
+			return err
+		}
+
+		repo.lock.Lock()
+		defer repo.lock.Unlock()
+		repo.settings = settings
+		return nil
+	})
+}
+
+/**
+Get a copy of the current settings
+*/
+func (repo *SmtpSender) current() smtpSettings {
+	repo.lock.RLock()
+	defer repo.lock.RUnlock()
+	return repo.settings
+}
+
+/**
+Start a new mail with the server, auth and from address
+*/
+func (repo *SmtpSender) newMail() *mailyak.MailYak {
+	settings := repo.current()
+
+	// Create a new email - specify the SMTP host and auth
+	mail := mailyak.New(settings.smtpServer+settings.smtpPort,
+		smtp.PlainAuth("", settings.smtpUser, settings.smtpPassword, settings.smtpServer)) //authentication
+	mail.From(settings.smtpFrom)
 
+	return mail
 }
 
 /**
 Get all of the news
 */
 func (repo *SmtpSender) SendEmail(email *HeaderInfo, body string, attachments map[string][]*utils.Base64File) error {
+	return repo.SendEmailContext(context.Background(), email, body, attachments)
+}
 
-	// Create a new email - specify the SMTP host and auth
-	mail := mailyak.New(repo.smtpServer+repo.smtpPort,
-		smtp.PlainAuth("", repo.smtpUser, repo.smtpPassword, repo.smtpServer)) //authentication
+/**
+The same as SendEmail but traced as part of the context
+*/
+func (repo *SmtpSender) SendEmailContext(ctx context.Context, email *HeaderInfo, body string, attachments map[string][]*utils.Base64File) error {
+
+	// Create a new email
+	mail := repo.newMail()
 
 	//Set the to info
 	mail.To(email.To...)
@@ -64,7 +149,6 @@ func (repo *SmtpSender) SendEmail(email *HeaderInfo, body string, attachments ma
 		mail.Bcc(email.Bcc...)
 	}
 	mail.Subject(email.Subject)
-	mail.From(repo.smtpFrom)
 	if len(email.ReplyTo) > 0 {
 		mail.ReplyTo(email.ReplyTo)
 	}
@@ -73,8 +157,49 @@ func (repo *SmtpSender) SendEmail(email *HeaderInfo, body string, attachments ma
 	mail.Plain().Set(body)
 
 	//Now Send
-	return mail.Send()
+	return repo.send(ctx, mail, "email.SmtpSender.SendEmail", email)
+
+}
+
+/**
+Send the mail inside of a span so slow smtp servers show up in the traces
+*/
+func (repo *SmtpSender) send(ctx context.Context, mail *mailyak.MailYak, name string, email *HeaderInfo) error {
+	//Trace the send
+	_, span := tracing.StartClientSpan(ctx, name, "smtp",
+		attribute.String("net.peer.name", repo.current().smtpServer),
+		attribute.Int("email.recipients", len(email.To)+len(email.Bcc)),
+	)
+	defer span.End()
+
+	return tracing.RecordError(span, mail.Send())
+}
+
+/**
+Make sure that the smtp server is accepting connections
+*/
+func (repo *SmtpSender) CheckConnection(ctx context.Context) error {
+	//Dial the server
+	settings := repo.current()
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", settings.smtpServer+settings.smtpPort)
+	if err != nil {
+		return err
+	}
+
+	//Don't wait past the deadline for the greeting
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
 
+	//Make sure it talks smtp
+	client, err := smtp.NewClient(conn, settings.smtpServer)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	return client.Quit()
 }
 
 func formatInTimeZone(dateTime *time.Time, timeZone string, format string) string {
@@ -94,9 +219,15 @@ func formatInTimeZone(dateTime *time.Time, timeZone string, format string) strin
 Get all of the news
 */
 func (repo *SmtpSender) SendEmailTemplateString(email *HeaderInfo, templateString string, data interface{}, attachments map[string][]*utils.Base64File) error {
-	// Create a new email - specify the SMTP host and auth
-	mail := mailyak.New(repo.smtpServer+repo.smtpPort,
-		smtp.PlainAuth("", repo.smtpUser, repo.smtpPassword, repo.smtpServer)) //authentication
+	return repo.SendEmailTemplateStringContext(context.Background(), email, templateString, data, attachments)
+}
+
+/**
+The same as SendEmailTemplateString but traced as part of the context
+*/
+func (repo *SmtpSender) SendEmailTemplateStringContext(ctx context.Context, email *HeaderInfo, templateString string, data interface{}, attachments map[string][]*utils.Base64File) error {
+	// Create a new email
+	mail := repo.newMail()
 
 	//Set the to info
 	mail.To(email.To...)
@@ -105,7 +236,6 @@ func (repo *SmtpSender) SendEmailTemplateString(email *HeaderInfo, templateStrin
 		mail.Bcc(email.Bcc...)
 	}
 	mail.Subject(email.Subject)
-	mail.From(repo.smtpFrom)
 	if len(email.ReplyTo) > 0 {
 		mail.ReplyTo(email.ReplyTo)
 	}
@@ -144,16 +274,22 @@ func (repo *SmtpSender) SendEmailTemplateString(email *HeaderInfo, templateStrin
 	}
 
 	//Now Send
-	return mail.Send()
+	return repo.send(ctx, mail, "email.SmtpSender.SendEmailTemplateString", email)
 }
 
 /**
 Get all of the news
 */
 func (repo *SmtpSender) SendEmailTemplateFile(email *HeaderInfo, templateFile string, data interface{}, attachments map[string][]*utils.Base64File) error {
-	// Create a new email - specify the SMTP host and auth
-	mail := mailyak.New(repo.smtpServer+repo.smtpPort,
-		smtp.PlainAuth("", repo.smtpUser, repo.smtpPassword, repo.smtpServer)) //authentication
+	return repo.SendEmailTemplateFileContext(context.Background(), email, templateFile, data, attachments)
+}
+
+/**
+The same as SendEmailTemplateFile but traced as part of the context
+*/
+func (repo *SmtpSender) SendEmailTemplateFileContext(ctx context.Context, email *HeaderInfo, templateFile string, data interface{}, attachments map[string][]*utils.Base64File) error {
+	// Create a new email
+	mail := repo.newMail()
 
 	//Set the to info
 	mail.To(email.To...)
@@ -164,7 +300,6 @@ func (repo *SmtpSender) SendEmailTemplateFile(email *HeaderInfo, templateFile st
 	}
 
 	mail.Subject(email.Subject)
-	mail.From(repo.smtpFrom)
 	if len(email.ReplyTo) > 0 {
 		mail.ReplyTo(email.ReplyTo)
 	}
@@ -199,17 +334,23 @@ func (repo *SmtpSender) SendEmailTemplateFile(email *HeaderInfo, templateFile st
 	}
 
 	//Now Send
-	return mail.Send()
+	return repo.send(ctx, mail, "email.SmtpSender.SendEmailTemplateFile", email)
 }
 
 /**
 Get all of the news
 */
 func (repo *SmtpSender) SendEmailTable(email *HeaderInfo, tableData TableInfo, attachments map[string][]*utils.Base64File) error {
+	return repo.SendEmailTableContext(context.Background(), email, tableData, attachments)
+}
 
-	// Create a new email - specify the SMTP host and auth
-	mail := mailyak.New(repo.smtpServer+repo.smtpPort,
-		smtp.PlainAuth("", repo.smtpUser, repo.smtpPassword, repo.smtpServer)) //authentication
+/**
+The same as SendEmailTable but traced as part of the context
+*/
+func (repo *SmtpSender) SendEmailTableContext(ctx context.Context, email *HeaderInfo, tableData TableInfo, attachments map[string][]*utils.Base64File) error {
+
+	// Create a new email
+	mail := repo.newMail()
 
 	//Set the to info
 	mail.To(email.To...)
@@ -218,7 +359,6 @@ func (repo *SmtpSender) SendEmailTable(email *HeaderInfo, tableData TableInfo, a
 		mail.Bcc(email.Bcc...)
 	}
 	mail.Subject(email.Subject)
-	mail.From(repo.smtpFrom)
 	if len(email.ReplyTo) > 0 {
 		mail.ReplyTo(email.ReplyTo)
 	}
@@ -257,7 +397,7 @@ func (repo *SmtpSender) SendEmailTable(email *HeaderInfo, tableData TableInfo, a
 	}
 
 	//Now Send
-	return mail.Send()
+	return repo.send(ctx, mail, "email.SmtpSender.SendEmailTable", email)
 
 }
 
@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"errors"
 	"github.com/reaction-eng/restlib/configuration"
+	"github.com/reaction-eng/restlib/tracing"
 	"golang.org/x/net/context"
 	"golang.org/x/net/html"
 	"golang.org/x/oauth2/google"
@@ -32,6 +33,9 @@ type Drive struct {
 
 	//Store the timezone
 	timeZone string
+
+	//Keep the jwt config so the credentials can be checked
+	jwtConfig *jwt.Config
 }
 
 //Get a new interface
@@ -59,9 +63,13 @@ func NewDrive(configFiles ...string) *Drive {
 	//Build the connection
 	httpCon := jwtConfig.Client(context.Background())
 
+	//Trace every call made to google drive
+	httpCon.Transport = tracing.NewTransport(httpCon.Transport, "google.drive")
+
 	//Now build the drive service
 	driveConn, err := drive.New(httpCon)
 	gInter.connection = driveConn
+	gInter.jwtConfig = jwtConfig
 
 	//Check for errors
 	if err != nil {
@@ -72,6 +80,14 @@ func NewDrive(configFiles ...string) *Drive {
 	return gInter
 }
 
+/**
+Make sure the google credentials can still be used to get a token
+*/
+func (gog *Drive) CheckCredentials(ctx context.Context) error {
+	_, err := gog.jwtConfig.TokenSource(ctx).Token()
+	return err
+}
+
 //See if starts with a date and name
 func (gog *Drive) splitNameAndDate(nameIn string) (string, *time.Time) {
 
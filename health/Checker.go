@@ -0,0 +1,86 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package health
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/go-redis/redis"
+	"github.com/reaction-eng/restlib/email"
+	"github.com/reaction-eng/restlib/google"
+)
+
+/**
+Define an interface that all dependency checks must follow
+*/
+type Checker interface {
+	/**
+	The name reported in the readiness response
+	*/
+	Name() string
+
+	/**
+	Check the dependency.  The context carries the check timeout
+	*/
+	Check(ctx context.Context) error
+}
+
+/**
+Simple checker built from a function
+*/
+type funcChecker struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+/**
+Build a checker from any function
+*/
+func NewChecker(name string, check func(ctx context.Context) error) Checker {
+	return &funcChecker{
+		name:  name,
+		check: check,
+	}
+}
+
+func (checker *funcChecker) Name() string {
+	return checker.name
+}
+
+func (checker *funcChecker) Check(ctx context.Context) error {
+	return checker.check(ctx)
+}
+
+/**
+Check that the sql database can be reached
+*/
+func NewSqlChecker(name string, db *sql.DB) Checker {
+	return NewChecker(name, db.PingContext)
+}
+
+/**
+Check that every shard in the redis ring can be reached
+*/
+func NewRedisChecker(name string, ring *redis.Ring) Checker {
+	return NewChecker(name, func(ctx context.Context) error {
+		return ring.ForEachShard(func(client *redis.Client) error {
+			return client.WithContext(ctx).Ping().Err()
+		})
+	})
+}
+
+/**
+Check that the smtp server is accepting connections
+*/
+func NewSmtpChecker(name string, sender *email.SmtpSender) Checker {
+	return NewChecker(name, sender.CheckConnection)
+}
+
+/**
+Check that the google credentials can still be used to get a token
+*/
+func NewGoogleChecker(name string, drive *google.Drive) Checker {
+	return NewChecker(name, drive.CheckCredentials)
+}
@@ -0,0 +1,224 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package health
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/reaction-eng/restlib/routing"
+	"github.com/reaction-eng/restlib/utils"
+)
+
+/**
+Define the possible statuses
+*/
+const (
+	StatusOk   = "ok"
+	StatusFail = "fail"
+)
+
+/**
+The result of a single dependency check
+*/
+type CheckResult struct {
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	Duration  string    `json:"duration"`
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+/**
+The full response for the health routes
+*/
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+/**
+ * Serves the liveness and readiness routes
+ */
+type Handler struct {
+	//Store the registered checkers
+	checkers []Checker
+
+	//How long each check can take
+	timeout time.Duration
+
+	//How long a result can be reused
+	cacheDuration time.Duration
+
+	//Keep the last result of each checker
+	results     map[string]CheckResult
+	resultsLock sync.Mutex
+}
+
+/**
+ * Build a new health handler.  Each check must complete within the timeout and results are reused for the cacheDuration
+ */
+func NewHandler(timeout time.Duration, cacheDuration time.Duration, checkers ...Checker) *Handler {
+	return &Handler{
+		checkers:      checkers,
+		timeout:       timeout,
+		cacheDuration: cacheDuration,
+		results:       make(map[string]CheckResult),
+	}
+}
+
+/**
+Add another checker to the readiness check
+*/
+func (handler *Handler) Register(checker Checker) {
+	handler.resultsLock.Lock()
+	defer handler.resultsLock.Unlock()
+
+	handler.checkers = append(handler.checkers, checker)
+}
+
+/**
+Function used to get routes
+*/
+func (handler *Handler) GetRoutes() []routing.Route {
+	return []routing.Route{
+		{
+			Name:        "Health Liveness",
+			Method:      "GET",
+			Pattern:     "/healthz",
+			HandlerFunc: handler.handleLiveness,
+			Public:      true,
+			Description: "Returns ok if the server can respond.",
+			Tags:        []string{"health"},
+			Response:    Report{},
+		},
+		{
+			Name:        "Health Readiness",
+			Method:      "GET",
+			Pattern:     "/readyz",
+			HandlerFunc: handler.handleReadiness,
+			Public:      true,
+			Description: "Checks each dependency.  Returns 503 if any check fails.",
+			Tags:        []string{"health"},
+			Response:    Report{},
+		},
+	}
+}
+
+/**
+If we can respond we are alive
+*/
+func (handler *Handler) handleLiveness(w http.ResponseWriter, r *http.Request) {
+	utils.ReturnJson(w, http.StatusOK, Report{Status: StatusOk})
+}
+
+/**
+Run all of the checks and report each dependency
+*/
+func (handler *Handler) handleReadiness(w http.ResponseWriter, r *http.Request) {
+	//Check everything
+	report := handler.Check(r.Context())
+
+	//Let the load balancer know if we are not ready
+	if report.Status == StatusOk {
+		utils.ReturnJson(w, http.StatusOK, report)
+	} else {
+		utils.ReturnJson(w, http.StatusServiceUnavailable, report)
+	}
+}
+
+/**
+Run each of the registered checks in parallel, reusing any cached results
+*/
+func (handler *Handler) Check(ctx context.Context) Report {
+	//Copy the list of checkers so we are not locked while checking
+	handler.resultsLock.Lock()
+	checkers := make([]Checker, len(handler.checkers))
+	copy(checkers, handler.checkers)
+	handler.resultsLock.Unlock()
+
+	//Build the report
+	report := Report{
+		Status: StatusOk,
+		Checks: make(map[string]CheckResult, len(checkers)),
+	}
+	var reportLock sync.Mutex
+
+	//Check each in parallel
+	var wg sync.WaitGroup
+	for _, checker := range checkers {
+		wg.Add(1)
+		go func(checker Checker) {
+			defer wg.Done()
+
+			//Get the result
+			result := handler.checkOne(ctx, checker)
+
+			//Store it
+			reportLock.Lock()
+			report.Checks[checker.Name()] = result
+			if result.Status != StatusOk {
+				report.Status = StatusFail
+			}
+			reportLock.Unlock()
+		}(checker)
+	}
+	wg.Wait()
+
+	return report
+}
+
+/**
+Run a single check, or return the cached result if it is still fresh
+*/
+func (handler *Handler) checkOne(ctx context.Context, checker Checker) CheckResult {
+	//See if there is a fresh result
+	handler.resultsLock.Lock()
+	cached, found := handler.results[checker.Name()]
+	handler.resultsLock.Unlock()
+
+	if found && time.Since(cached.CheckedAt) < handler.cacheDuration {
+		return cached
+	}
+
+	//Limit how long this check can take
+	checkCtx, cancel := context.WithTimeout(ctx, handler.timeout)
+	defer cancel()
+
+	//Run the check in the background so a check that ignores the context can't hang the request
+	start := time.Now()
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- checker.Check(checkCtx)
+	}()
+
+	//Wait for the check or the timeout
+	var err error
+	select {
+	case err = <-errChan:
+	case <-checkCtx.Done():
+		err = checkCtx.Err()
+	}
+
+	//Build the result
+	result := CheckResult{
+		Status:    StatusOk,
+		Duration:  time.Since(start).String(),
+		CheckedAt: time.Now(),
+	}
+	if err != nil {
+		result.Status = StatusFail
+		result.Error = err.Error()
+	}
+
+	//Save it for next time, unless the request went away since that says nothing about the dependency
+	if ctx.Err() == nil {
+		handler.resultsLock.Lock()
+		handler.results[checker.Name()] = result
+		handler.resultsLock.Unlock()
+	}
+
+	return result
+}
@@ -0,0 +1,127 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package health_test
+
+import (
+	"context"
+	"errors"
+	"github.com/reaction-eng/restlib/health"
+	"github.com/reaction-eng/restlib/routing"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+/**
+Perform the testing
+*/
+func TestHandlerRoutes(t *testing.T) {
+	//Define the list of checks we are testing
+	var checks = []struct {
+		name         string
+		err          error
+		expectedCode int
+	}{
+		{"healthy", nil, http.StatusOK},
+		{"unhealthy", errors.New("down"), http.StatusServiceUnavailable},
+	}
+
+	for _, test := range checks {
+		err := test.err
+		handler := health.NewHandler(time.Second, 0, health.NewChecker("db", func(ctx context.Context) error { return err }))
+		router := routing.NewRouter(nil, nil, nil, handler)
+
+		//Liveness never checks the dependencies
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("recived %d for %s liveness, expected %d", rec.Code, test.name, http.StatusOK)
+		}
+
+		rec = httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+		if rec.Code != test.expectedCode {
+			t.Errorf("recived %d for %s readiness, expected %d", rec.Code, test.name, test.expectedCode)
+		}
+	}
+}
+
+/**
+Perform the testing
+*/
+func TestHandlerCache(t *testing.T) {
+	var calls int32
+	checker := health.NewChecker("db", func(ctx context.Context) error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	})
+	handler := health.NewHandler(time.Second, time.Hour, checker)
+
+	//The second check should be cached
+	for i := 0; i < 2; i++ {
+		if report := handler.Check(context.Background()); report.Status != health.StatusOk {
+			t.Errorf("recived %s, expected %s", report.Status, health.StatusOk)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("recived %d calls, expected 1", calls)
+	}
+}
+
+/**
+Perform the testing
+*/
+func TestHandlerTimeout(t *testing.T) {
+	//The check ignores the context and never returns in time
+	var calls int32
+	checker := health.NewChecker("slow", func(ctx context.Context) error {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(time.Second)
+		return nil
+	})
+	handler := health.NewHandler(10*time.Millisecond, time.Hour, checker)
+
+	//A timeout is a real failure so it is cached
+	for i := 0; i < 2; i++ {
+		if report := handler.Check(context.Background()); report.Status != health.StatusFail || report.Checks["slow"].Error != context.DeadlineExceeded.Error() {
+			t.Errorf("recived %+v, expected the check to time out", report)
+		}
+	}
+	if calls := atomic.LoadInt32(&calls); calls != 1 {
+		t.Errorf("recived %d calls, expected 1", calls)
+	}
+}
+
+/**
+Perform the testing
+*/
+func TestHandlerCancelledRequest(t *testing.T) {
+	var healthy int32
+	checker := health.NewChecker("db", func(ctx context.Context) error {
+		if atomic.LoadInt32(&healthy) == 1 {
+			return nil
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	handler := health.NewHandler(time.Second, time.Hour, checker)
+
+	//The client goes away in the middle of the check
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	if report := handler.Check(ctx); report.Status != health.StatusFail {
+		t.Errorf("recived %s, expected %s", report.Status, health.StatusFail)
+	}
+
+	//The failure should not have been cached
+	atomic.StoreInt32(&healthy, 1)
+	if report := handler.Check(context.Background()); report.Status != health.StatusOk {
+		t.Errorf("recived %+v, expected the cancelled failure to be forgotten", report)
+	}
+}
@@ -4,129 +4,166 @@
 package main
 
 import (
+	"context"
 	"database/sql"
-	"fmt"
-	_ "github.com/go-sql-driver/mysql"
-	"github.com/reaction-eng/restlib/notification"
-	"github.com/reaction-eng/restlib/stl"
-	"github.com/reaction-eng/restlib/users"
+	"flag"
 	"log"
 	"os"
 	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/reaction-eng/restlib/configuration"
+	"github.com/reaction-eng/restlib/dialect"
+	"github.com/reaction-eng/restlib/email"
+	"github.com/reaction-eng/restlib/health"
+	"github.com/reaction-eng/restlib/middleware"
+	"github.com/reaction-eng/restlib/passwords"
+	"github.com/reaction-eng/restlib/roles"
+	"github.com/reaction-eng/restlib/routing"
+	"github.com/reaction-eng/restlib/server"
+	"github.com/reaction-eng/restlib/tracing"
+	"github.com/reaction-eng/restlib/transaction"
+	"github.com/reaction-eng/restlib/users"
 )
 
-//Define the global variables that are setup in the main
-//var calcsRepo calcs.Repo
+//The tables used by the server
+const (
+	usersTable         = "users"
+	rolesTable         = "roles"
+	resetRequestsTable = "resetrequests"
+)
 
-func main2() {
+/**
+Run a basic user server.  Each argument is a config file, later files override earlier ones, i.e.
+	restlib config.json config.mysql.json
+Secrets such as db_password can be references like env:DB_PASSWORD, file:///run/secrets/db_password or
+keystore:db_password.  The db_dialect is mysql or sqlite3, which stores everything in the db_file.  Each query
+is limited to db_query_timeout seconds if it is set.  Use the migrate command to manage the schema, i.e.
+	restlib migrate status config.json
+*/
+func main() {
+	flag.Parse()
 
-	file, err := os.Open("/Users/mcgurn/Desktop/TorusAscii.stl")
-	//file, err := os.Open("/Users/mcgurn/Downloads/AirAssis_mod_si.stl")
+	//Run the migrate command
+	if flag.Arg(0) == "migrate" {
+		runMigrate(flag.Args()[1:])
+		return
+	}
 
-	if err != nil {
-		log.Fatal(err)
+	//Load the config and database
+	configFiles := defaultConfigFiles(flag.Args())
+	config, sqlDialect, db := openDatabase(configFiles)
+
+	//Start tracing
+	tracer := tracing.NewProvider(configFiles...)
+
+	//Build the repos
+	emailer := email.NewSmtpSender(configFiles...)
+	passHelper := passwords.NewBasicHelper(configFiles...)
+	userRepo := users.NewRepoSql(db, sqlDialect, usersTable)
+	resetRepo := passwords.NewRepoSql(db, sqlDialect, resetRequestsTable, emailer, configFiles[0])
+	roleRepo := roles.NewRepoSql(db, sqlDialect, rolesTable, roles.NewPermissionTableJson(config.GetStringFatal("permissions_file")))
+	userHelper := users.NewUserHelper(userRepo, resetRepo, passHelper)
+	userHelper.UseTransactions(transaction.NewSqlManager(db))
+
+	//Give each new user the default roles in the same transaction
+	if config.Get("default_roles") != nil {
+		defaultRoles := config.GetStringArray("default_roles")
+		userHelper.OnUserCreated(func(ctx context.Context, user users.User) error {
+			return roleRepo.SetRolesByNameContext(ctx, user, defaultRoles)
+		})
 	}
-	defer file.Close()
 
-	stlMesh, err := stl.ReadMesh(file)
+	//Limit how long each query can run
+	if seconds, err := config.GetInt("db_query_timeout"); err == nil {
+		queryTimeout := time.Duration(seconds) * time.Second
+		userRepo.SetQueryTimeout(queryTimeout)
+		resetRepo.SetQueryTimeout(queryTimeout)
+		roleRepo.SetQueryTimeout(queryTimeout)
+	}
 
-	//Now try writing it
-	// Write the body to file
-	out, err := os.Create("/Users/mcgurn/Downloads/output.stl")
-	if err != nil {
+	//Build the server
+	cors := middleware.NewCorsPolicy(configFiles...)
+	srv := server.NewServer(config, cors.OptionsHandler(), routing.SimpleLogger)
+	srv.Mount(
+		users.NewHandler(userHelper, config.GetBool("allow_user_creation", false)),
+		roles.NewHandler(userRepo, roleRepo),
+		health.NewHandler(5*time.Second, 10*time.Second, health.NewSqlChecker("database", db)),
+	)
+	srv.Router.AddOpenApiRoutes("restlib", "1.0", true)
+
+	//Add the middleware
+	srv.Use(server.StageSecurity, middleware.MakeSecurityMiddlewareFunc(middleware.NewSecurityPolicy(configFiles...)))
+	srv.Use(server.StageCors, middleware.MakeCorsPolicyMiddlewareFunc(cors))
+	srv.Use(server.StageAuth, middleware.MakeJwtMiddlewareFunc(srv.Router, userRepo, roleRepo, passHelper, userHelper.SessionCookie()))
+
+	//Clean up everything when the server stops
+	srv.Manage(
+		tracer,
+		server.CleanUpFunc(func() { db.Close() }),
+		userRepo,
+		resetRepo,
+		roleRepo,
+	)
+
+	//Run until stopped
+	if err := srv.Run(); err != nil {
 		log.Fatal(err)
 	}
-	defer out.Close()
-
-	//Output the mesh
-	stlMesh.WriteMeshBinary(out)
-
-	fmt.Println(stlMesh)
-	fmt.Println(err)
-
 }
 
-func main3() {
-
-	//Layout some points
-	pts := []stl.Vertex{
-		{0.0, 0.0, 0.0},
-		{0.5, 0.5, 0.0},
-		{.75, 0.75, 0.0},
-		{.3, 0.9, 0.0},
-		{.6, 1.3, 0.0},
-		{.9, 1.3, 0.0},
-		{.9, 1.6, 0.0},
-		{0.0, 1.6, 0.0},
+/**
+Use config.json if no config files are given
+*/
+func defaultConfigFiles(configFiles []string) []string {
+	if len(configFiles) == 0 {
+		return []string{"config.json"}
 	}
+	return configFiles
+}
 
-	incPts, _ := stl.IncreaseLineResolution(pts, 0.1, 1.0)
-	//incPts := pts
-	mesh, _ := stl.RotateAndCreateMesh(incPts, 10)
-
-	//Now try writing it
-	// Write the body to file
-	out, err := os.Create("/Users/mcgurn/Downloads/output.stl")
-	if err != nil {
-		log.Fatal(err)
+/**
+Load the config and open the database
+*/
+func openDatabase(configFiles []string) (*configuration.Configuration, dialect.Dialect, *sql.DB) {
+	//Resolve keystore:name secrets if there is a master key
+	if masterKey := os.Getenv("RESTLIB_MASTER_KEY"); len(masterKey) > 0 {
+		keystoreFile := os.Getenv("RESTLIB_KEYSTORE")
+		if len(keystoreFile) == 0 {
+			keystoreFile = "config.keystore.json"
+		}
+		keystore, err := configuration.NewKeystore(keystoreFile, masterKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+		configuration.RegisterSecretProvider(keystore)
 	}
-	defer out.Close()
 
-	//Now try writing it
-	// Write the body to file
-	ptsout, err := os.Create("/Users/mcgurn/Downloads/output.pts")
+	//Load in the config
+	config, err := configuration.NewConfiguration(configFiles...)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer out.Close()
 
-	//Now try writing it
-	// Write the body to file
-	triout, err := os.Create("/Users/mcgurn/Downloads/output.tri")
+	//Get the dialect
+	sqlDialect, err := dialect.Parse(config.GetString("db_dialect"))
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer out.Close()
-
-	//Output the mesh
-	mesh.WriteMeshAscii(out)
-	mesh.WriteUintahPts(ptsout)
-	mesh.WriteUintahTri(triout)
-
-}
-
-func main() {
 
-	greetingNotif := notification.Notification{
-		Message:    "Sup my man!",
-		Priority:   5,
-		Expiration: time.Now(),
-		Send:       time.Now(),
-		UserID:     1,
+	//Connect to the database
+	dataSourceName := config.GetMySqlDataBaseSourceName()
+	switch sqlDialect {
+	case dialect.Postgres:
+		dataSourceName = config.GetPostgresDataBaseSourceName()
+	case dialect.Sqlite:
+		dataSourceName = config.GetSqliteDataBaseSourceName()
 	}
-
-	//userGrant := users.BasicUser{
-	//	Id_:2,
-	//	Email_:"keller@reaction-eng.com",
-	//	Token_:"1234567890",
-	//}
-	//config, _ := configuration.NewConfiguration("config.mysql.json", "config.host.json")
-	localSql, err := sql.Open("mysql", "root:P1p3sh0p@tcp(:3306)/localDB?parseTime=true") //"root:P1p3sh0p@tcp(:3306)/localDB?parseTime=true"
-
-	sqlConnectiont := users.NewRepoMySql(localSql, "users")
-
-	//include some kind of db call to get email to who'm we send to
-	userG, err := sqlConnectiont.GetUser(2)
-
+	db, err := sql.Open(sqlDialect.DriverName(), dataSourceName)
 	if err != nil {
-		log.Println("Getting user through error. ", err.Error())
+		log.Fatal(err)
 	}
 
-	dumNotifier := notification.NewDummyNotifier()
-	webNotif := notification.NewWebPushNotifier("config.deleteME.json", localSql)
-	//mailNotifier := Notification.NewEmailNotifier()
-
-	err = dumNotifier.Notify(greetingNotif, userG)
-	err = webNotif.Notify(greetingNotif, userG)
-
+	return config, sqlDialect, db
 }
@@ -0,0 +1,219 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package middleware
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/reaction-eng/restlib/configuration"
+)
+
+/**
+Define the cross origin policy.  Origins can be exact (https://app.example.com), a wildcard subdomain
+(https://*.example.com) or * to allow any origin.  * can not be used with AllowCredentials, since that would let
+any site make requests with the user's cookies.
+*/
+type CorsPolicy struct {
+	AllowedOrigins   []string `json:"allowedOrigins"`
+	AllowedMethods   []string `json:"allowedMethods"`
+	AllowedHeaders   []string `json:"allowedHeaders"`
+	ExposedHeaders   []string `json:"exposedHeaders"`
+	AllowCredentials bool     `json:"allowCredentials"`
+
+	//How long in seconds the browser can cache the preflight, 0 leaves it up to the browser
+	MaxAge int `json:"maxAge"`
+}
+
+/**
+Load the policy from the cors key in the configuration, i.e.
+	"cors": {
+		"allowedOrigins": ["https://app.example.com", "https://*.example.com"],
+		"allowCredentials": true,
+		"maxAge": 600
+	}
+Methods and headers default to the values used by MakeCORSMiddlewareFunc.
+*/
+func NewCorsPolicy(configFiles ...string) *CorsPolicy {
+	//Load in the config
+	config, err := configuration.NewConfiguration(configFiles...)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	//Get the policy
+	policy := &CorsPolicy{}
+	if err := config.GetStruct("cors", policy); err != nil {
+		log.Fatal("invalid cors configuration: ", err)
+	}
+
+	//Fill in the defaults
+	if len(policy.AllowedMethods) == 0 {
+		policy.AllowedMethods = []string{"GET", "POST", "PATCH", "PUT", "DELETE", "OPTIONS"}
+	}
+	if len(policy.AllowedHeaders) == 0 {
+		policy.AllowedHeaders = []string{"Origin", "Authorization", "Content-Type", "X-Ijt", "X-Auth-Token", "X-Requested-With"}
+	}
+
+	//Make sure the policy is safe
+	if err := policy.Validate(); err != nil {
+		log.Fatal("invalid cors configuration: ", err)
+	}
+
+	return policy
+}
+
+/**
+Make sure the policy can be used
+*/
+func (policy *CorsPolicy) Validate() error {
+	if policy.AllowCredentials && containsFold(policy.AllowedOrigins, "*") {
+		return errors.New("allowCredentials can not be used with the * origin, list each allowed origin instead")
+	}
+	return nil
+}
+
+/**
+Define a function to add the cors headers to each request.  Preflight requests are answered here.
+*/
+func MakeCorsPolicyMiddlewareFunc(policy *CorsPolicy) mux.MiddlewareFunc {
+	//Policies built in code skip NewCorsPolicy, so check them here too
+	if err := policy.Validate(); err != nil {
+		log.Fatal("invalid cors policy: ", err)
+	}
+
+	return func(next http.Handler) http.Handler {
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			//Answer any preflight
+			if isPreflight(r) {
+				policy.handlePreflight(w, r)
+				return
+			}
+
+			//Add the headers for the real request
+			policy.setOriginHeaders(w, r)
+			if origin := r.Header.Get("Origin"); len(origin) > 0 && policy.allowedOrigin(origin) && len(policy.ExposedHeaders) > 0 {
+				w.Header().Set("Access-Control-Expose-Headers", strings.Join(policy.ExposedHeaders, ", "))
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+/**
+Get a handler that can be passed to routing.NewRouter as the optionsHandler to answer preflight requests
+*/
+func (policy *CorsPolicy) OptionsHandler() http.HandlerFunc {
+	return policy.handlePreflight
+}
+
+/**
+Check to see if this is a cors preflight
+*/
+func isPreflight(r *http.Request) bool {
+	return r.Method == "OPTIONS" && len(r.Header.Get("Origin")) > 0 && len(r.Header.Get("Access-Control-Request-Method")) > 0
+}
+
+/**
+Answer the preflight.  If the request is not allowed no cors headers are set and the browser blocks it
+*/
+func (policy *CorsPolicy) handlePreflight(w http.ResponseWriter, r *http.Request) {
+	//Check the origin
+	allowed := policy.setOriginHeaders(w, r)
+
+	//The answer also depends on the requested method and headers
+	w.Header().Add("Vary", "Access-Control-Request-Method")
+	w.Header().Add("Vary", "Access-Control-Request-Headers")
+
+	//Check the method
+	method := strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))
+	if allowed && containsFold(policy.AllowedMethods, method) {
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(policy.AllowedMethods, ", "))
+
+		//If any header is allowed echo back the ones requested
+		if containsFold(policy.AllowedHeaders, "*") {
+			if requested := r.Header.Get("Access-Control-Request-Headers"); len(requested) > 0 {
+				w.Header().Set("Access-Control-Allow-Headers", requested)
+			}
+		} else {
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(policy.AllowedHeaders, ", "))
+		}
+
+		if policy.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(policy.MaxAge))
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+/**
+Set the allow origin and credentials headers.  Returns true if the origin is allowed
+*/
+func (policy *CorsPolicy) setOriginHeaders(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+
+	//The response depends on the origin
+	w.Header().Add("Vary", "Origin")
+
+	//Not a cors request or not allowed
+	if len(origin) == 0 || !policy.allowedOrigin(origin) {
+		return false
+	}
+
+	//Credentialed requests only match listed origins, so * is only sent without them
+	if !policy.AllowCredentials && containsFold(policy.AllowedOrigins, "*") {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+	if policy.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	return true
+}
+
+/**
+Check the origin against each of the allowed origins.  * never matches when credentials are allowed
+*/
+func (policy *CorsPolicy) allowedOrigin(origin string) bool {
+	origin = strings.ToLower(origin)
+
+	for _, allowed := range policy.AllowedOrigins {
+		allowed = strings.ToLower(allowed)
+
+		//Check for a wildcard subdomain
+		if loc := strings.Index(allowed, "*"); loc >= 0 && allowed != "*" {
+			prefix := allowed[:loc]
+			suffix := allowed[loc+1:]
+			if len(origin) > len(prefix)+len(suffix) && strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) &&
+				!strings.ContainsAny(origin[len(prefix):len(origin)-len(suffix)], "/:") {
+				return true
+			}
+		} else if (allowed == "*" && !policy.AllowCredentials) || allowed == origin {
+			return true
+		}
+	}
+
+	return false
+}
+
+/**
+Case insensitive check to see if the list has the value
+*/
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package middleware_test
+
+import (
+	"github.com/reaction-eng/restlib/middleware"
+	"github.com/reaction-eng/restlib/routing"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+/**
+Perform the testing
+*/
+func TestCorsPolicy(t *testing.T) {
+	//Load the policy from a config string
+	policy := middleware.NewCorsPolicy(`{"cors": {"allowedOrigins": ["https://app.example.com", "https://*.example.org"], "allowCredentials": true, "maxAge": 600}}`)
+
+	//Build a router that uses it
+	router := routing.NewRouter(policy.OptionsHandler(), []routing.Route{
+		{Name: "Get", Method: "GET", Pattern: "/thing", HandlerFunc: func(w http.ResponseWriter, r *http.Request) {}, Public: true},
+	}, nil)
+	router.Use(middleware.MakeCorsPolicyMiddlewareFunc(policy))
+
+	//Define the list of requests
+	var requests = []struct {
+		method         string
+		origin         string
+		requestMethod  string
+		expectedOrigin string
+		expectedCode   int
+	}{
+		{"GET", "https://app.example.com", "", "https://app.example.com", http.StatusOK},
+		{"GET", "https://api.example.org", "", "https://api.example.org", http.StatusOK},
+		{"GET", "https://example.org", "", "", http.StatusOK},
+		{"GET", "https://evil.com", "", "", http.StatusOK},
+		{"OPTIONS", "https://app.example.com", "PUT", "https://app.example.com", http.StatusNoContent},
+		{"OPTIONS", "https://evil.com", "PUT", "", http.StatusNoContent},
+	}
+
+	for _, rr := range requests {
+		req, err := http.NewRequest(rr.method, "/thing", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Origin", rr.origin)
+		if len(rr.requestMethod) > 0 {
+			req.Header.Set("Access-Control-Request-Method", rr.requestMethod)
+		}
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		//Check the result
+		if rec.Result().StatusCode != rr.expectedCode {
+			t.Errorf("%s from %s recived status code %d, expected %d", rr.method, rr.origin, rec.Result().StatusCode, rr.expectedCode)
+		}
+		if origin := rec.Header().Get("Access-Control-Allow-Origin"); origin != rr.expectedOrigin {
+			t.Errorf("%s from %s recived origin %s, expected %s", rr.method, rr.origin, origin, rr.expectedOrigin)
+		}
+		if rec.Header().Get("Vary") != "Origin" {
+			t.Errorf("%s from %s expected Vary: Origin", rr.method, rr.origin)
+		}
+		if len(rr.expectedOrigin) > 0 && rec.Header().Get("Access-Control-Allow-Credentials") != "true" {
+			t.Errorf("%s from %s expected credentials", rr.method, rr.origin)
+		}
+	}
+}
+
+/**
+Perform the testing
+*/
+func TestCorsPolicyValidate(t *testing.T) {
+
+	//Define the list of policies we are testing
+	var policies = []struct {
+		name        string
+		policy      middleware.CorsPolicy
+		expectedErr bool
+	}{
+		{"any origin", middleware.CorsPolicy{AllowedOrigins: []string{"*"}}, false},
+		{"listed with credentials", middleware.CorsPolicy{AllowedOrigins: []string{"https://app.example.com"}, AllowCredentials: true}, false},
+		{"any origin with credentials", middleware.CorsPolicy{AllowedOrigins: []string{"https://app.example.com", "*"}, AllowCredentials: true}, true},
+	}
+
+	for _, pp := range policies {
+		if err := pp.policy.Validate(); (err != nil) != pp.expectedErr {
+			t.Errorf("recived %v for %s, expected an error %t", err, pp.name, pp.expectedErr)
+		}
+	}
+}
+
+/**
+Perform the testing
+*/
+func TestCorsPolicyAnyOrigin(t *testing.T) {
+	policy := middleware.NewCorsPolicy(`{"cors": {"allowedOrigins": ["*"]}}`)
+	handler := middleware.MakeCorsPolicyMiddlewareFunc(policy)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	//Any origin gets * and never the credentials
+	req := httptest.NewRequest("GET", "/thing", nil)
+	req.Header.Set("Origin", "https://anywhere.com")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if origin := rec.Header().Get("Access-Control-Allow-Origin"); origin != "*" {
+		t.Errorf("recived origin %s, expected *", origin)
+	}
+	if credentials := rec.Header().Get("Access-Control-Allow-Credentials"); len(credentials) > 0 {
+		t.Errorf("recived credentials %s, expected none", credentials)
+	}
+}
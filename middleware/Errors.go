@@ -0,0 +1,17 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/reaction-eng/restlib/apierror"
+)
+
+/**
+Define the errors returned by the middleware
+*/
+var (
+	ErrCsrfTokenInvalid = apierror.New(http.StatusForbidden, "csrf_token_invalid")
+)
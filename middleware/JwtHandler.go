@@ -4,21 +4,40 @@
 package middleware
 
 import (
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/reaction-eng/restlib/apierror"
+	"github.com/reaction-eng/restlib/auth"
 	"github.com/reaction-eng/restlib/passwords"
 	"github.com/reaction-eng/restlib/roles"
 	"github.com/reaction-eng/restlib/routing"
 	"github.com/reaction-eng/restlib/users"
 	"github.com/reaction-eng/restlib/utils"
-	"github.com/gorilla/mux"
-	"golang.org/x/net/context"
 	"net/http"
-	"strings"
 )
 
 /**
-Define a function to handle checking for auth
+Define a function to handle checking for auth.  The token is read from the websocket protocol or the Authorization
+header.  If the user helper has a session cookie pass it in, i.e. userHelper.SessionCookie(), and the token is also
+read from the cookie
 */
-func MakeJwtMiddlewareFunc(router *routing.Router, userRepo users.Repo, permRepo roles.Repo, passHelper passwords.Helper) mux.MiddlewareFunc {
+func MakeJwtMiddlewareFunc(router *routing.Router, userRepo users.Repo, permRepo roles.Repo, passHelper passwords.Helper, sessionCookies ...*users.SessionCookie) mux.MiddlewareFunc {
+	extractors := []TokenExtractor{NewWebsocketTokenExtractor(), NewHeaderTokenExtractor()}
+
+	//The cookie is checked last so an explicit header always wins
+	for _, sessionCookie := range sessionCookies {
+		if sessionCookie != nil {
+			extractors = append(extractors, NewCookieTokenExtractor(sessionCookie.Name))
+		}
+	}
+
+	return MakeJwtMiddlewareFuncWithExtractors(router, userRepo, permRepo, passHelper, extractors...)
+}
+
+/**
+Define a function to handle checking for auth.  Each extractor is tried in order and the first token found is used
+*/
+func MakeJwtMiddlewareFuncWithExtractors(router *routing.Router, userRepo users.Repo, permRepo roles.Repo, passHelper passwords.Helper, extractors ...TokenExtractor) mux.MiddlewareFunc {
 
 	//Return an instance
 	return func(next http.Handler) http.Handler {
@@ -38,56 +57,60 @@ func MakeJwtMiddlewareFunc(router *routing.Router, userRepo users.Repo, permRepo
 			//If the route was not found return
 			if route == nil {
 				//Return the error
-				utils.ReturnJsonStatus(w, http.StatusForbidden, false, "")
+				utils.ReturnError(w, apierror.ErrForbidden)
 				return
 			}
 
-			//tokenHeader will get set here if we have a websocket. If this isn't a websocket, tokenHeader will be ""
-			//var tokenHeader string
-			tokenHeader := r.Header.Get("Sec-Websocket-Protocol")
-
-			//if true, it's not a websocket. If it has something, we are dealing with a websocket.
-			if tokenHeader == "" {
+			//check if request does not need middleware, serve the request if it doesn't need it
+			if route.Public {
+				//Just serve it
+				next.ServeHTTP(w, r)
+				return
+			}
 
-				//check if request does not need middleware, serve the request if it doesn't need it
-				if route.Public {
-					//Just serve it
-					next.ServeHTTP(w, r)
-					return
+			//Get the token from the first extractor that has one
+			tokenHeader := ""
+			method := auth.MethodJwt
+			for _, extractor := range extractors {
+				if tokenHeader = extractor.ExtractToken(r); tokenHeader != "" {
+					if _, isCookie := extractor.(*CookieTokenExtractor); isCookie {
+						method = auth.MethodCookie
+					}
+					break
 				}
-				//Get the header for auth
-				tokenHeader = r.Header.Get("Authorization") //Grab the token from the header
-			} else {
-				tokenHeader = strings.Replace(tokenHeader, "_Space_", " ", -1)
-				locOfComma := strings.Index(tokenHeader, ",")
-				tokenHeader = tokenHeader[0:locOfComma]
 			}
 
-			//Validate and get the user id
-			userId, tokenEmail, err := passHelper.ValidateToken(tokenHeader)
+			//Validate and get the claims
+			token, err := passHelper.ParseToken(tokenHeader)
 
 			//If there is an error return
 			if err != nil {
 				//Return the error
-				utils.ReturnJsonError(w, http.StatusForbidden, err)
+				utils.ReturnError(w, err)
 
 				return
 			}
 
 			//Now look up the user by id
-			loggedInUser, err := userRepo.GetUser(userId)
+			loggedInUser, err := userRepo.GetUserContext(r.Context(), token.UserId)
+
+			//A slow database is not a bad token
+			if errors.Is(err, apierror.ErrTimeout) {
+				utils.ReturnError(w, err)
+				return
+			}
 
 			//If there is an error return
 			if err != nil {
-				//Return the error
-				utils.ReturnJsonError(w, http.StatusForbidden, err)
+				//The token was valid but the user is gone
+				utils.ReturnError(w, passwords.ErrForbiddenToken.Wrap(err))
 
 				return
 			}
 			//Make sure the emails match in the token and logged in user
-			if loggedInUser.Email() != tokenEmail {
+			if loggedInUser.Email() != token.Email {
 				//Return the error
-				utils.ReturnJsonStatus(w, http.StatusForbidden, false, "auth_malformed_token")
+				utils.ReturnError(w, passwords.ErrMalformedToken)
 
 				return
 			}
@@ -95,27 +118,39 @@ func MakeJwtMiddlewareFunc(router *routing.Router, userRepo users.Repo, permRepo
 			//Make sure that the person is activated
 			if !loggedInUser.Activated() {
 				//There prob is not a user to return
-				utils.ReturnJsonStatus(w, http.StatusForbidden, false, "user_not_activated")
+				utils.ReturnError(w, users.ErrNotActivated)
+				return
+			}
+
+			//Keep track of who is calling
+			identity := &auth.Identity{
+				UserId:  token.UserId,
+				Email:   token.Email,
+				Method:  method,
+				TokenId: token.Id,
 			}
 
 			//Make sure that the user has permission
 			if permRepo != nil {
 				//See if we are allowed
-				userPerm, err := permRepo.GetPermissions(loggedInUser)
+				userPerm, err := permRepo.GetPermissionsContext(r.Context(), loggedInUser)
+				if errors.Is(err, apierror.ErrTimeout) {
+					utils.ReturnError(w, err)
+					return
+				}
 
 				//See if we are allowed to
 				if err != nil || !userPerm.AllowedTo(route.ReqPermissions...) {
 					//Return the error
-					utils.ReturnJsonStatus(w, http.StatusForbidden, false, "insufficient_access")
+					utils.ReturnError(w, roles.ErrInsufficientAccess)
 					return
 				}
+				identity.Permissions = userPerm.Permissions
 
 			}
 
 			//Everything went well, proceed with the request and set the caller to the user retrieved from the parsed token
-			//fmt.Sprintf("User %", tk.Username) //Useful for monitoring
-			ctx := context.WithValue(r.Context(), "user", userId)
-			r = r.WithContext(ctx)
+			r = r.WithContext(auth.WithIdentity(r.Context(), identity))
 			next.ServeHTTP(w, r) //proceed in the middleware chain!
 		})
 	}
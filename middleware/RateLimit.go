@@ -0,0 +1,110 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/reaction-eng/restlib/apierror"
+	"github.com/reaction-eng/restlib/auth"
+	"github.com/reaction-eng/restlib/routing"
+	"github.com/reaction-eng/restlib/utils"
+)
+
+//The prefix for every bucket key
+const rateLimitKeyPrefix = "ratelimit:"
+
+/**
+Define a function to limit the number of requests from each client.  The defaultPolicy is used for every route that
+does not set its own RateLimit.  Routes that limit by user must come after the jwt middleware so the user is known.
+*/
+func MakeRateLimitMiddlewareFunc(router *routing.Router, store RateLimitStore, defaultPolicy *routing.RateLimitPolicy) mux.MiddlewareFunc {
+
+	//Return an instance
+	return func(next http.Handler) http.Handler {
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			//If this is options just bypass
+			if r.Method == "OPTIONS" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			//Get the policy for this route, each route with its own policy gets its own bucket
+			policy := defaultPolicy
+			scope := "default"
+			if route := router.GetRoute(r); route != nil && route.RateLimit != nil {
+				policy = route.RateLimit
+				scope = route.Name
+			}
+
+			//If there is no limit just serve it
+			if !policy.Enabled() {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			//Take a token
+			key := rateLimitKeyPrefix + scope + ":" + rateLimitClientKey(r, policy.KeyBy)
+			result, err := store.Take(r.Context(), key, policy.Capacity(), policy.RefillRate(), time.Now())
+
+			//Don't block everyone if the store is down
+			if err != nil {
+				log.Printf("rate limit store error: %v", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			//Let the client know where they stand
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(policy.Capacity()))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.ResetAfter)))
+
+			//Stop them if they are out of tokens
+			if !result.Allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+				utils.ReturnError(w, apierror.ErrTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+/**
+Get the key for the client making the request
+*/
+func rateLimitClientKey(r *http.Request, keyBy routing.RateLimitKey) string {
+	switch keyBy {
+	case routing.RateLimitByUser:
+		if userId, ok := auth.UserId(r.Context()); ok {
+			return fmt.Sprint("user:", userId)
+		}
+	case routing.RateLimitByApiKey:
+		if apiKey := r.Header.Get("X-Api-Key"); len(apiKey) > 0 {
+			//Don't store the raw key
+			hash := sha256.Sum256([]byte(apiKey))
+			return "key:" + hex.EncodeToString(hash[:])
+		}
+	}
+
+	return "ip:" + ClientIp(r)
+}
+
+/**
+Round the duration up to whole seconds for the headers
+*/
+func ceilSeconds(duration time.Duration) int {
+	return int(math.Ceil(duration.Seconds()))
+}
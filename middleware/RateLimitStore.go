@@ -0,0 +1,213 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package middleware
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/reaction-eng/restlib/cache"
+	"github.com/reaction-eng/restlib/tracing"
+)
+
+/**
+The result of taking a token from a bucket
+*/
+type RateLimitResult struct {
+	//True if there was a token for this request
+	Allowed bool
+
+	//The number of whole tokens left in the bucket
+	Remaining int
+
+	//How long until the next token is available
+	RetryAfter time.Duration
+
+	//How long until the bucket is full again
+	ResetAfter time.Duration
+}
+
+/**
+Define an interface that all rate limit stores must follow
+*/
+type RateLimitStore interface {
+	/**
+	Take a single token from the bucket at the key.  The bucket holds at most capacity tokens and refills at
+	refillRate tokens per second
+	*/
+	Take(ctx context.Context, key string, capacity int, refillRate float64, now time.Time) (RateLimitResult, error)
+}
+
+/**
+The state of a single bucket
+*/
+type tokenBucket struct {
+	Tokens  float64 `json:"tokens"`
+	Updated int64   `json:"updated"`
+}
+
+/**
+Refill the bucket up to now and try to take a token
+*/
+func (bucket *tokenBucket) take(capacity int, refillRate float64, now time.Time) RateLimitResult {
+	nowMs := now.UnixNano() / int64(time.Millisecond)
+
+	//A new bucket starts full
+	if bucket.Updated == 0 {
+		bucket.Tokens = float64(capacity)
+		bucket.Updated = nowMs
+	}
+
+	//Add back the tokens since the last request
+	elapsed := math.Max(0, float64(nowMs-bucket.Updated)/1000.0)
+	bucket.Tokens = math.Min(float64(capacity), bucket.Tokens+elapsed*refillRate)
+	bucket.Updated = nowMs
+
+	//Try to take one
+	allowed := bucket.Tokens >= 1
+	if allowed {
+		bucket.Tokens--
+	}
+
+	return newRateLimitResult(allowed, bucket.Tokens, capacity, refillRate)
+}
+
+/**
+Build the result from what is left in the bucket
+*/
+func newRateLimitResult(allowed bool, tokens float64, capacity int, refillRate float64) RateLimitResult {
+	result := RateLimitResult{
+		Allowed:    allowed,
+		Remaining:  int(math.Floor(tokens)),
+		ResetAfter: time.Duration((float64(capacity) - tokens) / refillRate * float64(time.Second)),
+	}
+	if !allowed {
+		result.RetryAfter = time.Duration((1 - tokens) / refillRate * float64(time.Second))
+	}
+	return result
+}
+
+/**
+Store the buckets in an object cache.  Updates are only atomic inside of this process, so use the redis store
+when running more than one replica
+*/
+type CacheRateLimitStore struct {
+	cache cache.ObjectCache
+
+	//Only let one request update a bucket at a time
+	lock sync.Mutex
+}
+
+//Provide a method to make a new CacheRateLimitStore
+func NewCacheRateLimitStore(objectCache cache.ObjectCache) *CacheRateLimitStore {
+	return &CacheRateLimitStore{
+		cache: objectCache,
+	}
+}
+
+/**
+Take a token from the cached bucket
+*/
+func (store *CacheRateLimitStore) Take(ctx context.Context, key string, capacity int, refillRate float64, now time.Time) (RateLimitResult, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	//Get the current bucket, a miss leaves it empty
+	bucket := &tokenBucket{}
+	store.cache.GetContext(ctx, key, bucket)
+
+	//Take the token and save it back
+	result := bucket.take(capacity, refillRate, now)
+	return result, store.cache.SetContext(ctx, key, bucket)
+}
+
+/**
+The token bucket run inside of redis so that every replica shares the same count
+*/
+var rateLimitScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local ttl = tonumber(ARGV[4])
+
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "updated")
+local tokens = tonumber(bucket[1])
+local updated = tonumber(bucket[2])
+if tokens == nil or updated == nil then
+	tokens = capacity
+	updated = now
+end
+
+tokens = math.min(capacity, tokens + math.max(0, now - updated) / 1000 * rate)
+
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+redis.call("HMSET", KEYS[1], "tokens", tostring(tokens), "updated", now)
+redis.call("PEXPIRE", KEYS[1], ttl)
+
+return {allowed, tostring(tokens)}
+`)
+
+//Returned if the script reply can not be read
+var errUnexpectedRateLimitReply = errors.New("unexpected reply from the rate limit script")
+
+/**
+Store the buckets in redis.  Each take is a single script call so the limit holds across replicas
+*/
+type RedisRateLimitStore struct {
+	redis *redis.Ring
+}
+
+//Provide a method to make a new RedisRateLimitStore
+func NewRedisRateLimitStore(redis *redis.Ring) *RedisRateLimitStore {
+	return &RedisRateLimitStore{
+		redis: redis,
+	}
+}
+
+/**
+Take a token from the bucket in redis
+*/
+func (store *RedisRateLimitStore) Take(ctx context.Context, key string, capacity int, refillRate float64, now time.Time) (RateLimitResult, error) {
+	//Trace the call to redis
+	_, span := tracing.StartClientSpan(ctx, "middleware.RedisRateLimitStore.Take", "redis")
+	defer span.End()
+
+	//Keep the bucket around until it would be full again
+	ttl := int64(math.Ceil(float64(capacity)/refillRate*1000)) + 1000
+
+	//Run the script
+	reply, err := rateLimitScript.Run(store.redis, []string{key},
+		capacity,
+		strconv.FormatFloat(refillRate, 'f', -1, 64),
+		now.UnixNano()/int64(time.Millisecond),
+		ttl,
+	).Result()
+	if err != nil {
+		return RateLimitResult{}, tracing.RecordError(span, err)
+	}
+
+	//Get the values back out
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != 2 {
+		return RateLimitResult{}, tracing.RecordError(span, errUnexpectedRateLimitReply)
+	}
+	allowed, _ := values[0].(int64)
+	tokensString, _ := values[1].(string)
+	tokens, err := strconv.ParseFloat(tokensString, 64)
+	if err != nil {
+		return RateLimitResult{}, tracing.RecordError(span, err)
+	}
+
+	return newRateLimitResult(allowed == 1, tokens, capacity, refillRate), nil
+}
@@ -0,0 +1,49 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package middleware_test
+
+import (
+	"context"
+	"github.com/go-redis/redis"
+	"github.com/reaction-eng/restlib/middleware"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"testing"
+	"time"
+)
+
+/**
+Perform the testing
+*/
+func TestRedisRateLimitStoreTracing(t *testing.T) {
+	//Record the spans in memory
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(previous)
+
+	//Nothing is listening so the take fails, but it is still traced
+	ring := redis.NewRing(&redis.RingOptions{Addrs: map[string]string{"shard": "127.0.0.1:1"}})
+	defer ring.Close()
+	store := middleware.NewRedisRateLimitStore(ring)
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "request")
+	if _, err := store.Take(ctx, "key", 1, 1, time.Now()); err == nil {
+		t.Errorf("expected an error without redis")
+	}
+	parent.End()
+
+	//The take should be a child of the request
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("recived %d spans, expected 2", len(spans))
+	}
+	if spans[0].Name() != "middleware.RedisRateLimitStore.Take" || spans[0].Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("recived %s with parent %s, expected the take under %s", spans[0].Name(), spans[0].Parent().SpanID(), parent.SpanContext().SpanID())
+	}
+	if spans[0].SpanContext().TraceID() != parent.SpanContext().TraceID() {
+		t.Errorf("recived trace %s, expected %s", spans[0].SpanContext().TraceID(), parent.SpanContext().TraceID())
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package middleware_test
+
+import (
+	"github.com/reaction-eng/restlib/cache"
+	"github.com/reaction-eng/restlib/middleware"
+	"github.com/reaction-eng/restlib/routing"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+/**
+Perform the testing
+*/
+func TestRateLimit(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {}
+
+	//Build a router with a limited, a default and an unlimited route
+	router := routing.NewRouter(nil, []routing.Route{
+		{Name: "Limited", Method: "GET", Pattern: "/limited", HandlerFunc: handler, Public: true,
+			RateLimit: &routing.RateLimitPolicy{Limit: 1, Period: time.Hour, Burst: 2}},
+		{Name: "Default", Method: "GET", Pattern: "/default", HandlerFunc: handler, Public: true},
+		{Name: "Unlimited", Method: "GET", Pattern: "/unlimited", HandlerFunc: handler, Public: true,
+			RateLimit: routing.NoRateLimit},
+	}, nil)
+	store := middleware.NewCacheRateLimitStore(cache.NewObjectMemCache())
+	router.Use(middleware.MakeRateLimitMiddlewareFunc(router, store, &routing.RateLimitPolicy{Limit: 1, Period: time.Hour}))
+
+	//Define the list of requests in order
+	var requests = []struct {
+		path          string
+		remoteAddr    string
+		expectedCode  int
+		expectedRetry bool
+	}{
+		{"/limited", "10.0.0.1:1000", http.StatusOK, false},
+		{"/limited", "10.0.0.1:1001", http.StatusOK, false},
+		{"/limited", "10.0.0.1:1002", http.StatusTooManyRequests, true},
+		{"/limited", "10.0.0.2:1000", http.StatusOK, false},
+		{"/default", "10.0.0.1:1003", http.StatusOK, false},
+		{"/default", "10.0.0.1:1004", http.StatusTooManyRequests, true},
+		{"/unlimited", "10.0.0.1:1005", http.StatusOK, false},
+		{"/unlimited", "10.0.0.1:1006", http.StatusOK, false},
+	}
+
+	for _, rr := range requests {
+		req, err := http.NewRequest("GET", rr.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.RemoteAddr = rr.remoteAddr
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		//Make sure the status is correct
+		if rec.Result().StatusCode != rr.expectedCode {
+			t.Errorf("%s from %s recived status code %d, expected %d", rr.path, rr.remoteAddr, rec.Result().StatusCode, rr.expectedCode)
+		}
+		if hasRetry := len(rec.Header().Get("Retry-After")) > 0; hasRetry != rr.expectedRetry {
+			t.Errorf("%s from %s recived Retry-After %v, expected %v", rr.path, rr.remoteAddr, hasRetry, rr.expectedRetry)
+		}
+	}
+}
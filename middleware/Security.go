@@ -0,0 +1,301 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/reaction-eng/restlib/configuration"
+	"github.com/reaction-eng/restlib/routing"
+	"github.com/reaction-eng/restlib/utils"
+)
+
+/**
+Define the security headers and proxy settings
+*/
+type SecurityPolicy struct {
+	//Redirect any request that did not come over https
+	HttpsOnly bool `json:"httpsOnly"`
+
+	//How long in seconds browsers should only use https, 0 turns off the header
+	HstsMaxAge            int  `json:"hstsMaxAge"`
+	HstsIncludeSubdomains bool `json:"hstsIncludeSubdomains"`
+
+	//The header values, empty values use the defaults and - turns off the header.  Routes, i.e. the api docs, can
+	//set their own ContentSecurityPolicy
+	ContentSecurityPolicy string `json:"contentSecurityPolicy"`
+	FrameOptions          string `json:"frameOptions"`
+	ReferrerPolicy        string `json:"referrerPolicy"`
+
+	//The proxies, as CIDRs, that are trusted to set the X-Forwarded-For and X-Forwarded-Proto headers
+	TrustedProxies []string `json:"trustedProxies"`
+
+	//Optional double submit csrf protection for cookie sessions
+	Csrf CsrfPolicy `json:"csrf"`
+
+	//The parsed trusted proxies
+	trustedNets []*net.IPNet
+}
+
+/**
+Define the double submit csrf settings.  The token is stored in a cookie the page can read and must be sent back in
+the header on every unsafe request
+*/
+type CsrfPolicy struct {
+	Enabled    bool   `json:"enabled"`
+	CookieName string `json:"cookieName"`
+	HeaderName string `json:"headerName"`
+
+	//Set the cookie without the Secure flag, only use for local development
+	Insecure bool `json:"insecure"`
+}
+
+//Used to store the real client ip in the context
+type clientIpKey struct{}
+
+/**
+Load the policy from the security key in the configuration, i.e.
+	"security": {
+		"httpsOnly": true,
+		"hstsMaxAge": 31536000,
+		"trustedProxies": ["10.0.0.0/8"],
+		"csrf": {"enabled": true}
+	}
+*/
+func NewSecurityPolicy(configFiles ...string) *SecurityPolicy {
+	//Load in the config
+	config, err := configuration.NewConfiguration(configFiles...)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	//Get the policy
+	policy := &SecurityPolicy{}
+	if err := config.GetStruct("security", policy); err != nil {
+		log.Fatal("invalid security configuration: ", err)
+	}
+
+	//Fill in the defaults
+	if len(policy.ContentSecurityPolicy) == 0 {
+		policy.ContentSecurityPolicy = "default-src 'none'; frame-ancestors 'none'"
+	}
+	if len(policy.FrameOptions) == 0 {
+		policy.FrameOptions = "DENY"
+	}
+	if len(policy.ReferrerPolicy) == 0 {
+		policy.ReferrerPolicy = "strict-origin-when-cross-origin"
+	}
+	if len(policy.Csrf.CookieName) == 0 {
+		policy.Csrf.CookieName = "csrf_token"
+	}
+	if len(policy.Csrf.HeaderName) == 0 {
+		policy.Csrf.HeaderName = "X-CSRF-Token"
+	}
+
+	//Parse each of the proxies
+	for _, cidr := range policy.TrustedProxies {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.Fatal("invalid trusted proxy: ", err)
+		}
+		policy.trustedNets = append(policy.trustedNets, ipNet)
+	}
+
+	return policy
+}
+
+/**
+Define a function to add the security headers, enforce https and check the csrf token
+*/
+func MakeSecurityMiddlewareFunc(policy *SecurityPolicy) mux.MiddlewareFunc {
+
+	return func(next http.Handler) http.Handler {
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			//Store the real client ip for the rest of the chain
+			r = r.WithContext(context.WithValue(r.Context(), clientIpKey{}, policy.clientIp(r)))
+
+			//Check for https
+			isHttps := policy.isHttps(r)
+			if policy.HttpsOnly && !isHttps {
+				http.Redirect(w, r, "https://"+r.Host+r.RequestURI, http.StatusTemporaryRedirect)
+				return
+			}
+
+			//Add the headers
+			if isHttps && policy.HstsMaxAge > 0 {
+				hsts := "max-age=" + strconv.Itoa(policy.HstsMaxAge)
+				if policy.HstsIncludeSubdomains {
+					hsts += "; includeSubDomains"
+				}
+				w.Header().Set("Strict-Transport-Security", hsts)
+			}
+			setSecurityHeader(w, "Content-Security-Policy", policy.contentSecurityPolicy(r))
+			setSecurityHeader(w, "X-Frame-Options", policy.FrameOptions)
+			setSecurityHeader(w, "Referrer-Policy", policy.ReferrerPolicy)
+			w.Header().Set("X-Content-Type-Options", "nosniff")
+
+			//Check the csrf token
+			if policy.Csrf.Enabled && !policy.checkCsrf(w, r, isHttps) {
+				utils.ReturnError(w, ErrCsrfTokenInvalid)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+/**
+Get the ip of the client.  If the security middleware has run the forwarded ip from a trusted proxy is used
+*/
+func ClientIp(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIpKey{}).(string); ok {
+		return ip
+	}
+	return remoteIp(r)
+}
+
+/**
+Get the content security policy for the route.  Routes can set their own, i.e. for html pages, unless it has been
+turned off
+*/
+func (policy *SecurityPolicy) contentSecurityPolicy(r *http.Request) string {
+	if policy.ContentSecurityPolicy == "-" {
+		return policy.ContentSecurityPolicy
+	}
+	if route := routing.RouteFromContext(r.Context()); route != nil && len(route.ContentSecurityPolicy) > 0 {
+		return route.ContentSecurityPolicy
+	}
+	return policy.ContentSecurityPolicy
+}
+
+/**
+Set the header unless it has been turned off
+*/
+func setSecurityHeader(w http.ResponseWriter, header string, value string) {
+	if value != "-" {
+		w.Header().Set(header, value)
+	}
+}
+
+/**
+Get the ip of the connection
+*/
+func remoteIp(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+/**
+Check to see if the ip is one of the trusted proxies
+*/
+func (policy *SecurityPolicy) trusted(ip string) bool {
+	parsed := net.ParseIP(strings.TrimSpace(ip))
+	if parsed == nil {
+		return false
+	}
+
+	for _, ipNet := range policy.trustedNets {
+		if ipNet.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+Walk back through the X-Forwarded-For chain until the first ip that is not a trusted proxy
+*/
+func (policy *SecurityPolicy) clientIp(r *http.Request) string {
+	ip := remoteIp(r)
+	if !policy.trusted(ip) {
+		return ip
+	}
+
+	//Each proxy appends the ip it got the request from
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		ip = hop
+		if !policy.trusted(hop) {
+			break
+		}
+	}
+
+	return ip
+}
+
+/**
+Check to see if the request came over https.  The forwarded proto is only used from a trusted proxy
+*/
+func (policy *SecurityPolicy) isHttps(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	return policy.trusted(remoteIp(r)) && strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+/**
+Check the double submit token for unsafe requests, and hand out a new token if there is not one yet.  Requests using
+the Authorization header can't be forged by another site so they are not checked.
+*/
+func (policy *SecurityPolicy) checkCsrf(w http.ResponseWriter, r *http.Request, isHttps bool) bool {
+	cookie, err := r.Cookie(policy.Csrf.CookieName)
+
+	switch r.Method {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+		//Make sure the client has a token to send back
+		if err != nil || len(cookie.Value) == 0 {
+			token, err := newCsrfToken()
+			if err != nil {
+				log.Printf("could not create csrf token: %v", err)
+				return true
+			}
+			http.SetCookie(w, &http.Cookie{
+				Name:     policy.Csrf.CookieName,
+				Value:    token,
+				Path:     "/",
+				Secure:   !policy.Csrf.Insecure,
+				SameSite: http.SameSiteStrictMode,
+			})
+		}
+		return true
+	}
+
+	//Bearer tokens are not sent automatically by the browser
+	if len(r.Header.Get("Authorization")) > 0 {
+		return true
+	}
+
+	//The header must match the cookie
+	header := r.Header.Get(policy.Csrf.HeaderName)
+	return err == nil && len(cookie.Value) > 0 && subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(header)) == 1
+}
+
+/**
+Build a new random token
+*/
+func newCsrfToken() (string, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
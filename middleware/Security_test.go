@@ -0,0 +1,128 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package middleware_test
+
+import (
+	"github.com/reaction-eng/restlib/middleware"
+	"github.com/reaction-eng/restlib/routing"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+/**
+Perform the testing
+*/
+func TestSecurityMiddleware(t *testing.T) {
+	//Load the policy from a config string
+	policy := middleware.NewSecurityPolicy(`{"security": {"httpsOnly": true, "hstsMaxAge": 600, "trustedProxies": ["10.0.0.0/8"], "csrf": {"enabled": true}}}`)
+
+	//Record the client ip seen by the handler
+	var seenIp string
+	handler := func(w http.ResponseWriter, r *http.Request) { seenIp = middleware.ClientIp(r) }
+	router := routing.NewRouter(nil, []routing.Route{
+		{Name: "Get", Method: "GET", Pattern: "/thing", HandlerFunc: handler, Public: true},
+		{Name: "Post", Method: "POST", Pattern: "/thing", HandlerFunc: handler, Public: true},
+	}, nil)
+	router.Use(middleware.MakeSecurityMiddlewareFunc(policy))
+
+	//Define the list of requests
+	var requests = []struct {
+		name         string
+		method       string
+		remoteAddr   string
+		headers      map[string]string
+		expectedCode int
+		expectedIp   string
+	}{
+		{"untrusted proto", "GET", "1.2.3.4:100", map[string]string{"X-Forwarded-Proto": "https"}, http.StatusTemporaryRedirect, ""},
+		{"trusted proxy", "GET", "10.0.0.1:100", map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-For": "9.9.9.9, 1.2.3.4, 10.0.0.2"}, http.StatusOK, "1.2.3.4"},
+		{"missing csrf", "POST", "10.0.0.1:100", map[string]string{"X-Forwarded-Proto": "https"}, http.StatusForbidden, ""},
+		{"matching csrf", "POST", "10.0.0.1:100", map[string]string{"X-Forwarded-Proto": "https", "Cookie": "csrf_token=abc", "X-CSRF-Token": "abc"}, http.StatusOK, "10.0.0.1"},
+		{"wrong csrf", "POST", "10.0.0.1:100", map[string]string{"X-Forwarded-Proto": "https", "Cookie": "csrf_token=abc", "X-CSRF-Token": "abd"}, http.StatusForbidden, ""},
+		{"bearer token", "POST", "10.0.0.1:100", map[string]string{"X-Forwarded-Proto": "https", "Authorization": "Bearer abc"}, http.StatusOK, "10.0.0.1"},
+	}
+
+	for _, rr := range requests {
+		t.Run(rr.name, func(t *testing.T) {
+			seenIp = ""
+			req, err := http.NewRequest(rr.method, "/thing", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.RemoteAddr = rr.remoteAddr
+			for key, value := range rr.headers {
+				req.Header.Set(key, value)
+			}
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			//Check the result
+			if rec.Result().StatusCode != rr.expectedCode {
+				t.Errorf("recived status code %d, expected %d", rec.Result().StatusCode, rr.expectedCode)
+			}
+			if seenIp != rr.expectedIp {
+				t.Errorf("recived ip %s, expected %s", seenIp, rr.expectedIp)
+			}
+			if rr.expectedCode == http.StatusOK && rec.Header().Get("Strict-Transport-Security") != "max-age=600" {
+				t.Errorf("expected the hsts header")
+			}
+		})
+	}
+}
+
+/**
+Perform the testing
+*/
+func TestSecurityContentSecurityPolicy(t *testing.T) {
+	policy := middleware.NewSecurityPolicy(`{"security": {}}`)
+	router := routing.NewRouter(nil, []routing.Route{
+		{Name: "Get", Method: "GET", Pattern: "/thing", HandlerFunc: func(w http.ResponseWriter, r *http.Request) {}, Public: true},
+	}, nil)
+	router.AddOpenApiRoutes("Test", "1.0", true)
+	router.Use(middleware.MakeSecurityMiddlewareFunc(policy))
+
+	//Define the list of requests and the sources each page needs
+	var requests = []struct {
+		path     string
+		expected map[string]string
+	}{
+		{"/thing", map[string]string{"default-src": "'none'", "script-src": "", "style-src": ""}},
+		{"/api/docs", map[string]string{"default-src": "'none'", "script-src": "'self'", "style-src": "'self'", "connect-src": "'self'"}},
+	}
+
+	for _, rr := range requests {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", rr.path, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("recived %d for %s, expected %d", rec.Code, rr.path, http.StatusOK)
+		}
+
+		//Split the policy into its directives
+		csp := rec.Header().Get("Content-Security-Policy")
+		directives := map[string][]string{}
+		for _, directive := range strings.Split(csp, ";") {
+			fields := strings.Fields(directive)
+			if len(fields) > 0 {
+				directives[fields[0]] = fields[1:]
+			}
+		}
+
+		//Make sure each needed source is allowed, or the directive is left out when empty
+		for directive, source := range rr.expected {
+			sources, found := directives[directive]
+			if len(source) == 0 {
+				if found {
+					t.Errorf("recived %s for %s, expected no %s", csp, rr.path, directive)
+				}
+				continue
+			}
+			if !strings.Contains(" "+strings.Join(sources, " ")+" ", " "+source+" ") {
+				t.Errorf("recived %s for %s, expected %s to allow %s", csp, rr.path, directive, source)
+			}
+		}
+	}
+}
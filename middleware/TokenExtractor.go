@@ -0,0 +1,87 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package middleware
+
+import (
+	"net/http"
+	"strings"
+)
+
+/**
+Define an interface that pulls the token out of a request
+*/
+type TokenExtractor interface {
+	/**
+	Get the token in the form "Bearer {token}", or an empty string if this extractor did not find one
+	*/
+	ExtractToken(r *http.Request) string
+}
+
+/**
+Get the token from a header, i.e. Authorization
+*/
+type HeaderTokenExtractor struct {
+	Header string
+}
+
+//Provide a method to make a new HeaderTokenExtractor for the Authorization header
+func NewHeaderTokenExtractor() *HeaderTokenExtractor {
+	return &HeaderTokenExtractor{
+		Header: "Authorization",
+	}
+}
+
+func (extractor *HeaderTokenExtractor) ExtractToken(r *http.Request) string {
+	return r.Header.Get(extractor.Header)
+}
+
+/**
+Get the token from the Sec-Websocket-Protocol header.  Browsers can't set headers on a websocket so the token is
+passed as the first protocol with the space replaced by _Space_, i.e. Bearer_Space_{token}, chat
+*/
+type WebsocketTokenExtractor struct{}
+
+//Provide a method to make a new WebsocketTokenExtractor
+func NewWebsocketTokenExtractor() *WebsocketTokenExtractor {
+	return &WebsocketTokenExtractor{}
+}
+
+func (extractor *WebsocketTokenExtractor) ExtractToken(r *http.Request) string {
+	tokenHeader := r.Header.Get("Sec-Websocket-Protocol")
+	if tokenHeader == "" {
+		return ""
+	}
+
+	//Only use the first protocol
+	tokenHeader = strings.Replace(tokenHeader, "_Space_", " ", -1)
+	if locOfComma := strings.Index(tokenHeader, ","); locOfComma >= 0 {
+		tokenHeader = tokenHeader[0:locOfComma]
+	}
+
+	return strings.TrimSpace(tokenHeader)
+}
+
+/**
+Get the token from the HttpOnly session cookie
+*/
+type CookieTokenExtractor struct {
+	CookieName string
+}
+
+//Provide a method to make a new CookieTokenExtractor
+func NewCookieTokenExtractor(cookieName string) *CookieTokenExtractor {
+	return &CookieTokenExtractor{
+		CookieName: cookieName,
+	}
+}
+
+func (extractor *CookieTokenExtractor) ExtractToken(r *http.Request) string {
+	cookie, err := r.Cookie(extractor.CookieName)
+	if err != nil || len(cookie.Value) == 0 {
+		return ""
+	}
+
+	//The cookie only holds the token
+	return "Bearer " + cookie.Value
+}
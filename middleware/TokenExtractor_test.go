@@ -0,0 +1,45 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package middleware_test
+
+import (
+	"github.com/reaction-eng/restlib/middleware"
+	"net/http"
+	"testing"
+)
+
+/**
+Perform the testing
+*/
+func TestTokenExtractors(t *testing.T) {
+	//Define the list of extractors and requests
+	var extractors = []struct {
+		name      string
+		extractor middleware.TokenExtractor
+		header    string
+		value     string
+		expected  string
+	}{
+		{"header", middleware.NewHeaderTokenExtractor(), "Authorization", "Bearer abc", "Bearer abc"},
+		{"header missing", middleware.NewHeaderTokenExtractor(), "Cookie", "session=abc", ""},
+		{"websocket", middleware.NewWebsocketTokenExtractor(), "Sec-Websocket-Protocol", "Bearer_Space_abc, chat", "Bearer abc"},
+		{"websocket single", middleware.NewWebsocketTokenExtractor(), "Sec-Websocket-Protocol", "Bearer_Space_abc", "Bearer abc"},
+		{"cookie", middleware.NewCookieTokenExtractor("session"), "Cookie", "session=abc", "Bearer abc"},
+		{"cookie missing", middleware.NewCookieTokenExtractor("session"), "Cookie", "other=abc", ""},
+	}
+
+	for _, tt := range extractors {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", "/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set(tt.header, tt.value)
+
+			if token := tt.extractor.ExtractToken(req); token != tt.expected {
+				t.Errorf("recived token %s, expected %s", token, tt.expected)
+			}
+		})
+	}
+}
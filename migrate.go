@@ -0,0 +1,90 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/reaction-eng/restlib/migrations"
+	"github.com/reaction-eng/restlib/passwords"
+	"github.com/reaction-eng/restlib/roles"
+	"github.com/reaction-eng/restlib/users"
+)
+
+/**
+Run the migrate command, i.e.
+	restlib migrate up config.json
+	restlib migrate down -source users.users -steps 1 config.json
+	restlib migrate status config.json
+*/
+func runMigrate(args []string) {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	sourceName := flags.String("source", "", "the migration source to roll back, i.e. users.users")
+	steps := flags.Int("steps", 1, "the number of migrations to roll back")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: restlib migrate up|down|status [-source name] [-steps n] [config files]")
+		flags.PrintDefaults()
+	}
+
+	//Get the action then the flags and config files
+	if len(args) == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	action := args[0]
+	flags.Parse(args[1:])
+
+	//Open the database
+	_, sqlDialect, db := openDatabase(defaultConfigFiles(flags.Args()))
+	defer db.Close()
+
+	migrator := migrations.NewMigrator(db, sqlDialect,
+		users.Migrations(usersTable),
+		roles.Migrations(rolesTable),
+		passwords.Migrations(resetRequestsTable),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	switch action {
+	case "up":
+		if err := migrator.Up(ctx); err != nil {
+			log.Fatal(err)
+		}
+	case "down":
+		if len(*sourceName) == 0 {
+			log.Fatal("the source must be given to roll back")
+		}
+		if err := migrator.Down(ctx, *sourceName, *steps); err != nil {
+			log.Fatal(err)
+		}
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		//Print a table
+		writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, "SOURCE\tVERSION\tNAME\tAPPLIED")
+		for _, status := range statuses {
+			applied := "pending"
+			if status.Applied {
+				applied = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(writer, "%s\t%d\t%s\t%s\n", status.Source, status.Version, status.Name, applied)
+		}
+		writer.Flush()
+	default:
+		flags.Usage()
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package migrations
+
+import (
+	"fmt"
+	"github.com/reaction-eng/restlib/dialect"
+	"strings"
+)
+
+/**
+A single numbered change to the schema.  Each dialect has its own list of statements, since most drivers only run
+one statement per call.
+*/
+type Migration struct {
+	Version int
+	Name    string
+	Up      map[dialect.Dialect][]string
+	Down    map[dialect.Dialect][]string
+}
+
+/**
+A set of migrations owned by a single repo, i.e. the users table.  The name must be unique in the database, so it
+usually includes the table name.
+*/
+type Source struct {
+	Name       string
+	Migrations []Migration
+}
+
+/**
+Make sure the versions are positive, unique and in order
+*/
+func (source Source) validate(sqlDialect dialect.Dialect) error {
+	last := 0
+	for _, migration := range source.Migrations {
+		if migration.Version <= last {
+			return fmt.Errorf("migration %s %d must be numbered after %d", source.Name, migration.Version, last)
+		}
+		if len(migration.Up[sqlDialect]) == 0 {
+			return fmt.Errorf("migration %s %d has nothing to run for %s", source.Name, migration.Version, sqlDialect)
+		}
+		last = migration.Version
+	}
+	if len(strings.TrimSpace(source.Name)) == 0 {
+		return fmt.Errorf("every migration source needs a name")
+	}
+	return nil
+}
@@ -0,0 +1,320 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/reaction-eng/restlib/dialect"
+	"log"
+	"time"
+)
+
+//The name of the table that stores the applied migrations
+const AppliedTable = "schema_migrations"
+
+//The name of the lock used so only one replica migrates at a time
+const lockName = "restlib_migrations"
+
+//The postgres advisory lock id, any fixed number works as long as nothing else uses it
+const postgresLockId = 7301944171
+
+//How long to wait for another replica to finish migrating
+const lockTimeout = 60 * time.Second
+
+//Returned if another replica holds the lock for too long
+var ErrLockTimeout = errors.New("timed out waiting for the migration lock")
+
+/**
+The state of a single migration
+*/
+type Status struct {
+	Source    string
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt *time.Time
+}
+
+/**
+Applies and rolls back the migrations for each source
+*/
+type Migrator struct {
+	db         *sql.DB
+	sqlDialect dialect.Dialect
+	sources    []Source
+}
+
+//Provide a method to make a new Migrator
+func NewMigrator(db *sql.DB, sqlDialect dialect.Dialect, sources ...Source) *Migrator {
+	return &Migrator{
+		db:         db,
+		sqlDialect: sqlDialect,
+		sources:    sources,
+	}
+}
+
+/**
+Apply every migration that has not been applied yet, in version order for each source
+*/
+func (migrator *Migrator) Up(ctx context.Context) error {
+	return migrator.locked(ctx, func(conn *sql.Conn) error {
+		for _, source := range migrator.sources {
+			applied, err := migrator.applied(ctx, conn, source.Name)
+			if err != nil {
+				return err
+			}
+
+			for _, migration := range source.Migrations {
+				if _, found := applied[migration.Version]; found {
+					continue
+				}
+				if err := migrator.run(ctx, conn, source, migration, true); err != nil {
+					return err
+				}
+				log.Printf("applied migration %s %d %s", source.Name, migration.Version, migration.Name)
+			}
+		}
+		return nil
+	})
+}
+
+/**
+Roll back the last steps migrations applied for the source
+*/
+func (migrator *Migrator) Down(ctx context.Context, sourceName string, steps int) error {
+	//Find the source
+	var source *Source
+	for i := range migrator.sources {
+		if migrator.sources[i].Name == sourceName {
+			source = &migrator.sources[i]
+		}
+	}
+	if source == nil {
+		return fmt.Errorf("unknown migration source %s", sourceName)
+	}
+
+	return migrator.locked(ctx, func(conn *sql.Conn) error {
+		applied, err := migrator.applied(ctx, conn, source.Name)
+		if err != nil {
+			return err
+		}
+
+		//Walk back from the newest
+		for i := len(source.Migrations) - 1; i >= 0 && steps > 0; i-- {
+			migration := source.Migrations[i]
+			if _, found := applied[migration.Version]; !found {
+				continue
+			}
+			if err := migrator.run(ctx, conn, *source, migration, false); err != nil {
+				return err
+			}
+			log.Printf("rolled back migration %s %d %s", source.Name, migration.Version, migration.Name)
+			steps--
+		}
+		return nil
+	})
+}
+
+/**
+Get the state of every known migration
+*/
+func (migrator *Migrator) Status(ctx context.Context) ([]Status, error) {
+	conn, err := migrator.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := migrator.createAppliedTable(ctx, conn); err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0)
+	for _, source := range migrator.sources {
+		applied, err := migrator.applied(ctx, conn, source.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, migration := range source.Migrations {
+			status := Status{Source: source.Name, Version: migration.Version, Name: migration.Name}
+			if appliedAt, found := applied[migration.Version]; found {
+				status.Applied = true
+				status.AppliedAt = &appliedAt
+			}
+			statuses = append(statuses, status)
+		}
+	}
+
+	return statuses, nil
+}
+
+/**
+Hold the migration lock on a single connection while running the function
+*/
+func (migrator *Migrator) locked(ctx context.Context, function func(conn *sql.Conn) error) error {
+	//Check the sources first
+	for _, source := range migrator.sources {
+		if err := source.validate(migrator.sqlDialect); err != nil {
+			return err
+		}
+	}
+
+	//Locks belong to the session so keep a single connection
+	conn, err := migrator.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	//Get the lock
+	switch migrator.sqlDialect {
+	case dialect.MySql:
+		acquired := 0
+		if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", lockName, int(lockTimeout.Seconds())).Scan(&acquired); err != nil {
+			return err
+		}
+		if acquired != 1 {
+			return ErrLockTimeout
+		}
+		defer conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", lockName)
+	case dialect.Postgres:
+		lockCtx, cancel := context.WithTimeout(ctx, lockTimeout)
+		defer cancel()
+		if _, err := conn.ExecContext(lockCtx, "SELECT pg_advisory_lock($1)", postgresLockId); err != nil {
+			if lockCtx.Err() != nil {
+				return ErrLockTimeout
+			}
+			return err
+		}
+		defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", postgresLockId)
+	case dialect.Sqlite:
+		//Sqlite has no named locks, but it only allows one writer at a time and is rarely shared by replicas
+	default:
+		return fmt.Errorf("unknown sql dialect %s", migrator.sqlDialect)
+	}
+
+	if err := migrator.createAppliedTable(ctx, conn); err != nil {
+		return err
+	}
+
+	return function(conn)
+}
+
+/**
+Create the table of applied migrations if it is not there
+*/
+func (migrator *Migrator) createAppliedTable(ctx context.Context, conn *sql.Conn) error {
+	timeType := "DATETIME"
+	if migrator.sqlDialect == dialect.Postgres {
+		timeType = "TIMESTAMP"
+	}
+
+	_, err := conn.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+AppliedTable+
+		"(source VARCHAR(191) NOT NULL, version INT NOT NULL, name TEXT NOT NULL, appliedAt "+timeType+" NOT NULL, PRIMARY KEY (source, version))")
+	return err
+}
+
+/**
+Get the applied versions for the source and when they were applied
+*/
+func (migrator *Migrator) applied(ctx context.Context, conn *sql.Conn, sourceName string) (map[int]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, migrator.sqlDialect.Rebind("SELECT version, appliedAt FROM "+AppliedTable+" WHERE source = ?"), sourceName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt appliedTime
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt.Time
+	}
+
+	return applied, rows.Err()
+}
+
+//The text layouts a driver may return the applied time in
+var appliedTimeLayouts = []string{"2006-01-02 15:04:05.999999999", time.RFC3339Nano}
+
+/**
+Scans the applied time whether the driver returns a time or text, i.e. mysql without parseTime=true
+*/
+type appliedTime struct {
+	time.Time
+}
+
+/**
+Scan implements the Scanner interface.
+*/
+func (appliedAt *appliedTime) Scan(value interface{}) error {
+	switch value := value.(type) {
+	case time.Time:
+		appliedAt.Time = value
+		return nil
+	case []byte:
+		return appliedAt.parse(string(value))
+	case string:
+		return appliedAt.parse(value)
+	}
+	return fmt.Errorf("can not read the applied time from %T", value)
+}
+
+/**
+Parse the text in any of the known layouts.  The time is always stored in utc.
+*/
+func (appliedAt *appliedTime) parse(text string) error {
+	for _, layout := range appliedTimeLayouts {
+		if parsed, err := time.Parse(layout, text); err == nil {
+			appliedAt.Time = parsed
+			return nil
+		}
+	}
+	return fmt.Errorf("can not read the applied time from %q", text)
+}
+
+/**
+Run the migration up or down and record it in a single transaction.  Note that mysql commits each schema change
+right away, so keep each mysql migration to a single change when possible.
+*/
+func (migrator *Migrator) run(ctx context.Context, conn *sql.Conn, source Source, migration Migration, up bool) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	//Run each of the statements
+	statements := migration.Down[migrator.sqlDialect]
+	if up {
+		statements = migration.Up[migrator.sqlDialect]
+	}
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %s %d failed: %w", source.Name, migration.Version, err)
+		}
+	}
+
+	//Record it
+	if up {
+		_, err = tx.ExecContext(ctx, migrator.sqlDialect.Rebind("INSERT INTO "+AppliedTable+"(source, version, name, appliedAt) VALUES (?, ?, ?, ?)"),
+			source.Name, migration.Version, migration.Name, time.Now().UTC())
+	} else {
+		_, err = tx.ExecContext(ctx, migrator.sqlDialect.Rebind("DELETE FROM "+AppliedTable+" WHERE source = ? AND version = ?"),
+			source.Name, migration.Version)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
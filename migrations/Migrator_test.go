@@ -0,0 +1,213 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package migrations_test
+
+import (
+	"context"
+	"database/sql"
+	"github.com/DATA-DOG/go-sqlmock"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/reaction-eng/restlib/dialect"
+	"github.com/reaction-eng/restlib/migrations"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+/**
+Simple source used for the tests
+*/
+var testSource = migrations.Source{
+	Name: "things",
+	Migrations: []migrations.Migration{
+		{
+			Version: 1,
+			Name:    "create",
+			Up:      map[dialect.Dialect][]string{dialect.MySql: {"CREATE TABLE things(id int)"}, dialect.Sqlite: {"CREATE TABLE things(id int)"}},
+			Down:    map[dialect.Dialect][]string{dialect.MySql: {"DROP TABLE things"}, dialect.Sqlite: {"DROP TABLE things"}},
+		},
+		{
+			Version: 2,
+			Name:    "add name",
+			Up:      map[dialect.Dialect][]string{dialect.MySql: {"ALTER TABLE things ADD name TEXT"}, dialect.Sqlite: {"ALTER TABLE things ADD name TEXT"}},
+			Down:    map[dialect.Dialect][]string{dialect.MySql: {"ALTER TABLE things DROP name"}, dialect.Sqlite: {"ALTER TABLE things DROP name"}},
+		},
+	},
+}
+
+/**
+Perform the testing
+*/
+func TestMigratorUp(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	//Version 1 is already applied so only 2 should run, inside of the lock
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT GET_LOCK(?, ?)")).WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(1))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT version, appliedAt FROM schema_migrations").WithArgs("things").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "appliedAt"}).AddRow(1, time.Now()))
+	mock.ExpectBegin()
+	mock.ExpectExec("ALTER TABLE things ADD name TEXT").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO schema_migrations").WithArgs("things", 2, "add name", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec(regexp.QuoteMeta("SELECT RELEASE_LOCK(?)")).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	migrator := migrations.NewMigrator(db, dialect.MySql, testSource)
+	if err := migrator.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+/**
+Perform the testing
+*/
+func TestMigratorDown(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	//Both are applied so only the newest is rolled back
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT GET_LOCK(?, ?)")).WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(1))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT version, appliedAt FROM schema_migrations").WithArgs("things").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "appliedAt"}).AddRow(1, time.Now()).AddRow(2, time.Now()))
+	mock.ExpectBegin()
+	mock.ExpectExec("ALTER TABLE things DROP name").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM schema_migrations").WithArgs("things", 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec(regexp.QuoteMeta("SELECT RELEASE_LOCK(?)")).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	migrator := migrations.NewMigrator(db, dialect.MySql, testSource)
+	if err := migrator.Down(context.Background(), "things", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+/**
+Perform the testing
+*/
+func TestMigratorTextTimes(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	//Mysql without parseTime=true returns the time as text
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT version, appliedAt FROM schema_migrations").WithArgs("things").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "appliedAt"}).AddRow(1, []byte("2024-01-02 03:04:05")).AddRow(2, "2024-01-02 03:04:05.5"))
+
+	statuses, err := migrations.NewMigrator(db, dialect.MySql, testSource).Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []time.Time{time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), time.Date(2024, 1, 2, 3, 4, 5, 500000000, time.UTC)}
+	for i, status := range statuses {
+		if status.AppliedAt == nil || !status.AppliedAt.Equal(expected[i]) {
+			t.Errorf("recived %v for version %d, expected %v", status.AppliedAt, status.Version, expected[i])
+		}
+	}
+
+	//Anything else is an error instead of a zero time
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT version, appliedAt FROM schema_migrations").WithArgs("things").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "appliedAt"}).AddRow(1, "yesterday"))
+	if _, err := migrations.NewMigrator(db, dialect.MySql, testSource).Status(context.Background()); err == nil {
+		t.Errorf("expected an error for an unreadable time")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+/**
+Perform the testing
+*/
+func TestMigratorErrors(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	//Another replica holds the lock
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT GET_LOCK(?, ?)")).WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(0))
+	migrator := migrations.NewMigrator(db, dialect.MySql, testSource)
+	if err := migrator.Up(context.Background()); err != migrations.ErrLockTimeout {
+		t.Errorf("recived %v, expected %v", err, migrations.ErrLockTimeout)
+	}
+
+	//Out of order versions are rejected before anything runs
+	badSource := migrations.Source{Name: "bad", Migrations: []migrations.Migration{testSource.Migrations[1], testSource.Migrations[0]}}
+	if err := migrations.NewMigrator(db, dialect.MySql, badSource).Up(context.Background()); err == nil {
+		t.Errorf("expected an error for out of order versions")
+	}
+
+	//Unknown sources can't be rolled back
+	if err := migrator.Down(context.Background(), "missing", 1); err == nil {
+		t.Errorf("expected an error for an unknown source")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+/**
+Perform the testing
+*/
+func TestMigratorSqlite(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "migrations.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	migrator := migrations.NewMigrator(db, dialect.Sqlite, testSource)
+
+	//Running up twice should only apply each once
+	for i := 0; i < 2; i++ {
+		if err := migrator.Up(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec("INSERT INTO things(id, name) VALUES (1, 'one')"); err != nil {
+		t.Errorf("recived %v, expected the name column", err)
+	}
+
+	//Check the status
+	statuses, err := migrator.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 2 || !statuses[0].Applied || !statuses[1].Applied || statuses[1].AppliedAt == nil {
+		t.Errorf("recived %+v, expected both to be applied", statuses)
+	}
+
+	//Roll back everything
+	if err := migrator.Down(context.Background(), "things", 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("SELECT * FROM things"); err == nil {
+		t.Errorf("expected the things table to be dropped")
+	}
+	statuses, _ = migrator.Status(context.Background())
+	if len(statuses) != 2 || statuses[0].Applied || statuses[1].Applied {
+		t.Errorf("recived %+v, expected none to be applied", statuses)
+	}
+}
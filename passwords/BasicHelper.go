@@ -5,7 +5,6 @@ package passwords
 
 import (
 	"crypto/rand"
-	"errors"
 	"fmt"
 	"log"
 	"strings"
@@ -74,8 +73,9 @@ func (helper *BasicHelper) HashPassword(password string) string {
 */
 func (helper *BasicHelper) CreateJWTToken(userId int, email string) string {
 
-	//Create new JWT token for the newly registered account
+	//Create new JWT token for the newly registered account, the id lets a single token be found later
 	tk := &Token{UserId: userId, Email: email}
+	tk.Id = helper.newTokenId()
 	token := jwt.NewWithClaims(jwt.GetSigningMethod("HS256"), tk)
 	tokenString, _ := token.SignedString(helper.jwtTokenPassword)
 
@@ -108,19 +108,40 @@ func (helper *BasicHelper) TokenGenerator() string {
 }
 
 /**
-  Compare passwords.  Determine if they match
+ * Get a random id for each jwt token
+ */
+func (helper *BasicHelper) newTokenId() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return fmt.Sprintf("%x", b)
+}
+
+/**
+  Validate the token and get the user id and email from it
 */
 func (helper *BasicHelper) ValidateToken(tokenHeader string) (int, string, error) {
+	tk, err := helper.ParseToken(tokenHeader)
+	if err != nil {
+		return -1, "", err
+	}
+
+	return tk.UserId, tk.Email, nil
+}
+
+/**
+  Validate the token and get all of the claims from it
+*/
+func (helper *BasicHelper) ParseToken(tokenHeader string) (*Token, error) {
 
 	//Token is missing, returns with error code 403 Unauthorized
 	if tokenHeader == "" {
-		return -1, "", errors.New("auth_missing_token")
+		return nil, ErrMissingToken
 	}
 
 	//Now split the token to get the useful part
 	splitted := strings.Split(tokenHeader, " ") //The token normally comes in format `Bearer {token-body}`, we check if the retrieved token matched this requirement
 	if len(splitted) != 2 {
-		return -1, "", errors.New("auth_malformed_token")
+		return nil, ErrMalformedToken
 
 	}
 
@@ -138,18 +159,18 @@ func (helper *BasicHelper) ValidateToken(tokenHeader string) (int, string, error
 
 	//check for mailformed data
 	if err != nil { //Malformed token, returns with http code 403 as usual
-		return -1, "", errors.New("auth_malformed_token")
+		return nil, ErrMalformedToken
 
 	}
 
 	//Token is invalid, maybe not signed on this server
 	if !token.Valid {
 		//Return the error
-		return -1, "", errors.New("auth_forbidden")
+		return nil, ErrForbiddenToken
 
 	}
 
-	return tk.UserId, tk.Email, nil
+	return tk, nil
 
 }
 
@@ -158,7 +179,7 @@ Make sure that the password is valid
 */
 func (helper *BasicHelper) ValidatePassword(password string) error {
 	if len(password) < 6 {
-		return errors.New("validate_password_insufficient")
+		return ErrPasswordInsufficient
 	}
 	return nil
 }
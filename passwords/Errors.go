@@ -0,0 +1,23 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package passwords
+
+import (
+	"net/http"
+
+	"github.com/reaction-eng/restlib/apierror"
+)
+
+/**
+Define the errors returned by the password helpers and repos
+*/
+var (
+	ErrMissingToken            = apierror.New(http.StatusUnauthorized, "auth_missing_token")
+	ErrMalformedToken          = apierror.New(http.StatusUnauthorized, "auth_malformed_token")
+	ErrForbiddenToken          = apierror.New(http.StatusUnauthorized, "auth_forbidden")
+	ErrPasswordInsufficient    = apierror.New(http.StatusUnprocessableEntity, "validate_password_insufficient")
+	ErrPasswordChangeForbidden = apierror.New(http.StatusForbidden, "password_change_forbidden")
+	ErrActivationForbidden     = apierror.New(http.StatusForbidden, "activation_forbidden")
+	ErrInvalidToken            = apierror.New(http.StatusForbidden, "invalid_token")
+)
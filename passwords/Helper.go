@@ -9,5 +9,6 @@ type Helper interface {
 	ComparePasswords(currentPwHash string, testingPassword string) bool
 	TokenGenerator() string
 	ValidateToken(tokenHeader string) (int, string, error)
+	ParseToken(tokenHeader string) (*Token, error)
 	ValidatePassword(password string) error
 }
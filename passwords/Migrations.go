@@ -0,0 +1,34 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package passwords
+
+import (
+	"github.com/reaction-eng/restlib/dialect"
+	"github.com/reaction-eng/restlib/migrations"
+)
+
+/**
+Get the schema migrations for the reset request table.  New columns must be added as a new migration at the end
+*/
+func Migrations(tableName string) migrations.Source {
+	return migrations.Source{
+		Name: "passwords." + tableName,
+		Migrations: []migrations.Migration{
+			{
+				Version: 1,
+				Name:    "create the reset request table",
+				Up: map[dialect.Dialect][]string{
+					dialect.MySql:    {"CREATE TABLE IF NOT EXISTS " + tableName + "(id int NOT NULL AUTO_INCREMENT, userId int, email TEXT, token TEXT, issued DATE, type INT, PRIMARY KEY (id) )"},
+					dialect.Postgres: {"CREATE TABLE IF NOT EXISTS " + tableName + "(id SERIAL PRIMARY KEY, userId int NOT NULL, email TEXT NOT NULL, token TEXT NOT NULL,issued DATE NOT NULL, type int NOT NULL)"},
+					dialect.Sqlite:   {"CREATE TABLE IF NOT EXISTS " + tableName + "(id INTEGER PRIMARY KEY AUTOINCREMENT, userId int NOT NULL, email TEXT NOT NULL, token TEXT NOT NULL, issued DATE NOT NULL, type int NOT NULL)"},
+				},
+				Down: map[dialect.Dialect][]string{
+					dialect.MySql:    {"DROP TABLE " + tableName},
+					dialect.Postgres: {"DROP TABLE " + tableName},
+					dialect.Sqlite:   {"DROP TABLE " + tableName},
+				},
+			},
+		},
+	}
+}
@@ -3,6 +3,8 @@
 
 package passwords
 
+import "context"
+
 /**
 Define an interface that all Calc Repos must follow
 */
@@ -33,6 +35,15 @@ type ResetRepo interface {
 	*/
 	UseToken(id int) error
 
+	/**
+	The same as each method above but stopped when the context ends, i.e. when the client goes away
+	*/
+	IssueResetRequestContext(ctx context.Context, token string, userId int, email string) error
+	CheckForResetTokenContext(ctx context.Context, userId int, resetToken string) (int, error)
+	IssueActivationRequestContext(ctx context.Context, token string, userId int, email string) error
+	CheckForActivationTokenContext(ctx context.Context, userId int, activationToken string) (int, error)
+	UseTokenContext(ctx context.Context, id int) error
+
 	/**
 	Allow databases to be closed
 	*/
@@ -4,10 +4,16 @@
 package passwords
 
 import (
-	"github.com/reaction-eng/restlib/configuration"
-	"github.com/reaction-eng/restlib/email"
+	"context"
 	"database/sql"
 	"errors"
+	"github.com/reaction-eng/restlib/apierror"
+	"github.com/reaction-eng/restlib/configuration"
+	"github.com/reaction-eng/restlib/dialect"
+	"github.com/reaction-eng/restlib/email"
+	"github.com/reaction-eng/restlib/migrations"
+	"github.com/reaction-eng/restlib/tracing"
+	"github.com/reaction-eng/restlib/transaction"
 	"log"
 	"time"
 )
@@ -31,6 +37,9 @@ type ResetRepoSql struct {
 	addRequestStatement *sql.Stmt
 	getRequestStatement *sql.Stmt
 	rmRequestStatement  *sql.Stmt
+
+	//How long each query can run, zero for no limit
+	queryTimeout time.Duration
 }
 
 /**
@@ -45,73 +54,16 @@ const (
 
 //Provide a method to make a new UserRepoSql
 func NewRepoMySql(db *sql.DB, tableName string, emailer email.Interface, configFile string) *ResetRepoSql {
-
-	//Create a config
-	config, err := configuration.NewConfiguration(configFile)
-
-	//If there is no error
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	//Build a reset and activation config
-	resetEmailConfig := PasswordResetConfig{}
-	activationEmailConfig := PasswordResetConfig{}
-
-	//Pull from the config
-	config.GetStruct("password_reset", &resetEmailConfig)
-	config.GetStruct("user_activation", &activationEmailConfig)
-
-	//Define a new repo
-	newRepo := ResetRepoSql{
-		db:                    db,
-		tableName:             tableName,
-		emailer:               emailer,
-		resetEmailConfig:      resetEmailConfig,
-		activationEmailConfig: activationEmailConfig,
-	}
-
-	//Create the table if it is not already there
-	//Create a table
-	_, err = db.Exec("CREATE TABLE IF NOT EXISTS " + tableName + "(id int NOT NULL AUTO_INCREMENT, userId int, email TEXT, token TEXT, issued DATE, type INT, PRIMARY KEY (id) )")
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	//Add request data to table
-	addRequest, err := db.Prepare("INSERT INTO " + tableName + "(userId,email, token, issued, type) VALUES (?, ?, ?, ?, ?)")
-	//Check for error
-	if err != nil {
-		log.Fatal(err)
-	}
-	//Store it
-	newRepo.addRequestStatement = addRequest
-
-	//pull the request from the table
-	getRequest, err := db.Prepare("SELECT * FROM " + tableName + " where userId = ? AND token = ? AND type = ?")
-	//Check for error
-	if err != nil {
-		log.Fatal(err)
-	}
-	//Store it
-	newRepo.getRequestStatement = getRequest
-
-	//pull the request from the table
-	rmRequest, err := db.Prepare("delete FROM " + tableName + " where id = ? limit 1")
-	//Check for error
-	if err != nil {
-		log.Fatal(err)
-	}
-	//Store it
-	newRepo.rmRequestStatement = rmRequest
-
-	//Return a point
-	return &newRepo
-
+	return NewRepoSql(db, dialect.MySql, tableName, emailer, configFile)
 }
 
 //Provide a method to make a new UserRepoSql
 func NewRepoPostgresSql(db *sql.DB, tableName string, emailer email.Interface, configFile ...string) *ResetRepoSql {
+	return NewRepoSql(db, dialect.Postgres, tableName, emailer, configFile...)
+}
+
+//Provide a method to make a new UserRepoSql for any supported dialect
+func NewRepoSql(db *sql.DB, sqlDialect dialect.Dialect, tableName string, emailer email.Interface, configFile ...string) *ResetRepoSql {
 
 	//Create a config
 	config, err := configuration.NewConfiguration(configFile...)
@@ -138,16 +90,14 @@ func NewRepoPostgresSql(db *sql.DB, tableName string, emailer email.Interface, c
 		activationEmailConfig: activationEmailConfig,
 	}
 
-	//Create the table if it is not already there
-	//Create a table
-	_, err = db.Exec("CREATE TABLE IF NOT EXISTS " + tableName + "(id SERIAL PRIMARY KEY, userId int NOT NULL, email TEXT NOT NULL, token TEXT NOT NULL,issued DATE NOT NULL, type int NOT NULL)")
-
+	//Bring the table up to date
+	err = migrations.NewMigrator(db, sqlDialect, Migrations(tableName)).Up(context.Background())
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	//Add request data to table
-	addRequest, err := db.Prepare("INSERT INTO " + tableName + "(userId,email, token, issued, type) VALUES ($1, $2, $3, $4, $5)")
+	addRequest, err := sqlDialect.Prepare(db, "INSERT INTO "+tableName+"(userId,email, token, issued, type) VALUES (?, ?, ?, ?, ?)")
 	//Check for error
 	if err != nil {
 		log.Fatal(err)
@@ -156,7 +106,7 @@ func NewRepoPostgresSql(db *sql.DB, tableName string, emailer email.Interface, c
 	newRepo.addRequestStatement = addRequest
 
 	//pull the request from the table
-	getRequest, err := db.Prepare("SELECT * FROM " + tableName + " where userId = $1 AND token = $2 AND type = $3")
+	getRequest, err := sqlDialect.Prepare(db, "SELECT * FROM "+tableName+" where userId = ? AND token = ? AND type = ?")
 	//Check for error
 	if err != nil {
 		log.Fatal(err)
@@ -164,8 +114,8 @@ func NewRepoPostgresSql(db *sql.DB, tableName string, emailer email.Interface, c
 	//Store it
 	newRepo.getRequestStatement = getRequest
 
-	//pull the request from the table
-	rmRequest, err := db.Prepare("delete FROM " + tableName + " where id = $1")
+	//pull the request from the table, the id is unique so there is no need to limit it
+	rmRequest, err := sqlDialect.Prepare(db, "delete FROM "+tableName+" where id = ?")
 	//Check for error
 	if err != nil {
 		log.Fatal(err)
@@ -178,48 +128,63 @@ func NewRepoPostgresSql(db *sql.DB, tableName string, emailer email.Interface, c
 
 }
 
+/**
+Limit how long each query can run.  Zero, the default, only stops a query when its context ends
+*/
+func (repo *ResetRepoSql) SetQueryTimeout(timeout time.Duration) {
+	repo.queryTimeout = timeout
+}
+
 /**
 Look up the user and return if they were found
 */
 func (repo *ResetRepoSql) IssueResetRequest(token string, userId int, emailAddress string) error {
+	return repo.IssueResetRequestContext(context.Background(), token, userId, emailAddress)
+}
 
-	//Now add it to the database
-	//Add the info
-	//execute the statement//(userId,name,input,flow)- "(userId,email, token, issued)
-	_, err := repo.addRequestStatement.Exec(userId, emailAddress, token, time.Now(), reset)
-
-	//Make the email header
-	header := email.HeaderInfo{
-		Subject: repo.resetEmailConfig.Subject,
-		To:      []string{emailAddress},
-	}
-
-	//Build a reset token
-	resetInfo := PasswordResetInfo{
-		Token: token,
-		Email: emailAddress,
-	}
-
-	//Now email
-	err = repo.emailer.SendEmailTemplateFile(&header, repo.resetEmailConfig.Template, resetInfo, nil)
-
-	//Return the user calcs
-	return err
+/**
+Look up the user and return if they were found
+*/
+func (repo *ResetRepoSql) IssueResetRequestContext(ctx context.Context, token string, userId int, emailAddress string) error {
+	return repo.issueRequest(ctx, "passwords.ResetRepoSql.IssueResetRequest", token, userId, emailAddress, reset, repo.resetEmailConfig)
 }
 
 /**
 Look up the user and return if they were found
 */
 func (repo *ResetRepoSql) IssueActivationRequest(token string, userId int, emailAddress string) error {
+	return repo.IssueActivationRequestContext(context.Background(), token, userId, emailAddress)
+}
+
+/**
+Look up the user and return if they were found
+*/
+func (repo *ResetRepoSql) IssueActivationRequestContext(ctx context.Context, token string, userId int, emailAddress string) error {
+	return repo.issueRequest(ctx, "passwords.ResetRepoSql.IssueActivationRequest", token, userId, emailAddress, activation, repo.activationEmailConfig)
+}
+
+/**
+Store the request and email the token
+*/
+func (repo *ResetRepoSql) issueRequest(ctx context.Context, spanName string, token string, userId int, emailAddress string, tkType tokenType, emailConfig PasswordResetConfig) error {
+	//Trace the query
+	ctx, span := tracing.StartSqlSpan(ctx, spanName, repo.tableName)
+	defer span.End()
+	ctx, cancel := dialect.WithTimeout(ctx, repo.queryTimeout)
+	defer cancel()
 
 	//Now add it to the database
 	//Add the info
 	//execute the statement//(userId,name,input,flow)- "(userId,email, token, issued)
-	_, err := repo.addRequestStatement.Exec(userId, emailAddress, token, time.Now(), activation)
+	_, err := transaction.Stmt(ctx, repo.db, repo.addRequestStatement).ExecContext(ctx, userId, emailAddress, token, time.Now(), tkType)
+	tracing.RecordError(span, err)
+	if err != nil {
+		return dialect.QueryError(err)
+	}
 
 	//Make the email header
 	header := email.HeaderInfo{
-		Subject: repo.activationEmailConfig.Subject,
+		Subject: emailConfig.Subject,
 		To:      []string{emailAddress},
 	}
 
@@ -229,24 +194,30 @@ func (repo *ResetRepoSql) IssueActivationRequest(token string, userId int, email
 		Email: emailAddress,
 	}
 
-	//Now email
-	err = repo.emailer.SendEmailTemplateFile(&header, repo.activationEmailConfig.Template, resetInfo, nil)
-
-	//Return the user calcs
-	return err
+	//Only email once the request is kept, so a rolled back token is never sent
+	return transaction.AfterCommit(ctx, func(ctx context.Context) error {
+		return repo.emailer.SendEmailTemplateFileContext(ctx, &header, emailConfig.Template, resetInfo, nil)
+	})
 }
 
 /**
 Use the taken to validate
 */
 func (repo *ResetRepoSql) CheckForResetToken(userId int, token string) (int, error) {
+	return repo.CheckForResetTokenContext(context.Background(), userId, token)
+}
+
+/**
+Use the taken to validate
+*/
+func (repo *ResetRepoSql) CheckForResetTokenContext(ctx context.Context, userId int, token string) (int, error) {
 
 	//Get the id and errors
-	id, err := repo.checkForToken(userId, token, reset)
+	id, err := repo.checkForToken(ctx, userId, token, reset)
 
 	//If there is an error customize it
-	if err != nil {
-		err = errors.New("password_change_forbidden")
+	if err == ErrInvalidToken {
+		err = ErrPasswordChangeForbidden
 	}
 
 	return id, err
@@ -257,13 +228,20 @@ func (repo *ResetRepoSql) CheckForResetToken(userId int, token string) (int, err
 Use the taken to validate
 */
 func (repo *ResetRepoSql) CheckForActivationToken(userId int, token string) (int, error) {
+	return repo.CheckForActivationTokenContext(context.Background(), userId, token)
+}
+
+/**
+Use the taken to validate
+*/
+func (repo *ResetRepoSql) CheckForActivationTokenContext(ctx context.Context, userId int, token string) (int, error) {
 
 	//Get the id and errors
-	id, err := repo.checkForToken(userId, token, activation)
+	id, err := repo.checkForToken(ctx, userId, token, activation)
 
 	//If there is an error customize it
-	if err != nil {
-		err = errors.New("activation_forbidden")
+	if err == ErrInvalidToken {
+		err = ErrActivationForbidden
 	}
 
 	return id, err
@@ -273,7 +251,12 @@ func (repo *ResetRepoSql) CheckForActivationToken(userId int, token string) (int
 /**
 Use the taken to validate
 */
-func (repo *ResetRepoSql) checkForToken(userId int, token string, tkType tokenType) (int, error) {
+func (repo *ResetRepoSql) checkForToken(ctx context.Context, userId int, token string, tkType tokenType) (int, error) {
+	//Trace the query
+	ctx, span := tracing.StartSqlSpan(ctx, "passwords.ResetRepoSql.checkForToken", repo.tableName)
+	defer span.End()
+	ctx, cancel := dialect.WithTimeout(ctx, repo.queryTimeout)
+	defer cancel()
 
 	//Prepare to get values
 	//id,  userId int, email TEXT, token TEXT, issued DATE,
@@ -285,19 +268,25 @@ func (repo *ResetRepoSql) checkForToken(userId int, token string, tkType tokenTy
 	var tokenDb tokenType
 
 	//Get the value
-	err := repo.getRequestStatement.QueryRow(userId, token, tkType).Scan(&id, &userIdDB, &emailDB, &tokenDB, &issued, &tokenDb)
+	err := transaction.Stmt(ctx, repo.db, repo.getRequestStatement).QueryRowContext(ctx, userId, token, tkType).Scan(&id, &userIdDB, &emailDB, &tokenDB, &issued, &tokenDb)
 
 	//So it was correct, check the date
 	//TODO: check the date
 
+	//A timeout is not a bad token
+	if err = dialect.QueryError(err); errors.Is(err, apierror.ErrTimeout) {
+		tracing.RecordError(span, err)
+		return -1, err
+	}
+
 	//If there is an error, assume it can't be done
 	if err != nil {
-		return -1, errors.New("invalid_token")
+		return -1, ErrInvalidToken
 	}
 
 	//Make sure the user id and token match
 	if userId != userIdDB || tokenDB != token {
-		return -1, errors.New("invalid_token")
+		return -1, ErrInvalidToken
 	}
 
 	//Return the user calcs
@@ -305,15 +294,21 @@ func (repo *ResetRepoSql) checkForToken(userId int, token string, tkType tokenTy
 }
 
 func (repo *ResetRepoSql) UseToken(id int) error {
+	return repo.UseTokenContext(context.Background(), id)
+}
 
-	//Remove the token
-	_, err := repo.rmRequestStatement.Exec(id)
+func (repo *ResetRepoSql) UseTokenContext(ctx context.Context, id int) error {
+	//Trace the query
+	ctx, span := tracing.StartSqlSpan(ctx, "passwords.ResetRepoSql.UseToken", repo.tableName)
+	defer span.End()
+	ctx, cancel := dialect.WithTimeout(ctx, repo.queryTimeout)
+	defer cancel()
 
-	if err != nil {
-		return err
-	}
+	//Remove the token
+	_, err := transaction.Stmt(ctx, repo.db, repo.rmRequestStatement).ExecContext(ctx, id)
+	tracing.RecordError(span, err)
 
-	return nil
+	return dialect.QueryError(err)
 }
 
 /**
@@ -0,0 +1,110 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package passwords_test
+
+import (
+	"context"
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/reaction-eng/restlib/dialect"
+	"github.com/reaction-eng/restlib/email"
+	"github.com/reaction-eng/restlib/passwords"
+	"github.com/reaction-eng/restlib/utils"
+	"path/filepath"
+	"testing"
+)
+
+/**
+Keep track of the emails instead of sending them
+*/
+type recordingEmailer struct {
+	sent []string
+}
+
+func (emailer *recordingEmailer) SendEmail(header *email.HeaderInfo, body string, attachments map[string][]*utils.Base64File) error {
+	emailer.sent = append(emailer.sent, header.To...)
+	return nil
+}
+
+func (emailer *recordingEmailer) SendEmailTemplateString(header *email.HeaderInfo, templateString string, data interface{}, attachments map[string][]*utils.Base64File) error {
+	emailer.sent = append(emailer.sent, header.To...)
+	return nil
+}
+
+func (emailer *recordingEmailer) SendEmailTemplateFile(header *email.HeaderInfo, templateFile string, data interface{}, attachments map[string][]*utils.Base64File) error {
+	emailer.sent = append(emailer.sent, header.To...)
+	return nil
+}
+
+func (emailer *recordingEmailer) SendEmailTable(header *email.HeaderInfo, tableData email.TableInfo, attachments map[string][]*utils.Base64File) error {
+	emailer.sent = append(emailer.sent, header.To...)
+	return nil
+}
+
+func (emailer *recordingEmailer) SendEmailContext(ctx context.Context, header *email.HeaderInfo, body string, attachments map[string][]*utils.Base64File) error {
+	return emailer.SendEmail(header, body, attachments)
+}
+
+func (emailer *recordingEmailer) SendEmailTemplateStringContext(ctx context.Context, header *email.HeaderInfo, templateString string, data interface{}, attachments map[string][]*utils.Base64File) error {
+	return emailer.SendEmailTemplateString(header, templateString, data, attachments)
+}
+
+func (emailer *recordingEmailer) SendEmailTemplateFileContext(ctx context.Context, header *email.HeaderInfo, templateFile string, data interface{}, attachments map[string][]*utils.Base64File) error {
+	return emailer.SendEmailTemplateFile(header, templateFile, data, attachments)
+}
+
+func (emailer *recordingEmailer) SendEmailTableContext(ctx context.Context, header *email.HeaderInfo, tableData email.TableInfo, attachments map[string][]*utils.Base64File) error {
+	return emailer.SendEmailTable(header, tableData, attachments)
+}
+
+/**
+Perform the testing
+*/
+func TestResetRepoSql(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "passwords.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	emailer := &recordingEmailer{}
+	repo := passwords.NewRepoSql(db, dialect.Sqlite, "resetrequests", emailer)
+	defer repo.CleanUp()
+
+	//Issue one of each
+	if err := repo.IssueResetRequest("reset-token", 4, "bob@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.IssueActivationRequest("activation-token", 4, "bob@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if len(emailer.sent) != 2 {
+		t.Errorf("recived %v, expected two emails", emailer.sent)
+	}
+
+	//The tokens are only good for their own type and user
+	if _, err := repo.CheckForResetToken(4, "activation-token"); err != passwords.ErrPasswordChangeForbidden {
+		t.Errorf("recived %v, expected %v", err, passwords.ErrPasswordChangeForbidden)
+	}
+	if _, err := repo.CheckForActivationToken(5, "activation-token"); err != passwords.ErrActivationForbidden {
+		t.Errorf("recived %v, expected %v", err, passwords.ErrActivationForbidden)
+	}
+
+	//Use the reset token
+	id, err := repo.CheckForResetToken(4, "reset-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.UseToken(id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.CheckForResetToken(4, "reset-token"); err != passwords.ErrPasswordChangeForbidden {
+		t.Errorf("recived %v, expected the token to be used up", err)
+	}
+
+	//The activation token is still there
+	if _, err := repo.CheckForActivationToken(4, "activation-token"); err != nil {
+		t.Errorf("recived %v, expected the activation token to still be valid", err)
+	}
+}
@@ -0,0 +1,19 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package preferences
+
+import (
+	"net/http"
+
+	"github.com/reaction-eng/restlib/apierror"
+)
+
+/**
+Define the errors returned by the preferences repos and handlers
+*/
+var (
+	ErrVersionConflict = apierror.New(http.StatusPreconditionFailed, "preferences_version_conflict")
+	ErrPatchMalformed  = apierror.New(http.StatusBadRequest, "preferences_patch_malformed")
+	ErrPatchFailed     = apierror.New(http.StatusConflict, "preferences_patch_failed")
+)
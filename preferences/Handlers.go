@@ -4,11 +4,14 @@
 package preferences
 
 import (
+	"errors"
+	"github.com/reaction-eng/restlib/apierror"
+	"github.com/reaction-eng/restlib/auth"
 	"github.com/reaction-eng/restlib/routing"
 	"github.com/reaction-eng/restlib/users"
 	"github.com/reaction-eng/restlib/utils"
-	"encoding/json"
 	"io/ioutil"
+	"mime"
 	"net/http"
 )
 
@@ -47,12 +50,28 @@ func (handler *Handler) GetRoutes() []routing.Route {
 			Method:      "GET",
 			Pattern:     "/users/preferences",
 			HandlerFunc: handler.handleUserPreferencesGet,
+			Description: "Get the settings and options of the logged in user.",
+			Tags:        []string{"preferences"},
+			Response:    Preferences{},
 		},
 		{ //Allow for the user to login
 			Name:        "Set the User Preferences",
 			Method:      "POST",
 			Pattern:     "/users/preferences",
 			HandlerFunc: handler.handleUserPreferencesSet,
+			Description: "Set the settings of the logged in user.  Each setting is checked against its option and hidden options can not be changed.  Send the ETag from the get as If-Match to only save over that version.",
+			Tags:        []string{"preferences"},
+			Request:     SettingGroup{},
+			Response:    Preferences{},
+		},
+		{ //Allow for the user to change only some settings
+			Name:        "Patch the User Preferences",
+			Method:      "PATCH",
+			Pattern:     "/users/preferences",
+			HandlerFunc: handler.handleUserPreferencesPatch,
+			Description: "Change some of the settings of the logged in user with a " + MergePatchContentType + " or " + JsonPatchContentType + " body.  Send the ETag from the get as If-Match to only patch that version.",
+			Tags:        []string{"preferences"},
+			Response:    Preferences{},
 		},
 	}
 
@@ -66,25 +85,30 @@ func (handler *Handler) GetRoutes() []routing.Route {
 func (handler *Handler) handleUserPreferencesGet(w http.ResponseWriter, r *http.Request) {
 
 	//We have gone through the auth, so we should know the id of the logged in user
-	loggedInUser := r.Context().Value("user").(int) //Grab the id of the user that send the request
+	loggedInUser, ok := auth.UserId(r.Context()) //Grab the id of the user that send the request
+	if !ok {
+		utils.ReturnError(w, apierror.ErrUnauthorized)
+		return
+	}
 
 	//Get the user
-	user, err := handler.userRepo.GetUser(loggedInUser)
+	user, err := handler.userRepo.GetUserContext(r.Context(), loggedInUser)
 
 	//If there is no error
 	if err != nil {
-		utils.ReturnJsonError(w, http.StatusUnprocessableEntity, err)
+		utils.ReturnError(w, err)
 		return
 	}
 
 	//Get the list of permissions
-	perf, err := handler.roleRepo.GetPreferences(user)
+	perf, err := handler.roleRepo.GetPreferencesContext(r.Context(), user)
 
 	//Check to see if the user was created
 	if err == nil {
+		utils.SetETag(w, perf.Version)
 		utils.ReturnJson(w, http.StatusOK, perf)
 	} else {
-		utils.ReturnJsonStatus(w, http.StatusUnsupportedMediaType, false, err.Error())
+		utils.ReturnError(w, err)
 	}
 
 }
@@ -95,37 +119,150 @@ func (handler *Handler) handleUserPreferencesGet(w http.ResponseWriter, r *http.
 func (handler *Handler) handleUserPreferencesSet(w http.ResponseWriter, r *http.Request) {
 
 	//We have gone through the auth, so we should know the id of the logged in user
-	loggedInUser := r.Context().Value("user").(int) //Grab the id of the user that send the request
+	loggedInUser, ok := auth.UserId(r.Context()) //Grab the id of the user that send the request
+	if !ok {
+		utils.ReturnError(w, apierror.ErrUnauthorized)
+		return
+	}
 
 	//Get the user
-	user, err := handler.userRepo.GetUser(loggedInUser)
+	user, err := handler.userRepo.GetUserContext(r.Context(), loggedInUser)
 
 	//If there is no error
 	if err != nil {
-		utils.ReturnJsonError(w, http.StatusUnprocessableEntity, err)
+		utils.ReturnError(w, err)
 		return
 	}
 
-	//Load in a limited amount of data from the body
-	body, err := ioutil.ReadAll(r.Body)
-
 	//Create an empty new calc
 	settings := SettingGroup{}
 
-	//Now marshal it into the body
-	if err := json.Unmarshal(body, &settings); err != nil {
-		utils.ReturnJsonStatus(w, http.StatusForbidden, false, err.Error())
+	//Load in a limited amount of data from the body
+	if err := utils.DecodeAndValidate(r, &settings); err != nil {
+		utils.ReturnError(w, err)
 		return
 	}
 
-	//Get the list of permissions
-	pref, err := handler.roleRepo.SetPreferences(user, &settings)
+	//Get the current settings
+	current, err := handler.roleRepo.GetPreferencesContext(r.Context(), user)
+	if err != nil {
+		utils.ReturnError(w, err)
+		return
+	}
+
+	//Make sure the client has the current version if it asked
+	if !utils.IfMatch(r, current.Version) {
+		utils.ReturnError(w, apierror.ErrPrecondition)
+		return
+	}
+
+	//Check each setting against its option
+	if err := current.Options.ValidateSettings(&settings, current.Settings); err != nil {
+		utils.ReturnError(w, err)
+		return
+	}
+
+	//Only save over the version the client has if it asked, the repo makes sure no one else saves in between
+	var pref *Preferences
+	if utils.HasIfMatch(r) {
+		pref, err = handler.roleRepo.SetPreferencesVersionContext(r.Context(), user, &settings, current.Version)
+	} else {
+		pref, err = handler.roleRepo.SetPreferencesContext(r.Context(), user, &settings)
+	}
+
+	//Check to see if the user was created
+	if err == nil {
+		utils.SetETag(w, pref.Version)
+		utils.ReturnJson(w, http.StatusOK, pref)
+	} else {
+		utils.ReturnError(w, err)
+	}
+
+}
+
+/**
+Apply a merge patch or json patch to the settings of the logged in user
+*/
+func (handler *Handler) handleUserPreferencesPatch(w http.ResponseWriter, r *http.Request) {
+
+	//We have gone through the auth, so we should know the id of the logged in user
+	loggedInUser, ok := auth.UserId(r.Context()) //Grab the id of the user that send the request
+	if !ok {
+		utils.ReturnError(w, apierror.ErrUnauthorized)
+		return
+	}
+
+	//Get the user
+	user, err := handler.userRepo.GetUserContext(r.Context(), loggedInUser)
+
+	//If there is no error
+	if err != nil {
+		utils.ReturnError(w, err)
+		return
+	}
+
+	//Read the patch in the format the client sent
+	patch, err := readPatch(r)
+	if err != nil {
+		utils.ReturnError(w, err)
+		return
+	}
+
+	//Make sure the client has the current version if it asked
+	version := AnyVersion
+	if utils.HasIfMatch(r) {
+		current, err := handler.roleRepo.GetPreferencesContext(r.Context(), user)
+		if err != nil {
+			utils.ReturnError(w, err)
+			return
+		}
+		if !utils.IfMatch(r, current.Version) {
+			utils.ReturnError(w, apierror.ErrPrecondition)
+			return
+		}
+		version = current.Version
+	}
+
+	//The repo checks and saves the patched settings
+	pref, err := handler.roleRepo.PatchPreferencesContext(r.Context(), user, patch, version)
 
 	//Check to see if the user was created
 	if err == nil {
+		utils.SetETag(w, pref.Version)
 		utils.ReturnJson(w, http.StatusOK, pref)
 	} else {
-		utils.ReturnJsonStatus(w, http.StatusUnsupportedMediaType, false, err.Error())
+		utils.ReturnError(w, err)
+	}
+
+}
+
+/**
+Read the body as a patch based on the content type
+*/
+func readPatch(r *http.Request) (Patch, error) {
+	supportedErr := apierror.ErrUnsupportedMedia.WithDetail("the patch must be " + MergePatchContentType + " or " + JsonPatchContentType)
+
+	//Get the type of patch
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, supportedErr
+	}
+
+	//Load in a limited amount of data from the body
+	body, err := ioutil.ReadAll(utils.LimitBody(r.Body, utils.DefaultMaxBodyBytes))
+	if err != nil {
+		if errors.Is(err, utils.ErrBodyTooLarge) {
+			return nil, apierror.ErrRequestTooLarge.Wrap(err)
+		}
+		return nil, apierror.ErrMalformedRequest.Wrap(err)
 	}
 
+	switch mediaType {
+	case MergePatchContentType:
+		return NewMergePatch(body)
+	case JsonPatchContentType:
+		return NewJsonPatch(body)
+	default:
+		return nil, supportedErr
+	}
 }
@@ -0,0 +1,163 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package preferences_test
+
+import (
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/reaction-eng/restlib/auth"
+	"github.com/reaction-eng/restlib/dialect"
+	"github.com/reaction-eng/restlib/preferences"
+	"github.com/reaction-eng/restlib/routing"
+	"github.com/reaction-eng/restlib/users"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/**
+Build a router for a logged in user on a new sqlite database
+*/
+func newTestRouter(t *testing.T) (*routing.Router, *preferences.RepoSql, users.User) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "preferences.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	repo := preferences.NewRepoSql(db, dialect.Sqlite, "preferences", newTestOptions())
+	t.Cleanup(repo.CleanUp)
+
+	//Add the logged in user
+	userRepo := users.NewRepoMemory()
+	user, _ := userRepo.AddUser(&users.BasicUser{Email_: "bob@example.com"})
+	return routing.NewRouter(nil, nil, nil, preferences.NewHandler(userRepo, repo)), repo, user
+}
+
+/**
+Send a request as the user
+*/
+func serveAs(router *routing.Router, user users.User, method string, body string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/users/preferences", strings.NewReader(body))
+	req = req.WithContext(auth.WithIdentity(req.Context(), &auth.Identity{UserId: user.Id()}))
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+/**
+Perform the testing
+*/
+func TestHandler(t *testing.T) {
+	router, repo, user := newTestRouter(t)
+
+	//Send a request as the user
+	serve := func(method string, body string, ifMatch string) *httptest.ResponseRecorder {
+		headers := map[string]string{}
+		if len(ifMatch) > 0 {
+			headers["If-Match"] = ifMatch
+		}
+		return serveAs(router, user, method, body, headers)
+	}
+
+	//Nothing has been saved yet
+	if rec := serve("GET", "", ""); rec.Code != http.StatusOK || rec.Header().Get("ETag") != `"0"` {
+		t.Errorf("recived %d %s, expected %d with version 0", rec.Code, rec.Header().Get("ETag"), http.StatusOK)
+	}
+
+	//Define the list of requests we are testing, in order
+	var requests = []struct {
+		name         string
+		body         string
+		ifMatch      string
+		expectedCode int
+		expectedETag string
+	}{
+		{"invalid", `{"settings":{"darkMode":"maybe"}}`, "", http.StatusUnprocessableEntity, ""},
+		{"hidden", `{"settings":{"plan":"paid"}}`, "", http.StatusUnprocessableEntity, ""},
+		{"first save", `{"settings":{"darkMode":"true"}}`, `"0"`, http.StatusOK, `"1"`},
+		{"stale", `{"settings":{"darkMode":"false"}}`, `"0"`, http.StatusPreconditionFailed, ""},
+		{"current", `{"settings":{"darkMode":"false"}}`, `"1"`, http.StatusOK, `"2"`},
+		{"no check", `{"settings":{"darkMode":"true"}}`, "", http.StatusOK, `"3"`},
+	}
+
+	for _, test := range requests {
+		rec := serve("POST", test.body, test.ifMatch)
+		if rec.Code != test.expectedCode {
+			t.Errorf("recived %d for %s, expected %d", rec.Code, test.name, test.expectedCode)
+		}
+		if etag := rec.Header().Get("ETag"); etag != test.expectedETag {
+			t.Errorf("recived %s for %s, expected %s", etag, test.name, test.expectedETag)
+		}
+	}
+
+	//The hidden option was never changed
+	saved, _ := repo.GetPreferences(user)
+	if value, _ := saved.Settings.GetValueAsString("plan"); value != "free" || saved.Version != 3 {
+		t.Errorf("recived %s at %d, expected free at 3", value, saved.Version)
+	}
+}
+
+/**
+Perform the testing
+*/
+func TestHandlerPatch(t *testing.T) {
+	router, repo, user := newTestRouter(t)
+
+	//Define the list of requests we are testing, in order
+	var requests = []struct {
+		name         string
+		contentType  string
+		body         string
+		ifMatch      string
+		expectedCode int
+		expectedETag string
+	}{
+		{"merge", preferences.MergePatchContentType, `{"settings":{"darkMode":"TRUE"}}`, "", http.StatusOK, `"1"`},
+		{"json", preferences.JsonPatchContentType, `[{"op":"replace","path":"/subgroup/view/settings/zoom","value":"200"}]`, `"1"`, http.StatusOK, `"2"`},
+		{"stale", preferences.JsonPatchContentType, `[{"op":"replace","path":"/settings/theme","value":"dark"}]`, `"1"`, http.StatusPreconditionFailed, ""},
+		{"plain json", "application/json", `{"settings":{"darkMode":"false"}}`, "", http.StatusUnsupportedMediaType, ""},
+		{"test fails", preferences.JsonPatchContentType, `[{"op":"test","path":"/settings/theme","value":"dark"}]`, "", http.StatusConflict, ""},
+		{"hidden", preferences.MergePatchContentType, `{"settings":{"plan":"paid"}}`, "", http.StatusUnprocessableEntity, ""},
+		{"out of range", preferences.MergePatchContentType, `{"subgroup":{"view":{"settings":{"zoom":"1000"}}}}`, "", http.StatusUnprocessableEntity, ""},
+		{
+			"partly invalid",
+			preferences.JsonPatchContentType,
+			`[{"op":"replace","path":"/settings/theme","value":"dark"},{"op":"replace","path":"/settings/darkMode","value":"maybe"}]`,
+			"",
+			http.StatusUnprocessableEntity,
+			"",
+		},
+	}
+
+	for _, test := range requests {
+		headers := map[string]string{"Content-Type": test.contentType}
+		if len(test.ifMatch) > 0 {
+			headers["If-Match"] = test.ifMatch
+		}
+
+		rec := serveAs(router, user, "PATCH", test.body, headers)
+		if rec.Code != test.expectedCode {
+			t.Errorf("recived %d for %s, expected %d", rec.Code, test.name, test.expectedCode)
+		}
+		if etag := rec.Header().Get("ETag"); etag != test.expectedETag {
+			t.Errorf("recived %s for %s, expected %s", etag, test.name, test.expectedETag)
+		}
+	}
+
+	//Only the good patches were saved
+	saved, _ := repo.GetPreferences(user)
+	darkMode, _ := saved.Settings.GetValueAsString("darkMode")
+	theme, _ := saved.Settings.GetValueAsString("theme")
+	zoom, _ := saved.Settings.GetSettingAsString([]string{"view", "zoom"})
+	if darkMode != "true" || theme != "light" || zoom != "200" || saved.Version != 2 {
+		t.Errorf("recived %s %s %s at %d, expected true light 200 at 2", darkMode, theme, zoom, saved.Version)
+	}
+}
+
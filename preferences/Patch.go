@@ -0,0 +1,430 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package preferences
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/reaction-eng/restlib/apierror"
+)
+
+//The content types for each kind of patch
+const (
+	MergePatchContentType = "application/merge-patch+json"
+	JsonPatchContentType  = "application/json-patch+json"
+)
+
+/**
+A change to part of a settings tree.  The paths are in the json form of the SettingGroup, i.e.
+/subgroup/view/settings/zoom
+*/
+type Patch interface {
+	//Apply the patch to a copy of the settings
+	Apply(settings *SettingGroup) (*SettingGroup, error)
+}
+
+/**
+A RFC 7396 merge patch.  Objects are merged, null removes a value and anything else replaces it
+*/
+type MergePatch struct {
+	patch interface{}
+}
+
+//Provide a method to make a new MergePatch from the request body
+func NewMergePatch(body []byte) (*MergePatch, error) {
+	var patch interface{}
+	if err := json.Unmarshal(body, &patch); err != nil {
+		return nil, ErrPatchMalformed.Wrap(err)
+	}
+
+	return &MergePatch{
+		patch: patch,
+	}, nil
+}
+
+func (mergePatch *MergePatch) Apply(settings *SettingGroup) (*SettingGroup, error) {
+	return applyToSettings(settings, func(doc interface{}) (interface{}, error) {
+		return mergeValue(doc, mergePatch.patch), nil
+	})
+}
+
+/**
+Merge the patch into the target
+*/
+func mergeValue(target interface{}, patch interface{}) interface{} {
+	//Anything but an object replaces the target
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = make(map[string]interface{})
+	}
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+		} else {
+			targetObject[key] = mergeValue(targetObject[key], value)
+		}
+	}
+	return targetObject
+}
+
+/**
+A single RFC 6902 operation
+*/
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+/**
+A RFC 6902 json patch.  The operations are applied in order and if any fails none are
+*/
+type JsonPatch struct {
+	operations []PatchOperation
+}
+
+//Provide a method to make a new JsonPatch from the request body
+func NewJsonPatch(body []byte) (*JsonPatch, error) {
+	operations := make([]PatchOperation, 0)
+
+	//Other members are ignored as RFC 6902 requires
+	if err := json.Unmarshal(body, &operations); err != nil {
+		return nil, ErrPatchMalformed.Wrap(err)
+	}
+
+	//Make sure each operation is complete
+	for i, operation := range operations {
+		switch operation.Op {
+		case "add", "replace", "test":
+			if len(operation.Value) == 0 {
+				return nil, ErrPatchMalformed.WithDetail("operation " + strconv.Itoa(i) + " is missing the value")
+			}
+		case "move", "copy":
+			if _, err := parsePointer(operation.From); err != nil {
+				return nil, err
+			}
+		case "remove":
+		default:
+			return nil, ErrPatchMalformed.WithDetail("operation " + strconv.Itoa(i) + " has an unknown op " + operation.Op)
+		}
+
+		if _, err := parsePointer(operation.Path); err != nil {
+			return nil, err
+		}
+	}
+
+	return &JsonPatch{
+		operations: operations,
+	}, nil
+}
+
+func (jsonPatch *JsonPatch) Apply(settings *SettingGroup) (*SettingGroup, error) {
+	return applyToSettings(settings, func(doc interface{}) (interface{}, error) {
+		var err error
+		for _, operation := range jsonPatch.operations {
+			if doc, err = operation.apply(doc); err != nil {
+				return nil, err
+			}
+		}
+		return doc, nil
+	})
+}
+
+/**
+Apply the operation to the document and return the new document
+*/
+func (operation PatchOperation) apply(doc interface{}) (interface{}, error) {
+	path, _ := parsePointer(operation.Path)
+
+	//Get the value if there is one
+	var value interface{}
+	if len(operation.Value) > 0 {
+		if err := json.Unmarshal(operation.Value, &value); err != nil {
+			return nil, ErrPatchMalformed.Wrap(err)
+		}
+	}
+
+	switch operation.Op {
+	case "add":
+		return addValue(doc, path, value, false)
+	case "replace":
+		return addValue(doc, path, value, true)
+	case "remove":
+		doc, _, err := removeValue(doc, path)
+		return doc, err
+	case "move":
+		//A value can't be moved into itself
+		if strings.HasPrefix(operation.Path+"/", operation.From+"/") && operation.Path != operation.From {
+			return nil, ErrPatchFailed.WithDetail("can not move " + operation.From + " into itself")
+		}
+
+		from, _ := parsePointer(operation.From)
+		doc, moved, err := removeValue(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		return addValue(doc, path, moved, false)
+	case "copy":
+		from, _ := parsePointer(operation.From)
+		copied, err := getValue(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		return addValue(doc, path, deepCopy(copied), false)
+	case "test":
+		current, err := getValue(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(current, value) {
+			return nil, ErrPatchFailed.WithDetail("test failed at " + operation.Path)
+		}
+		return doc, nil
+	}
+
+	return nil, ErrPatchMalformed.WithDetail("unknown op " + operation.Op)
+}
+
+/**
+Split the RFC 6901 json pointer into its unescaped tokens
+*/
+func parsePointer(pointer string) ([]string, error) {
+	if len(pointer) == 0 {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, ErrPatchMalformed.WithDetail("the path " + pointer + " must start with /")
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+	}
+	return tokens, nil
+}
+
+/**
+Get the value at the path
+*/
+func getValue(doc interface{}, path []string) (interface{}, error) {
+	for i, token := range path {
+		switch container := doc.(type) {
+		case map[string]interface{}:
+			value, found := container[token]
+			if !found {
+				return nil, pathNotFound(path[:i+1])
+			}
+			doc = value
+		case []interface{}:
+			index, err := arrayIndex(token, len(container)-1)
+			if err != nil {
+				return nil, pathNotFound(path[:i+1])
+			}
+			doc = container[index]
+		default:
+			return nil, pathNotFound(path[:i+1])
+		}
+	}
+	return doc, nil
+}
+
+/**
+Add the value at the path and return the new document.  If replace is set the value must already be there
+*/
+func addValue(doc interface{}, path []string, value interface{}, replace bool) (interface{}, error) {
+	//The whole document is replaced
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	//Get the parent
+	parent, err := getValue(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	token := path[len(path)-1]
+
+	switch container := parent.(type) {
+	case map[string]interface{}:
+		if _, found := container[token]; replace && !found {
+			return nil, pathNotFound(path)
+		}
+		container[token] = value
+		return doc, nil
+	case []interface{}:
+		//Find where it goes, - is the end
+		last := len(container)
+		if replace {
+			last--
+		}
+		index := last
+		if token != "-" || replace {
+			if index, err = arrayIndex(token, last); err != nil {
+				return nil, pathNotFound(path)
+			}
+		}
+
+		if replace {
+			container[index] = value
+			return doc, nil
+		}
+		grown := append(container[:index:index], value)
+		grown = append(grown, container[index:]...)
+		return setValue(doc, path[:len(path)-1], grown), nil
+	}
+
+	return nil, pathNotFound(path)
+}
+
+/**
+Remove the value at the path and return the new document and the removed value
+*/
+func removeValue(doc interface{}, path []string) (interface{}, interface{}, error) {
+	if len(path) == 0 {
+		return nil, nil, ErrPatchFailed.WithDetail("can not remove the whole document")
+	}
+
+	//Get the parent
+	parent, err := getValue(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, nil, err
+	}
+	token := path[len(path)-1]
+
+	switch container := parent.(type) {
+	case map[string]interface{}:
+		removed, found := container[token]
+		if !found {
+			return nil, nil, pathNotFound(path)
+		}
+		delete(container, token)
+		return doc, removed, nil
+	case []interface{}:
+		index, err := arrayIndex(token, len(container)-1)
+		if err != nil {
+			return nil, nil, pathNotFound(path)
+		}
+		removed := container[index]
+		shrunk := append(container[:index:index], container[index+1:]...)
+		return setValue(doc, path[:len(path)-1], shrunk), removed, nil
+	}
+
+	return nil, nil, pathNotFound(path)
+}
+
+/**
+Put the value at a path that is known to be there and return the new document.  Arrays are replaced this way since
+they change size
+*/
+func setValue(doc interface{}, path []string, value interface{}) interface{} {
+	if len(path) == 0 {
+		return value
+	}
+
+	parent, _ := getValue(doc, path[:len(path)-1])
+	token := path[len(path)-1]
+	switch container := parent.(type) {
+	case map[string]interface{}:
+		container[token] = value
+	case []interface{}:
+		index, _ := arrayIndex(token, len(container)-1)
+		container[index] = value
+	}
+	return doc
+}
+
+/**
+Get the array index from the token, it must be between 0 and last
+*/
+func arrayIndex(token string, last int) (int, error) {
+	//Leading zeros are not allowed
+	if len(token) > 1 && token[0] == '0' {
+		return 0, ErrPatchMalformed
+	}
+
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || index > last {
+		return 0, ErrPatchMalformed
+	}
+	return index, nil
+}
+
+/**
+Copy the json value so the copy can be changed on its own
+*/
+func deepCopy(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(typed))
+		for key, child := range typed {
+			copied[key] = deepCopy(child)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(typed))
+		for i, child := range typed {
+			copied[i] = deepCopy(child)
+		}
+		return copied
+	default:
+		return value
+	}
+}
+
+/**
+Build the error for a path that is not in the document
+*/
+func pathNotFound(path []string) error {
+	tokens := make([]string, len(path))
+	for i, token := range path {
+		tokens[i] = strings.Replace(strings.Replace(token, "~", "~0", -1), "/", "~1", -1)
+	}
+	return ErrPatchFailed.WithDetail("the path /" + strings.Join(tokens, "/") + " does not exist")
+}
+
+/**
+Convert the settings to a json document, apply the change and convert it back to a new SettingGroup
+*/
+func applyToSettings(settings *SettingGroup, change func(doc interface{}) (interface{}, error)) (*SettingGroup, error) {
+	//Get the json document
+	jsonBytes, err := json.Marshal(settings)
+	if err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	if err := json.Unmarshal(jsonBytes, &doc); err != nil {
+		return nil, err
+	}
+
+	//Change it
+	doc, err = change(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	//Convert it back, the result must still be a settings group
+	jsonBytes, err = json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	patched := newSettingGroup()
+	decoder := json.NewDecoder(bytes.NewReader(jsonBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(patched); err != nil {
+		return nil, apierror.ErrValidation.WithDetail("the patched settings are not valid").Wrap(err)
+	}
+	patched.checkSubStructureValid()
+
+	return patched, nil
+}
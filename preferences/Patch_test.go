@@ -0,0 +1,169 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package preferences_test
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/reaction-eng/restlib/apierror"
+	"github.com/reaction-eng/restlib/preferences"
+	"testing"
+)
+
+/**
+Build the settings used for testing
+*/
+func newTestSettings() *preferences.SettingGroup {
+	return &preferences.SettingGroup{
+		Settings: map[string]string{"darkMode": "false", "theme": "light"},
+		SubGroup: map[string]*preferences.SettingGroup{
+			"view": {Settings: map[string]string{"zoom": "100"}, SubGroup: map[string]*preferences.SettingGroup{}},
+		},
+	}
+}
+
+/**
+Perform the testing
+*/
+func TestJsonPatch(t *testing.T) {
+
+	//Define the list of patches we are testing
+	var patches = []struct {
+		name        string
+		patch       string
+		expected    string
+		expectedErr error
+	}{
+		{
+			"replace",
+			`[{"op":"replace","path":"/settings/darkMode","value":"true"}]`,
+			`{"settings":{"darkMode":"true","theme":"light"},"subgroup":{"view":{"settings":{"zoom":"100"},"subgroup":{}}}}`,
+			nil,
+		},
+		{
+			"add and remove",
+			`[{"op":"add","path":"/subgroup/view/settings/scale","value":"2"},{"op":"remove","path":"/settings/theme"}]`,
+			`{"settings":{"darkMode":"false"},"subgroup":{"view":{"settings":{"scale":"2","zoom":"100"},"subgroup":{}}}}`,
+			nil,
+		},
+		{
+			"move and copy",
+			`[{"op":"copy","from":"/settings/darkMode","path":"/settings/contrast"},{"op":"move","from":"/subgroup/view/settings/zoom","path":"/subgroup/view/settings/scale"}]`,
+			`{"settings":{"contrast":"false","darkMode":"false","theme":"light"},"subgroup":{"view":{"settings":{"scale":"100"},"subgroup":{}}}}`,
+			nil,
+		},
+		{
+			"test passes",
+			`[{"op":"test","path":"/settings/theme","value":"light"},{"op":"replace","path":"/settings/theme","value":"dark"}]`,
+			`{"settings":{"darkMode":"false","theme":"dark"},"subgroup":{"view":{"settings":{"zoom":"100"},"subgroup":{}}}}`,
+			nil,
+		},
+		{
+			"unknown member",
+			`[{"op":"replace","path":"/settings/darkMode","value":"true","comment":"ignored"}]`,
+			`{"settings":{"darkMode":"true","theme":"light"},"subgroup":{"view":{"settings":{"zoom":"100"},"subgroup":{}}}}`,
+			nil,
+		},
+		{"test fails", `[{"op":"test","path":"/settings/theme","value":"dark"}]`, "", preferences.ErrPatchFailed},
+		{"missing path", `[{"op":"replace","path":"/settings/fontSize","value":"12"}]`, "", preferences.ErrPatchFailed},
+		{"missing value", `[{"op":"add","path":"/settings/fontSize"}]`, "", preferences.ErrPatchMalformed},
+		{"unknown op", `[{"op":"swap","path":"/settings/theme"}]`, "", preferences.ErrPatchMalformed},
+		{"bad path", `[{"op":"remove","path":"settings"}]`, "", preferences.ErrPatchMalformed},
+		{"not a list", `{"op":"remove","path":"/settings/theme"}`, "", preferences.ErrPatchMalformed},
+		{"wrong type", `[{"op":"replace","path":"/settings/darkMode","value":true}]`, "", apierror.ErrValidation},
+	}
+
+	//March over each patch
+	for _, test := range patches {
+		settings := newTestSettings()
+
+		patched, err := applyPatch(parseJsonPatch, test.patch, settings)
+		if test.expectedErr != nil {
+			if !errors.Is(err, test.expectedErr) {
+				t.Errorf("recived %v for %s, expected %v", err, test.name, test.expectedErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("recived %v for %s", err, test.name)
+			continue
+		}
+		if result, _ := json.Marshal(patched); string(result) != test.expected {
+			t.Errorf("recived %s for %s, expected %s", result, test.name, test.expected)
+		}
+
+		//The original is never changed
+		if result, _ := json.Marshal(settings); string(result) != `{"settings":{"darkMode":"false","theme":"light"},"subgroup":{"view":{"settings":{"zoom":"100"},"subgroup":{}}}}` {
+			t.Errorf("recived %s for %s, expected the original to be left alone", result, test.name)
+		}
+	}
+}
+
+/**
+Perform the testing
+*/
+func TestMergePatch(t *testing.T) {
+
+	//Define the list of patches we are testing
+	var patches = []struct {
+		name        string
+		patch       string
+		expected    string
+		expectedErr error
+	}{
+		{
+			"merge",
+			`{"settings":{"darkMode":"true"},"subgroup":{"view":{"settings":{"scale":"2"}}}}`,
+			`{"settings":{"darkMode":"true","theme":"light"},"subgroup":{"view":{"settings":{"scale":"2","zoom":"100"},"subgroup":{}}}}`,
+			nil,
+		},
+		{
+			"null removes",
+			`{"settings":{"theme":null},"subgroup":{"view":null}}`,
+			`{"settings":{"darkMode":"false"},"subgroup":{}}`,
+			nil,
+		},
+		{"malformed", `{"settings":`, "", preferences.ErrPatchMalformed},
+		{"unknown field", `{"colors":{"background":"red"}}`, "", apierror.ErrValidation},
+	}
+
+	//March over each patch
+	for _, test := range patches {
+		patched, err := applyPatch(parseMergePatch, test.patch, newTestSettings())
+		if test.expectedErr != nil {
+			if !errors.Is(err, test.expectedErr) {
+				t.Errorf("recived %v for %s, expected %v", err, test.name, test.expectedErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("recived %v for %s", err, test.name)
+			continue
+		}
+		if result, _ := json.Marshal(patched); string(result) != test.expected {
+			t.Errorf("recived %s for %s, expected %s", result, test.name, test.expected)
+		}
+	}
+}
+
+/**
+Parse each kind of patch
+*/
+func parseJsonPatch(body []byte) (preferences.Patch, error) {
+	return preferences.NewJsonPatch(body)
+}
+func parseMergePatch(body []byte) (preferences.Patch, error) {
+	return preferences.NewMergePatch(body)
+}
+
+/**
+Parse and apply the patch
+*/
+func applyPatch(parse func(body []byte) (preferences.Patch, error), body string, settings *preferences.SettingGroup) (*preferences.SettingGroup, error) {
+	patch, err := parse([]byte(body))
+	if err != nil {
+		return nil, err
+	}
+	return patch.Apply(settings)
+}
@@ -3,6 +3,9 @@
 
 package preferences
 
+//Used in place of a version to save over whatever version is current
+const AnyVersion = -1
+
 //Get the setting group
 type Preferences struct {
 	//And the value
@@ -10,4 +13,7 @@ type Preferences struct {
 
 	//We can also old other groups
 	Options *OptionGroup `json:"options"`
+
+	//Goes up by one each time the settings are saved, zero if they never have been
+	Version int `json:"-"`
 }
@@ -4,6 +4,7 @@
 package preferences
 
 import (
+	"context"
 	"github.com/reaction-eng/restlib/users"
 )
 
@@ -21,6 +22,28 @@ type Repo interface {
 	*/
 	SetPreferences(user users.User, userSetting *SettingGroup) (*Preferences, error)
 
+	/**
+	Update the User pref only if they are still at the version, i.e. the one the client last read.  Version zero
+	means nothing has been saved yet.  ErrVersionConflict is returned if someone else got there first.
+	*/
+	SetPreferencesVersion(user users.User, userSetting *SettingGroup, version int) (*Preferences, error)
+
+	/**
+	Apply the patch to the current settings, check them against the options and save them all at once.  Hidden
+	options can not be changed.  ErrVersionConflict is returned if the settings are not at the version, use AnyVersion
+	to patch whatever is current.  With AnyVersion the patch is tried again if someone else saves in between.  The
+	patch is applied to the settings with their defaults, but only the values the user changed are stored.
+	*/
+	PatchPreferences(user users.User, patch Patch, version int) (*Preferences, error)
+
+	/**
+	The same as each method above but stopped when the context ends, i.e. when the client goes away
+	*/
+	GetPreferencesContext(ctx context.Context, user users.User) (*Preferences, error)
+	SetPreferencesContext(ctx context.Context, user users.User, userSetting *SettingGroup) (*Preferences, error)
+	SetPreferencesVersionContext(ctx context.Context, user users.User, userSetting *SettingGroup, version int) (*Preferences, error)
+	PatchPreferencesContext(ctx context.Context, user users.User, patch Patch, version int) (*Preferences, error)
+
 	/**
 	Allow databases to be closed
 	*/
@@ -4,9 +4,16 @@
 package preferences
 
 import (
-	"github.com/reaction-eng/restlib/users"
+	"context"
 	"database/sql"
+	"errors"
+	"github.com/reaction-eng/restlib/dialect"
+	"github.com/reaction-eng/restlib/migrations"
+	"github.com/reaction-eng/restlib/tracing"
+	"github.com/reaction-eng/restlib/transaction"
+	"github.com/reaction-eng/restlib/users"
 	"log"
+	"time"
 )
 
 /**
@@ -20,32 +27,53 @@ type RepoSql struct {
 	tableName string
 
 	//Store the required statements to reduce comput time
-	getSettingFromDbCmd *sql.Stmt
-	setSettingIntoDbCmd *sql.Stmt
+	getSettingFromDbCmd    *sql.Stmt
+	setSettingIntoDbCmd    *sql.Stmt
+	updateSettingInDbCmd   *sql.Stmt
+	insertSettingIntoDbCmd *sql.Stmt
+
+	//Keep the save and the read of the new version together
+	transactions *transaction.SqlManager
 
 	//We need the role Repo
 	baseOptions *OptionGroup
+
+	//How long each query can run, zero for no limit
+	queryTimeout time.Duration
 }
 
+//How many more times a patch at AnyVersion is tried when someone else saved first
+const anyVersionRetries = 3
+
 //Provide a method to make a new UserRepoSql
 func NewRepoMySql(db *sql.DB, tableName string, baseOptions *OptionGroup) *RepoSql {
+	return NewRepoSql(db, dialect.MySql, tableName, baseOptions)
+}
+
+//Provide a method to make a new UserRepoSql
+func NewRepoPostgresSql(db *sql.DB, tableName string, baseOptions *OptionGroup) *RepoSql {
+	return NewRepoSql(db, dialect.Postgres, tableName, baseOptions)
+}
+
+//Provide a method to make a new UserRepoSql for any supported dialect
+func NewRepoSql(db *sql.DB, sqlDialect dialect.Dialect, tableName string, baseOptions *OptionGroup) *RepoSql {
 
 	//Define a new repo
 	newRepo := RepoSql{
-		db:          db,
-		tableName:   tableName,
-		baseOptions: baseOptions,
+		db:           db,
+		tableName:    tableName,
+		baseOptions:  baseOptions,
+		transactions: transaction.NewSqlManager(db),
 	}
 
-	//Create the table if it is not already there
-	//Create a table
-	_, err := db.Exec("CREATE TABLE IF NOT EXISTS " + tableName + "(userId int NOT NULL, settings TEXT NOT NULL, PRIMARY KEY (userId) )")
+	//Bring the table up to date
+	err := migrations.NewMigrator(db, sqlDialect, Migrations(tableName)).Up(context.Background())
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	//Get the settings
-	getSetting, err := db.Prepare("SELECT settings FROM " + tableName + " WHERE userID = ?")
+	getSetting, err := sqlDialect.Prepare(db, "SELECT settings, version FROM "+tableName+" WHERE userID = ?")
 	//Check for error
 	if err != nil {
 		log.Fatal(err)
@@ -53,62 +81,52 @@ func NewRepoMySql(db *sql.DB, tableName string, baseOptions *OptionGroup) *RepoS
 	newRepo.getSettingFromDbCmd = getSetting
 
 	//Get the settings
-	setSetting, err := db.Prepare("INSERT INTO " + tableName + "(userId,settings) VALUES (?,?) ON DUPLICATE KEY UPDATE settings = VALUES(settings)")
+	setSetting, err := db.Prepare(sqlDialect.UpsertVersion(tableName, []string{"userId"}, "version", "userId", "settings"))
 	//Check for error
 	if err != nil {
 		log.Fatal(err)
 	}
 	newRepo.setSettingIntoDbCmd = setSetting
 
-	//Return a point
-	return &newRepo
-
-}
-
-//Provide a method to make a new UserRepoSql
-func NewRepoPostgresSql(db *sql.DB, tableName string, baseOptions *OptionGroup) *RepoSql {
-
-	//Define a new repo
-	newRepo := RepoSql{
-		db:          db,
-		tableName:   tableName,
-		baseOptions: baseOptions,
-	}
-
-	//Create the table if it is not already there
-	//Create a table
-	_, err := db.Exec("CREATE TABLE IF NOT EXISTS " + tableName + "(userId SERIAL PRIMARY KEY, settings TEXT NOT NULL)")
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	//Get the settings
-	getSetting, err := db.Prepare("SELECT settings FROM " + tableName + " WHERE userID = $1")
-	//Check for error
+	//Update the settings if no one else has since they were read
+	updateSetting, err := sqlDialect.Prepare(db, "UPDATE "+tableName+" SET settings = ?, version = version + 1 WHERE userId = ? AND version = ?")
 	if err != nil {
 		log.Fatal(err)
 	}
-	newRepo.getSettingFromDbCmd = getSetting
+	newRepo.updateSettingInDbCmd = updateSetting
 
-	//Get the settings
-	setSetting, err := db.Prepare("INSERT INTO " + tableName + "(userId,settings) VALUES ($1, $2) ON CONFLICT (userId) DO UPDATE SET settings = $2")
-	//Check for error
+	//Add the first settings if no one else has
+	insertSetting, err := db.Prepare(sqlDialect.InsertIfMissing(tableName, []string{"userId"}, "userId", "settings"))
 	if err != nil {
 		log.Fatal(err)
 	}
-	newRepo.setSettingIntoDbCmd = setSetting
+	newRepo.insertSettingIntoDbCmd = insertSetting
 
 	//Return a point
 	return &newRepo
 
 }
 
+/**
+Limit how long each query can run.  Zero, the default, only stops a query when its context ends
+*/
+func (repo *RepoSql) SetQueryTimeout(timeout time.Duration) {
+	repo.queryTimeout = timeout
+}
+
 /**
 Get the user with the email.  An error is thrown is not found
 */
 func (repo *RepoSql) GetPreferences(user users.User) (*Preferences, error) {
+	return repo.GetPreferencesContext(context.Background(), user)
+}
+
+/**
+Get the user with the email.  An error is thrown is not found
+*/
+func (repo *RepoSql) GetPreferencesContext(ctx context.Context, user users.User) (*Preferences, error) {
 	//Get the settings from the db
-	settings, err := repo.getSettingsFromDb(user)
+	settings, version, err := repo.getSettingsFromDb(ctx, user)
 	if err != nil {
 		return nil, err
 	}
@@ -122,47 +140,184 @@ func (repo *RepoSql) GetPreferences(user users.User) (*Preferences, error) {
 	return &Preferences{
 		Settings: settings,
 		Options:  repo.baseOptions,
+		Version:  version,
 	}, nil
 
 }
 
 /**
-Get the user with the email.  An error is thrown is not found
+Get the settings and their version.  Users without settings get an empty group at version zero
 */
-func (repo *RepoSql) getSettingsFromDb(user users.User) (*SettingGroup, error) {
+func (repo *RepoSql) getSettingsFromDb(ctx context.Context, user users.User) (*SettingGroup, int, error) {
+	//Trace the query
+	ctx, span := tracing.StartSqlSpan(ctx, "preferences.RepoSql.getSettingsFromDb", repo.tableName)
+	defer span.End()
+	ctx, cancel := dialect.WithTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
 	//Get the id
 	var setting *SettingGroup
+	var version int
 
 	//Pull from the database
-	err := repo.getSettingFromDbCmd.QueryRow(user.Id()).Scan(&setting)
+	err := transaction.Stmt(ctx, repo.db, repo.getSettingFromDbCmd).QueryRowContext(ctx, user.Id()).Scan(&setting, &version)
 	//If there is an error return
 	if err == nil {
-		return setting, nil
+		return setting, version, nil
 	} else if err == sql.ErrNoRows {
-		return newSettingGroup(), nil
+		return newSettingGroup(), 0, nil
 
 	} else {
-		return nil, err
+		tracing.RecordError(span, err)
+		return nil, 0, dialect.QueryError(err)
 	}
 }
 
 func (repo *RepoSql) SetPreferences(user users.User, userSetting *SettingGroup) (*Preferences, error) {
+	return repo.SetPreferencesContext(context.Background(), user, userSetting)
+}
 
-	//Now add the //(asmId,type,Date, comments)
-	_, err := repo.setSettingIntoDbCmd.Exec(user.Id(), userSetting)
+func (repo *RepoSql) SetPreferencesContext(ctx context.Context, user users.User, userSetting *SettingGroup) (*Preferences, error) {
+	//Trace the query
+	ctx, span := tracing.StartSqlSpan(ctx, "preferences.RepoSql.SetPreferences", repo.tableName)
+	defer span.End()
+	ctx, cancel := dialect.WithTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	//Save them and read back the new version
+	version := 0
+	err := repo.transactions.Atomic(ctx, func(ctx context.Context) error {
+		//Now add the //(asmId,type,Date, comments)
+		_, err := transaction.Stmt(ctx, repo.db, repo.setSettingIntoDbCmd).ExecContext(ctx, user.Id(), userSetting)
+		if err != nil {
+			return err
+		}
+
+		_, version, err = repo.getSettingsFromDb(ctx, user)
+		return err
+	})
+	tracing.RecordError(span, err)
 
 	return &Preferences{
 		Settings: userSetting,
 		Options:  repo.baseOptions,
-	}, err
+		Version:  version,
+	}, dialect.QueryError(err)
 
 }
 
+func (repo *RepoSql) SetPreferencesVersion(user users.User, userSetting *SettingGroup, version int) (*Preferences, error) {
+	return repo.SetPreferencesVersionContext(context.Background(), user, userSetting, version)
+}
+
+func (repo *RepoSql) SetPreferencesVersionContext(ctx context.Context, user users.User, userSetting *SettingGroup, version int) (*Preferences, error) {
+	//Trace the query
+	ctx, span := tracing.StartSqlSpan(ctx, "preferences.RepoSql.SetPreferencesVersion", repo.tableName)
+	defer span.End()
+	ctx, cancel := dialect.WithTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
+	//Add them if there are none yet, otherwise update the version that was read
+	var result sql.Result
+	var err error
+	if version == 0 {
+		result, err = transaction.Stmt(ctx, repo.db, repo.insertSettingIntoDbCmd).ExecContext(ctx, user.Id(), userSetting)
+	} else {
+		result, err = transaction.Stmt(ctx, repo.db, repo.updateSettingInDbCmd).ExecContext(ctx, userSetting, user.Id(), version)
+	}
+	tracing.RecordError(span, err)
+	if err != nil {
+		return nil, dialect.QueryError(err)
+	}
+
+	//If nothing was saved someone else got there first
+	saved, err := result.RowsAffected()
+	if err != nil {
+		return nil, dialect.QueryError(err)
+	}
+	if saved == 0 {
+		return nil, ErrVersionConflict
+	}
+
+	return &Preferences{
+		Settings: userSetting,
+		Options:  repo.baseOptions,
+		Version:  version + 1,
+	}, nil
+}
+
+func (repo *RepoSql) PatchPreferences(user users.User, patch Patch, version int) (*Preferences, error) {
+	return repo.PatchPreferencesContext(context.Background(), user, patch, version)
+}
+
+func (repo *RepoSql) PatchPreferencesContext(ctx context.Context, user users.User, patch Patch, version int) (*Preferences, error) {
+	//Trace the query
+	ctx, span := tracing.StartSqlSpan(ctx, "preferences.RepoSql.PatchPreferences", repo.tableName)
+	defer span.End()
+
+	//Read, patch and save in one transaction.  When any version will do, try again if someone else saved first
+	pref, err := repo.patchPreferences(ctx, user, patch, version)
+	for retry := 0; retry < anyVersionRetries && version == AnyVersion && errors.Is(err, ErrVersionConflict); retry++ {
+		pref, err = repo.patchPreferences(ctx, user, patch, version)
+	}
+	tracing.RecordError(span, err)
+
+	return pref, err
+}
+
+/**
+Read, patch and save the settings once.  The patch sees the settings with the defaults, but only the values the
+user has set are saved so the others keep following the defaults
+*/
+func (repo *RepoSql) patchPreferences(ctx context.Context, user users.User, patch Patch, version int) (*Preferences, error) {
+	var pref *Preferences
+	err := repo.transactions.Atomic(ctx, func(ctx context.Context) error {
+		//Get the stored settings
+		stored, currentVersion, err := repo.getSettingsFromDb(ctx, user)
+		if err != nil {
+			return err
+		}
+		if version != AnyVersion && version != currentVersion {
+			return ErrVersionConflict
+		}
+		stored.checkSubStructureValid()
+
+		//Fill in the defaults so the patch sees what the user sees
+		current := stored.copy()
+		current.checkAndSetDefaultValues(repo.baseOptions)
+
+		//Patch them and make sure they are still valid
+		settings, err := patch.Apply(current)
+		if err != nil {
+			return err
+		}
+		if err := repo.baseOptions.ValidateSettings(settings, current); err != nil {
+			return err
+		}
+
+		//Only save over the version that was read
+		pref, err = repo.SetPreferencesVersionContext(ctx, user, storedSettings(stored, current, settings), currentVersion)
+		if err != nil {
+			return err
+		}
+
+		//Return the settings with the defaults
+		settings.checkAndSetDefaultValues(repo.baseOptions)
+		pref.Settings = settings
+		return nil
+	})
+
+	return pref, err
+}
+
 /**
 Nothing much to do for the clean up
 */
 func (repo *RepoSql) CleanUp() {
 	//Close all of the prepared statements
 	repo.getSettingFromDbCmd.Close()
+	repo.setSettingIntoDbCmd.Close()
+	repo.updateSettingInDbCmd.Close()
+	repo.insertSettingIntoDbCmd.Close()
 
 }
@@ -0,0 +1,235 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package preferences_test
+
+import (
+	"context"
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/reaction-eng/restlib/dialect"
+	"github.com/reaction-eng/restlib/preferences"
+	"github.com/reaction-eng/restlib/transaction"
+	"github.com/reaction-eng/restlib/users"
+	"path/filepath"
+	"testing"
+)
+
+/**
+Perform the testing
+*/
+func TestRepoSql(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "preferences.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	options := &preferences.OptionGroup{
+		Id:      "root",
+		Options: []preferences.Option{{Id: "darkMode", Type: preferences.Bool, DefaultValue: "false"}},
+		SubGroups: []preferences.OptionGroup{
+			{Id: "view", Options: []preferences.Option{{Id: "zoom", Type: preferences.Int, DefaultValue: "100"}}},
+		},
+	}
+	repo := preferences.NewRepoSql(db, dialect.Sqlite, "preferences", options)
+	defer repo.CleanUp()
+
+	user := &users.BasicUser{Id_: 3}
+
+	//New users get the defaults
+	prefs, err := repo.GetPreferences(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := prefs.Settings.GetSettingAsString([]string{"view", "zoom"}); value != "100" {
+		t.Errorf("recived %s, expected the default of 100", value)
+	}
+
+	//Save them twice so the second one updates the first
+	for _, darkMode := range []string{"true", "false"} {
+		prefs.Settings.Settings["darkMode"] = darkMode
+		if _, err := repo.SetPreferences(user, prefs.Settings); err != nil {
+			t.Fatal(err)
+		}
+
+		saved, err := repo.GetPreferences(user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value, _ := saved.Settings.GetValueAsString("darkMode"); value != darkMode {
+			t.Errorf("recived %s, expected %s", value, darkMode)
+		}
+	}
+	//The version counts each save
+	saved, _ := repo.GetPreferences(user)
+	if saved.Version != 2 {
+		t.Errorf("recived %d, expected 2", saved.Version)
+	}
+
+	//Only the current version can be saved over
+	if _, err := repo.SetPreferencesVersion(user, saved.Settings, 1); err != preferences.ErrVersionConflict {
+		t.Errorf("recived %v, expected %v", err, preferences.ErrVersionConflict)
+	}
+	if prefs, err := repo.SetPreferencesVersion(user, saved.Settings, 2); err != nil || prefs.Version != 3 {
+		t.Errorf("recived %v %v, expected version 3", prefs, err)
+	}
+
+	//A new user can only be added once
+	other := &users.BasicUser{Id_: 4}
+	if prefs, err := repo.SetPreferencesVersion(other, saved.Settings, 0); err != nil || prefs.Version != 1 {
+		t.Errorf("recived %v %v, expected version 1", prefs, err)
+	}
+	if _, err := repo.SetPreferencesVersion(other, saved.Settings, 0); err != preferences.ErrVersionConflict {
+		t.Errorf("recived %v, expected %v", err, preferences.ErrVersionConflict)
+	}
+}
+
+/**
+A patch that saves over the settings the first few times it is applied, like another request getting there first
+*/
+type racingPatch struct {
+	ctx     context.Context
+	repo    *preferences.RepoSql
+	user    users.User
+	races   int
+	applied int
+}
+
+func (patch *racingPatch) Apply(settings *preferences.SettingGroup) (*preferences.SettingGroup, error) {
+	patch.applied++
+	if patch.applied <= patch.races {
+		if _, err := patch.repo.SetPreferencesContext(patch.ctx, patch.user, settings); err != nil {
+			return nil, err
+		}
+	}
+	merge, err := preferences.NewMergePatch([]byte(`{"settings": {"darkMode": "true"}}`))
+	if err != nil {
+		return nil, err
+	}
+	return merge.Apply(settings)
+}
+
+/**
+Perform the testing
+*/
+func TestRepoSqlPatchRetry(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "preferences.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	options := &preferences.OptionGroup{
+		Id:      "root",
+		Options: []preferences.Option{{Id: "darkMode", Type: preferences.Bool, DefaultValue: "false"}},
+	}
+	repo := preferences.NewRepoSql(db, dialect.Sqlite, "preferences", options)
+	defer repo.CleanUp()
+	user := &users.BasicUser{Id_: 3}
+	if _, err := repo.GetPreferences(user); err != nil {
+		t.Fatal(err)
+	}
+
+	//Define the list of patches we are testing
+	var patches = []struct {
+		name        string
+		version     int
+		races       int
+		expectedErr error
+	}{
+		{"any version", preferences.AnyVersion, 1, nil},
+		{"set version", 0, 1, preferences.ErrVersionConflict},
+		{"always racing", preferences.AnyVersion, 100, preferences.ErrVersionConflict},
+	}
+
+	for _, pp := range patches {
+		//Share the transaction so the racing save can run while the patch is being read
+		err := transaction.NewSqlManager(db).Atomic(context.Background(), func(ctx context.Context) error {
+			version := pp.version
+			if version != preferences.AnyVersion {
+				current, _ := repo.GetPreferencesContext(ctx, user)
+				version = current.Version
+			}
+
+			patch := &racingPatch{ctx: ctx, repo: repo, user: user, races: pp.races}
+			_, err := repo.PatchPreferencesContext(ctx, user, patch, version)
+			return err
+		})
+		if err != pp.expectedErr {
+			t.Errorf("recived %v for %s, expected %v", err, pp.name, pp.expectedErr)
+		}
+	}
+}
+
+/**
+Perform the testing
+*/
+func TestRepoSqlPatchStoresChanges(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "preferences.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	//Build the repo with the defaults
+	newRepo := func(theme string) *preferences.RepoSql {
+		return preferences.NewRepoSql(db, dialect.Sqlite, "preferences", &preferences.OptionGroup{
+			Id: "root",
+			Options: []preferences.Option{
+				{Id: "darkMode", Type: preferences.Bool, DefaultValue: "false"},
+				{Id: "theme", Type: preferences.String, DefaultValue: theme},
+			},
+			SubGroups: []preferences.OptionGroup{
+				{Id: "view", Options: []preferences.Option{{Id: "zoom", Type: preferences.Int, DefaultValue: "100"}}},
+			},
+		})
+	}
+	repo := newRepo("light")
+	defer repo.CleanUp()
+	user := &users.BasicUser{Id_: 3}
+
+	//Get what is in the db
+	stored := func() string {
+		var settings string
+		if err := db.QueryRow("SELECT settings FROM preferences WHERE userId = ?", user.Id()).Scan(&settings); err != nil {
+			t.Fatal(err)
+		}
+		return settings
+	}
+
+	//Patch one key, the result has the defaults but only the key is stored
+	patch, _ := preferences.NewMergePatch([]byte(`{"settings": {"darkMode": "true"}}`))
+	pref, err := repo.PatchPreferences(user, patch, preferences.AnyVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if theme, _ := pref.Settings.GetValueAsString("theme"); theme != "light" {
+		t.Errorf("recived %s, expected the default theme", theme)
+	}
+	if settings := stored(); settings != `{"settings":{"darkMode":"true"},"subgroup":{}}` {
+		t.Errorf("recived %s, expected only darkMode to be stored", settings)
+	}
+
+	//Json patches see the defaults too
+	jsonPatch, _ := preferences.NewJsonPatch([]byte(`[{"op":"replace","path":"/subgroup/view/settings/zoom","value":"150"},{"op":"remove","path":"/settings/darkMode"}]`))
+	if _, err := repo.PatchPreferences(user, jsonPatch, preferences.AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	if settings := stored(); settings != `{"settings":{},"subgroup":{"view":{"settings":{"zoom":"150"},"subgroup":{}}}}` {
+		t.Errorf("recived %s, expected only the zoom to be stored", settings)
+	}
+
+	//A new default reaches the user
+	changed := newRepo("dark")
+	defer changed.CleanUp()
+	prefs, err := changed.GetPreferences(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	theme, _ := prefs.Settings.GetValueAsString("theme")
+	zoom, _ := prefs.Settings.GetSettingAsString([]string{"view", "zoom"})
+	if theme != "dark" || zoom != "150" {
+		t.Errorf("recived %s %s, expected the new default and the stored zoom", theme, zoom)
+	}
+}
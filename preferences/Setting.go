@@ -131,6 +131,55 @@ func (setGroup *SettingGroup) checkAndSetDefaultValues(options *OptionGroup) {
 
 }
 
+/**
+Get a copy of the settings and all of the subgroups
+*/
+func (setGroup *SettingGroup) copy() *SettingGroup {
+	copied := newSettingGroup()
+	for id, value := range setGroup.Settings {
+		copied.Settings[id] = value
+	}
+	for id, subGroup := range setGroup.SubGroup {
+		copied.SubGroup[id] = subGroup.copy()
+	}
+	return copied
+}
+
+/**
+Get the settings to store when the user changes from before to after.  Values that were stored or changed are kept
+and the rest are left out so they keep following the option defaults
+*/
+func storedSettings(stored *SettingGroup, before *SettingGroup, after *SettingGroup) *SettingGroup {
+	result := newSettingGroup()
+	for id, value := range after.Settings {
+		_, wasStored := stored.Settings[id]
+		beforeValue, found := before.Settings[id]
+		if wasStored || !found || beforeValue != value {
+			result.Settings[id] = value
+		}
+	}
+
+	//Only keep the subgroups that were stored or have something to store
+	for id, subGroup := range after.SubGroup {
+		storedSubGroup, wasStored := stored.SubGroup[id]
+		if !wasStored {
+			storedSubGroup = newSettingGroup()
+		}
+		beforeSubGroup, found := before.SubGroup[id]
+		if !found {
+			beforeSubGroup = newSettingGroup()
+		}
+		storedSubGroup.checkSubStructureValid()
+		beforeSubGroup.checkSubStructureValid()
+
+		resultSubGroup := storedSettings(storedSubGroup, beforeSubGroup, subGroup)
+		if wasStored || len(resultSubGroup.Settings) > 0 || len(resultSubGroup.SubGroup) > 0 {
+			result.SubGroup[id] = resultSubGroup
+		}
+	}
+	return result
+}
+
 /**
 Define custom methods to serialize and un serialize for sql
 */
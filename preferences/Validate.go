@@ -0,0 +1,241 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package preferences
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/reaction-eng/restlib/apierror"
+)
+
+/**
+Check the settings against the options and put each value in its standard form, i.e. TRUE becomes true.  Hidden
+options can't be changed by clients so they are taken from the current settings, which may be nil.  Every problem
+is returned in a single ErrValidation with the path to each setting, i.e. settings.view.zoom.
+*/
+func (options *OptionGroup) ValidateSettings(settings *SettingGroup, current *SettingGroup) error {
+	//Build the list of errors
+	fields := make([]apierror.FieldError, 0)
+
+	settings.checkSubStructureValid()
+	options.validateSettings(settings, current, "settings", &fields)
+
+	//If there are any errors return them
+	if len(fields) > 0 {
+		return apierror.ErrValidation.WithFields(fields)
+	}
+	return nil
+}
+
+/**
+Recursively march over the option groups
+*/
+func (options *OptionGroup) validateSettings(settings *SettingGroup, current *SettingGroup, path string, fields *[]apierror.FieldError) {
+	//Index the options and groups
+	optionsById := make(map[string]*Option)
+	for i := range options.Options {
+		optionsById[options.Options[i].Id] = &options.Options[i]
+	}
+	groupsById := make(map[string]*OptionGroup)
+	for i := range options.SubGroups {
+		groupsById[options.SubGroups[i].Id] = &options.SubGroups[i]
+	}
+
+	//Hidden options always keep their current value
+	for _, opt := range options.Options {
+		if !opt.Hidden {
+			continue
+		}
+
+		currentValue := opt.DefaultValue
+		if current != nil {
+			if value, found := current.Settings[opt.Id]; found {
+				currentValue = value
+			}
+		}
+
+		if value, found := settings.Settings[opt.Id]; found && value != currentValue {
+			*fields = append(*fields, apierror.FieldError{Field: path + "." + opt.Id, Code: "hidden", Message: "can not be changed"})
+		}
+		settings.Settings[opt.Id] = currentValue
+	}
+
+	//Check each of the values, in order so the errors are too
+	for _, id := range sortedKeys(settings.Settings) {
+		opt, found := optionsById[id]
+		if !found {
+			*fields = append(*fields, apierror.FieldError{Field: path + "." + id, Code: "unknown", Message: "is not an allowed setting"})
+			continue
+		}
+		if opt.Hidden {
+			continue
+		}
+
+		value, fieldErr := opt.coerce(settings.Settings[id])
+		if fieldErr != nil {
+			fieldErr.Field = path + "." + id
+			*fields = append(*fields, *fieldErr)
+			continue
+		}
+		settings.Settings[id] = value
+	}
+
+	//Now check each of the subgroups.  Groups with hidden options are checked even if they were left out
+	groupIds := make([]string, 0, len(settings.SubGroup))
+	for id := range settings.SubGroup {
+		groupIds = append(groupIds, id)
+	}
+	for _, optGroup := range options.SubGroups {
+		if _, found := settings.SubGroup[optGroup.Id]; !found && optGroup.hasHidden() {
+			groupIds = append(groupIds, optGroup.Id)
+		}
+	}
+	sort.Strings(groupIds)
+
+	for _, id := range groupIds {
+		optGroup, found := groupsById[id]
+		if !found {
+			*fields = append(*fields, apierror.FieldError{Field: path + "." + id, Code: "unknown", Message: "is not an allowed group"})
+			continue
+		}
+
+		//Get the current subgroup if there is one
+		var currentSubGroup *SettingGroup
+		if current != nil {
+			currentSubGroup = current.SubGroup[id]
+		}
+
+		subGroup := settings.SubGroup[id]
+		if subGroup == nil {
+			subGroup = newSettingGroup()
+			settings.SubGroup[id] = subGroup
+		}
+		subGroup.checkSubStructureValid()
+		optGroup.validateSettings(subGroup, currentSubGroup, path+"."+id, fields)
+	}
+}
+
+/**
+Check to see if the group or any of its subgroups have a hidden option
+*/
+func (options *OptionGroup) hasHidden() bool {
+	for _, opt := range options.Options {
+		if opt.Hidden {
+			return true
+		}
+	}
+	for i := range options.SubGroups {
+		if options.SubGroups[i].hasHidden() {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+Convert the value to the standard form for the option type and check the selection and range.  MinValue and
+MaxValue only limit int and float options and only when the max is above the min.
+*/
+func (opt *Option) coerce(value string) (string, *apierror.FieldError) {
+	trimmed := strings.TrimSpace(value)
+
+	//Get the standard form and the number if there is one
+	var number float64
+	switch opt.Type {
+	case Int:
+		parsed, err := parseInt(trimmed)
+		if err != nil {
+			return "", err
+		}
+		number = float64(parsed)
+		value = strconv.FormatInt(parsed, 10)
+	case Float:
+		parsed, err := strconv.ParseFloat(trimmed, 64)
+		if err != nil || math.IsNaN(parsed) || math.IsInf(parsed, 0) {
+			return "", &apierror.FieldError{Code: "type", Message: "must be a float"}
+		}
+		number = parsed
+		value = strconv.FormatFloat(parsed, 'g', -1, 64)
+	case Bool:
+		parsed, err := strconv.ParseBool(trimmed)
+		if err != nil {
+			return "", &apierror.FieldError{Code: "type", Message: "must be true or false"}
+		}
+		value = strconv.FormatBool(parsed)
+	}
+
+	//Check the selection
+	if len(opt.Selection) > 0 {
+		allowed := false
+		for _, selection := range opt.Selection {
+			if value == selection {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return "", &apierror.FieldError{Code: "enum", Message: "must be one of " + strings.Join(opt.Selection, ", ")}
+		}
+	}
+
+	//Check the range
+	if (opt.Type == Int || opt.Type == Float) && opt.MaxValue > opt.MinValue {
+		if number < opt.MinValue {
+			return "", &apierror.FieldError{Code: "min", Message: "must be at least " + strconv.FormatFloat(opt.MinValue, 'g', -1, 64)}
+		}
+		if number > opt.MaxValue {
+			return "", &apierror.FieldError{Code: "max", Message: "must be at most " + strconv.FormatFloat(opt.MaxValue, 'g', -1, 64)}
+		}
+	}
+
+	return value, nil
+}
+
+//The int64 limits as floats, the max can't be stored exactly so it is one past the largest int
+const (
+	minIntFloat = -(1 << 63)
+	maxIntFloat = 1 << 63
+)
+
+/**
+Parse an int exactly.  Exponent forms like 1e3 are read as a float and must be a whole number within the int64 range
+*/
+func parseInt(value string) (int64, *apierror.FieldError) {
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err == nil {
+		return parsed, nil
+	}
+	if errors.Is(err, strconv.ErrRange) {
+		return 0, &apierror.FieldError{Code: "type", Message: "must fit in a 64 bit int"}
+	}
+
+	//Only fall back to a float for exponents, so values like 1.5 or 150.0 are not ints
+	if !strings.ContainsAny(value, "eE") {
+		return 0, &apierror.FieldError{Code: "type", Message: "must be an int"}
+	}
+	parsedFloat, err := strconv.ParseFloat(value, 64)
+	if err != nil || parsedFloat != math.Trunc(parsedFloat) {
+		return 0, &apierror.FieldError{Code: "type", Message: "must be an int"}
+	}
+	if parsedFloat < minIntFloat || parsedFloat >= maxIntFloat {
+		return 0, &apierror.FieldError{Code: "type", Message: "must fit in a 64 bit int"}
+	}
+	return int64(parsedFloat), nil
+}
+
+/**
+Get the keys of the map in order
+*/
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,155 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package preferences_test
+
+import (
+	"errors"
+	"github.com/reaction-eng/restlib/apierror"
+	"github.com/reaction-eng/restlib/preferences"
+	"reflect"
+	"testing"
+)
+
+/**
+Build the options used for testing
+*/
+func newTestOptions() *preferences.OptionGroup {
+	return &preferences.OptionGroup{
+		Id: "root",
+		Options: []preferences.Option{
+			{Id: "darkMode", Type: preferences.Bool, DefaultValue: "false"},
+			{Id: "theme", Type: preferences.String, DefaultValue: "light", Selection: []string{"light", "dark"}},
+			{Id: "plan", Type: preferences.String, DefaultValue: "free", Hidden: true},
+		},
+		SubGroups: []preferences.OptionGroup{
+			{
+				Id: "view",
+				Options: []preferences.Option{
+					{Id: "zoom", Type: preferences.Int, DefaultValue: "100", MinValue: 10, MaxValue: 400},
+					{Id: "scale", Type: preferences.Float, DefaultValue: "1"},
+					{Id: "count", Type: preferences.Int, DefaultValue: "0"},
+				},
+			},
+		},
+	}
+}
+
+/**
+Perform the testing
+*/
+func TestValidateSettings(t *testing.T) {
+	//The user is on a paid plan
+	current := &preferences.SettingGroup{
+		Settings: map[string]string{"darkMode": "false", "theme": "light", "plan": "paid"},
+		SubGroup: map[string]*preferences.SettingGroup{},
+	}
+
+	//Define the list of settings we are testing
+	var settings = []struct {
+		name           string
+		settings       map[string]string
+		view           map[string]string
+		expectedFields []string
+		expected       map[string]string
+		expectedView   map[string]string
+	}{
+		{
+			"coerced",
+			map[string]string{"darkMode": "TRUE", "theme": "dark"},
+			map[string]string{"zoom": " 1.5e2 ", "scale": " 1.50 ", "count": "9007199254740993"},
+			nil,
+			map[string]string{"darkMode": "true", "theme": "dark", "plan": "paid"},
+			map[string]string{"zoom": "150", "scale": "1.5", "count": "9007199254740993"},
+		},
+		{
+			"wrong types",
+			map[string]string{"darkMode": "maybe"},
+			map[string]string{"zoom": "1.5", "scale": "big", "count": "150.0"},
+			[]string{"settings.darkMode:type", "settings.view.count:type", "settings.view.scale:type", "settings.view.zoom:type"},
+			nil,
+			nil,
+		},
+		{
+			"out of range",
+			map[string]string{"theme": "blue"},
+			map[string]string{"zoom": "5"},
+			[]string{"settings.theme:enum", "settings.view.zoom:min"},
+			nil,
+			nil,
+		},
+		{
+			"int range",
+			nil,
+			map[string]string{"count": "9223372036854775808"},
+			[]string{"settings.view.count:type"},
+			nil,
+			nil,
+		},
+		{
+			"int exponent range",
+			nil,
+			map[string]string{"count": "1e19"},
+			[]string{"settings.view.count:type"},
+			nil,
+			nil,
+		},
+		{
+			"unknown",
+			map[string]string{"fontSize": "12"},
+			map[string]string{"zoom": "500", "rotation": "90"},
+			[]string{"settings.fontSize:unknown", "settings.view.rotation:unknown", "settings.view.zoom:max"},
+			nil,
+			nil,
+		},
+		{
+			"hidden",
+			map[string]string{"plan": "enterprise"},
+			nil,
+			[]string{"settings.plan:hidden"},
+			nil,
+			nil,
+		},
+		{
+			"hidden unchanged",
+			map[string]string{"plan": "paid"},
+			nil,
+			nil,
+			map[string]string{"plan": "paid"},
+			nil,
+		},
+	}
+
+	//March over each test
+	for _, test := range settings {
+		group := &preferences.SettingGroup{Settings: test.settings, SubGroup: map[string]*preferences.SettingGroup{}}
+		if test.view != nil {
+			group.SubGroup["view"] = &preferences.SettingGroup{Settings: test.view}
+		}
+
+		err := newTestOptions().ValidateSettings(group, current)
+
+		//Get each of the field errors
+		var fields []string
+		var apiErr *apierror.Error
+		if errors.As(err, &apiErr) {
+			for _, field := range apiErr.Fields {
+				fields = append(fields, field.Field+":"+field.Code)
+			}
+		}
+		if !reflect.DeepEqual(fields, test.expectedFields) {
+			t.Errorf("recived %v for %s, expected %v", fields, test.name, test.expectedFields)
+		}
+		if err != nil && !errors.Is(err, apierror.ErrValidation) {
+			t.Errorf("recived %v for %s, expected %v", err, test.name, apierror.ErrValidation)
+		}
+
+		//Check the values
+		if test.expected != nil && !reflect.DeepEqual(group.Settings, test.expected) {
+			t.Errorf("recived %v for %s, expected %v", group.Settings, test.name, test.expected)
+		}
+		if test.expectedView != nil && !reflect.DeepEqual(group.SubGroup["view"].Settings, test.expectedView) {
+			t.Errorf("recived %v for %s, expected %v", group.SubGroup["view"].Settings, test.name, test.expectedView)
+		}
+	}
+}
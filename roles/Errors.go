@@ -0,0 +1,17 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package roles
+
+import (
+	"net/http"
+
+	"github.com/reaction-eng/restlib/apierror"
+)
+
+/**
+Define the errors returned when checking roles
+*/
+var (
+	ErrInsufficientAccess = apierror.New(http.StatusForbidden, "insufficient_access")
+)
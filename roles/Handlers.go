@@ -4,6 +4,8 @@
 package roles
 
 import (
+	"github.com/reaction-eng/restlib/apierror"
+	"github.com/reaction-eng/restlib/auth"
 	"github.com/reaction-eng/restlib/routing"
 	"github.com/reaction-eng/restlib/users"
 	"github.com/reaction-eng/restlib/utils"
@@ -41,17 +43,22 @@ func (handler *Handler) GetRoutes() []routing.Route {
 
 	var routes = []routing.Route{
 		{ //Allow for the user to login
-			Name:        "User Api Documentation",
+			Name:        "Permissions Api Documentation",
 			Method:      "GET",
 			Pattern:     "/api/users/permissions",
 			HandlerFunc: handler.handlePermissionsDocumentation,
 			Public:      true,
+			Description: "Html documentation for the permissions api.",
+			Tags:        []string{"documentation"},
 		},
 		{ //Allow for the user to login
 			Name:        "Get the User Permissions",
 			Method:      "GET",
 			Pattern:     "/users/permissions",
 			HandlerFunc: handler.handleUserPermissionsGet,
+			Description: "Get the permissions of the logged in user.",
+			Tags:        []string{"permissions"},
+			Response:    Permissions{},
 		},
 	}
 
@@ -65,25 +72,29 @@ func (handler *Handler) GetRoutes() []routing.Route {
 func (handler *Handler) handleUserPermissionsGet(w http.ResponseWriter, r *http.Request) {
 
 	//We have gone through the auth, so we should know the id of the logged in user
-	loggedInUser := r.Context().Value("user").(int) //Grab the id of the user that send the request
+	loggedInUser, ok := auth.UserId(r.Context()) //Grab the id of the user that send the request
+	if !ok {
+		utils.ReturnError(w, apierror.ErrUnauthorized)
+		return
+	}
 
 	//Get the user
-	user, err := handler.userRepo.GetUser(loggedInUser)
+	user, err := handler.userRepo.GetUserContext(r.Context(), loggedInUser)
 
 	//If there is no error
 	if err != nil {
-		utils.ReturnJsonError(w, http.StatusUnprocessableEntity, err)
+		utils.ReturnError(w, err)
 		return
 	}
 
 	//Get the list of permissions
-	perm, err := handler.roleRepo.GetPermissions(user)
+	perm, err := handler.roleRepo.GetPermissionsContext(r.Context(), user)
 
 	//Check to see if the user was created
 	if err == nil {
 		utils.ReturnJson(w, http.StatusOK, perm)
 	} else {
-		utils.ReturnJsonStatus(w, http.StatusUnsupportedMediaType, false, err.Error())
+		utils.ReturnError(w, err)
 	}
 
 }
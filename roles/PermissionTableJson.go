@@ -6,9 +6,13 @@ package roles
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"os"
 	"strings"
+	"sync"
+
+	"github.com/reaction-eng/restlib/configuration"
 )
 
 //Simple struct to hold the role
@@ -23,6 +27,9 @@ Define a struct for Repo for use with users
 type PermissionTableJson struct {
 	//Hold a map of the strings
 	Roles map[int]Role
+
+	//Protect the roles while they are reloaded
+	lock sync.RWMutex
 }
 
 //Provide a method to make a new UserRepoSql
@@ -47,10 +54,36 @@ func NewPermissionTableJson(fileName string) *PermissionTableJson {
 	return permTable
 }
 
+/**
+Use the new roles each time the table file is reloaded.  The reloader should be built from the same file.  A table
+with a role that has no name is rejected.
+*/
+func (repo *PermissionTableJson) Subscribe(reloader *configuration.Reloader) {
+	reloader.Subscribe([]string{"Roles"}, func(config *configuration.Configuration) error {
+		roles := make(map[int]Role)
+		if err := config.GetStruct("Roles", &roles); err != nil {
+			return err
+		}
+		for roleId, role := range roles {
+			if len(role.Name) == 0 {
+				return fmt.Errorf("role %d must have a name", roleId)
+			}
+		}
+
+		repo.lock.Lock()
+		defer repo.lock.Unlock()
+		repo.Roles = roles
+		return nil
+	})
+}
+
 /**
 Get the user with the email.  An error is thrown is not found
 */
 func (repo *PermissionTableJson) GetPermissions(roleId int) []string {
+	repo.lock.RLock()
+	defer repo.lock.RUnlock()
+
 	//Look up the role
 	role := repo.Roles[roleId]
 
@@ -63,6 +96,9 @@ func (repo *PermissionTableJson) GetPermissions(roleId int) []string {
 Get the role id for this name
 */
 func (repo *PermissionTableJson) LookUpRoleId(roleLookUp string) (int, error) {
+	repo.lock.RLock()
+	defer repo.lock.RUnlock()
+
 	//March over each config
 	for index, role := range repo.Roles {
 		//If the role equals
@@ -3,7 +3,10 @@
 
 package roles
 
-import "github.com/reaction-eng/restlib/users"
+import (
+	"context"
+	"github.com/reaction-eng/restlib/users"
+)
 
 /**
 Define an interface for roles
@@ -23,4 +26,11 @@ type Repo interface {
 	Set the user's roles.  Note this wipes out all current roles
 	*/
 	SetRolesByName(user users.User, roles []string) error
+
+	/**
+	The same as each method above but stopped when the context ends, i.e. when the client goes away
+	*/
+	GetPermissionsContext(ctx context.Context, user users.User) (*Permissions, error)
+	SetRolesByRoleIdContext(ctx context.Context, user users.User, roles []int) error
+	SetRolesByNameContext(ctx context.Context, user users.User, roles []string) error
 }
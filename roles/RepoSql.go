@@ -4,9 +4,15 @@
 package roles
 
 import (
-	"github.com/reaction-eng/restlib/users"
+	"context"
 	"database/sql"
+	"github.com/reaction-eng/restlib/dialect"
+	"github.com/reaction-eng/restlib/migrations"
+	"github.com/reaction-eng/restlib/tracing"
+	"github.com/reaction-eng/restlib/transaction"
+	"github.com/reaction-eng/restlib/users"
 	"log"
+	"time"
 )
 
 /**
@@ -26,73 +32,43 @@ type RepoSql struct {
 
 	//We need the role Repo
 	permTable PermissionTable
+
+	//Used to replace the roles in one step
+	transactions *transaction.SqlManager
+
+	//How long each query can run, zero for no limit
+	queryTimeout time.Duration
 }
 
 //Provide a method to make a new UserRepoSql
 func NewRepoMySql(db *sql.DB, tableName string, roleRepo PermissionTable) *RepoSql {
-
-	//Define a new repo
-	newRepo := RepoSql{
-		db:        db,
-		tableName: tableName,
-		permTable: roleRepo,
-	}
-
-	//Create the table if it is not already there
-	//Create a table
-	_, err := db.Exec("CREATE TABLE IF NOT EXISTS " + tableName + "(id int NOT NULL AUTO_INCREMENT, userId int, roleId int, PRIMARY KEY (id) )")
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	//Add calc data to table
-	getRoles, err := db.Prepare("SELECT roleId FROM " + tableName + " WHERE userId = ? ")
-	//Check for error
-	if err != nil {
-		log.Fatal(err)
-	}
-	newRepo.getUserRoles = getRoles
-
-	//Clear all roles of a user
-	clearRoles, err := db.Prepare("DELETE  FROM " + tableName + " WHERE userId = ? ")
-	//Check for error
-	if err != nil {
-		log.Fatal(err)
-	}
-	newRepo.clearUserRoles = clearRoles
-
-	//Clear all roles of a user
-	addRole, err := db.Prepare("INSERT INTO " + tableName + "(userId,roleId) VALUES (?, ?)")
-	//Check for error
-	if err != nil {
-		log.Fatal(err)
-	}
-	newRepo.addUserRole = addRole
-
-	//Return a point
-	return &newRepo
-
+	return NewRepoSql(db, dialect.MySql, tableName, roleRepo)
 }
 
 //Provide a method to make a new UserRepoSql
 func NewRepoPostgresSql(db *sql.DB, tableName string, roleRepo PermissionTable) *RepoSql {
+	return NewRepoSql(db, dialect.Postgres, tableName, roleRepo)
+}
+
+//Provide a method to make a new UserRepoSql for any supported dialect
+func NewRepoSql(db *sql.DB, sqlDialect dialect.Dialect, tableName string, roleRepo PermissionTable) *RepoSql {
 
 	//Define a new repo
 	newRepo := RepoSql{
-		db:        db,
-		tableName: tableName,
-		permTable: roleRepo,
+		db:           db,
+		tableName:    tableName,
+		permTable:    roleRepo,
+		transactions: transaction.NewSqlManager(db),
 	}
 
-	//Create the table if it is not already there
-	//Create a table
-	_, err := db.Exec("CREATE TABLE IF NOT EXISTS " + tableName + "(id SERIAL PRIMARY KEY, userId int NOT NULL, roleId int NOT NULL )")
+	//Bring the table up to date
+	err := migrations.NewMigrator(db, sqlDialect, Migrations(tableName)).Up(context.Background())
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	//Add calc data to table
-	getRoles, err := db.Prepare("SELECT roleId FROM " + tableName + " WHERE userId = $1 ")
+	getRoles, err := sqlDialect.Prepare(db, "SELECT roleId FROM "+tableName+" WHERE userId = ? ")
 	//Check for error
 	if err != nil {
 		log.Fatal(err)
@@ -100,7 +76,7 @@ func NewRepoPostgresSql(db *sql.DB, tableName string, roleRepo PermissionTable)
 	newRepo.getUserRoles = getRoles
 
 	//Clear all roles of a user
-	clearRoles, err := db.Prepare("DELETE  FROM " + tableName + " WHERE userId = $1 ")
+	clearRoles, err := sqlDialect.Prepare(db, "DELETE  FROM "+tableName+" WHERE userId = ? ")
 	//Check for error
 	if err != nil {
 		log.Fatal(err)
@@ -108,7 +84,7 @@ func NewRepoPostgresSql(db *sql.DB, tableName string, roleRepo PermissionTable)
 	newRepo.clearUserRoles = clearRoles
 
 	//Clear all roles of a user
-	addRole, err := db.Prepare("INSERT INTO " + tableName + "(userId,roleId) VALUES ($1, $2)")
+	addRole, err := sqlDialect.Prepare(db, "INSERT INTO "+tableName+"(userId,roleId) VALUES (?, ?)")
 	//Check for error
 	if err != nil {
 		log.Fatal(err)
@@ -120,38 +96,41 @@ func NewRepoPostgresSql(db *sql.DB, tableName string, roleRepo PermissionTable)
 
 }
 
+/**
+Limit how long each query can run.  Zero, the default, only stops a query when its context ends
+*/
+func (repo *RepoSql) SetQueryTimeout(timeout time.Duration) {
+	repo.queryTimeout = timeout
+}
+
 /**
 Get the user with the email.  An error is thrown is not found
 */
 func (repo *RepoSql) GetPermissions(user users.User) (*Permissions, error) {
-	//Get a list of roles
-	permissions := make([]string, 0)
-
-	//Get the value //id int NOT NULL AUTO_INCREMENT, email TEXT, password TEXT, PRIMARY KEY (id)
-	rows, err := repo.getUserRoles.Query(user.Id())
-
-	//Rows is the result of a query. Its cursor starts before  the first row of the result set. Use Next to advance through the rows:
-	defer rows.Close()
-	for rows.Next() {
-		//Get the role id
-		var roleId int
-		err = rows.Scan(&roleId)
-
-		//Get the permissions
-		rolePermissions := repo.permTable.GetPermissions(roleId)
-
-		//Push back
-		permissions = append(permissions, rolePermissions...)
+	return repo.GetPermissionsContext(context.Background(), user)
+}
 
-	}
-	rows.Close()
-	err = rows.Err() // get any error encountered ing iteration
+/**
+Get the user with the email.  An error is thrown is not found
+*/
+func (repo *RepoSql) GetPermissionsContext(ctx context.Context, user users.User) (*Permissions, error) {
+	//Trace the query
+	ctx, span := tracing.StartSqlSpan(ctx, "roles.RepoSql.GetPermissions", repo.tableName)
+	defer span.End()
 
-	//If there is an error
+	//Get a list of roles
+	roleIds, err := repo.getRoleIds(ctx, user)
+	tracing.RecordError(span, err)
 	if err != nil {
 		return nil, err
 	}
 
+	//Get the permissions for each role
+	permissions := make([]string, 0)
+	for _, roleId := range roleIds {
+		permissions = append(permissions, repo.permTable.GetPermissions(roleId)...)
+	}
+
 	//Get the permissions from
 	return &Permissions{
 		Permissions: permissions,
@@ -163,31 +142,56 @@ func (repo *RepoSql) GetPermissions(user users.User) (*Permissions, error) {
 Get all of the roles
 */
 func (repo *RepoSql) GetRoleIds(user users.User) ([]int, error) {
+	return repo.GetRoleIdsContext(context.Background(), user)
+}
+
+/**
+Get all of the roles
+*/
+func (repo *RepoSql) GetRoleIdsContext(ctx context.Context, user users.User) ([]int, error) {
+	//Trace the query
+	ctx, span := tracing.StartSqlSpan(ctx, "roles.RepoSql.GetRoleIds", repo.tableName)
+	defer span.End()
+
+	roles, err := repo.getRoleIds(ctx, user)
+	tracing.RecordError(span, err)
+	return roles, err
+}
+
+/**
+Get the role ids for the user
+*/
+func (repo *RepoSql) getRoleIds(ctx context.Context, user users.User) ([]int, error) {
+	ctx, cancel := dialect.WithTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
 	//Get a list of roles
 	roles := make([]int, 0)
 
 	//Get the value //id int NOT NULL AUTO_INCREMENT, email TEXT, password TEXT, PRIMARY KEY (id)
-	rows, err := repo.getUserRoles.Query(user.Id())
-
-	//Build the list
+	rows, err := transaction.Stmt(ctx, repo.db, repo.getUserRoles).QueryContext(ctx, user.Id())
+	if err != nil {
+		return nil, dialect.QueryError(err)
+	}
 
 	//Rows is the result of a query. Its cursor starts before  the first row of the result set. Use Next to advance through the rows:
 	defer rows.Close()
 	for rows.Next() {
 		//Get the role id
 		var roleId int
-		err = rows.Scan(&roleId)
+		if err := rows.Scan(&roleId); err != nil {
+			return nil, err
+		}
 
 		//Push back
 		roles = append(roles, roleId)
 
 	}
-	rows.Close()
 	err = rows.Err() // get any error encountered ing iteration
 
 	//If there is an error
 	if err != nil {
-		return nil, err
+		return nil, dialect.QueryError(err)
 	}
 
 	return roles, nil
@@ -197,22 +201,42 @@ func (repo *RepoSql) GetRoleIds(user users.User) ([]int, error) {
 Get the user with the email.  An error is thrown is not found
 */
 func (repo *RepoSql) SetRolesByRoleId(user users.User, roles []int) error {
+	return repo.SetRolesByRoleIdContext(context.Background(), user, roles)
+}
+
+/**
+Get the user with the email.  An error is thrown is not found
+*/
+func (repo *RepoSql) SetRolesByRoleIdContext(ctx context.Context, user users.User, roles []int) error {
+	//Trace the query
+	ctx, span := tracing.StartSqlSpan(ctx, "roles.RepoSql.SetRolesByRoleId", repo.tableName)
+	defer span.End()
+
 	//Get all of the
-	currentRoles, err := repo.GetRoleIds(user)
+	currentRoles, err := repo.getRoleIds(ctx, user)
 
 	//If the roles dont' equal replace them
 	if err != nil || !sameRoles(currentRoles, roles) {
-
-		//Clear all of the roles
-		_, err := repo.clearUserRoles.Exec(user.Id())
-
-		//Now add each role
-		for _, roleId := range roles {
-			_, err = repo.addUserRole.Exec(user.Id(), roleId)
-		}
-
-		//Check for error
-		return err
+		ctx, cancel := dialect.WithTimeout(ctx, repo.queryTimeout)
+		defer cancel()
+
+		//Clear and add in one step so the user is never left without roles
+		err = repo.transactions.Atomic(ctx, func(ctx context.Context) error {
+			//Clear all of the roles
+			if _, err := transaction.Stmt(ctx, repo.db, repo.clearUserRoles).ExecContext(ctx, user.Id()); err != nil {
+				return err
+			}
+
+			//Now add each role
+			for _, roleId := range roles {
+				if _, err := transaction.Stmt(ctx, repo.db, repo.addUserRole).ExecContext(ctx, user.Id(), roleId); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		tracing.RecordError(span, err)
+		return dialect.QueryError(err)
 	}
 	return nil
 }
@@ -221,6 +245,13 @@ func (repo *RepoSql) SetRolesByRoleId(user users.User, roles []int) error {
 Set the user's roles.  Note this wipes out all current roles
 */
 func (repo *RepoSql) SetRolesByName(user users.User, roles []string) error {
+	return repo.SetRolesByNameContext(context.Background(), user, roles)
+}
+
+/**
+Set the user's roles.  Note this wipes out all current roles
+*/
+func (repo *RepoSql) SetRolesByNameContext(ctx context.Context, user users.User, roles []string) error {
 	//Build a list of roles to add
 	roleIds := make([]int, 0)
 
@@ -236,7 +267,7 @@ func (repo *RepoSql) SetRolesByName(user users.User, roles []string) error {
 	}
 
 	//Now update the roles
-	return repo.SetRolesByRoleId(user, roleIds)
+	return repo.SetRolesByRoleIdContext(ctx, user, roleIds)
 }
 
 /**
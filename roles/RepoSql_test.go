@@ -0,0 +1,80 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package roles_test
+
+import (
+	"database/sql"
+	"errors"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/reaction-eng/restlib/dialect"
+	"github.com/reaction-eng/restlib/roles"
+	"github.com/reaction-eng/restlib/users"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+/**
+Simple permission table held in memory
+*/
+type mapPermissionTable map[string]int
+
+func (table mapPermissionTable) GetPermissions(roleId int) []string {
+	for name, id := range table {
+		if id == roleId {
+			return []string{name + ".read"}
+		}
+	}
+	return []string{}
+}
+
+func (table mapPermissionTable) LookUpRoleId(name string) (int, error) {
+	if id, found := table[name]; found {
+		return id, nil
+	}
+	return -1, errors.New("unknown role")
+}
+
+/**
+Perform the testing
+*/
+func TestRepoSql(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "roles.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	repo := roles.NewRepoSql(db, dialect.Sqlite, "roles", mapPermissionTable{"admin": 1, "user": 2})
+	defer repo.CleanUp()
+
+	user := &users.BasicUser{Id_: 7}
+
+	//Unknown roles are skipped
+	if err := repo.SetRolesByName(user, []string{"admin", "user", "root"}); err != nil {
+		t.Fatal(err)
+	}
+	roleIds, err := repo.GetRoleIds(user)
+	if err != nil || !reflect.DeepEqual(roleIds, []int{1, 2}) {
+		t.Errorf("recived %v %v, expected [1 2]", roleIds, err)
+	}
+	permissions, err := repo.GetPermissions(user)
+	if err != nil || !reflect.DeepEqual(permissions.Permissions, []string{"admin.read", "user.read"}) {
+		t.Errorf("recived %v %v, expected both permissions", permissions, err)
+	}
+
+	//Setting the roles replaces them
+	if err := repo.SetRolesByRoleId(user, []int{2}); err != nil {
+		t.Fatal(err)
+	}
+	roleIds, _ = repo.GetRoleIds(user)
+	if !reflect.DeepEqual(roleIds, []int{2}) {
+		t.Errorf("recived %v, expected [2]", roleIds)
+	}
+
+	//Other users have none
+	roleIds, _ = repo.GetRoleIds(&users.BasicUser{Id_: 8})
+	if len(roleIds) != 0 {
+		t.Errorf("recived %v, expected no roles", roleIds)
+	}
+}
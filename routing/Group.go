@@ -0,0 +1,65 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package routing
+
+import (
+	"github.com/gorilla/mux"
+)
+
+/**
+A set of routes that share a path prefix and middleware, i.e. /v1
+*/
+type Group struct {
+	router *Router
+
+	//Added to the start of each route pattern
+	prefix string
+
+	//Wraps each route in the group
+	middleware []mux.MiddlewareFunc
+}
+
+/**
+Start a new group of routes under the prefix.  The middleware only wraps routes added to this group and runs after
+any middleware added to the router with Use.
+*/
+func (router *Router) Group(prefix string, middleware ...mux.MiddlewareFunc) *Group {
+	return &Group{
+		router:     router,
+		prefix:     prefix,
+		middleware: middleware,
+	}
+}
+
+/**
+Start a group inside of this one.  The prefixes are joined and the parent middleware runs first
+*/
+func (group *Group) Group(prefix string, middleware ...mux.MiddlewareFunc) *Group {
+	return &Group{
+		router:     group.router,
+		prefix:     group.prefix + prefix,
+		middleware: append(append([]mux.MiddlewareFunc{}, group.middleware...), middleware...),
+	}
+}
+
+/**
+Add the routes to the group
+*/
+func (group *Group) Handle(routes ...Route) *Group {
+	for _, route := range routes {
+		route.Pattern = group.prefix + route.Pattern
+		group.router.addRoute(route, group.middleware)
+	}
+	return group
+}
+
+/**
+Add all of the routes from each producer to the group
+*/
+func (group *Group) Mount(producers ...RouteProducer) *Group {
+	for _, producer := range producers {
+		group.Handle(producer.GetRoutes()...)
+	}
+	return group
+}
@@ -0,0 +1,82 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package routing_test
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/reaction-eng/restlib/routing"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+/**
+Build a middleware that records when it runs
+*/
+func recordMiddleware(name string, order *[]string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			*order = append(*order, name)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+/**
+Perform the testing
+*/
+func TestGroup(t *testing.T) {
+	order := make([]string, 0)
+
+	//Build the nested groups
+	router := routing.NewRouter(nil, nil, nil)
+	pattern := ""
+	v1 := router.Group("/api", recordMiddleware("api", &order)).Group("/v1", recordMiddleware("v1", &order))
+	v1.Handle(routing.Route{
+		Name:    "Get Thing",
+		Method:  "GET",
+		Pattern: "/things/{id}",
+		HandlerFunc: func(w http.ResponseWriter, r *http.Request) {
+			order = append(order, "handler")
+			if route := router.GetRoute(r); route != nil {
+				pattern = route.Pattern
+			}
+		},
+		Middleware: []mux.MiddlewareFunc{recordMiddleware("route1", &order), recordMiddleware("route2", &order)},
+	})
+
+	testCases := []struct {
+		path           string
+		expectedStatus int
+		expectedOrder  string
+	}{
+		{"/api/v1/things/10", http.StatusOK, "api,v1,route1,route2,handler"},
+		{"/things/10", http.StatusNotFound, ""},
+		{"/api/things/10", http.StatusNotFound, ""},
+	}
+
+	for _, testCase := range testCases {
+		order = order[:0]
+
+		req, err := http.NewRequest("GET", testCase.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Result().StatusCode != testCase.expectedStatus {
+			t.Errorf("recived status code %d for %s, expected %d", rec.Result().StatusCode, testCase.path, testCase.expectedStatus)
+		}
+		if strings.Join(order, ",") != testCase.expectedOrder {
+			t.Errorf("recived order %v for %s, expected %s", order, testCase.path, testCase.expectedOrder)
+		}
+	}
+
+	//The route should be stored with the prefix
+	if pattern != "/api/v1/things/{id}" {
+		t.Errorf("recived pattern %s, expected /api/v1/things/{id}", pattern)
+	}
+}
@@ -0,0 +1,498 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package routing
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gorilla/mux"
+	"github.com/reaction-eng/restlib/utils"
+	swaggerFiles "github.com/swaggo/files/v2"
+)
+
+/**
+The top level OpenAPI 3 document
+*/
+type OpenApiDocument struct {
+	OpenApi    string                                  `json:"openapi"`
+	Info       OpenApiInfo                             `json:"info"`
+	Paths      map[string]map[string]*OpenApiOperation `json:"paths"`
+	Components OpenApiComponents                       `json:"components"`
+}
+
+type OpenApiInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type OpenApiComponents struct {
+	Schemas         map[string]*OpenApiSchema        `json:"schemas,omitempty"`
+	SecuritySchemes map[string]OpenApiSecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type OpenApiSecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+}
+
+/**
+A single method on a path
+*/
+type OpenApiOperation struct {
+	OperationId string                     `json:"operationId"`
+	Summary     string                     `json:"summary"`
+	Description string                     `json:"description,omitempty"`
+	Tags        []string                   `json:"tags,omitempty"`
+	Parameters  []OpenApiParameter         `json:"parameters,omitempty"`
+	RequestBody *OpenApiRequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]OpenApiResponse `json:"responses"`
+	Security    []map[string][]string      `json:"security,omitempty"`
+
+	//Not part of the standard, but lets clients know what is needed
+	ReqPermissions []string `json:"x-required-permissions,omitempty"`
+}
+
+type OpenApiParameter struct {
+	Name     string         `json:"name"`
+	In       string         `json:"in"`
+	Required bool           `json:"required"`
+	Schema   *OpenApiSchema `json:"schema"`
+}
+
+type OpenApiRequestBody struct {
+	Required bool                        `json:"required"`
+	Content  map[string]OpenApiMediaType `json:"content"`
+}
+
+type OpenApiResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]OpenApiMediaType `json:"content,omitempty"`
+}
+
+type OpenApiMediaType struct {
+	Schema *OpenApiSchema `json:"schema"`
+}
+
+/**
+The subset of json schema used to describe the bodies
+*/
+type OpenApiSchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Properties           map[string]*OpenApiSchema `json:"properties,omitempty"`
+	AdditionalProperties *OpenApiSchema            `json:"additionalProperties,omitempty"`
+	Items                *OpenApiSchema            `json:"items,omitempty"`
+	Required             []string                  `json:"required,omitempty"`
+	Enum                 []string                  `json:"enum,omitempty"`
+	MinLength            *int                      `json:"minLength,omitempty"`
+	MaxLength            *int                      `json:"maxLength,omitempty"`
+	MinItems             *int                      `json:"minItems,omitempty"`
+	MaxItems             *int                      `json:"maxItems,omitempty"`
+	Minimum              *float64                  `json:"minimum,omitempty"`
+	Maximum              *float64                  `json:"maximum,omitempty"`
+}
+
+//The name of the security scheme used for non public routes
+const openApiBearerScheme = "bearerAuth"
+
+//Match the mux path variables, i.e. {id} or {id:[0-9]+}
+var openApiPathVariable = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+
+/**
+Add the routes to serve the OpenAPI document at /api/openapi.json and optionally the Swagger UI at /api/docs.  The
+document is built on the first request so it includes every route added to the router
+*/
+func (router *Router) AddOpenApiRoutes(title string, version string, swaggerUi bool) {
+	router.addRoute(Route{
+		Name:        "OpenApi Specification",
+		Method:      "GET",
+		Pattern:     "/api/openapi.json",
+		HandlerFunc: router.openApiHandler(title, version),
+		Public:      true,
+		Description: "The OpenAPI 3 document describing this api.",
+		Tags:        []string{"documentation"},
+	}, nil)
+
+	if swaggerUi {
+		router.addRoute(Route{
+			Name:                  "OpenApi Documentation",
+			Method:                "GET",
+			Pattern:               "/api/docs",
+			HandlerFunc:           handleSwaggerUi,
+			Public:                true,
+			Description:           "Interactive documentation for this api.",
+			Tags:                  []string{"documentation"},
+			ContentSecurityPolicy: swaggerUiContentSecurityPolicy,
+		}, nil)
+		router.addRoute(Route{
+			Name:        "OpenApi Documentation Assets",
+			Method:      "GET",
+			Pattern:     "/api/docs/{file}",
+			HandlerFunc: handleSwaggerUiAsset,
+			Public:      true,
+			Description: "The scripts and styles used by the interactive documentation.",
+			Tags:        []string{"documentation"},
+		}, nil)
+	}
+}
+
+/**
+Build the handler that lazily creates the document
+*/
+func (router *Router) openApiHandler(title string, version string) http.HandlerFunc {
+	var document []byte
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		//Build it the first time
+		router.openApiOnce.Do(func() {
+			var err error
+			document, err = json.Marshal(router.OpenApi(title, version))
+			if err != nil {
+				panic(err)
+			}
+		})
+
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write(document)
+	}
+}
+
+/**
+Build the OpenAPI document from the routes added to this router
+*/
+func (router *Router) OpenApi(title string, version string) *OpenApiDocument {
+	document := &OpenApiDocument{
+		OpenApi: "3.0.3",
+		Info: OpenApiInfo{
+			Title:   title,
+			Version: version,
+		},
+		Paths: make(map[string]map[string]*OpenApiOperation),
+		Components: OpenApiComponents{
+			Schemas: make(map[string]*OpenApiSchema),
+			SecuritySchemes: map[string]OpenApiSecurityScheme{
+				openApiBearerScheme: {Type: "http", Scheme: "bearer", BearerFormat: "JWT"},
+			},
+		},
+	}
+
+	//All errors are returned as problems
+	problemSchema := schemaFor(reflect.TypeOf(utils.Problem{}), document.Components.Schemas)
+
+	for _, route := range router.routes {
+		//Convert the path
+		pattern := openApiPathVariable.ReplaceAllString(route.Pattern, "{$1}")
+
+		operation := &OpenApiOperation{
+			OperationId:    operationId(route.Name),
+			Summary:        route.Name,
+			Description:    route.Description,
+			Tags:           route.Tags,
+			ReqPermissions: route.ReqPermissions,
+			Responses:      make(map[string]OpenApiResponse),
+		}
+
+		//Add in each of the path variables
+		for _, match := range openApiPathVariable.FindAllStringSubmatch(route.Pattern, -1) {
+			operation.Parameters = append(operation.Parameters, OpenApiParameter{
+				Name:     match[1],
+				In:       "path",
+				Required: true,
+				Schema:   &OpenApiSchema{Type: "string"},
+			})
+		}
+
+		//Describe the body
+		if route.Request != nil {
+			operation.RequestBody = &OpenApiRequestBody{
+				Required: true,
+				Content: map[string]OpenApiMediaType{
+					"application/json": {Schema: schemaFor(reflect.TypeOf(route.Request), document.Components.Schemas)},
+				},
+			}
+		}
+
+		//Describe the response
+		status := route.ResponseStatus
+		if status == 0 {
+			status = http.StatusOK
+		}
+		response := OpenApiResponse{Description: http.StatusText(status)}
+		if route.Response != nil {
+			response.Content = map[string]OpenApiMediaType{
+				"application/json": {Schema: schemaFor(reflect.TypeOf(route.Response), document.Components.Schemas)},
+			}
+		}
+		operation.Responses[strconv.Itoa(status)] = response
+		operation.Responses["default"] = OpenApiResponse{
+			Description: "Error",
+			Content: map[string]OpenApiMediaType{
+				"application/problem+json": {Schema: problemSchema},
+			},
+		}
+
+		//Private routes need a token
+		if !route.Public {
+			operation.Security = []map[string][]string{{openApiBearerScheme: {}}}
+		}
+
+		//Store it
+		if document.Paths[pattern] == nil {
+			document.Paths[pattern] = make(map[string]*OpenApiOperation)
+		}
+		document.Paths[pattern][strings.ToLower(route.Method)] = operation
+	}
+
+	return document
+}
+
+/**
+Convert the route name into an id, i.e. "Get the User Permissions" to "getTheUserPermissions"
+*/
+func operationId(name string) string {
+	words := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	id := ""
+	for i, word := range words {
+		if i == 0 {
+			id += strings.ToLower(word[:1]) + word[1:]
+		} else {
+			id += strings.ToUpper(word[:1]) + word[1:]
+		}
+	}
+	return id
+}
+
+/**
+Build the schema for a type.  Named structs are stored in the components and referenced
+*/
+func schemaFor(valueType reflect.Type, components map[string]*OpenApiSchema) *OpenApiSchema {
+	//Get the real type
+	for valueType.Kind() == reflect.Ptr {
+		valueType = valueType.Elem()
+	}
+
+	//Check for the special types
+	switch valueType {
+	case reflect.TypeOf(time.Time{}), reflect.TypeOf(utils.NullTime{}):
+		return &OpenApiSchema{Type: "string", Format: "date-time"}
+	case reflect.TypeOf(json.RawMessage{}):
+		return &OpenApiSchema{}
+	}
+
+	switch valueType.Kind() {
+	case reflect.Bool:
+		return &OpenApiSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &OpenApiSchema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &OpenApiSchema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &OpenApiSchema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &OpenApiSchema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &OpenApiSchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if valueType.Elem().Kind() == reflect.Uint8 {
+			return &OpenApiSchema{Type: "string", Format: "byte"}
+		}
+		return &OpenApiSchema{Type: "array", Items: schemaFor(valueType.Elem(), components)}
+	case reflect.Map:
+		return &OpenApiSchema{Type: "object", AdditionalProperties: schemaFor(valueType.Elem(), components)}
+	case reflect.Struct:
+		//Anonymous structs are inlined
+		if len(valueType.Name()) == 0 {
+			return structSchema(valueType, components)
+		}
+
+		//Only build each named struct once, this also stops recursive types
+		name := path.Base(valueType.PkgPath()) + "." + valueType.Name()
+		if _, found := components[name]; !found {
+			components[name] = &OpenApiSchema{}
+			*components[name] = *structSchema(valueType, components)
+		}
+		return &OpenApiSchema{Ref: "#/components/schemas/" + name}
+	}
+
+	//Anything else can be any value
+	return &OpenApiSchema{}
+}
+
+/**
+Build the object schema for the struct fields
+*/
+func structSchema(valueType reflect.Type, components map[string]*OpenApiSchema) *OpenApiSchema {
+	schema := &OpenApiSchema{
+		Type:       "object",
+		Properties: make(map[string]*OpenApiSchema),
+	}
+
+	for i := 0; i < valueType.NumField(); i++ {
+		field := valueType.Field(i)
+
+		//Get the json name
+		tag := strings.Split(field.Tag.Get("json"), ",")
+		name := tag[0]
+		if name == "-" {
+			continue
+		}
+
+		//Embedded structs add their fields to this one
+		if field.Anonymous && len(name) == 0 {
+			fieldType := field.Type
+			if fieldType.Kind() == reflect.Ptr {
+				fieldType = fieldType.Elem()
+			}
+			if fieldType.Kind() == reflect.Struct {
+				embedded := structSchema(fieldType, components)
+				for propName, prop := range embedded.Properties {
+					schema.Properties[propName] = prop
+				}
+				schema.Required = append(schema.Required, embedded.Required...)
+				continue
+			}
+		}
+
+		//Skip private fields
+		if field.PkgPath != "" {
+			continue
+		}
+		if len(name) == 0 {
+			name = field.Name
+		}
+
+		//Build the field schema and apply the validation rules
+		prop := schemaFor(field.Type, components)
+		if applyValidateTag(prop, field.Tag.Get("validate")) {
+			schema.Required = append(schema.Required, name)
+		}
+		schema.Properties[name] = prop
+	}
+
+	return schema
+}
+
+/**
+Add the constraints from the validate tag used by utils.Validate.  Returns true if the field is required
+*/
+func applyValidateTag(schema *OpenApiSchema, tag string) bool {
+	required := false
+
+	for _, rule := range strings.Split(tag, ",") {
+		name := strings.TrimSpace(rule)
+		arg := ""
+		if loc := strings.Index(name, "="); loc >= 0 {
+			arg = name[loc+1:]
+			name = name[:loc]
+		}
+
+		switch name {
+		case "required":
+			required = true
+		case "email":
+			schema.Format = "email"
+		case "enum":
+			schema.Enum = strings.Split(arg, "|")
+		case "min", "max":
+			value, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				continue
+			}
+			size := int(value)
+
+			switch schema.Type {
+			case "string":
+				if name == "min" {
+					schema.MinLength = &size
+				} else {
+					schema.MaxLength = &size
+				}
+			case "array":
+				if name == "min" {
+					schema.MinItems = &size
+				} else {
+					schema.MaxItems = &size
+				}
+			case "integer", "number":
+				if name == "min" {
+					schema.Minimum = &value
+				} else {
+					schema.Maximum = &value
+				}
+			}
+		}
+	}
+
+	return required
+}
+
+/**
+Show the swagger ui pointed at the generated document
+*/
+func handleSwaggerUi(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(swaggerUiHtml))
+}
+
+/**
+Serve the swagger ui files embedded in the binary, so the docs work without reaching a cdn
+*/
+var swaggerUiAssets = http.StripPrefix("/api/docs/", http.FileServer(http.FS(swaggerFiles.FS)))
+
+func handleSwaggerUiAsset(w http.ResponseWriter, r *http.Request) {
+	//Point the ui at our document instead of the example one that ships with it
+	if mux.Vars(r)["file"] == "swagger-initializer.js" {
+		w.Header().Set("Content-Type", "text/javascript; charset=UTF-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(swaggerUiInitializer))
+		return
+	}
+
+	swaggerUiAssets.ServeHTTP(w, r)
+}
+
+//The swagger ui loads its own scripts and styles and then the document, but nothing from other sites
+const swaggerUiContentSecurityPolicy = "default-src 'none'; script-src 'self'; style-src 'self' 'unsafe-inline'; img-src 'self' data:; connect-src 'self'; frame-ancestors 'none'"
+
+//The swagger ui page, everything is loaded from /api/docs
+const swaggerUiHtml = `
+<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <title>Api Documentation</title>
+    <link rel="stylesheet" href="docs/swagger-ui.css">
+</head>
+<body>
+    <div id="swagger-ui"></div>
+    <script src="docs/swagger-ui-bundle.js"></script>
+    <script src="docs/swagger-initializer.js"></script>
+</body>
+</html>
+`
+
+//Start the swagger ui once the page loads
+const swaggerUiInitializer = `
+window.onload = function () {
+    window.ui = SwaggerUIBundle({
+        url: "openapi.json",
+        dom_id: "#swagger-ui"
+    });
+};
+`
@@ -0,0 +1,146 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package routing_test
+
+import (
+	"encoding/json"
+	"github.com/reaction-eng/restlib/routing"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+/**
+Simple request body used to build the schema
+*/
+type openApiTestRequest struct {
+	Email    string               `json:"email" validate:"required,email"`
+	Name     string               `json:"name,omitempty" validate:"max=10"`
+	Children []openApiTestRequest `json:"children"`
+}
+
+/**
+Perform the testing
+*/
+func TestOpenApi(t *testing.T) {
+	//Build a router with a couple of routes
+	router := routing.NewRouter(nil, []routing.Route{
+		{
+			Name:           "Create Thing",
+			Method:         "POST",
+			Pattern:        "/things/{id:[0-9]+}",
+			HandlerFunc:    func(w http.ResponseWriter, r *http.Request) {},
+			Request:        openApiTestRequest{},
+			ResponseStatus: http.StatusCreated,
+		},
+		{
+			Name:        "Get Things",
+			Method:      "GET",
+			Pattern:     "/things",
+			HandlerFunc: func(w http.ResponseWriter, r *http.Request) {},
+			Public:      true,
+		},
+	}, nil)
+	router.AddOpenApiRoutes("Test", "1.0", true)
+
+	//Get the document from the route
+	req, err := http.NewRequest("GET", "/api/openapi.json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Result().StatusCode != http.StatusOK {
+		t.Fatalf("recived status code %d, expected %d", rec.Result().StatusCode, http.StatusOK)
+	}
+
+	document := routing.OpenApiDocument{}
+	if err := json.NewDecoder(rec.Body).Decode(&document); err != nil {
+		t.Fatal(err)
+	}
+
+	//Check the private route
+	create := document.Paths["/things/{id}"]["post"]
+	if create == nil {
+		t.Fatalf("missing operation in %v", document.Paths)
+	}
+	if create.OperationId != "createThing" {
+		t.Errorf("recived operation id %s, expected createThing", create.OperationId)
+	}
+	if len(create.Parameters) != 1 || create.Parameters[0].Name != "id" {
+		t.Errorf("recived parameters %v, expected id", create.Parameters)
+	}
+	if len(create.Security) != 1 {
+		t.Errorf("expected security on a private route")
+	}
+	if _, found := create.Responses["201"]; !found {
+		t.Errorf("recived responses %v, expected 201", create.Responses)
+	}
+
+	//Check the schema
+	schema := document.Components.Schemas["routing_test.openApiTestRequest"]
+	if schema == nil {
+		t.Fatalf("missing schema in %v", document.Components.Schemas)
+	}
+	if len(schema.Required) != 1 || schema.Required[0] != "email" {
+		t.Errorf("recived required %v, expected email", schema.Required)
+	}
+	if schema.Properties["email"].Format != "email" {
+		t.Errorf("expected the email format")
+	}
+	if schema.Properties["name"].MaxLength == nil || *schema.Properties["name"].MaxLength != 10 {
+		t.Errorf("expected a max length of 10")
+	}
+	if schema.Properties["children"].Items.Ref != "#/components/schemas/routing_test.openApiTestRequest" {
+		t.Errorf("recived children %v, expected a reference", schema.Properties["children"].Items)
+	}
+
+	//Check the public route
+	if get := document.Paths["/things"]["get"]; get == nil || len(get.Security) != 0 {
+		t.Errorf("expected a public operation")
+	}
+}
+
+/**
+Perform the testing
+*/
+func TestSwaggerUi(t *testing.T) {
+	router := routing.NewRouter(nil, nil, nil)
+	router.AddOpenApiRoutes("Test", "1.0", true)
+
+	//Define the list of requests we are testing
+	var requests = []struct {
+		path             string
+		expectedCode     int
+		expectedType     string
+		expectedContains string
+	}{
+		{"/api/docs", http.StatusOK, "text/html", "docs/swagger-ui-bundle.js"},
+		{"/api/docs/swagger-ui-bundle.js", http.StatusOK, "text/javascript", "SwaggerUIBundle"},
+		{"/api/docs/swagger-ui.css", http.StatusOK, "text/css", ".swagger-ui"},
+		{"/api/docs/swagger-initializer.js", http.StatusOK, "text/javascript", `url: "openapi.json"`},
+		{"/api/docs/missing.js", http.StatusNotFound, "", ""},
+	}
+
+	for _, test := range requests {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", test.path, nil))
+		if rec.Code != test.expectedCode {
+			t.Errorf("recived %d for %s, expected %d", rec.Code, test.path, test.expectedCode)
+			continue
+		}
+		if !strings.HasPrefix(rec.Header().Get("Content-Type"), test.expectedType) {
+			t.Errorf("recived %s for %s, expected %s", rec.Header().Get("Content-Type"), test.path, test.expectedType)
+		}
+		if !strings.Contains(rec.Body.String(), test.expectedContains) {
+			t.Errorf("recived a body without %s for %s", test.expectedContains, test.path)
+		}
+
+		//Nothing should come from another site
+		if strings.Contains(rec.Body.String(), "unpkg.com") {
+			t.Errorf("recived a reference to unpkg.com for %s, expected everything to be served locally", test.path)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package routing
+
+import "time"
+
+/**
+Define what the requests are counted against
+*/
+type RateLimitKey int
+
+const (
+	//Count requests from each client ip
+	RateLimitByIp RateLimitKey = iota
+
+	//Count requests from each logged in user, falling back to the ip for public routes
+	RateLimitByUser
+
+	//Count requests for each X-Api-Key header, falling back to the ip if there is no key
+	RateLimitByApiKey
+)
+
+/**
+Define a token bucket rate limit.  Limit tokens are added back every Period up to the Burst size
+*/
+type RateLimitPolicy struct {
+	//The number of requests allowed each period
+	Limit  int
+	Period time.Duration
+
+	//The largest number of requests allowed at once, defaults to the Limit
+	Burst int
+
+	//What to count the requests against
+	KeyBy RateLimitKey
+}
+
+//Set on a route to turn off the rate limit for that route
+var NoRateLimit = &RateLimitPolicy{}
+
+/**
+Check to see if the policy limits anything
+*/
+func (policy *RateLimitPolicy) Enabled() bool {
+	return policy != nil && policy.Limit > 0 && policy.Period > 0
+}
+
+/**
+Get the size of the bucket
+*/
+func (policy *RateLimitPolicy) Capacity() int {
+	if policy.Burst > 0 {
+		return policy.Burst
+	}
+	return policy.Limit
+}
+
+/**
+Get the number of tokens added back each second
+*/
+func (policy *RateLimitPolicy) RefillRate() float64 {
+	return float64(policy.Limit) / policy.Period.Seconds()
+}
@@ -4,12 +4,17 @@
 package routing
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/reaction-eng/restlib/auth"
+	"github.com/reaction-eng/restlib/tracing"
 )
 
 /**
@@ -21,6 +26,16 @@ type Router struct {
 
 	//Store the paths so we can use them
 	routes []Route
+
+	//Look up the routes by name and find duplicates
+	routesByName  map[string]*Route
+	routePatterns map[string]string
+
+	//Keep the logger so routes can be added later
+	loggerWrapper LoggerWrapper
+
+	//Only build the OpenAPI document once
+	openApiOnce sync.Once
 }
 
 /**
@@ -36,6 +51,9 @@ type RouteProducer interface {
 //Type def a logger wrapper
 type LoggerWrapper func(inner http.Handler, name string) http.Handler
 
+//Used to store the matched route in the context
+type routeKey struct{}
+
 /**
 * Build a new instance of this router.  It contains all of the paths so we can ghceck them later
  */
@@ -49,41 +67,63 @@ func NewRouter(optionsHandler http.HandlerFunc, routes []Route, loggerWrapper Lo
 
 	//Combine the newrouter into this one
 	router := Router{
-		muxRouter,
-		make([]Route, 0),
-	}
-
-	//For each route
-	for _, route := range routes {
-		router.addRoute(route, loggerWrapper)
-
+		Router:        muxRouter,
+		routes:        make([]Route, 0),
+		routesByName:  make(map[string]*Route),
+		routePatterns: make(map[string]string),
+		loggerWrapper: loggerWrapper,
 	}
 
-	// Now march over each route produce
-	for _, producer := range routeProducers {
-		//For each route produced
-		for _, route := range producer.GetRoutes() {
-			router.addRoute(route, loggerWrapper)
-		}
+	//Store the matched route before any other middleware runs
+	muxRouter.Use(router.routeMiddleware)
 
-	}
+	//Add the routes and producers without a prefix
+	root := router.Group("")
+	root.Handle(routes...)
+	root.Mount(routeProducers...)
 
 	// Return pointer to router
 	return &router
 }
 
 /**
-Determines if it is a public path based upon the routes
+Add the route to the mux router.  The group middleware runs before the route middleware.  Duplicate names or
+method and pattern combinations stop the server.
 */
-func (router *Router) addRoute(route Route, loggerWrapper LoggerWrapper) {
+func (router *Router) addRoute(route Route, groupMiddleware []mux.MiddlewareFunc) {
+
+	//Make sure the route is named and unique
+	if len(route.Name) == 0 {
+		log.Fatalf("the route %s %s must have a name", route.Method, route.Pattern)
+	}
+	if _, found := router.routesByName[route.Name]; found {
+		log.Fatalf("duplicate route name %q", route.Name)
+	}
+	patternKey := strings.ToUpper(route.Method) + " " + openApiPathVariable.ReplaceAllString(route.Pattern, "{}")
+	if existing, found := router.routePatterns[patternKey]; found {
+		log.Fatalf("route %q uses the same method and pattern as %q: %s", route.Name, existing, patternKey)
+	}
+	stored := route
+	router.routesByName[route.Name] = &stored
+	router.routePatterns[patternKey] = route.Name
 
 	// Define a new handler
 	var handler http.Handler = route.HandlerFunc
 
+	// Apply the middleware so the first one listed runs first
+	middleware := append(append([]mux.MiddlewareFunc{}, groupMiddleware...), route.Middleware...)
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+
 	// Wrap the handler in a Logger wrapper
-	if loggerWrapper != nil {
-		handler = loggerWrapper(handler, route.Name)
+	if router.loggerWrapper != nil {
+		handler = router.loggerWrapper(handler, route.Name)
 	}
+
+	// Start a span for every route.  This is a no-op unless a tracing provider was set up
+	handler = tracing.WrapHandler(handler, route.Name)
+
 	// Add the route to the router
 	router.
 		Methods(route.Method).
@@ -97,28 +137,44 @@ func (router *Router) addRoute(route Route, loggerWrapper LoggerWrapper) {
 }
 
 /**
-Determines if it is a public path based upon the routes
+Get the route that matched the request.  Returns nil if no named route matched
 */
 func (router *Router) GetRoute(req *http.Request) *Route {
+	//Use the route stored when the request was dispatched
+	if route := RouteFromContext(req.Context()); route != nil {
+		return route
+	}
 
-	//Get the route name from the req
+	//Fall back to the mux route for requests that did not go through the router middleware
 	if muxRoute := mux.CurrentRoute(req); muxRoute != nil {
-		routeName := muxRoute.GetName()
-
-		//Now march over the routes and return one
-		for _, route := range router.routes {
-			if route.Name == routeName {
-				return &route
-			}
-
-		}
-
+		return router.routesByName[muxRoute.GetName()]
 	}
 
-	//Assume not public by default
 	return nil
 }
 
+/**
+Get the route stored in the context by the router.  The route must not be changed
+*/
+func RouteFromContext(ctx context.Context) *Route {
+	route, _ := ctx.Value(routeKey{}).(*Route)
+	return route
+}
+
+/**
+Store the matched route in the request context so middleware and handlers can read it directly
+*/
+func (router *Router) routeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if muxRoute := mux.CurrentRoute(r); muxRoute != nil {
+			if route, found := router.routesByName[muxRoute.GetName()]; found {
+				r = r.WithContext(context.WithValue(r.Context(), routeKey{}, route))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 /**
 Simple wrapping function that can be used else where.
 */
@@ -128,11 +184,11 @@ func SimpleLogger(inner http.Handler, name string) http.Handler {
 
 		inner.ServeHTTP(w, r)
 
-		//See if there is a user
-		userId := r.Context().Value("user") //Grab the id of the user that send the request
-
-		//Make a user ID string
-		userIdString := fmt.Sprint("userId", userId)
+		//Make a user ID string if there is a user
+		userIdString := "userId<nil>"
+		if userId, ok := auth.UserId(r.Context()); ok {
+			userIdString = fmt.Sprint("userId", userId)
+		}
 
 		log.Printf(
 			"%s\t%s\t%s\t%s\t%s",
@@ -0,0 +1,71 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package routing_test
+
+import (
+	"github.com/reaction-eng/restlib/routing"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+/**
+Perform the testing
+*/
+func TestRouteFromContext(t *testing.T) {
+	router := routing.NewRouter(nil, []routing.Route{
+		{
+			Name:           "Get Thing",
+			Method:         "GET",
+			Pattern:        "/things/{id}",
+			HandlerFunc:    func(w http.ResponseWriter, r *http.Request) {},
+			ReqPermissions: []string{"read_things"},
+		},
+		{
+			Name:        "Get Status",
+			Method:      "GET",
+			Pattern:     "/status",
+			HandlerFunc: func(w http.ResponseWriter, r *http.Request) {},
+			Public:      true,
+		},
+	}, nil)
+
+	//Record the route seen by the middleware
+	var seen *routing.Route
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			seen = routing.RouteFromContext(r.Context())
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	testCases := []struct {
+		path           string
+		expectedName   string
+		expectedPublic bool
+	}{
+		{"/things/10", "Get Thing", false},
+		{"/status", "Get Status", true},
+	}
+
+	for _, testCase := range testCases {
+		seen = nil
+
+		req, err := http.NewRequest("GET", testCase.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(httptest.NewRecorder(), req)
+
+		if seen == nil {
+			t.Fatalf("recived no route for %s", testCase.path)
+		}
+		if seen.Name != testCase.expectedName {
+			t.Errorf("recived route %s, expected %s", seen.Name, testCase.expectedName)
+		}
+		if seen.Public != testCase.expectedPublic {
+			t.Errorf("recived public %v for %s, expected %v", seen.Public, testCase.path, testCase.expectedPublic)
+		}
+	}
+}
@@ -5,6 +5,8 @@ package routing
 
 import (
 	"net/http"
+
+	"github.com/gorilla/mux"
 )
 
 type Route struct {
@@ -14,4 +16,24 @@ type Route struct {
 	HandlerFunc    http.HandlerFunc
 	Public         bool
 	ReqPermissions []string
+
+	//Optional documentation used to build the OpenAPI spec
+	Description string
+	Tags        []string
+
+	//An example of the request and response bodies.  Only the type is used to build the schema
+	Request  interface{}
+	Response interface{}
+
+	//The status returned on success, defaults to 200
+	ResponseStatus int
+
+	//Optional rate limit that overrides the default policy for this route
+	RateLimit *RateLimitPolicy
+
+	//Optional Content-Security-Policy that overrides the default one from the security middleware, i.e. for html pages
+	ContentSecurityPolicy string
+
+	//Optional middleware that only wraps this route.  It runs after the router and group middleware
+	Middleware []mux.MiddlewareFunc
 }
@@ -0,0 +1,324 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/reaction-eng/restlib/configuration"
+	"github.com/reaction-eng/restlib/routing"
+)
+
+/**
+Anything that holds on to a resource that must be released when the server stops, i.e. every repo
+*/
+type CleanUpper interface {
+	CleanUp()
+}
+
+/**
+Allow a plain function to be used as a CleanUpper, i.e. to close the database
+*/
+type CleanUpFunc func()
+
+func (cleanUp CleanUpFunc) CleanUp() {
+	cleanUp()
+}
+
+/**
+Define when a middleware runs.  Middleware in a lower stage always runs first no matter the order it was added.
+*/
+type Stage int
+
+const (
+	//Security headers, https redirects and the client ip
+	StageSecurity Stage = iota
+
+	//Cross origin headers and preflight requests
+	StageCors
+
+	//Finding the caller, i.e. the jwt middleware
+	StageAuth
+
+	//Rate limits that may depend on the caller
+	StageRateLimit
+
+	//Everything else
+	StageApp
+)
+
+/**
+The http settings read from the server key in the configuration.  Each of the times are in seconds.
+*/
+type Settings struct {
+	Address           string `json:"address"`
+	ReadTimeout       int    `json:"readTimeout"`
+	ReadHeaderTimeout int    `json:"readHeaderTimeout" default:"10"`
+	WriteTimeout      int    `json:"writeTimeout"`
+	IdleTimeout       int    `json:"idleTimeout" default:"120"`
+
+	//How long to wait for open requests to finish after being asked to stop
+	ShutdownTimeout int `json:"shutdownTimeout" default:"30"`
+}
+
+/**
+A single middleware and the stage it runs in
+*/
+type stagedMiddleware struct {
+	stage      Stage
+	middleware mux.MiddlewareFunc
+}
+
+/**
+Holds the router, middleware and resources for a single http server
+*/
+type Server struct {
+	//The router so routes can be added directly
+	Router *routing.Router
+
+	//The settings from the config
+	settings Settings
+
+	//The middleware to add to the router once the server starts
+	middleware []stagedMiddleware
+
+	//Released once the server stops, in the reverse order they were added
+	cleanUps []CleanUpper
+
+	//Called as soon as the server is asked to stop, i.e. to close long lived connections
+	shutdownHooks []func()
+
+	//The running http server
+	httpServer *http.Server
+	lock       sync.Mutex
+	started    bool
+	stopped    bool
+}
+
+/**
+Build a new server from the config, i.e.
+	"server": {
+		"address": ":8080",
+		"readTimeout": 15,
+		"writeTimeout": 15,
+		"shutdownTimeout": 30
+	}
+The address defaults to the PORT environment variable.
+*/
+func NewServer(config *configuration.Configuration, optionsHandler http.HandlerFunc, loggerWrapper routing.LoggerWrapper) *Server {
+	//Get the settings
+	settings := Settings{}
+	if err := config.Bind("server", &settings); err != nil {
+		log.Fatal(err)
+	}
+
+	//Fill in the defaults
+	if len(settings.Address) == 0 {
+		port := os.Getenv("PORT")
+		if len(port) == 0 {
+			port = "8080"
+		}
+		settings.Address = ":" + port
+	}
+
+	return &Server{
+		Router:   routing.NewRouter(optionsHandler, nil, loggerWrapper),
+		settings: settings,
+	}
+}
+
+/**
+Get the settings used by the server
+*/
+func (server *Server) Settings() Settings {
+	return server.settings
+}
+
+/**
+Add all of the routes from each producer
+*/
+func (server *Server) Mount(producers ...routing.RouteProducer) *Server {
+	server.Router.Group("").Mount(producers...)
+	return server
+}
+
+/**
+Add middleware to every route.  Middleware in the same stage runs in the order it was added.  The security stage also
+runs for requests that don't match a route, i.e. 404 and 405 responses.
+*/
+func (server *Server) Use(stage Stage, middleware ...mux.MiddlewareFunc) *Server {
+	for _, mw := range middleware {
+		server.middleware = append(server.middleware, stagedMiddleware{stage: stage, middleware: mw})
+	}
+	return server
+}
+
+/**
+Release the resources once the server has stopped.  They are cleaned up in the reverse order they were added
+*/
+func (server *Server) Manage(cleanUps ...CleanUpper) *Server {
+	server.cleanUps = append(server.cleanUps, cleanUps...)
+	return server
+}
+
+/**
+Call the function as soon as the server starts to shutdown.  Use this to close websockets and event streams,
+which are not drained by the http server.
+*/
+func (server *Server) OnShutdown(hook func()) *Server {
+	server.shutdownHooks = append(server.shutdownHooks, hook)
+	return server
+}
+
+/**
+Listen on the configured address and serve until SIGINT or SIGTERM.  Open requests are given the shutdown timeout
+to finish and then every managed resource is cleaned up.
+*/
+func (server *Server) Run() error {
+	listener, err := net.Listen("tcp", server.settings.Address)
+	if err != nil {
+		return err
+	}
+
+	//Stop when asked to
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	//Serve until there is an error or signal
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-served:
+		return err
+	case sig := <-signals:
+		log.Printf("received %v, shutting down", sig)
+	}
+
+	//Give the open requests time to finish
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(server.settings.ShutdownTimeout)*time.Second)
+	defer cancel()
+	shutdownErr := server.Shutdown(ctx)
+
+	//Wait for serve to return
+	if err := <-served; err != nil {
+		return err
+	}
+	return shutdownErr
+}
+
+/**
+Serve requests on the listener until Shutdown is called.  Returns nil after a clean shutdown
+*/
+func (server *Server) Serve(listener net.Listener) error {
+	server.lock.Lock()
+	if server.started {
+		server.lock.Unlock()
+		return errors.New("the server has already been started")
+	}
+	server.started = true
+	if server.stopped {
+		server.lock.Unlock()
+		return nil
+	}
+
+	//Add the middleware in stage order
+	sort.SliceStable(server.middleware, func(i, j int) bool {
+		return server.middleware[i].stage < server.middleware[j].stage
+	})
+	for _, staged := range server.middleware {
+		server.Router.Use(staged.middleware)
+	}
+
+	//The router middleware only runs for matched routes, so wrap the unmatched handlers in the security stage
+	notFound := server.Router.NotFoundHandler
+	if notFound == nil {
+		notFound = http.NotFoundHandler()
+	}
+	methodNotAllowed := server.Router.MethodNotAllowedHandler
+	if methodNotAllowed == nil {
+		methodNotAllowed = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		})
+	}
+	server.Router.NotFoundHandler = server.wrapStage(StageSecurity, notFound)
+	server.Router.MethodNotAllowedHandler = server.wrapStage(StageSecurity, methodNotAllowed)
+
+	//Build the http server
+	server.httpServer = &http.Server{
+		Handler:           server.Router,
+		ReadTimeout:       time.Duration(server.settings.ReadTimeout) * time.Second,
+		ReadHeaderTimeout: time.Duration(server.settings.ReadHeaderTimeout) * time.Second,
+		WriteTimeout:      time.Duration(server.settings.WriteTimeout) * time.Second,
+		IdleTimeout:       time.Duration(server.settings.IdleTimeout) * time.Second,
+	}
+	for _, hook := range server.shutdownHooks {
+		server.httpServer.RegisterOnShutdown(hook)
+	}
+	server.lock.Unlock()
+
+	//Serve until shutdown
+	err := server.httpServer.Serve(listener)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+/**
+Wrap the handler in the middleware for a single stage so the first one added runs first
+*/
+func (server *Server) wrapStage(stage Stage, handler http.Handler) http.Handler {
+	for i := len(server.middleware) - 1; i >= 0; i-- {
+		if server.middleware[i].stage == stage {
+			handler = server.middleware[i].middleware(handler)
+		}
+	}
+	return handler
+}
+
+/**
+Stop taking new requests, wait for the open ones to finish or the context to end and then clean up every managed
+resource.  If the context ends first the open connections are closed before cleaning up and the context error is
+returned.
+*/
+func (server *Server) Shutdown(ctx context.Context) error {
+	server.lock.Lock()
+	httpServer := server.httpServer
+	server.stopped = true
+	server.lock.Unlock()
+
+	//Drain the connections
+	var err error
+	if httpServer != nil {
+		err = httpServer.Shutdown(ctx)
+
+		//Don't pull the resources out from under requests that are still being sent
+		if err != nil {
+			httpServer.Close()
+		}
+	}
+
+	//Release everything, last in first out
+	for i := len(server.cleanUps) - 1; i >= 0; i-- {
+		server.cleanUps[i].CleanUp()
+	}
+	server.cleanUps = nil
+
+	return err
+}
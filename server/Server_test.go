@@ -0,0 +1,305 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package server_test
+
+import (
+	"context"
+	"github.com/gorilla/mux"
+	"github.com/reaction-eng/restlib/configuration"
+	"github.com/reaction-eng/restlib/middleware"
+	"github.com/reaction-eng/restlib/routing"
+	"github.com/reaction-eng/restlib/server"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+/**
+Record the order things happen in
+*/
+type recorder struct {
+	lock  sync.Mutex
+	steps []string
+}
+
+func (rec *recorder) add(step string) {
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	rec.steps = append(rec.steps, step)
+}
+
+func (rec *recorder) String() string {
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	return strings.Join(rec.steps, ",")
+}
+
+func (rec *recorder) middleware(name string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rec.add(name)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+/**
+A resource that records when it is cleaned up
+*/
+type recordCleanUp struct {
+	name string
+	rec  *recorder
+}
+
+func (cleanUp *recordCleanUp) CleanUp() {
+	cleanUp.rec.add(cleanUp.name)
+}
+
+/**
+Simple producer for the tests
+*/
+type testProducer struct {
+	started chan bool
+	rec     *recorder
+}
+
+func (producer *testProducer) GetRoutes() []routing.Route {
+	return []routing.Route{
+		{
+			Name:    "Slow",
+			Method:  "GET",
+			Pattern: "/slow",
+			HandlerFunc: func(w http.ResponseWriter, r *http.Request) {
+				producer.started <- true
+				time.Sleep(100 * time.Millisecond)
+				producer.rec.add("handler")
+				w.Write([]byte("done"))
+			},
+			Public: true,
+		},
+	}
+}
+
+/**
+Perform the testing
+*/
+func TestServer(t *testing.T) {
+	config, err := configuration.NewConfiguration(`{"server": {"shutdownTimeout": 5}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//Build the server with the middleware out of order
+	rec := &recorder{}
+	producer := &testProducer{started: make(chan bool, 1), rec: rec}
+	srv := server.NewServer(config, nil, nil)
+	srv.Mount(producer).
+		Use(server.StageRateLimit, rec.middleware("rateLimit")).
+		Use(server.StageSecurity, rec.middleware("security")).
+		Use(server.StageAuth, rec.middleware("auth")).
+		Manage(&recordCleanUp{name: "first", rec: rec}, &recordCleanUp{name: "second", rec: rec}).
+		OnShutdown(func() { rec.add("shutdown") })
+
+	if srv.Settings().ShutdownTimeout != 5 || srv.Settings().IdleTimeout != 120 {
+		t.Errorf("recived settings %v, expected the config and defaults", srv.Settings())
+	}
+
+	//Start it
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- srv.Serve(listener)
+	}()
+
+	//Start a slow request
+	responses := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String() + "/slow")
+		if err != nil {
+			responses <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		responses <- string(body)
+	}()
+
+	//Shutdown while it is running
+	<-producer.started
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Errorf("recived shutdown error %v", err)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("recived serve error %v", err)
+	}
+
+	//The open request should finish
+	if response := <-responses; response != "done" {
+		t.Errorf("recived response %s, expected done", response)
+	}
+
+	//Check the order
+	expected := "security,auth,rateLimit,shutdown,handler,second,first"
+	if rec.String() != expected {
+		t.Errorf("recived order %s, expected %s", rec.String(), expected)
+	}
+}
+
+/**
+Perform the testing
+*/
+func TestServerShutdownTimeout(t *testing.T) {
+	config, err := configuration.NewConfiguration(`{}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := &recorder{}
+	producer := &testProducer{started: make(chan bool, 1), rec: rec}
+	srv := server.NewServer(config, nil, nil)
+	srv.Mount(producer).Manage(&recordCleanUp{name: "cleanUp", rec: rec})
+
+	//Start it
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(listener)
+
+	//Start a slow request
+	responses := make(chan error, 1)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String() + "/slow")
+		if err == nil {
+			_, err = ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+		responses <- err
+	}()
+
+	//Give up before the request is done
+	<-producer.started
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("recived shutdown error %v, expected %v", err, context.DeadlineExceeded)
+	}
+
+	//The connection should be closed before the clean up
+	if err := <-responses; err == nil {
+		t.Errorf("expected the open connection to be closed")
+	}
+	if rec.String() != "cleanUp" {
+		t.Errorf("recived %s, expected only the clean up before the handler finished", rec.String())
+	}
+}
+
+/**
+Perform the testing
+*/
+func TestServerUnmatchedSecurity(t *testing.T) {
+	config, err := configuration.NewConfiguration(`{}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := &recorder{}
+	srv := server.NewServer(config, nil, nil)
+	srv.Mount(&testProducer{started: make(chan bool, 1), rec: rec}).
+		Use(server.StageSecurity, middleware.MakeSecurityMiddlewareFunc(middleware.NewSecurityPolicy(`{"security": {"httpsOnly": true}}`))).
+		Use(server.StageAuth, rec.middleware("auth"))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(listener)
+	defer srv.Shutdown(context.Background())
+
+	//Define the list of requests that don't match a route
+	var requests = []struct {
+		method string
+		path   string
+	}{
+		{"GET", "/missing"},
+		{"POST", "/slow"},
+	}
+
+	//Each should still be sent to https
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	for _, request := range requests {
+		req, err := http.NewRequest(request.method, "http://"+listener.Addr().String()+request.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusTemporaryRedirect || !strings.HasPrefix(resp.Header.Get("Location"), "https://") {
+			t.Errorf("recived %d to %s for %s %s, expected a redirect to https", resp.StatusCode, resp.Header.Get("Location"), request.method, request.path)
+		}
+	}
+
+	//Only the security stage runs for them
+	if rec.String() != "" {
+		t.Errorf("recived %s, expected the other stages to be skipped", rec.String())
+	}
+}
+
+/**
+Perform the testing
+*/
+func TestServerUnmatchedHeaders(t *testing.T) {
+	config, err := configuration.NewConfiguration(`{}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := server.NewServer(config, nil, nil)
+	srv.Mount(&testProducer{started: make(chan bool, 1), rec: &recorder{}}).
+		Use(server.StageSecurity, middleware.MakeSecurityMiddlewareFunc(middleware.NewSecurityPolicy(`{}`)))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(listener)
+	defer srv.Shutdown(context.Background())
+
+	//Define the list of requests and the status they get
+	var requests = []struct {
+		method   string
+		path     string
+		expected int
+	}{
+		{"GET", "/missing", http.StatusNotFound},
+		{"POST", "/slow", http.StatusMethodNotAllowed},
+	}
+
+	for _, request := range requests {
+		req, err := http.NewRequest(request.method, "http://"+listener.Addr().String()+request.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != request.expected || resp.Header.Get("X-Content-Type-Options") != "nosniff" || resp.Header.Get("X-Frame-Options") != "DENY" {
+			t.Errorf("recived %d with %v for %s %s, expected %d with the security headers", resp.StatusCode, resp.Header, request.method, request.path, request.expected)
+		}
+	}
+}
@@ -0,0 +1,371 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package sse
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/reaction-eng/restlib/apierror"
+	"github.com/reaction-eng/restlib/auth"
+	"github.com/reaction-eng/restlib/notification"
+	"github.com/reaction-eng/restlib/routing"
+	"github.com/reaction-eng/restlib/users"
+	"github.com/reaction-eng/restlib/utils"
+)
+
+//The event type used for notifications
+const TypeNotification = "notification"
+
+//How many events can be waiting for a client before it is dropped
+const clientBufferSize = 64
+
+//How long the replay buffer is kept after the user's last event by default
+const defaultBufferTtl = 5 * time.Minute
+
+/**
+A single connected client
+*/
+type client struct {
+	events chan Event
+	done   chan struct{}
+	once   sync.Once
+}
+
+/**
+Stop sending to the client
+*/
+func (c *client) close() {
+	c.once.Do(func() {
+		close(c.done)
+	})
+}
+
+/**
+Streams the events for each logged in user
+*/
+type Broker struct {
+	//The path the clients connect to
+	pattern string
+
+	//How many events to keep for each user so clients can resume
+	bufferSize int
+
+	//How often to send a comment to keep the connection open
+	heartbeat time.Duration
+
+	//Optional publisher used to share events between replicas
+	publisher Publisher
+
+	//How long to keep a user's replay buffer after their last event.  Older buffers are dropped so users that
+	//stop getting events don't hold memory forever
+	BufferTtl time.Duration
+
+	//Keep track of the clients and the recent events
+	lock         sync.Mutex
+	clients      map[int]map[*client]bool
+	buffers      map[int][]Event
+	bufferTimes  map[int]time.Time
+	lastEviction time.Time
+	lastId       uint64
+	closed       bool
+}
+
+/**
+Build a new broker that streams events at the pattern, i.e. /users/events.  The last bufferSize events for each user
+are kept for BufferTtl so clients can resume with Last-Event-ID.  A heartbeat of zero or less sends no heartbeats.
+*/
+func NewBroker(pattern string, bufferSize int, heartbeat time.Duration) *Broker {
+	return &Broker{
+		pattern:     pattern,
+		bufferSize:  bufferSize,
+		heartbeat:   heartbeat,
+		BufferTtl:   defaultBufferTtl,
+		clients:     make(map[int]map[*client]bool),
+		buffers:     make(map[int][]Event),
+		bufferTimes: make(map[int]time.Time),
+	}
+}
+
+/**
+Send events through the publisher so every replica gets them
+*/
+func (broker *Broker) UsePublisher(publisher Publisher) error {
+	broker.publisher = publisher
+	return publisher.Subscribe(broker.deliver)
+}
+
+/**
+Function used to get routes
+*/
+func (broker *Broker) GetRoutes() []routing.Route {
+	return []routing.Route{
+		{
+			Name:        "Live Events",
+			Method:      "GET",
+			Pattern:     broker.pattern,
+			HandlerFunc: broker.handleEvents,
+			Description: "Stream events for the logged in user as text/event-stream.  Send Last-Event-ID to resume.",
+			Tags:        []string{"realtime"},
+		},
+	}
+}
+
+/**
+Send the event to every client the user has open
+*/
+func (broker *Broker) SendToUser(userId int, eventType string, data interface{}) error {
+	return broker.SendToUserContext(context.Background(), userId, eventType, data)
+}
+
+/**
+The same as SendToUser but the publish is traced as part of the context
+*/
+func (broker *Broker) SendToUserContext(ctx context.Context, userId int, eventType string, data interface{}) error {
+	//Convert the data once
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	event := Event{
+		Id:     broker.nextId(),
+		UserId: userId,
+		Type:   eventType,
+		Data:   jsonData,
+	}
+
+	//Let every replica know
+	if broker.publisher != nil {
+		return broker.publisher.Publish(ctx, event)
+	}
+
+	broker.deliver(event)
+	return nil
+}
+
+/**
+Send the notification to the user so the broker can be used as a notification.Notifier
+*/
+func (broker *Broker) Notify(notification notification.Notification, user users.User) error {
+	return broker.SendToUser(user.Id(), TypeNotification, notification)
+}
+
+/**
+Close every stream and the publisher
+*/
+func (broker *Broker) Close() {
+	broker.lock.Lock()
+	broker.closed = true
+	for _, userClients := range broker.clients {
+		for c := range userClients {
+			c.close()
+		}
+	}
+	broker.clients = make(map[int]map[*client]bool)
+	broker.lock.Unlock()
+
+	if broker.publisher != nil {
+		broker.publisher.Close()
+	}
+}
+
+/**
+Get the next event id.  Ids are based on the time so they keep increasing across restarts and replicas
+*/
+func (broker *Broker) nextId() uint64 {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+
+	id := uint64(time.Now().UnixNano())
+	if id <= broker.lastId {
+		id = broker.lastId + 1
+	}
+	broker.lastId = id
+	return id
+}
+
+/**
+Store the event and pass it to each of the user's clients
+*/
+func (broker *Broker) deliver(event Event) {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+
+	//Keep track of the newest id seen from any replica
+	if event.Id > broker.lastId {
+		broker.lastId = event.Id
+	}
+
+	//Drop any old buffers before adding to this one
+	now := time.Now()
+	broker.evictBuffers(now)
+
+	//Add it to the replay buffer
+	buffer := append(broker.buffers[event.UserId], event)
+	if len(buffer) > broker.bufferSize {
+		buffer = buffer[len(buffer)-broker.bufferSize:]
+	}
+	broker.buffers[event.UserId] = buffer
+	broker.bufferTimes[event.UserId] = now
+
+	//Send it to each client
+	for c := range broker.clients[event.UserId] {
+		select {
+		case c.events <- event:
+		default:
+			//The client is too slow, it can resume with the Last-Event-ID
+			delete(broker.clients[event.UserId], c)
+			c.close()
+		}
+	}
+}
+
+/**
+Add the client and get the events it missed
+*/
+func (broker *Broker) add(userId int, c *client, lastEventId uint64) ([]Event, error) {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+
+	if broker.closed {
+		return nil, apierror.ErrInternal.WithDetail("the event stream is closed")
+	}
+
+	if broker.clients[userId] == nil {
+		broker.clients[userId] = make(map[*client]bool)
+	}
+	broker.clients[userId][c] = true
+
+	//Only replay if the client is resuming
+	missed := make([]Event, 0)
+	if lastEventId > 0 {
+		for _, event := range broker.buffers[userId] {
+			if event.Id > lastEventId {
+				missed = append(missed, event)
+			}
+		}
+	}
+	return missed, nil
+}
+
+/**
+Remove the client
+*/
+func (broker *Broker) remove(userId int, c *client) {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+
+	delete(broker.clients[userId], c)
+	if len(broker.clients[userId]) == 0 {
+		delete(broker.clients, userId)
+	}
+	c.close()
+
+	broker.evictBuffers(time.Now())
+}
+
+/**
+Drop the replay buffers that have not had an event within the BufferTtl.  The buffers are only checked once per
+BufferTtl so sending stays cheap.  The lock must be held
+*/
+func (broker *Broker) evictBuffers(now time.Time) {
+	if broker.BufferTtl <= 0 || now.Sub(broker.lastEviction) < broker.BufferTtl {
+		return
+	}
+	broker.lastEviction = now
+
+	for userId, updated := range broker.bufferTimes {
+		if now.Sub(updated) >= broker.BufferTtl {
+			delete(broker.buffers, userId)
+			delete(broker.bufferTimes, userId)
+		}
+	}
+}
+
+/**
+Stream the events to the logged in user
+*/
+func (broker *Broker) handleEvents(w http.ResponseWriter, r *http.Request) {
+	//We have gone through the auth, so we should know the id of the logged in user
+	loggedInUser, ok := auth.UserId(r.Context())
+	if !ok {
+		utils.ReturnError(w, apierror.ErrUnauthorized)
+		return
+	}
+
+	//Make sure we can stream
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		utils.ReturnError(w, apierror.ErrInternal.WithDetail("streaming is not supported"))
+		return
+	}
+
+	//Get where to resume from, browsers send the header and the query is for clients that can't set it
+	lastEventIdString := r.Header.Get("Last-Event-ID")
+	if len(lastEventIdString) == 0 {
+		lastEventIdString = r.URL.Query().Get("lastEventId")
+	}
+	lastEventId, _ := strconv.ParseUint(lastEventIdString, 10, 64)
+
+	//Add the client
+	c := &client{
+		events: make(chan Event, clientBufferSize),
+		done:   make(chan struct{}),
+	}
+	missed, err := broker.add(loggedInUser, c, lastEventId)
+	if err != nil {
+		utils.ReturnError(w, err)
+		return
+	}
+	defer broker.remove(loggedInUser, c)
+
+	//Start the stream
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", 3000)
+
+	//Send anything that was missed
+	for _, event := range missed {
+		if event.write(w) != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	//Keep the connection open, only sending heartbeats if asked to
+	var heartbeats <-chan time.Time
+	if broker.heartbeat > 0 {
+		heartbeat := time.NewTicker(broker.heartbeat)
+		defer heartbeat.Stop()
+		heartbeats = heartbeat.C
+	}
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-c.done:
+			return
+		case event := <-c.events:
+			if event.write(w) != nil {
+				return
+			}
+			flusher.Flush()
+		case <-heartbeats:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
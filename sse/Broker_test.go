@@ -0,0 +1,182 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package sse_test
+
+import (
+	"bufio"
+	"context"
+	"github.com/reaction-eng/restlib/auth"
+	"github.com/reaction-eng/restlib/sse"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+/**
+Perform the testing
+*/
+func TestBrokerReplay(t *testing.T) {
+	broker := sse.NewBroker("/events", 2, time.Minute)
+	defer broker.Close()
+
+	//Fake the jwt middleware
+	route := broker.GetRoutes()[0]
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route.HandlerFunc(w, r.WithContext(auth.WithIdentity(r.Context(), &auth.Identity{UserId: 1})))
+	}))
+	defer server.Close()
+
+	//Queue up some events, only the last two are kept
+	for _, data := range []string{"one", "two", "three"} {
+		if err := broker.SendToUser(1, "update", data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	broker.SendToUser(2, "update", "other user")
+
+	//Resume from the start of the buffer
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", server.URL+"/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("recived content type %s", resp.Header.Get("Content-Type"))
+	}
+
+	//Read the data lines
+	reader := bufio.NewReader(resp.Body)
+	readData := func() string {
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.HasPrefix(line, "data: ") {
+				return strings.TrimSpace(strings.TrimPrefix(line, "data: "))
+			}
+		}
+	}
+
+	//Check the replay
+	if data := readData(); data != `"two"` {
+		t.Errorf("recived %s, expected two", data)
+	}
+	if data := readData(); data != `"three"` {
+		t.Errorf("recived %s, expected three", data)
+	}
+
+	//Now check a live event
+	broker.SendToUser(1, "update", "four")
+	if data := readData(); data != `"four"` {
+		t.Errorf("recived %s, expected four", data)
+	}
+}
+
+/**
+Perform the testing
+*/
+func TestBrokerBufferTtl(t *testing.T) {
+	broker := sse.NewBroker("/events", 2, time.Minute)
+	broker.BufferTtl = 50 * time.Millisecond
+	defer broker.Close()
+
+	//Fake the jwt middleware
+	route := broker.GetRoutes()[0]
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route.HandlerFunc(w, r.WithContext(auth.WithIdentity(r.Context(), &auth.Identity{UserId: 1})))
+	}))
+	defer server.Close()
+
+	//Queue up an event and let it expire, the next event drops the old buffer
+	broker.SendToUser(1, "update", "old")
+	time.Sleep(100 * time.Millisecond)
+	broker.SendToUser(2, "update", "other user")
+
+	//Try to resume from the start
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", server.URL+"/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	//The expired event should not be replayed, so the first one is live
+	broker.SendToUser(1, "update", "new")
+	reader := bufio.NewReader(resp.Body)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasPrefix(line, "data: ") {
+			if data := strings.TrimSpace(strings.TrimPrefix(line, "data: ")); data != `"new"` {
+				t.Errorf("recived %s, expected new", data)
+			}
+			break
+		}
+	}
+}
+
+/**
+Perform the testing
+*/
+func TestBrokerNoHeartbeat(t *testing.T) {
+	broker := sse.NewBroker("/events", 2, 0)
+	defer broker.Close()
+
+	//Fake the jwt middleware
+	route := broker.GetRoutes()[0]
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route.HandlerFunc(w, r.WithContext(auth.WithIdentity(r.Context(), &auth.Identity{UserId: 1})))
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", server.URL+"/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	//The stream should still deliver events without any heartbeats
+	broker.SendToUser(1, "update", "live")
+	reader := bufio.NewReader(resp.Body)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasPrefix(line, ": heartbeat") {
+			t.Errorf("recived a heartbeat, expected none")
+		}
+		if strings.HasPrefix(line, "data: ") {
+			if data := strings.TrimSpace(strings.TrimPrefix(line, "data: ")); data != `"live"` {
+				t.Errorf("recived %s, expected live", data)
+			}
+			break
+		}
+	}
+}
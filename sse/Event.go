@@ -0,0 +1,33 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package sse
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+/**
+A single event sent to a user.  Ids increase so a client can resume with Last-Event-ID
+*/
+type Event struct {
+	Id     uint64          `json:"id"`
+	UserId int             `json:"userId"`
+	Type   string          `json:"type"`
+	Data   json.RawMessage `json:"data"`
+}
+
+/**
+Write the event in the text/event-stream format
+*/
+func (event *Event) write(w io.Writer) error {
+	//Each line of the data needs its own prefix
+	data := strings.Replace(string(event.Data), "\n", "\ndata: ", -1)
+
+	_, err := fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", strconv.FormatUint(event.Id, 10), event.Type, data)
+	return err
+}
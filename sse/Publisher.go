@@ -0,0 +1,105 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package sse
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/go-redis/redis"
+	"github.com/reaction-eng/restlib/tracing"
+)
+
+/**
+Define an interface used to fan events out to every replica
+*/
+type Publisher interface {
+	/**
+	Send the event to every subscribed broker, including this one
+	*/
+	Publish(ctx context.Context, event Event) error
+
+	/**
+	Start delivering the published events
+	*/
+	Subscribe(deliver func(event Event)) error
+
+	/**
+	Stop delivering events
+	*/
+	Close() error
+}
+
+/**
+Publish the events over a redis channel
+*/
+type RedisPublisher struct {
+	redis   *redis.Ring
+	channel string
+
+	//Store the subscription so it can be closed
+	pubSub *redis.PubSub
+}
+
+//Provide a method to make a new RedisPublisher
+func NewRedisPublisher(redis *redis.Ring, channel string) *RedisPublisher {
+	return &RedisPublisher{
+		redis:   redis,
+		channel: channel,
+	}
+}
+
+/**
+Send the event to the redis channel
+*/
+func (publisher *RedisPublisher) Publish(ctx context.Context, event Event) error {
+	//Trace the call to redis
+	_, span := tracing.StartClientSpan(ctx, "sse.RedisPublisher.Publish", "redis")
+	defer span.End()
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return tracing.RecordError(span, err)
+	}
+
+	return tracing.RecordError(span, publisher.redis.Publish(publisher.channel, data).Err())
+}
+
+/**
+Listen to the channel and pass each event to deliver
+*/
+func (publisher *RedisPublisher) Subscribe(deliver func(event Event)) error {
+	publisher.pubSub = publisher.redis.Subscribe(publisher.channel)
+
+	//Make sure the subscription worked
+	if _, err := publisher.pubSub.Receive(); err != nil {
+		publisher.pubSub.Close()
+		return err
+	}
+
+	//Deliver until closed
+	go func() {
+		for message := range publisher.pubSub.Channel() {
+			event := Event{}
+			if err := json.Unmarshal([]byte(message.Payload), &event); err != nil {
+				log.Printf("could not read sse event: %v", err)
+				continue
+			}
+			deliver(event)
+		}
+	}()
+
+	return nil
+}
+
+/**
+Stop listening to the channel
+*/
+func (publisher *RedisPublisher) Close() error {
+	if publisher.pubSub == nil {
+		return nil
+	}
+	return publisher.pubSub.Close()
+}
@@ -0,0 +1,48 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package sse_test
+
+import (
+	"context"
+	"github.com/go-redis/redis"
+	"github.com/reaction-eng/restlib/sse"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"testing"
+)
+
+/**
+Perform the testing
+*/
+func TestRedisPublisherTracing(t *testing.T) {
+	//Record the spans in memory
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(previous)
+
+	//Nothing is listening so the publish fails, but it is still traced
+	ring := redis.NewRing(&redis.RingOptions{Addrs: map[string]string{"shard": "127.0.0.1:1"}})
+	defer ring.Close()
+	publisher := sse.NewRedisPublisher(ring, "events")
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "request")
+	if err := publisher.Publish(ctx, sse.Event{Id: 1, UserId: 1, Type: "update"}); err == nil {
+		t.Errorf("expected an error without redis")
+	}
+	parent.End()
+
+	//The publish should be a child of the request
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("recived %d spans, expected 2", len(spans))
+	}
+	if spans[0].Name() != "sse.RedisPublisher.Publish" || spans[0].Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("recived %s with parent %s, expected the publish under %s", spans[0].Name(), spans[0].Parent().SpanID(), parent.SpanContext().SpanID())
+	}
+	if spans[0].SpanContext().TraceID() != parent.SpanContext().TraceID() {
+		t.Errorf("recived trace %s, expected %s", spans[0].SpanContext().TraceID(), parent.SpanContext().TraceID())
+	}
+}
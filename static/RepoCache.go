@@ -4,6 +4,9 @@
 package static
 
 import (
+	"log"
+	"sync"
+
 	"github.com/reaction-eng/restlib/cache"
 	"github.com/reaction-eng/restlib/configuration"
 	"github.com/reaction-eng/restlib/google"
@@ -22,14 +25,23 @@ type RepoCache struct {
 	//Store the public and private
 	privateConfig *configuration.Configuration
 	publicConfig  *configuration.Configuration
+
+	//Protect the configs while they are reloaded
+	lock sync.RWMutex
 }
 
 //Provide a method to make a new AnimalRepoSql
 func NewRepoCache(drive *google.Drive, cas cache.ObjectCache, privateConfigFile string, publicConfigFile string) *RepoCache {
 
 	//Create a new config
-	privateConfig, _ := configuration.NewConfiguration(privateConfigFile)
-	publicConfig, _ := configuration.NewConfiguration(publicConfigFile)
+	privateConfig, err := configuration.NewConfiguration(privateConfigFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	publicConfig, err := configuration.NewConfiguration(publicConfigFile)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	//Define a new repo
 	newRepo := RepoCache{
@@ -44,13 +56,37 @@ func NewRepoCache(drive *google.Drive, cas cache.ObjectCache, privateConfigFile
 
 }
 
+/**
+Use the new document ids each time either config is reloaded.  Either reloader can be nil
+*/
+func (repo *RepoCache) Subscribe(privateReloader *configuration.Reloader, publicReloader *configuration.Reloader) {
+	if privateReloader != nil {
+		privateReloader.Subscribe(nil, func(config *configuration.Configuration) error {
+			repo.lock.Lock()
+			defer repo.lock.Unlock()
+			repo.privateConfig = config
+			return nil
+		})
+	}
+	if publicReloader != nil {
+		publicReloader.Subscribe(nil, func(config *configuration.Configuration) error {
+			repo.lock.Lock()
+			defer repo.lock.Unlock()
+			repo.publicConfig = config
+			return nil
+		})
+	}
+}
+
 /**
 Get the public static
 */
 func (repo *RepoCache) GetStaticPublicDocument(path string) (string, error) {
 
 	//Look up the document id from the config
+	repo.lock.RLock()
 	documentId, err := repo.publicConfig.GetStringError(path)
+	repo.lock.RUnlock()
 
 	if err != nil {
 		return "", err
@@ -82,7 +118,9 @@ Get the public static
 func (repo *RepoCache) GetStaticPrivateDocument(path string) (string, error) {
 
 	//Look up the document id from the config
+	repo.lock.RLock()
 	documentId, err := repo.privateConfig.GetStringError(path)
+	repo.lock.RUnlock()
 
 	if err != nil {
 		return "", err
@@ -0,0 +1,137 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package tracing_test
+
+import (
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/reaction-eng/restlib/dialect"
+	"github.com/reaction-eng/restlib/tracing"
+	"github.com/reaction-eng/restlib/users"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+/**
+Perform the testing
+*/
+func TestHandlerChildSpans(t *testing.T) {
+	//Record the spans in memory
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(previous)
+	previousPropagator := otel.GetTextMapPropagator()
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTextMapPropagator(previousPropagator)
+
+	//Build a sql repo to query
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "tracing.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	userRepo := users.NewRepoSql(db, dialect.Sqlite, "users")
+	defer userRepo.CleanUp()
+
+	//The outside service remembers the trace it was sent
+	var remoteParent trace.SpanContext
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		remoteParent = trace.SpanContextFromContext(ctx)
+	}))
+	defer remote.Close()
+	client := &http.Client{Transport: tracing.NewTransport(nil, "remote")}
+
+	//The handler queries the db and calls the outside service with the request context
+	handler := tracing.WrapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userRepo.GetUserContext(r.Context(), 1)
+
+		req, err := http.NewRequestWithContext(r.Context(), "GET", remote.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}), "Get Thing")
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/thing", nil))
+
+	//Find each span by name
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	request, found := spans["Get Thing"]
+	if !found {
+		t.Fatalf("recived %v, expected the request span", spans)
+	}
+	if request.SpanKind() != trace.SpanKindServer {
+		t.Errorf("recived %s, expected a server span", request.SpanKind())
+	}
+
+	//Each call should be a child of the request
+	for _, name := range []string{"users.RepoSql.GetUser", "remote GET"} {
+		span, found := spans[name]
+		if !found {
+			t.Errorf("missing the %s span in %v", name, spans)
+			continue
+		}
+		if span.Parent().SpanID() != request.SpanContext().SpanID() {
+			t.Errorf("recived parent %s for %s, expected %s", span.Parent().SpanID(), name, request.SpanContext().SpanID())
+		}
+		if span.SpanContext().TraceID() != request.SpanContext().TraceID() {
+			t.Errorf("recived trace %s for %s, expected %s", span.SpanContext().TraceID(), name, request.SpanContext().TraceID())
+		}
+	}
+
+	//The outside service continues the trace from the client span
+	if remoteParent.SpanID() != spans["remote GET"].SpanContext().SpanID() {
+		t.Errorf("recived remote parent %s, expected %s", remoteParent.SpanID(), spans["remote GET"].SpanContext().SpanID())
+	}
+}
+
+/**
+Perform the testing
+*/
+func TestHandlerContinuesTrace(t *testing.T) {
+	//Record the spans in memory
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(previous)
+	previousPropagator := otel.GetTextMapPropagator()
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTextMapPropagator(previousPropagator)
+
+	//Send a request that is already part of a trace
+	handler := tracing.WrapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}), "Get Thing")
+	req := httptest.NewRequest("GET", "/thing", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("recived %d spans, expected 1", len(spans))
+	}
+	if spans[0].SpanContext().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" || spans[0].Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("recived trace %s with parent %s, expected the incoming trace", spans[0].SpanContext().TraceID(), spans[0].Parent().SpanID())
+	}
+	if spans[0].Status().Code != codes.Error {
+		t.Errorf("recived status %s, expected an error for a 502", spans[0].Status().Code)
+	}
+}
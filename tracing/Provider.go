@@ -0,0 +1,137 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package tracing
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"github.com/reaction-eng/restlib/configuration"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+/**
+Define the supported span exporters
+*/
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOtlp   = "otlp"
+)
+
+/**
+Holds the tracer provider so it can be flushed and shutdown
+*/
+type Provider struct {
+	//Store the sdk provider, nil if tracing is off
+	provider *sdktrace.TracerProvider
+}
+
+/**
+Build a new provider from the config and register it as the global otel provider.  The following keys are used:
+tracing_exporter (none, stdout, otlp), tracing_service_name, tracing_sample_ratio, tracing_otlp_endpoint and
+tracing_otlp_insecure.
+*/
+func NewProvider(configFiles ...string) *Provider {
+	//Load in the config
+	config, err := configuration.NewConfiguration(configFiles...)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	//Build the exporter
+	exporter, err := newExporter(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	//If there is no exporter just use the default no-op otel provider
+	if exporter == nil {
+		return &Provider{}
+	}
+
+	//Get the service name
+	serviceName := config.GetString("tracing_service_name")
+	if len(serviceName) == 0 {
+		serviceName = "restlib"
+	}
+
+	//Get the sample ratio, default to always sample
+	sampleRatio, err := config.GetFloat("tracing_sample_ratio")
+	if err != nil {
+		sampleRatio = 1.0
+	}
+
+	//Build the provider
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceName(serviceName),
+		)),
+	)
+
+	//Register it globally so all of the restlib spans use it
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return &Provider{
+		provider: provider,
+	}
+}
+
+/**
+Build the exporter requested in the config
+*/
+func newExporter(config *configuration.Configuration) (sdktrace.SpanExporter, error) {
+	switch config.GetString("tracing_exporter") {
+	case "", ExporterNone:
+		return nil, nil
+	case ExporterStdout:
+		return stdouttrace.New(stdouttrace.WithWriter(os.Stdout), stdouttrace.WithPrettyPrint())
+	case ExporterOtlp:
+		//Build the options
+		options := make([]otlptracehttp.Option, 0)
+
+		//The endpoint is optional, the otel env variables are used if not set
+		if endpoint := config.GetString("tracing_otlp_endpoint"); len(endpoint) > 0 {
+			options = append(options, otlptracehttp.WithEndpoint(endpoint))
+		}
+		if config.GetBool("tracing_otlp_insecure", false) {
+			options = append(options, otlptracehttp.WithInsecure())
+		}
+
+		return otlptracehttp.New(context.Background(), options...)
+	default:
+		return nil, errors.New("unknown tracing exporter " + config.GetString("tracing_exporter"))
+	}
+}
+
+/**
+Flush any remaining spans and shutdown the provider
+*/
+func (prov *Provider) CleanUp() {
+	//If there is no provider, there is nothing to do
+	if prov.provider == nil {
+		return
+	}
+
+	//Give it a little bit of time to flush
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := prov.provider.Shutdown(ctx); err != nil {
+		log.Println("Could not shutdown the tracing provider: ", err)
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+//Name all of the spans produced by restlib
+const instrumentationName = "github.com/reaction-eng/restlib"
+
+/**
+Get the tracer used for all restlib spans.  If no provider has been set up this is a no-op tracer
+*/
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+/**
+Start a new internal span as a child of any span in the context
+*/
+func StartSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+/**
+Start a client span around a sql call
+*/
+func StartSqlSpan(ctx context.Context, name string, tableName string) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.sql.table", tableName),
+		),
+	)
+}
+
+/**
+Start a client span around a call to an outside service (google, redis, smtp, etc.)
+*/
+func StartClientSpan(ctx context.Context, name string, system string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	//Always tag the system
+	attrs = append(attrs, attribute.String("peer.service", system))
+
+	return Tracer().Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+/**
+Record the error (if any) on the span.  The error is returned so it can be used inline
+*/
+func RecordError(span trace.Span, err error) error {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	return err
+}
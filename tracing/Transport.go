@@ -0,0 +1,62 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package tracing
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+/**
+Round tripper that wraps every outbound http call in a client span
+*/
+type transport struct {
+	//The real transport
+	base http.RoundTripper
+
+	//The name of the remote system
+	system string
+}
+
+/**
+Wrap the base transport so each request produces a client span.  If base is nil the default transport is used.
+*/
+func NewTransport(base http.RoundTripper, system string) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return &transport{
+		base:   base,
+		system: system,
+	}
+}
+
+/**
+Perform the request inside of a span
+*/
+func (trans *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	//Start the span
+	ctx, span := StartClientSpan(req.Context(), trans.system+" "+req.Method, trans.system,
+		attribute.String("http.method", req.Method),
+		attribute.String("http.url", req.URL.Host+req.URL.Path),
+	)
+	defer span.End()
+
+	//Pass the trace along
+	req = req.Clone(ctx)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	//Now do the request
+	resp, err := trans.base.RoundTrip(req)
+	if err != nil {
+		return nil, RecordError(span, err)
+	}
+
+	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+	return resp, nil
+}
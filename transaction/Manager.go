@@ -0,0 +1,190 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package transaction
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+)
+
+/**
+Runs a unit of work so that every change in it is kept or none are
+*/
+type Manager interface {
+	//Run the function in a transaction.  It is committed if the function returns nil and rolled back otherwise.
+	//Calls inside of the function join the same transaction.
+	Atomic(ctx context.Context, function func(ctx context.Context) error) error
+}
+
+/**
+The key used to store the open transaction for each database in the context
+*/
+type txKey struct {
+	db *sql.DB
+}
+
+/**
+The key used to store the functions waiting for the unit of work to commit
+*/
+type afterCommitKey struct{}
+
+/**
+Keeps the functions to run once the unit of work commits
+*/
+type afterCommitHooks struct {
+	lock      sync.Mutex
+	functions []func(ctx context.Context) error
+}
+
+/**
+Run the function once the current unit of work commits, i.e. to send an email only when the changes are kept.  The
+function is dropped if the unit of work rolls back.  Without a unit of work in the context it runs right away.
+*/
+func AfterCommit(ctx context.Context, function func(ctx context.Context) error) error {
+	hooks, found := ctx.Value(afterCommitKey{}).(*afterCommitHooks)
+	if !found {
+		return function(ctx)
+	}
+
+	hooks.lock.Lock()
+	defer hooks.lock.Unlock()
+	hooks.functions = append(hooks.functions, function)
+	return nil
+}
+
+/**
+Start collecting the functions to run after the commit
+*/
+func withAfterCommit(ctx context.Context) (context.Context, *afterCommitHooks) {
+	hooks := &afterCommitHooks{}
+	return context.WithValue(ctx, afterCommitKey{}, hooks), hooks
+}
+
+/**
+Run each of the functions in order.  They all run, and the first error is returned
+*/
+func (hooks *afterCommitHooks) run(ctx context.Context) error {
+	hooks.lock.Lock()
+	functions := hooks.functions
+	hooks.functions = nil
+	hooks.lock.Unlock()
+
+	var firstErr error
+	for _, function := range functions {
+		if err := function(ctx); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+/**
+Runs units of work in a sql transaction.  Any sql repo on the same database joins it through the context
+*/
+type SqlManager struct {
+	db *sql.DB
+
+	//Options used for new transactions, nil for the driver defaults
+	options *sql.TxOptions
+}
+
+//Provide a method to make a new SqlManager
+func NewSqlManager(db *sql.DB) *SqlManager {
+	return &SqlManager{
+		db: db,
+	}
+}
+
+/**
+Set the isolation level used for new transactions
+*/
+func (manager *SqlManager) SetOptions(options *sql.TxOptions) {
+	manager.options = options
+}
+
+/**
+Run the function in a transaction, or in the current one if there is already one open for the database.  Anything
+added with AfterCommit runs once the transaction commits, with the ctx passed in
+*/
+func (manager *SqlManager) Atomic(ctx context.Context, function func(ctx context.Context) error) (err error) {
+	//Join the open transaction, it is up to the outer call to commit
+	if _, found := FromContext(ctx, manager.db); found {
+		return function(ctx)
+	}
+
+	tx, err := manager.db.BeginTx(ctx, manager.options)
+	if err != nil {
+		return err
+	}
+
+	//Always roll back if the function does not finish
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			tx.Rollback()
+			panic(recovered)
+		}
+	}()
+
+	txCtx, hooks := withAfterCommit(context.WithValue(ctx, txKey{manager.db}, tx))
+	if err := function(txCtx); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return fmt.Errorf("%w, and could not roll back: %v", err, rollbackErr)
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	//Only now is it safe to let anyone else know
+	return hooks.run(ctx)
+}
+
+/**
+Get the open transaction for the database
+*/
+func FromContext(ctx context.Context, db *sql.DB) (*sql.Tx, bool) {
+	tx, found := ctx.Value(txKey{db}).(*sql.Tx)
+	return tx, found
+}
+
+/**
+Get the version of the prepared statement to use.  If there is a transaction open for the database the statement
+runs in it, otherwise the statement is returned as is.
+*/
+func Stmt(ctx context.Context, db *sql.DB, stmt *sql.Stmt) *sql.Stmt {
+	if tx, found := FromContext(ctx, db); found {
+		return tx.StmtContext(ctx, stmt)
+	}
+	return stmt
+}
+
+/**
+Runs the function with no transaction, for repos that can't roll back such as the memory repo
+*/
+type NoopManager struct{}
+
+//Provide a method to make a new NoopManager
+func NewNoopManager() *NoopManager {
+	return &NoopManager{}
+}
+
+/**
+Run the function, anything added with AfterCommit runs if it finishes without an error
+*/
+func (manager *NoopManager) Atomic(ctx context.Context, function func(ctx context.Context) error) error {
+	//Join the outer unit of work
+	if _, found := ctx.Value(afterCommitKey{}).(*afterCommitHooks); found {
+		return function(ctx)
+	}
+
+	hooksCtx, hooks := withAfterCommit(ctx)
+	if err := function(hooksCtx); err != nil {
+		return err
+	}
+	return hooks.run(ctx)
+}
@@ -0,0 +1,156 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package transaction_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/reaction-eng/restlib/transaction"
+	"path/filepath"
+	"testing"
+)
+
+/**
+Perform the testing
+*/
+func TestSqlManager(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "transaction.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE things(id int)"); err != nil {
+		t.Fatal(err)
+	}
+	insert, err := db.Prepare("INSERT INTO things(id) VALUES (?)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer insert.Close()
+
+	manager := transaction.NewSqlManager(db)
+	count := func() int {
+		var rows int
+		db.QueryRow("SELECT COUNT(*) FROM things").Scan(&rows)
+		return rows
+	}
+
+	//Insert two things, in a nested call, and then fail
+	failed := errors.New("failed")
+	err = manager.Atomic(context.Background(), func(ctx context.Context) error {
+		if _, found := transaction.FromContext(ctx, db); !found {
+			t.Errorf("expected the transaction to be in the context")
+		}
+		if _, err := transaction.Stmt(ctx, db, insert).ExecContext(ctx, 1); err != nil {
+			return err
+		}
+		if err := manager.Atomic(ctx, func(ctx context.Context) error {
+			_, err := transaction.Stmt(ctx, db, insert).ExecContext(ctx, 2)
+			return err
+		}); err != nil {
+			return err
+		}
+		return failed
+	})
+	if err != failed || count() != 0 {
+		t.Errorf("recived %v with %d rows, expected %v with none", err, count(), failed)
+	}
+
+	//Now let it finish
+	err = manager.Atomic(context.Background(), func(ctx context.Context) error {
+		if _, err := transaction.Stmt(ctx, db, insert).ExecContext(ctx, 1); err != nil {
+			return err
+		}
+		return manager.Atomic(ctx, func(ctx context.Context) error {
+			_, err := transaction.Stmt(ctx, db, insert).ExecContext(ctx, 2)
+			return err
+		})
+	})
+	if err != nil || count() != 2 {
+		t.Errorf("recived %v with %d rows, expected 2 rows", err, count())
+	}
+
+	//A panic also rolls back
+	func() {
+		defer func() { recover() }()
+		manager.Atomic(context.Background(), func(ctx context.Context) error {
+			transaction.Stmt(ctx, db, insert).ExecContext(ctx, 3)
+			panic("stop")
+		})
+	}()
+	if count() != 2 {
+		t.Errorf("recived %d rows, expected the panic to roll back", count())
+	}
+
+	//Without a transaction the statement is used as is
+	if stmt := transaction.Stmt(context.Background(), db, insert); stmt != insert {
+		t.Errorf("expected the statement to be unchanged")
+	}
+}
+
+/**
+Perform the testing
+*/
+func TestAfterCommit(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "transaction.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	//Define the list of managers we are testing
+	var managers = []struct {
+		name    string
+		manager transaction.Manager
+	}{
+		{"sql", transaction.NewSqlManager(db)},
+		{"noop", transaction.NewNoopManager()},
+	}
+
+	for _, mm := range managers {
+		ran := 0
+		hook := func(ctx context.Context) error {
+			if _, found := transaction.FromContext(ctx, db); found {
+				t.Errorf("%s expected the hook to run outside of the transaction", mm.name)
+			}
+			ran++
+			return nil
+		}
+
+		//A failed unit of work drops the hook
+		mm.manager.Atomic(context.Background(), func(ctx context.Context) error {
+			transaction.AfterCommit(ctx, hook)
+			return errors.New("failed")
+		})
+		if ran != 0 {
+			t.Errorf("%s recived %d runs, expected none after a roll back", mm.name, ran)
+		}
+
+		//Nested calls wait for the outer one
+		err := mm.manager.Atomic(context.Background(), func(ctx context.Context) error {
+			mm.manager.Atomic(ctx, func(ctx context.Context) error {
+				return transaction.AfterCommit(ctx, hook)
+			})
+			if ran != 0 {
+				t.Errorf("%s expected the hook to wait for the outer commit", mm.name)
+			}
+			return nil
+		})
+		if err != nil || ran != 1 {
+			t.Errorf("%s recived %v with %d runs, expected one run", mm.name, err, ran)
+		}
+	}
+
+	//Without a unit of work it runs right away
+	ran := false
+	transaction.AfterCommit(context.Background(), func(ctx context.Context) error {
+		ran = true
+		return nil
+	})
+	if !ran {
+		t.Errorf("expected the hook to run without a transaction")
+	}
+}
@@ -13,6 +13,7 @@ type BasicUser struct {
 	Token_         string `json:"token";sql:"-"`
 	activated_     bool
 	passwordlogin_ bool
+	version_       int
 }
 
 /**
@@ -55,6 +56,13 @@ func (basic *BasicUser) PasswordLogin() bool {
 	return basic.passwordlogin_
 }
 
+func (basic *BasicUser) Version() int {
+	return basic.version_
+}
+func (basic *BasicUser) SetVersion(version int) {
+	basic.version_ = version
+}
+
 /**
 Provide code to copy the user into this user
 */
@@ -68,5 +76,6 @@ func (basic *BasicUser) CopyFrom(from User) {
 	basic.Token_ = from.Token()
 	basic.activated_ = from.Activated()
 	basic.passwordlogin_ = from.PasswordLogin()
+	basic.version_ = from.Version()
 
 }
@@ -0,0 +1,29 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package users
+
+import (
+	"net/http"
+
+	"github.com/reaction-eng/restlib/apierror"
+)
+
+/**
+Define the errors returned by the user helpers, repos and handlers
+*/
+var (
+	ErrEmailNotFound              = apierror.New(http.StatusNotFound, "login_email_not_found")
+	ErrUserIdNotFound             = apierror.New(http.StatusNotFound, "login_user_id_not_found")
+	ErrMissingEmail               = apierror.New(http.StatusUnprocessableEntity, "validate_missing_email")
+	ErrEmailInUse                 = apierror.New(http.StatusConflict, "validate_email_in_use")
+	ErrUpdateForbidden            = apierror.New(http.StatusForbidden, "update_forbidden")
+	ErrVersionConflict            = apierror.New(http.StatusPreconditionFailed, "user_version_conflict")
+	ErrPasswordLoginForbidden     = apierror.New(http.StatusForbidden, "user_password_login_forbidden")
+	ErrNotActivated               = apierror.New(http.StatusForbidden, "user_not_activated")
+	ErrInvalidPassword            = apierror.New(http.StatusUnauthorized, "login_invalid_password")
+	ErrPasswordChangeMissingEmail = apierror.New(http.StatusUnprocessableEntity, "password_change_missing_email")
+	ErrActivationMissingEmail     = apierror.New(http.StatusUnprocessableEntity, "activation_token_missing_email")
+	ErrInvalidOAuthToken          = apierror.New(http.StatusUnauthorized, "invalid_token") //The code the oauth logins have always returned
+	ErrInvalidOAuthEmail          = apierror.New(http.StatusUnprocessableEntity, "invalid_email")
+)
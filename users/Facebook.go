@@ -4,12 +4,14 @@
 package users
 
 import (
+	"github.com/reaction-eng/restlib/apierror"
 	"github.com/reaction-eng/restlib/configuration"
 	"github.com/reaction-eng/restlib/routing"
 	"github.com/reaction-eng/restlib/utils"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"net/url"
 )
@@ -71,7 +73,10 @@ type FacebookHandler struct {
  */
 func NewFacebookHandler(helper *Helper, configFiles ...string) *FacebookHandler {
 	//Create a new config
-	config, _ := configuration.NewConfiguration(configFiles...)
+	config, err := configuration.NewConfiguration(configFiles...)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	//Create a new
 	facebook := &FacebookHandler{
@@ -95,6 +100,10 @@ func (fbHandler *FacebookHandler) GetRoutes() []routing.Route {
 			Pattern:     "/users/login/facebook",
 			HandlerFunc: fbHandler.handleUserLoginFacebook,
 			Public:      true,
+			Description: "Login with a facebook access token.  The user is created if needed.",
+			Tags:        []string{"users"},
+			Request:     FacebookLoginToken{},
+			Response:    fbHandler.helper.NewEmptyUser(),
 		},
 	}
 
@@ -195,7 +204,7 @@ func (fbHandler *FacebookHandler) handleUserLoginFacebook(w http.ResponseWriter,
 	//decode the request body into struct and failed if any error occur
 	err := json.NewDecoder(r.Body).Decode(&cred)
 	if err != nil {
-		utils.ReturnJsonError(w, http.StatusUnprocessableEntity, err)
+		utils.ReturnError(w, apierror.ErrMalformedRequest.Wrap(err))
 		return
 
 	}
@@ -204,13 +213,13 @@ func (fbHandler *FacebookHandler) handleUserLoginFacebook(w http.ResponseWriter,
 	email, err := fbHandler.tokenToEmail(cred)
 
 	if err != nil {
-		utils.ReturnJsonError(w, http.StatusUnprocessableEntity, err)
+		utils.ReturnError(w, ErrInvalidOAuthToken.Wrap(err))
 		return
 
 	}
 
 	//Now get the user by email
-	user, err := fbHandler.helper.GetUserByEmail(email)
+	user, err := fbHandler.helper.GetUserByEmailContext(r.Context(), email)
 
 	//See if it a new error
 	if err != nil && user == nil {
@@ -221,27 +230,29 @@ func (fbHandler *FacebookHandler) handleUserLoginFacebook(w http.ResponseWriter,
 		newUser.SetPassword("") //This is a blank password that prevents being able to login
 
 		//Now store it
-		user, err = fbHandler.helper.AddUser(newUser)
+		user, err = fbHandler.helper.AddUserContext(r.Context(), newUser)
 
 		//Make sure it created an id
 		if err != nil {
-			utils.ReturnJsonError(w, http.StatusForbidden, err)
+			utils.ReturnError(w, err)
+			return
 		}
 
 		//Now activate user
-		fbHandler.helper.ActivateUser(user)
+		fbHandler.helper.ActivateUserContext(r.Context(), user)
 
 		//Now get the user again
 		//Now get the user by email
-		user, err = fbHandler.helper.GetUserByEmail(email)
+		user, err = fbHandler.helper.GetUserByEmailContext(r.Context(), email)
 
 		if err != nil {
-			utils.ReturnJsonError(w, http.StatusForbidden, err)
+			utils.ReturnError(w, err)
+			return
 		}
 
 	} else if err != nil {
 		//There prob is not a user to return
-		utils.ReturnJsonError(w, http.StatusForbidden, err)
+		utils.ReturnError(w, err)
 		return
 	}
 
@@ -250,9 +261,10 @@ func (fbHandler *FacebookHandler) handleUserLoginFacebook(w http.ResponseWriter,
 
 	//Check to see if the user was created
 	if err == nil {
-		utils.ReturnJson(w, http.StatusCreated, user)
+		fbHandler.helper.setSessionCookie(w, user)
+		utils.ReturnJson(w, http.StatusOK, user)
 	} else {
-		utils.ReturnJsonError(w, http.StatusForbidden, err)
+		utils.ReturnError(w, err)
 	}
 
 }
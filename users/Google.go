@@ -4,13 +4,14 @@
 package users
 
 import (
+	"github.com/reaction-eng/restlib/apierror"
 	"github.com/reaction-eng/restlib/configuration"
 	"github.com/reaction-eng/restlib/routing"
 	"github.com/reaction-eng/restlib/utils"
 	"context"
 	"encoding/json"
-	"errors"
 	"golang.org/x/oauth2/google"
+	"log"
 	"net/http"
 
 	"golang.org/x/oauth2"
@@ -41,7 +42,10 @@ type GoogleHandler struct {
  */
 func NewGoogleHandler(helper *Helper, configFiles ...string) *GoogleHandler {
 	//Create a new config
-	config, _ := configuration.NewConfiguration(configFiles...)
+	config, err := configuration.NewConfiguration(configFiles...)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	//Create a new
 	google := &GoogleHandler{
@@ -69,6 +73,10 @@ func (gHandler *GoogleHandler) GetRoutes() []routing.Route {
 			Pattern:     "/users/login/google",
 			HandlerFunc: gHandler.handleUserLoginGoogle,
 			Public:      true,
+			Description: "Login with a google oauth2 token.  The user is created if needed.",
+			Tags:        []string{"users"},
+			Request:     oauth2.Token{},
+			Response:    gHandler.helper.NewEmptyUser(),
 		},
 	}
 
@@ -87,13 +95,13 @@ func (gHandler *GoogleHandler) handleUserLoginGoogle(w http.ResponseWriter, r *h
 	//decode the request body into struct and failed if any error occur
 	err := json.NewDecoder(r.Body).Decode(&tok)
 	if err != nil {
-		utils.ReturnJsonError(w, http.StatusUnprocessableEntity, err)
+		utils.ReturnError(w, apierror.ErrMalformedRequest.Wrap(err))
 		return
 
 	}
 	//Make sure it is valid
 	if !tok.Valid() {
-		utils.ReturnJsonError(w, http.StatusUnprocessableEntity, errors.New("invalid_token"))
+		utils.ReturnError(w, ErrInvalidOAuthToken)
 		return
 
 	}
@@ -103,7 +111,7 @@ func (gHandler *GoogleHandler) handleUserLoginGoogle(w http.ResponseWriter, r *h
 	client := oauth2.NewClient(ctx, gHandler.oAuthConfig.TokenSource(ctx, tok))
 	svc, err := goauth2.New(client)
 	if err != nil {
-		utils.ReturnJsonError(w, http.StatusUnprocessableEntity, err)
+		utils.ReturnError(w, err)
 		return
 
 	}
@@ -111,18 +119,18 @@ func (gHandler *GoogleHandler) handleUserLoginGoogle(w http.ResponseWriter, r *h
 	//And get the user info
 	userInfo, err := svc.Userinfo.Get().Do()
 	if err != nil {
-		utils.ReturnJsonError(w, http.StatusUnprocessableEntity, err)
+		utils.ReturnError(w, ErrInvalidOAuthToken.Wrap(err))
 		return
 	}
 
 	//Make sure there is an email
 	if len(userInfo.Email) == 0 {
-		utils.ReturnJsonError(w, http.StatusUnprocessableEntity, errors.New("invalid_email"))
+		utils.ReturnError(w, ErrInvalidOAuthEmail)
 		return
 	}
 
 	//Now get the user by email
-	user, err := gHandler.helper.GetUserByEmail(userInfo.Email)
+	user, err := gHandler.helper.GetUserByEmailContext(r.Context(), userInfo.Email)
 
 	//See if it a new error
 	if err != nil && user == nil {
@@ -133,27 +141,29 @@ func (gHandler *GoogleHandler) handleUserLoginGoogle(w http.ResponseWriter, r *h
 		newUser.SetPassword("") //This is a blank password that prevents being able to login
 
 		//Now store it
-		user, err = gHandler.helper.AddUser(newUser)
+		user, err = gHandler.helper.AddUserContext(r.Context(), newUser)
 
 		//Make sure it created an id
 		if err != nil {
-			utils.ReturnJsonError(w, http.StatusForbidden, err)
+			utils.ReturnError(w, err)
+			return
 		}
 
 		//Now activate user
-		gHandler.helper.ActivateUser(user)
+		gHandler.helper.ActivateUserContext(r.Context(), user)
 
 		//Now get the user again
 		//Now get the user by email
-		user, err = gHandler.helper.GetUserByEmail(user.Email())
+		user, err = gHandler.helper.GetUserByEmailContext(r.Context(), user.Email())
 
 		if err != nil {
-			utils.ReturnJsonError(w, http.StatusForbidden, err)
+			utils.ReturnError(w, err)
+			return
 		}
 
 	} else if err != nil {
 		//There prob is not a user to return
-		utils.ReturnJsonError(w, http.StatusForbidden, err)
+		utils.ReturnError(w, err)
 		return
 	}
 
@@ -162,9 +172,10 @@ func (gHandler *GoogleHandler) handleUserLoginGoogle(w http.ResponseWriter, r *h
 
 	//Check to see if the user was created
 	if err == nil {
-		utils.ReturnJson(w, http.StatusCreated, user)
+		gHandler.helper.setSessionCookie(w, user)
+		utils.ReturnJson(w, http.StatusOK, user)
 	} else {
-		utils.ReturnJsonError(w, http.StatusForbidden, err)
+		utils.ReturnError(w, err)
 	}
 
 }
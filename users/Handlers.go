@@ -4,10 +4,11 @@
 package users
 
 import (
-	"encoding/json"
 	"net/http"
 	"strings"
 
+	"github.com/reaction-eng/restlib/apierror"
+	"github.com/reaction-eng/restlib/auth"
 	"github.com/reaction-eng/restlib/routing"
 	"github.com/reaction-eng/restlib/utils"
 )
@@ -50,39 +51,50 @@ func (handler *Handler) GetRoutes() []routing.Route {
 
 		routes = append(routes,
 			routing.Route{ //Now for the user info
-				Name:        "UserCreate",
-				Method:      "POST",
-				Pattern:     "/users/new",
-				HandlerFunc: handler.handleUserCreate,
-				Public:      true,
+				Name:           "UserCreate",
+				Method:         "POST",
+				Pattern:        "/users/new",
+				HandlerFunc:    handler.handleUserCreate,
+				Public:         true,
+				Description:    "Create a new user.  An activation token is emailed to the user.",
+				Tags:           []string{"users"},
+				Request:        newUserStruct{},
+				Response:       utils.JsonStatus{},
+				ResponseStatus: http.StatusCreated,
 			},
 			routing.Route{ //Allow the user to turn on their account
-				Name:        "User Activate",
-				Method:      "POST",
-				Pattern:     "/users/activate",
-				HandlerFunc: handler.handleUserActivationPut,
-				Public:      true,
+				Name:           "User Activate",
+				Method:         "POST",
+				Pattern:        "/users/activate",
+				HandlerFunc:    handler.handleUserActivationPut,
+				Public:         true,
+				Description:    "Activate the user using the emailed activation token.",
+				Tags:           []string{"users"},
+				Request:        activationPutStruct{},
+				Response:       utils.JsonStatus{},
+				ResponseStatus: http.StatusAccepted,
 			},
-			routing.Route{ //Allow the user to turn on their account
-				Name:        "User Activate",
+			routing.Route{ //Allow the user to ask for a new activation token
+				Name:        "User Activation Request",
 				Method:      "GET",
 				Pattern:     "/users/activate",
 				HandlerFunc: handler.handleUserActivationGet,
 				Public:      true,
-			},
-			routing.Route{ //Allow the user to turn on their account
-				Name:        "Get User Activation Token",
-				Method:      "GET",
-				Pattern:     "/users/activate",
-				HandlerFunc: handler.handleUserActivationPut,
-				Public:      true,
+				Description: "Request a new activation token be emailed to the user given by the email query parameter.",
+				Tags:        []string{"users"},
+				Response:    utils.JsonStatus{},
 			},
 			routing.Route{ //Allow for the user to get an update of them selves
-				Name:        "PasswordChange",
-				Method:      "POST",
-				Pattern:     "/users/password/change",
-				HandlerFunc: handler.handlePasswordUpdate,
-				Public:      false,
+				Name:           "PasswordChange",
+				Method:         "POST",
+				Pattern:        "/users/password/change",
+				HandlerFunc:    handler.handlePasswordUpdate,
+				Public:         false,
+				Description:    "Change the password of the logged in user.",
+				Tags:           []string{"users"},
+				Request:        updatePasswordChangeStruct{},
+				Response:       utils.JsonStatus{},
+				ResponseStatus: http.StatusAccepted,
 			},
 			routing.Route{ //Allow for the user to ask for a password change
 				Name:        "PasswordResetGet",
@@ -90,13 +102,21 @@ func (handler *Handler) GetRoutes() []routing.Route {
 				Pattern:     "/users/password/reset",
 				HandlerFunc: handler.handlePasswordResetGet,
 				Public:      true,
+				Description: "Request a password reset token be emailed to the user given by the email query parameter.",
+				Tags:        []string{"users"},
+				Response:    utils.JsonStatus{},
 			},
 			routing.Route{ //Allow the user to set their password
-				Name:        "PasswordResetPost",
-				Method:      "POST",
-				Pattern:     "/users/password/reset",
-				HandlerFunc: handler.handlePasswordResetPut,
-				Public:      true,
+				Name:           "PasswordResetPost",
+				Method:         "POST",
+				Pattern:        "/users/password/reset",
+				HandlerFunc:    handler.handlePasswordResetPut,
+				Public:         true,
+				Description:    "Set a new password using the emailed reset token.",
+				Tags:           []string{"users"},
+				Request:        resetPutStruct{},
+				Response:       utils.JsonStatus{},
+				ResponseStatus: http.StatusAccepted,
 			},
 		)
 
@@ -110,6 +130,20 @@ func (handler *Handler) GetRoutes() []routing.Route {
 			Pattern:     "/users/login",
 			HandlerFunc: handler.handleUserLogin,
 			Public:      true,
+			Description: "Login with an email and password.  The returned user includes the token.",
+			Tags:        []string{"users"},
+			Request:     loginUserStruct{},
+			Response:    handler.userHelper.NewEmptyUser(),
+		},
+		routing.Route{ //Allow for the user to clear the session cookie
+			Name:        "UserLogout",
+			Method:      "POST",
+			Pattern:     "/users/logout",
+			HandlerFunc: handler.handleUserLogout,
+			Public:      true,
+			Description: "Clear the session cookie.  Bearer tokens are not affected.",
+			Tags:        []string{"users"},
+			Response:    utils.JsonStatus{},
 		},
 		routing.Route{ //Allow for the user to login
 			Name:        "User Api Documentation",
@@ -117,13 +151,20 @@ func (handler *Handler) GetRoutes() []routing.Route {
 			Pattern:     "/api/users",
 			HandlerFunc: handler.handleUserDocumentation,
 			Public:      true,
+			Description: "Html documentation for the user api.",
+			Tags:        []string{"documentation"},
 		},
 		routing.Route{ //Allow for the user to update them selves
-			Name:        "UserUpdate",
-			Method:      "PUT",
-			Pattern:     "/users/",
-			HandlerFunc: handler.handleUserUpdate,
-			Public:      false,
+			Name:           "UserUpdate",
+			Method:         "PUT",
+			Pattern:        "/users/",
+			HandlerFunc:    handler.handleUserUpdate,
+			Public:         false,
+			Description:    "Update the logged in user.  Send the ETag from the get as If-Match to only save over that version.",
+			Tags:           []string{"users"},
+			Request:        handler.userHelper.NewEmptyUser(),
+			Response:       handler.userHelper.NewEmptyUser(),
+			ResponseStatus: http.StatusAccepted,
 		},
 		routing.Route{ //Allow for the user to get an update of them selves
 			Name:        "UserGet",
@@ -131,6 +172,9 @@ func (handler *Handler) GetRoutes() []routing.Route {
 			Pattern:     "/users/",
 			HandlerFunc: handler.handleUserGet,
 			Public:      false,
+			Description: "Get the logged in user.",
+			Tags:        []string{"users"},
+			Response:    handler.userHelper.NewEmptyUser(),
 		},
 	)
 
@@ -138,6 +182,39 @@ func (handler *Handler) GetRoutes() []routing.Route {
 
 }
 
+/**
+Define a struct for creating a new user
+*/
+type newUserStruct struct {
+	Email    string `json:"email" validate:"required,email,max=254"`
+	Password string `json:"password" validate:"required,max=1024"`
+}
+
+/**
+Define a struct for logging in
+*/
+type loginUserStruct struct {
+	Email    string `json:"email" validate:"required,max=254"`
+	Password string `json:"password" validate:"required,max=1024"`
+}
+
+/**
+Define a struct to get the email and token out of the reset request
+*/
+type resetPutStruct struct {
+	Email      string `json:"email" validate:"required,email"`
+	ResetToken string `json:"reset_token" validate:"required"`
+	Password   string `json:"password" validate:"required,max=1024"`
+}
+
+/**
+Define a struct to get the email and token out of the activation request
+*/
+type activationPutStruct struct {
+	Email    string `json:"email" validate:"required,email"`
+	ActToken string `json:"activation_token" validate:"required"`
+}
+
 /**
 Function used to create new user
 */
@@ -146,23 +223,14 @@ func (handler *Handler) handleUserCreate(w http.ResponseWriter, r *http.Request)
 	//Create an empty new user
 	newUser := handler.userHelper.NewEmptyUser()
 
-	/**
-	Define a struct for just updating password
-	*/
-	type newUserStruct struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
-	}
-
 	//Create the new user
 	newUserInfo := &newUserStruct{}
 
 	//decode the request body into struct and failed if any error occur
-	err := json.NewDecoder(r.Body).Decode(newUserInfo)
+	err := utils.DecodeAndValidate(r, newUserInfo)
 	if err != nil {
-		utils.ReturnJsonStatus(w, http.StatusUnprocessableEntity, false, err.Error())
+		utils.ReturnError(w, err)
 		return
-
 	}
 
 	//Copy over the new user data
@@ -170,10 +238,10 @@ func (handler *Handler) handleUserCreate(w http.ResponseWriter, r *http.Request)
 	newUser.SetPassword(newUserInfo.Password)
 
 	//Now create the new suer
-	err = handler.userHelper.createUser(newUser)
+	err = handler.userHelper.createUser(r.Context(), newUser)
 
 	if err != nil {
-		utils.ReturnJsonStatus(w, http.StatusUnprocessableEntity, false, err.Error())
+		utils.ReturnError(w, err)
 		return
 	}
 
@@ -181,7 +249,7 @@ func (handler *Handler) handleUserCreate(w http.ResponseWriter, r *http.Request)
 	if err == nil {
 		utils.ReturnJsonStatus(w, http.StatusCreated, true, "create_user_added")
 	} else {
-		utils.ReturnJsonStatus(w, http.StatusUnprocessableEntity, false, err.Error())
+		utils.ReturnError(w, err)
 	}
 
 }
@@ -191,31 +259,22 @@ Function used to create new user
 */
 func (handler *Handler) handleUserLogin(w http.ResponseWriter, r *http.Request) {
 
-	/**
-	Define a struct for just updating password
-	*/
-	type loginUserStruct struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
-	}
-
 	userCred := &loginUserStruct{}
 
 	//decode the request body into struct and failed if any error occur
-	err := json.NewDecoder(r.Body).Decode(userCred)
+	err := utils.DecodeAndValidate(r, userCred)
 	if err != nil {
-		utils.ReturnJsonError(w, http.StatusUnprocessableEntity, err)
+		utils.ReturnError(w, err)
 		return
-
 	}
 
 	//Now look up the user
-	user, err := handler.userHelper.GetUserByEmail(strings.TrimSpace(strings.ToLower(userCred.Email)))
+	user, err := handler.userHelper.GetUserByEmailContext(r.Context(), strings.TrimSpace(strings.ToLower(userCred.Email)))
 
 	//check for an error
 	if err != nil {
 		//There prob is not a user to return
-		utils.ReturnJsonError(w, http.StatusForbidden, err)
+		utils.ReturnError(w, err)
 		return
 	}
 
@@ -225,52 +284,72 @@ func (handler *Handler) handleUserLogin(w http.ResponseWriter, r *http.Request)
 	//If there is an error, don't login
 	if err != nil {
 		//There prob is not a user to return
-		utils.ReturnJsonError(w, http.StatusForbidden, err)
+		utils.ReturnError(w, err)
 		return
 	}
 
 	//Check to see if the user was created
 	if err == nil {
-		utils.ReturnJson(w, http.StatusCreated, user)
+		handler.userHelper.setSessionCookie(w, user)
+		utils.ReturnJson(w, http.StatusOK, user)
 	} else {
-		utils.ReturnJsonError(w, http.StatusForbidden, err)
+		utils.ReturnError(w, err)
 	}
 
 }
 
+/**
+Clear the session cookie
+*/
+func (handler *Handler) handleUserLogout(w http.ResponseWriter, r *http.Request) {
+	handler.userHelper.clearSessionCookie(w)
+	utils.ReturnJsonStatus(w, http.StatusOK, true, "logout_success")
+}
+
 /**
 Updates the password for this user
 */
 func (handler *Handler) handleUserUpdate(w http.ResponseWriter, r *http.Request) {
 
 	//We have gone through the auth, so we should know the id of the logged in user
-	loggedInUser := r.Context().Value("user").(int) //Grab the id of the user that send the request
+	loggedInUser, ok := auth.UserId(r.Context()) //Grab the id of the user that send the request
+	if !ok {
+		utils.ReturnError(w, apierror.ErrUnauthorized)
+		return
+	}
 
 	//Now load the current user from the repo
-	user, err := handler.userHelper.GetUser(loggedInUser)
+	user, err := handler.userHelper.GetUserContext(r.Context(), loggedInUser)
 
 	//Check for an error
 	if err != nil {
-		utils.ReturnJsonError(w, http.StatusUnprocessableEntity, err)
+		utils.ReturnError(w, err)
+		return
+	}
+
+	//Make sure the client has the current version
+	if !utils.IfMatch(r, user.Version()) {
+		utils.ReturnError(w, apierror.ErrPrecondition)
 		return
 	}
 
 	//decode the request body into struct with all of the info specified and failed if any error occur
-	err = json.NewDecoder(r.Body).Decode(user)
+	err = utils.DecodeAndValidate(r, user)
 	if err != nil {
-		utils.ReturnJsonError(w, http.StatusUnprocessableEntity, err)
+		utils.ReturnError(w, err)
 		return
 
 	}
 
 	//Now update the user
-	user, err = handler.userHelper.updateUser(loggedInUser, user)
+	user, err = handler.userHelper.updateUser(r.Context(), loggedInUser, user)
 
 	//Check to see if the user was created
 	if err == nil {
+		utils.SetETag(w, user.Version())
 		utils.ReturnJson(w, http.StatusAccepted, user)
 	} else {
-		utils.ReturnJsonError(w, http.StatusForbidden, err)
+		utils.ReturnError(w, err)
 	}
 
 }
@@ -281,20 +360,23 @@ Get the current up to date user
 func (handler *Handler) handleUserGet(w http.ResponseWriter, r *http.Request) {
 
 	//We have gone through the auth, so we should know the id of the logged in user
-	loggedInUser := r.Context().Value("user").(int) //Grab the id of the user that send the request
+	loggedInUser, ok := auth.UserId(r.Context()) //Grab the id of the user that send the request
+	if !ok {
+		utils.ReturnError(w, apierror.ErrUnauthorized)
+		return
+	}
 
 	//Get the user
-	user, err := handler.userHelper.GetUser(loggedInUser)
-
-	//Make sure we null the password
-	//Blank out the password before returning
-	user.SetPassword("")
+	user, err := handler.userHelper.GetUserContext(r.Context(), loggedInUser)
 
 	//Check to see if the user was created
 	if err == nil {
+		//Blank out the password before returning
+		user.SetPassword("")
+		utils.SetETag(w, user.Version())
 		utils.ReturnJson(w, http.StatusOK, user)
 	} else {
-		utils.ReturnJsonStatus(w, http.StatusUnsupportedMediaType, false, err.Error())
+		utils.ReturnError(w, err)
 	}
 
 }
@@ -305,27 +387,30 @@ Updates the password for this user
 func (handler *Handler) handlePasswordUpdate(w http.ResponseWriter, r *http.Request) {
 
 	//We have gone through the auth, so we should know the id of the logged in user
-	loggedInUser := r.Context().Value("user").(int) //Grab the id of the user that send the request
+	loggedInUser, ok := auth.UserId(r.Context()) //Grab the id of the user that send the request
+	if !ok {
+		utils.ReturnError(w, apierror.ErrUnauthorized)
+		return
+	}
 
 	//Create a new password change object
 	info := updatePasswordChangeStruct{}
 
 	//Now get the json info
-	err := json.NewDecoder(r.Body).Decode(&info)
+	err := utils.DecodeAndValidate(r, &info)
 	if err != nil {
-		utils.ReturnJsonError(w, http.StatusUnprocessableEntity, err)
+		utils.ReturnError(w, err)
 		return
-
 	}
 
 	//Now update the password
-	err = handler.userHelper.passwordChange(loggedInUser, info)
+	err = handler.userHelper.passwordChange(r.Context(), loggedInUser, info)
 
 	//Check to see if the user was created
 	if err == nil {
 		utils.ReturnJsonStatus(w, http.StatusAccepted, true, "password_change_success")
 	} else {
-		utils.ReturnJsonError(w, http.StatusForbidden, err)
+		utils.ReturnError(w, err)
 	}
 
 }
@@ -340,14 +425,15 @@ func (handler *Handler) handlePasswordResetGet(w http.ResponseWriter, r *http.Re
 
 	//Only take the first one
 	if !ok || len(keys[0]) < 1 {
-		utils.ReturnJsonStatus(w, http.StatusUnprocessableEntity, false, "password_change_missing_email")
+		utils.ReturnError(w, ErrPasswordChangeMissingEmail)
+		return
 	}
 
 	//Get the email
 	email := keys[0]
 
 	//Look up the user
-	user, err := handler.userHelper.GetUserByEmail(email)
+	user, err := handler.userHelper.GetUserByEmailContext(r.Context(), email)
 
 	//If there is an error just return, we don't want people to know if there was an email here
 	if err != nil {
@@ -356,11 +442,11 @@ func (handler *Handler) handlePasswordResetGet(w http.ResponseWriter, r *http.Re
 	}
 
 	//Now issue a request
-	err = handler.userHelper.IssueResetRequest(handler.userHelper.passwordHelper.TokenGenerator(), user.Id(), user.Email())
+	err = handler.userHelper.IssueResetRequestContext(r.Context(), handler.userHelper.passwordHelper.TokenGenerator(), user.Id(), user.Email())
 
 	//There was a real error return
 	if err != nil {
-		utils.ReturnJsonError(w, http.StatusNotFound, err)
+		utils.ReturnError(w, err)
 		return
 	}
 
@@ -374,57 +460,24 @@ Function to request a password change
 */
 func (handler *Handler) handlePasswordResetPut(w http.ResponseWriter, r *http.Request) {
 
-	//Define a local struct to get the email out of the request
-	type ResetGet struct {
-		Email      string `json:"email"`
-		ResetToken string `json:"reset_token"`
-		Password   string `json:"password"`
-	}
-
 	//Create a new password change object
-	info := ResetGet{}
+	info := resetPutStruct{}
 
 	//Now get the json info
-	err := json.NewDecoder(r.Body).Decode(&info)
+	err := utils.DecodeAndValidate(r, &info)
 	if err != nil {
-		utils.ReturnJsonError(w, http.StatusUnprocessableEntity, err)
-		return
-
-	}
-
-	//Lookup the user id
-	user, err := handler.userHelper.GetUserByEmail(info.Email)
-
-	//Return the error
-	if err != nil {
-		utils.ReturnJsonStatus(w, http.StatusForbidden, false, "password_change_forbidden")
+		utils.ReturnError(w, err)
 		return
 	}
 
-	//Try to use the token
-	requestId, err := handler.userHelper.CheckForResetToken(user.Id(), info.ResetToken)
-
-	//Return the error
-	if err != nil {
-		utils.ReturnJsonStatus(w, http.StatusForbidden, false, "password_change_forbidden")
-		return
-	}
-
-	//Now update the password
-	err = handler.userHelper.passwordChangeForced(user.Id(), user.Email(), info.Password)
-	//Return the error
-	if err != nil {
-		utils.ReturnJsonError(w, http.StatusForbidden, err)
-		return
-	}
-	//Mark the request as used
-	err = handler.userHelper.UseToken(requestId)
+	//Use the token and change the password
+	err = handler.userHelper.resetPassword(r.Context(), info)
 
 	//Check to see if the user was created
 	if err == nil {
 		utils.ReturnJsonStatus(w, http.StatusAccepted, true, "password_change_success")
 	} else {
-		utils.ReturnJsonError(w, http.StatusForbidden, err)
+		utils.ReturnError(w, err)
 	}
 }
 
@@ -433,56 +486,24 @@ Function to request a password change
 */
 func (handler *Handler) handleUserActivationPut(w http.ResponseWriter, r *http.Request) {
 
-	//Define a local struct to get the email out of the request
-	type ActivationGet struct {
-		Email    string `json:"email"`
-		ActToken string `json:"activation_token"`
-	}
-
 	//Create a new password change object
-	info := ActivationGet{}
+	info := activationPutStruct{}
 
 	//Now get the json info
-	err := json.NewDecoder(r.Body).Decode(&info)
-	if err != nil {
-		utils.ReturnJsonError(w, http.StatusUnprocessableEntity, err)
-		return
-
-	}
-
-	//Lookup the user id
-	user, err := handler.userHelper.GetUserByEmail(info.Email)
-
-	//Return the error
+	err := utils.DecodeAndValidate(r, &info)
 	if err != nil {
-		utils.ReturnJsonStatus(w, http.StatusForbidden, false, "activation_forbidden")
+		utils.ReturnError(w, err)
 		return
 	}
 
-	//Try to use the token
-	requestId, err := handler.userHelper.CheckForActivationToken(user.Id(), info.ActToken)
-
-	//Return the error
-	if err != nil {
-		utils.ReturnJsonStatus(w, http.StatusForbidden, false, "activation_forbidden")
-		return
-	}
-	//Now activate the user
-	err = handler.userHelper.ActivateUser(user)
-
-	//Return the error
-	if err != nil {
-		utils.ReturnJsonError(w, http.StatusForbidden, err)
-		return
-	}
-	//Mark the request as used
-	err = handler.userHelper.UseToken(requestId)
+	//Use the token and activate the user
+	err = handler.userHelper.activateUser(r.Context(), info)
 
 	//Check to see if the user was created
 	if err == nil {
 		utils.ReturnJsonStatus(w, http.StatusAccepted, true, "user_activated")
 	} else {
-		utils.ReturnJsonError(w, http.StatusForbidden, err)
+		utils.ReturnError(w, err)
 	}
 }
 
@@ -496,14 +517,15 @@ func (handler *Handler) handleUserActivationGet(w http.ResponseWriter, r *http.R
 
 	//Only take the first one
 	if !ok || len(keys[0]) < 1 {
-		utils.ReturnJsonStatus(w, http.StatusUnprocessableEntity, false, "activation_token_missing_email")
+		utils.ReturnError(w, ErrActivationMissingEmail)
+		return
 	}
 
 	//Get the email
 	email := keys[0]
 
 	//Look up the user
-	user, err := handler.userHelper.GetUserByEmail(email)
+	user, err := handler.userHelper.GetUserByEmailContext(r.Context(), email)
 
 	//If there is an error just return, we don't want people to know if there was an email here
 	if err != nil {
@@ -515,13 +537,14 @@ func (handler *Handler) handleUserActivationGet(w http.ResponseWriter, r *http.R
 	//If the user is not already active
 	if user.Activated() {
 		utils.ReturnJsonStatus(w, http.StatusOK, true, "activation_token_request_received")
+		return
 	}
 	//Else issue the request
-	err = handler.userHelper.IssueActivationRequest(handler.userHelper.passwordHelper.TokenGenerator(), user.Id(), user.Email())
+	err = handler.userHelper.IssueActivationRequestContext(r.Context(), handler.userHelper.passwordHelper.TokenGenerator(), user.Id(), user.Email())
 
 	//There was a real error return
 	if err != nil {
-		utils.ReturnJsonError(w, http.StatusNotFound, err)
+		utils.ReturnError(w, err)
 		return
 	}
 
@@ -4,11 +4,16 @@
 package users
 
 import (
-	"github.com/reaction-eng/restlib/passwords"
+	"context"
 	"errors"
+	"github.com/reaction-eng/restlib/passwords"
+	"github.com/reaction-eng/restlib/transaction"
 	"strings"
 )
 
+//How many more times a password change is tried when someone else saved the user first
+const passwordChangeRetries = 3
+
 type Helper struct {
 
 	//Hold the user repo
@@ -19,57 +24,96 @@ type Helper struct {
 
 	//And store a password helper
 	passwordHelper passwords.Helper
+
+	//Optional cookie used to store the token for browser sessions
+	sessionCookie *SessionCookie
+
+	//Used to keep each multi step flow all or nothing
+	transactions transaction.Manager
+
+	//Called in the same transaction as each new user
+	userCreatedHooks []UserCreatedHook
 }
 
+/**
+Called for each new user before it is committed, i.e. to set the default roles.  Returning an error rolls back the
+new user.
+*/
+type UserCreatedHook func(ctx context.Context, user User) error
+
 func NewUserHelper(usersRepo Repo, passRepo passwords.ResetRepo, passwordHelper passwords.Helper) *Helper {
 
 	return &Helper{
 		Repo:           usersRepo,
 		ResetRepo:      passRepo,
 		passwordHelper: passwordHelper,
+		transactions:   transaction.NewNoopManager(),
 	}
 
 }
 
+/**
+Run each multi step flow, i.e. creating a user, in a single transaction.  Use a transaction.SqlManager on the same
+database as the sql repos.
+*/
+func (helper *Helper) UseTransactions(manager transaction.Manager) {
+	helper.transactions = manager
+}
+
+/**
+Call the hook for each new user in the same transaction, i.e.
+	helper.OnUserCreated(func(ctx context.Context, user users.User) error {
+		return roleRepo.SetRolesByNameContext(ctx, user, []string{"user"})
+	})
+*/
+func (helper *Helper) OnUserCreated(hook UserCreatedHook) {
+	helper.userCreatedHooks = append(helper.userCreatedHooks, hook)
+}
+
 /**
 Static method to create a new user
 */
-func (helper *Helper) createUser(user User) error {
+func (helper *Helper) createUser(ctx context.Context, user User) error {
 
 	//Make sure the info being passed in is valid
-	if ok, err := helper.validateUser(user); !ok {
+	if ok, err := helper.validateUser(ctx, user); !ok {
 		return err
 	}
 
 	//Now hash the password
 	user.SetPassword(helper.passwordHelper.HashPassword(user.Password()))
 
-	//Now store it
-	newUser, err := helper.AddUser(user)
+	//Add the user, the hooks and the request together so there is never a user that can't be activated.  The
+	//activation email is only sent once it all commits
+	return helper.transactions.Atomic(ctx, func(ctx context.Context) error {
+		//Now store it
+		newUser, err := helper.AddUserContext(ctx, user)
 
-	//Make sure it created an id
-	if err != nil {
-		return err
-	}
+		//Make sure it created an id
+		if err != nil {
+			return err
+		}
 
-	//Else issue the request
-	err = helper.IssueActivationRequest(helper.passwordHelper.TokenGenerator(), newUser.Id(), newUser.Email())
+		//Let everyone else add to the user
+		for _, hook := range helper.userCreatedHooks {
+			if err := hook(ctx, newUser); err != nil {
+				return err
+			}
+		}
 
-	if err != nil {
-		return err
-	}
-
-	return nil
+		//Else issue the request
+		return helper.IssueActivationRequestContext(ctx, helper.passwordHelper.TokenGenerator(), newUser.Id(), newUser.Email())
+	})
 
 }
 
 /**
 Validate incoming user details to make sure it has an email address and stuff
 */
-func (helper *Helper) validateUser(user User) (bool, error) {
+func (helper *Helper) validateUser(ctx context.Context, user User) (bool, error) {
 
 	if !strings.Contains(user.Email(), "@") {
-		return false, errors.New("validate_missing_email")
+		return false, ErrMissingEmail
 	}
 
 	//Check the password
@@ -81,11 +125,11 @@ func (helper *Helper) validateUser(user User) (bool, error) {
 	}
 
 	//Now look up a possible user
-	user, err = helper.GetUserByEmail(user.Email())
+	user, err = helper.GetUserByEmailContext(ctx, user.Email())
 
 	//If the user already exists
 	if err == nil || user != nil {
-		return false, errors.New("validate_email_in_use")
+		return false, ErrEmailInUse
 	}
 
 	//All is good
@@ -95,10 +139,10 @@ func (helper *Helper) validateUser(user User) (bool, error) {
 /**
 Updates everything from the password
 */
-func (helper *Helper) updateUser(userId int, newUser User) (User, error) {
+func (helper *Helper) updateUser(ctx context.Context, userId int, newUser User) (User, error) {
 
 	//Load up the user
-	oldUser, err := helper.GetUser(userId)
+	oldUser, err := helper.GetUserContext(ctx, userId)
 
 	//Check for err
 	if err != nil {
@@ -107,23 +151,26 @@ func (helper *Helper) updateUser(userId int, newUser User) (User, error) {
 
 	//There are three things we cannot change when we update the user, the id
 	if newUser.Id() != oldUser.Id() {
-		return nil, errors.New("update_forbidden")
+		return nil, ErrUpdateForbidden
 	}
 
 	//And the password
 	if newUser.Password() != oldUser.Password() {
-		return nil, errors.New("update_forbidden")
+		return nil, ErrUpdateForbidden
 	}
 
 	//And the email
 	if newUser.Email() != oldUser.Email() {
-		return nil, errors.New("update_forbidden")
+		return nil, ErrUpdateForbidden
 	}
 
-	//Make sure we
+	//Make sure no one has changed the user since it was read
+	if newUser.Version() != oldUser.Version() {
+		return nil, ErrVersionConflict
+	}
 
 	//Now update in the repo
-	newUser, err = helper.UpdateUser(newUser)
+	newUser, err = helper.UpdateUserContext(ctx, newUser)
 
 	return newUser, err
 
@@ -133,30 +180,45 @@ func (helper *Helper) updateUser(userId int, newUser User) (User, error) {
 Define a struct for just updating password
 */
 type updatePasswordChangeStruct struct {
-	Email       string `json:"email"`
-	Password    string `json:"password"`
-	PasswordOld string `json:"passwordold"`
+	Email       string `json:"email" validate:"required,email"`
+	Password    string `json:"password" validate:"required,max=1024"`
+	PasswordOld string `json:"passwordold" validate:"required"`
 }
 
 /**
-Updates everything from the password
+Updates everything from the password.  The client does not send a version, so the change is tried again at the
+fresh version if someone else saved the user first.
+*/
+func (helper *Helper) passwordChange(ctx context.Context, userId int, passwordChange updatePasswordChangeStruct) error {
+	return retryVersionConflict(func() error {
+		return helper.passwordChangeOnce(ctx, userId, passwordChange)
+	})
+}
+
+/**
+Check the old password and save the new one at the current version
 */
-func (helper *Helper) passwordChange(userId int, passwordChange updatePasswordChangeStruct) error {
+func (helper *Helper) passwordChangeOnce(ctx context.Context, userId int, passwordChange updatePasswordChangeStruct) error {
 
 	//Clean up the email
 	passwordChange.Email = strings.TrimSpace(strings.ToLower(passwordChange.Email))
 
 	//Load up the user
-	oldUser, err := helper.GetUser(userId)
+	oldUser, err := helper.GetUserContext(ctx, userId)
+
+	//Check for err
+	if err != nil {
+		return err
+	}
 
 	//Make sure the user can login with password
 	if !oldUser.PasswordLogin() {
-		return errors.New("user_password_login_forbidden")
+		return ErrPasswordLoginForbidden
 	}
 
 	//Make sure that the emails match
 	if passwordChange.Email != oldUser.Email() {
-		return errors.New("password_change_forbidden")
+		return passwords.ErrPasswordChangeForbidden
 	}
 
 	//Make sure the old password matches
@@ -164,7 +226,7 @@ func (helper *Helper) passwordChange(userId int, passwordChange updatePasswordCh
 
 	//Make sure that the emails match
 	if !passwordsMath {
-		return errors.New("password_change_forbidden")
+		return passwords.ErrPasswordChangeForbidden
 	}
 
 	//Make sure the new password is valid
@@ -179,26 +241,38 @@ func (helper *Helper) passwordChange(userId int, passwordChange updatePasswordCh
 	oldUser.SetPassword(helper.passwordHelper.HashPassword(passwordChange.Password))
 
 	//Now update in the repo
-	_, err = helper.UpdateUser(oldUser)
+	_, err = helper.UpdateUserContext(ctx, oldUser)
 
 	return err
 
 }
 
 /**
-Updates everything from the password
+Updates everything from the password.  The change is tried again at the fresh version if someone else saved the user
+first.
 */
-func (helper *Helper) passwordChangeForced(userId int, email string, newPassword string) error {
+func (helper *Helper) passwordChangeForced(ctx context.Context, userId int, email string, newPassword string) error {
+	return retryVersionConflict(func() error {
+		return helper.passwordChangeForcedOnce(ctx, userId, newPassword)
+	})
+}
 
-	//Clean up the email
-	email = strings.TrimSpace(strings.ToLower(email))
+/**
+Save the new password at the current version
+*/
+func (helper *Helper) passwordChangeForcedOnce(ctx context.Context, userId int, newPassword string) error {
 
 	//Load up the user
-	oldUser, err := helper.GetUser(userId)
+	oldUser, err := helper.GetUserContext(ctx, userId)
+
+	//Check for err
+	if err != nil {
+		return err
+	}
 
 	//Make sure the user can login with password
 	//if !oldUser.PasswordLogin() {
-	//	return errors.New("user_password_login_forbidden")
+	//	return ErrPasswordLoginForbidden
 	//}
 
 	//Make sure the new password is valid
@@ -213,12 +287,23 @@ func (helper *Helper) passwordChangeForced(userId int, email string, newPassword
 	oldUser.SetPassword(helper.passwordHelper.HashPassword(newPassword))
 
 	//Now update in the repo
-	_, err = helper.UpdateUser(oldUser)
+	_, err = helper.UpdateUserContext(ctx, oldUser)
 
 	return err
 
 }
 
+/**
+Run the function again while someone else keeps saving the user first
+*/
+func retryVersionConflict(function func() error) error {
+	err := function()
+	for retry := 0; retry < passwordChangeRetries && errors.Is(err, ErrVersionConflict); retry++ {
+		err = function()
+	}
+	return err
+}
+
 /**
 Login in the user
 */
@@ -226,12 +311,12 @@ func (helper *Helper) login(userPassword string, user User) (User, error) {
 
 	//Make sure the user can login with password
 	if !user.PasswordLogin() {
-		return nil, errors.New("user_password_login_forbidden")
+		return nil, ErrPasswordLoginForbidden
 	}
 
 	//Before you can login the user must be active
 	if !user.Activated() {
-		return nil, errors.New("user_not_activated")
+		return nil, ErrNotActivated
 	}
 
 	//Make sure the new password is valid
@@ -239,7 +324,7 @@ func (helper *Helper) login(userPassword string, user User) (User, error) {
 
 	//If the password is bad
 	if err != nil {
-		return nil, errors.New("login_invalid_password")
+		return nil, ErrInvalidPassword
 	}
 
 	//Now see if we login
@@ -250,7 +335,7 @@ func (helper *Helper) login(userPassword string, user User) (User, error) {
 
 	//If they do not match
 	if !passwordsMath {
-		return nil, errors.New("login_invalid_password")
+		return nil, ErrInvalidPassword
 	}
 
 	//Create JWT token and Store the token in the response
@@ -258,3 +343,67 @@ func (helper *Helper) login(userPassword string, user User) (User, error) {
 
 	return user, nil
 }
+
+/**
+Use the reset token to set the new password.  The password is only changed if the token is used up
+*/
+func (helper *Helper) resetPassword(ctx context.Context, info resetPutStruct) error {
+	return helper.transactions.Atomic(ctx, func(ctx context.Context) error {
+		//Lookup the user id
+		user, err := helper.GetUserByEmailContext(ctx, info.Email)
+
+		//Return the error
+		if err != nil {
+			return passwords.ErrPasswordChangeForbidden
+		}
+
+		//Try to use the token
+		requestId, err := helper.CheckForResetTokenContext(ctx, user.Id(), info.ResetToken)
+
+		//Return the error
+		if err != nil {
+			return passwords.ErrPasswordChangeForbidden
+		}
+
+		//Now update the password
+		err = helper.passwordChangeForced(ctx, user.Id(), user.Email(), info.Password)
+		if err != nil {
+			return err
+		}
+
+		//Mark the request as used
+		return helper.UseTokenContext(ctx, requestId)
+	})
+}
+
+/**
+Use the activation token to activate the user.  The user is only activated if the token is used up
+*/
+func (helper *Helper) activateUser(ctx context.Context, info activationPutStruct) error {
+	return helper.transactions.Atomic(ctx, func(ctx context.Context) error {
+		//Lookup the user id
+		user, err := helper.GetUserByEmailContext(ctx, info.Email)
+
+		//Return the error
+		if err != nil {
+			return passwords.ErrActivationForbidden
+		}
+
+		//Try to use the token
+		requestId, err := helper.CheckForActivationTokenContext(ctx, user.Id(), info.ActToken)
+
+		//Return the error
+		if err != nil {
+			return passwords.ErrActivationForbidden
+		}
+
+		//Now activate the user
+		err = helper.ActivateUserContext(ctx, user)
+		if err != nil {
+			return err
+		}
+
+		//Mark the request as used
+		return helper.UseTokenContext(ctx, requestId)
+	})
+}
@@ -0,0 +1,273 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package users_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/reaction-eng/restlib/auth"
+	"github.com/reaction-eng/restlib/dialect"
+	"github.com/reaction-eng/restlib/email"
+	"github.com/reaction-eng/restlib/passwords"
+	"github.com/reaction-eng/restlib/routing"
+	"github.com/reaction-eng/restlib/transaction"
+	"github.com/reaction-eng/restlib/users"
+	"github.com/reaction-eng/restlib/utils"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/**
+Drop every email
+*/
+type discardEmailer struct{}
+
+func (emailer discardEmailer) SendEmail(header *email.HeaderInfo, body string, attachments map[string][]*utils.Base64File) error {
+	return nil
+}
+
+func (emailer discardEmailer) SendEmailTemplateString(header *email.HeaderInfo, templateString string, data interface{}, attachments map[string][]*utils.Base64File) error {
+	return nil
+}
+
+func (emailer discardEmailer) SendEmailTemplateFile(header *email.HeaderInfo, templateFile string, data interface{}, attachments map[string][]*utils.Base64File) error {
+	return nil
+}
+
+func (emailer discardEmailer) SendEmailTable(header *email.HeaderInfo, tableData email.TableInfo, attachments map[string][]*utils.Base64File) error {
+	return nil
+}
+
+func (emailer discardEmailer) SendEmailContext(ctx context.Context, header *email.HeaderInfo, body string, attachments map[string][]*utils.Base64File) error {
+	return emailer.SendEmail(header, body, attachments)
+}
+
+func (emailer discardEmailer) SendEmailTemplateStringContext(ctx context.Context, header *email.HeaderInfo, templateString string, data interface{}, attachments map[string][]*utils.Base64File) error {
+	return emailer.SendEmailTemplateString(header, templateString, data, attachments)
+}
+
+func (emailer discardEmailer) SendEmailTemplateFileContext(ctx context.Context, header *email.HeaderInfo, templateFile string, data interface{}, attachments map[string][]*utils.Base64File) error {
+	return emailer.SendEmailTemplateFile(header, templateFile, data, attachments)
+}
+
+func (emailer discardEmailer) SendEmailTableContext(ctx context.Context, header *email.HeaderInfo, tableData email.TableInfo, attachments map[string][]*utils.Base64File) error {
+	return emailer.SendEmailTable(header, tableData, attachments)
+}
+
+/**
+Count the template emails, which is how the requests are sent
+*/
+type countingEmailer struct {
+	discardEmailer
+	sent *int
+}
+
+func (emailer countingEmailer) SendEmailTemplateFileContext(ctx context.Context, header *email.HeaderInfo, templateFile string, data interface{}, attachments map[string][]*utils.Base64File) error {
+	*emailer.sent++
+	return nil
+}
+
+/**
+Perform the testing
+*/
+func TestHelperTransactions(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "helper.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	//Build the helper on sqlite
+	userRepo := users.NewRepoSql(db, dialect.Sqlite, "users")
+	defer userRepo.CleanUp()
+	resetRepo := passwords.NewRepoSql(db, dialect.Sqlite, "resetrequests", discardEmailer{})
+	defer resetRepo.CleanUp()
+	helper := users.NewUserHelper(userRepo, resetRepo, passwords.NewBasicHelper(`{"token_password": "RvUP*b7fj9JPJ0*OQ9FlCW%Gg7vNTJWfvV7aQf@u9gWuYQ!S@e9SegAYjh!G%V7btMuGC8g29$qOw"}`))
+	helper.UseTransactions(transaction.NewSqlManager(db))
+
+	//The hook fails the first time
+	hookErr := errors.New("could not set the roles")
+	var hookUsers []string
+	helper.OnUserCreated(func(ctx context.Context, user users.User) error {
+		hookUsers = append(hookUsers, user.Email())
+		if len(hookUsers) == 1 {
+			return hookErr
+		}
+		return nil
+	})
+	router := routing.NewRouter(nil, nil, nil, users.NewHandler(helper, true))
+
+	//Send a request
+	serve := func(method string, path string, body string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	count := func(table string) int {
+		var rows int
+		db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&rows)
+		return rows
+	}
+
+	//Nothing is left behind when the hook fails
+	newUser := `{"email":"bob@example.com","password":"a good password"}`
+	if code := serve("POST", "/users/new", newUser); code == http.StatusCreated {
+		t.Errorf("recived %d, expected the create to fail", code)
+	}
+	if count("users") != 0 || count("resetrequests") != 0 {
+		t.Errorf("recived %d users and %d requests, expected everything to be rolled back", count("users"), count("resetrequests"))
+	}
+
+	//Now let it through
+	if code := serve("POST", "/users/new", newUser); code != http.StatusCreated {
+		t.Fatalf("recived %d, expected %d", code, http.StatusCreated)
+	}
+	if count("users") != 1 || count("resetrequests") != 1 || len(hookUsers) != 2 {
+		t.Errorf("recived %d users, %d requests and %v, expected one of each", count("users"), count("resetrequests"), hookUsers)
+	}
+
+	//Activate the user with the emailed token
+	var token string
+	db.QueryRow("SELECT token FROM resetrequests").Scan(&token)
+	if code := serve("POST", "/users/activate", `{"email":"bob@example.com","activation_token":"`+token+`"}`); code != http.StatusAccepted {
+		t.Fatalf("recived %d, expected %d", code, http.StatusAccepted)
+	}
+	user, _ := userRepo.GetUserByEmail("bob@example.com")
+	if !user.Activated() || count("resetrequests") != 0 {
+		t.Errorf("recived %+v with %d requests, expected an active user and the token to be used", user, count("resetrequests"))
+	}
+}
+
+/**
+Perform the testing
+*/
+func TestHelperCommitFails(t *testing.T) {
+	//Check the foreign keys so a deferred one can fail the commit
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "helper.db")+"?_foreign_keys=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE parents(id INTEGER PRIMARY KEY); CREATE TABLE children(parent INTEGER REFERENCES parents(id) DEFERRABLE INITIALLY DEFERRED)"); err != nil {
+		t.Fatal(err)
+	}
+
+	//Build the helper on sqlite
+	sent := 0
+	userRepo := users.NewRepoSql(db, dialect.Sqlite, "users")
+	defer userRepo.CleanUp()
+	resetRepo := passwords.NewRepoSql(db, dialect.Sqlite, "resetrequests", countingEmailer{sent: &sent})
+	defer resetRepo.CleanUp()
+	helper := users.NewUserHelper(userRepo, resetRepo, passwords.NewBasicHelper(`{"token_password": "RvUP*b7fj9JPJ0*OQ9FlCW%Gg7vNTJWfvV7aQf@u9gWuYQ!S@e9SegAYjh!G%V7btMuGC8g29$qOw"}`))
+	helper.UseTransactions(transaction.NewSqlManager(db))
+
+	//The first hook leaves a child without a parent, which is only found when committing
+	orphan := true
+	helper.OnUserCreated(func(ctx context.Context, user users.User) error {
+		if !orphan {
+			return nil
+		}
+		tx, _ := transaction.FromContext(ctx, db)
+		_, err := tx.ExecContext(ctx, "INSERT INTO children(parent) VALUES (?)", user.Id())
+		return err
+	})
+	router := routing.NewRouter(nil, nil, nil, users.NewHandler(helper, true))
+
+	//Send a request
+	serve := func() int {
+		req := httptest.NewRequest("POST", "/users/new", strings.NewReader(`{"email":"bob@example.com","password":"a good password"}`))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	//The commit fails so nothing should be emailed
+	if code := serve(); code == http.StatusCreated {
+		t.Errorf("recived %d, expected the commit to fail", code)
+	}
+	if sent != 0 {
+		t.Errorf("recived %d emails, expected none when the commit fails", sent)
+	}
+
+	//Now let it commit
+	orphan = false
+	if code := serve(); code != http.StatusCreated {
+		t.Fatalf("recived %d, expected %d", code, http.StatusCreated)
+	}
+	if sent != 1 {
+		t.Errorf("recived %d emails, expected one after the commit", sent)
+	}
+}
+
+/**
+Someone else saves the user right before the first update
+*/
+type racingRepo struct {
+	users.Repo
+	raced bool
+}
+
+func (repo *racingRepo) UpdateUserContext(ctx context.Context, user users.User) (users.User, error) {
+	if !repo.raced {
+		repo.raced = true
+		other, _ := repo.Repo.GetUserContext(ctx, user.Id())
+		repo.Repo.UpdateUserContext(ctx, other)
+	}
+	return repo.Repo.UpdateUserContext(ctx, user)
+}
+
+/**
+Perform the testing
+*/
+func TestHelperPasswordChangeRetry(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "helper.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	//Add a user to change
+	passHelper := passwords.NewBasicHelper(`{"token_password": "RvUP*b7fj9JPJ0*OQ9FlCW%Gg7vNTJWfvV7aQf@u9gWuYQ!S@e9SegAYjh!G%V7btMuGC8g29$qOw"}`)
+	sqlRepo := users.NewRepoSql(db, dialect.Sqlite, "users")
+	defer sqlRepo.CleanUp()
+	user := users.BasicUser{}
+	user.SetEmail("bob@example.com")
+	user.SetPassword(passHelper.HashPassword("a good password"))
+	added, err := sqlRepo.AddUser(&user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userRepo := &racingRepo{Repo: sqlRepo}
+	router := routing.NewRouter(nil, nil, nil, users.NewHandler(users.NewUserHelper(userRepo, nil, passHelper), true))
+
+	//Fake the jwt middleware
+	serve := func(userId int) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/users/password/change", strings.NewReader(`{"email":"bob@example.com","password":"a new password","passwordold":"a good password"}`))
+		req = req.WithContext(auth.WithIdentity(req.Context(), &auth.Identity{UserId: userId}))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	//The client never sent a version, so the change should not fail because of the other save
+	if rec := serve(added.Id()); rec.Code != http.StatusAccepted {
+		t.Fatalf("recived %d with %s, expected %d", rec.Code, rec.Body.String(), http.StatusAccepted)
+	}
+	changed, _ := sqlRepo.GetUser(added.Id())
+	if !userRepo.raced || !passHelper.ComparePasswords(changed.Password(), "a new password") {
+		t.Errorf("expected the new password to be saved after the race")
+	}
+
+	//An unknown user is not found
+	if rec := serve(added.Id() + 1); rec.Code != http.StatusNotFound {
+		t.Errorf("recived %d, expected %d", rec.Code, http.StatusNotFound)
+	}
+}
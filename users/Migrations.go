@@ -0,0 +1,48 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package users
+
+import (
+	"github.com/reaction-eng/restlib/dialect"
+	"github.com/reaction-eng/restlib/migrations"
+)
+
+/**
+Get the schema migrations for the users table.  New columns must be added as a new migration at the end
+*/
+func Migrations(tableName string) migrations.Source {
+	return migrations.Source{
+		Name: "users." + tableName,
+		Migrations: []migrations.Migration{
+			{
+				Version: 1,
+				Name:    "create the users table",
+				Up: map[dialect.Dialect][]string{
+					dialect.MySql:    {"CREATE TABLE IF NOT EXISTS " + tableName + "(id int NOT NULL AUTO_INCREMENT, email TEXT, password TEXT, activation Date, PRIMARY KEY (id) )"},
+					dialect.Postgres: {"CREATE TABLE IF NOT EXISTS " + tableName + "(id SERIAL PRIMARY KEY, email TEXT NOT NULL, password TEXT NOT NULL, activation Date)"},
+					dialect.Sqlite:   {"CREATE TABLE IF NOT EXISTS " + tableName + "(id INTEGER PRIMARY KEY AUTOINCREMENT, email TEXT NOT NULL, password TEXT NOT NULL, activation DATE)"},
+				},
+				Down: map[dialect.Dialect][]string{
+					dialect.MySql:    {"DROP TABLE " + tableName},
+					dialect.Postgres: {"DROP TABLE " + tableName},
+					dialect.Sqlite:   {"DROP TABLE " + tableName},
+				},
+			},
+			{
+				Version: 2,
+				Name:    "add the version used for optimistic locking",
+				Up: map[dialect.Dialect][]string{
+					dialect.MySql:    {"ALTER TABLE " + tableName + " ADD COLUMN version int NOT NULL DEFAULT 1"},
+					dialect.Postgres: {"ALTER TABLE " + tableName + " ADD COLUMN version integer NOT NULL DEFAULT 1"},
+					dialect.Sqlite:   {"ALTER TABLE " + tableName + " ADD COLUMN version INTEGER NOT NULL DEFAULT 1"},
+				},
+				Down: map[dialect.Dialect][]string{
+					dialect.MySql:    {"ALTER TABLE " + tableName + " DROP COLUMN version"},
+					dialect.Postgres: {"ALTER TABLE " + tableName + " DROP COLUMN version"},
+					dialect.Sqlite:   {"ALTER TABLE " + tableName + " DROP COLUMN version"},
+				},
+			},
+		},
+	}
+}
@@ -3,6 +3,8 @@
 
 package users
 
+import "context"
+
 /**
 Define an interface that all Calc Repos must follow
 */
@@ -47,4 +49,15 @@ type Repo interface {
 	*/
 	ListAllUsers() ([]int, error)
 	ListAllActiveUsers() ([]int, error)
+
+	/**
+	The same as each method above but stopped when the context ends, i.e. when the client goes away
+	*/
+	GetUserByEmailContext(ctx context.Context, email string) (User, error)
+	GetUserContext(ctx context.Context, id int) (User, error)
+	AddUserContext(ctx context.Context, user User) (User, error)
+	UpdateUserContext(ctx context.Context, user User) (User, error)
+	ActivateUserContext(ctx context.Context, user User) error
+	ListAllUsersContext(ctx context.Context) ([]int, error)
+	ListAllActiveUsersContext(ctx context.Context) ([]int, error)
 }
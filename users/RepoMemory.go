@@ -3,10 +3,7 @@
 
 package users
 
-import (
-	"errors"
-	//"log"
-)
+import "context"
 
 /**
 Define a struct for Repo for use with users
@@ -40,11 +37,11 @@ func (repo *RepoMemory) GetUserByEmail(email string) (User, error) {
 	for _, v := range repo.usersList {
 		//Check the email
 		if v.Email() == email {
-			return v, nil
+			return copyUser(v), nil
 		}
 	}
 
-	return nil, errors.New("no user with email")
+	return nil, ErrEmailNotFound
 }
 
 /**
@@ -55,11 +52,11 @@ func (repo *RepoMemory) GetUser(id int) (User, error) {
 	for _, v := range repo.usersList {
 		//Check the email
 		if v.Id() == id {
-			return v, nil
+			return copyUser(v), nil
 		}
 	}
 
-	return nil, errors.New("no user with id")
+	return nil, ErrUserIdNotFound
 }
 
 /**
@@ -68,8 +65,9 @@ Add the user to the database
 func (repo *RepoMemory) AddUser(t User) (User, error) {
 	repo.currentId += 1
 	t.SetId(repo.currentId)
+	t.SetVersion(1)
 
-	repo.usersList = append(repo.usersList, t)
+	repo.usersList = append(repo.usersList, copyUser(t))
 	return t, nil
 }
 
@@ -88,22 +86,36 @@ func (repo *RepoMemory) NewEmptyUser() User {
 }
 
 /**
-Update the user table.  No checks are made here,
+Update the user table.  The user must still be at its version or ErrVersionConflict is returned, and
+ErrUserIdNotFound is returned if there is no user with the id
 */
 func (repo *RepoMemory) UpdateUser(user User) (User, error) {
-	////Update the user statement
-	////Just update the info
-	////execute the statement//"UPDATE  " + tableName + " SET email = ?, password = ? WHERE id = ?"
-	//_, err := repo.updateUserStatement.Exec(user.Email(), user.Password(), user.Id())
-	//
-	////Check for error
-	//if err != nil {
-	//	log.Fatal(err)
-	//}
+	//March over each
+	for i, v := range repo.usersList {
+		if v.Id() != user.Id() {
+			continue
+		}
+
+		//Make sure no one else got there first
+		if v.Version() != user.Version() {
+			return user, ErrVersionConflict
+		}
+
+		user.SetVersion(user.Version() + 1)
+		repo.usersList[i] = copyUser(user)
+		return user, nil
+	}
 
-	// TODO: Doesn't do anything
+	return user, ErrUserIdNotFound
+}
 
-	return user, nil
+/**
+Copy the user so changes to it are not stored until the user is updated
+*/
+func copyUser(user User) User {
+	copied := &BasicUser{}
+	copied.CopyFrom(user)
+	return copied
 }
 
 /**
@@ -131,8 +143,35 @@ func (repo *RepoMemory) ActivateUser(user User) error {
 }
 
 /**
-Activate User
+Memory never blocks so the context versions just call the plain ones
 */
+func (repo *RepoMemory) GetUserByEmailContext(ctx context.Context, email string) (User, error) {
+	return repo.GetUserByEmail(email)
+}
+
+func (repo *RepoMemory) GetUserContext(ctx context.Context, id int) (User, error) {
+	return repo.GetUser(id)
+}
+
+func (repo *RepoMemory) AddUserContext(ctx context.Context, user User) (User, error) {
+	return repo.AddUser(user)
+}
+
+func (repo *RepoMemory) UpdateUserContext(ctx context.Context, user User) (User, error) {
+	return repo.UpdateUser(user)
+}
+
+func (repo *RepoMemory) ActivateUserContext(ctx context.Context, user User) error {
+	return repo.ActivateUser(user)
+}
+
+func (repo *RepoMemory) ListAllUsersContext(ctx context.Context) ([]int, error) {
+	return repo.ListAllUsers()
+}
+
+func (repo *RepoMemory) ListAllActiveUsersContext(ctx context.Context) ([]int, error) {
+	return repo.ListAllActiveUsers()
+}
 
 //func RepoDestroyCalc(id int) error {
 //	for i, t := range usersList {
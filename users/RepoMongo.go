@@ -67,11 +67,6 @@ Get the user with the ID.  An error is thrown is not found
 //func (repo *RepoMongo)AddUser(user User) (User, error){}
 //
 ///**
-//Update User
-//*/
-//func (repo *RepoMongo)UpdateUser(user User) (User, error){}
-//
-///**
 //Activate User
 //*/
 //func (repo *RepoMongo)ActivateUser(user User) error{}
@@ -91,6 +86,51 @@ Get the user with the ID.  An error is thrown is not found
 //*/
 //func (repo *RepoMongo)ListAllUsers() ([]int, error){}
 
+/**
+Update the user if no one else has since it was read.  Users saved before versions were added are at version 1
+*/
+func (repo *RepoMongo) UpdateUser(user User) (User, error) {
+	return repo.UpdateUserContext(context.Background(), user)
+}
+
+/**
+Update the user if no one else has since it was read.  Users saved before versions were added are at version 1
+*/
+func (repo *RepoMongo) UpdateUserContext(ctx context.Context, user User) (User, error) {
+	//Only match the version that was read
+	filter := bson.M{"ID": user.Id(), "Version": user.Version()}
+	if user.Version() == 1 {
+		filter = bson.M{"ID": user.Id(), "$or": bson.A{bson.M{"Version": 1}, bson.M{"Version": bson.M{"$exists": false}}}}
+	}
+
+	update := bson.M{"$set": bson.M{
+		"Email":    user.Email(),
+		"Password": user.Password(),
+		"Version":  user.Version() + 1,
+	}}
+
+	result, err := repo.db.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return user, err
+	}
+
+	//If nothing matched someone else got there first
+	if result.MatchedCount == 0 {
+		//Unless the user is gone
+		count, err := repo.db.CountDocuments(ctx, bson.M{"ID": user.Id()})
+		if err != nil {
+			return user, err
+		}
+		if count == 0 {
+			return user, ErrUserIdNotFound
+		}
+		return user, ErrVersionConflict
+	}
+
+	user.SetVersion(user.Version() + 1)
+	return user, nil
+}
+
 //Connect to a db, returns pointer to db
 func ConnectToDB(locOfDB string, dbName string) *mongo.Database {
 	ctx := context.Background()
@@ -141,6 +181,17 @@ func GetUserFromCursor(cursor mongo.Cursor) *BasicUser {
 		return nil
 	}
 
+	//Users saved before versions were added are at version 1
+	version := 1
+	switch stored := jsonElem["Version"].(type) {
+	case int32:
+		version = int(stored)
+	case int64:
+		version = int(stored)
+	case float64:
+		version = int(stored)
+	}
+
 	basicUser := BasicUser{
 		Id_:            int(jsonElem["ID"].(float64)),
 		Email_:         jsonElem["Email"].(string),
@@ -148,6 +199,7 @@ func GetUserFromCursor(cursor mongo.Cursor) *BasicUser {
 		Token_:         jsonElem["Token"].(string),
 		activated_:     jsonElem["ID"].(bool),
 		passwordlogin_: jsonElem["ID"].(bool),
+		version_:       version,
 	}
 
 	return &basicUser
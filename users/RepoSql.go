@@ -4,8 +4,12 @@
 package users
 
 import (
+	"context"
 	"database/sql"
-	"errors"
+	"github.com/reaction-eng/restlib/dialect"
+	"github.com/reaction-eng/restlib/migrations"
+	"github.com/reaction-eng/restlib/tracing"
+	"github.com/reaction-eng/restlib/transaction"
 	"github.com/reaction-eng/restlib/utils"
 	"log"
 	"strings"
@@ -30,12 +34,22 @@ type RepoSql struct {
 	activateStatement       *sql.Stmt
 	listAllUsersStatement   *sql.Stmt
 
-	//Store the nullable time object
-
+	//How long each query can run, zero for no limit
+	queryTimeout time.Duration
 }
 
 //Provide a method to make a new UserRepoSql
 func NewRepoMySql(db *sql.DB, tableName string) *RepoSql {
+	return NewRepoSql(db, dialect.MySql, tableName)
+}
+
+//Provide a method to make a new UserRepoSql
+func NewRepoPostgresSql(db *sql.DB, tableName string) *RepoSql {
+	return NewRepoSql(db, dialect.Postgres, tableName)
+}
+
+//Provide a method to make a new UserRepoSql for any supported dialect
+func NewRepoSql(db *sql.DB, sqlDialect dialect.Dialect, tableName string) *RepoSql {
 
 	//Define a new repo
 	newRepo := RepoSql{
@@ -43,15 +57,14 @@ func NewRepoMySql(db *sql.DB, tableName string) *RepoSql {
 		tableName: tableName,
 	}
 
-	//Create the table if it is not already there
-	//Create a table
-	_, err := db.Exec("CREATE TABLE IF NOT EXISTS " + tableName + "(id int NOT NULL AUTO_INCREMENT, email TEXT, password TEXT, activation Date, PRIMARY KEY (id) )")
+	//Bring the table up to date
+	err := migrations.NewMigrator(db, sqlDialect, Migrations(tableName)).Up(context.Background())
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	//Add calc data to table
-	addUser, err := db.Prepare("INSERT INTO " + tableName + "(email,password) VALUES (?, ?)")
+	addUser, err := sqlDialect.Prepare(db, "INSERT INTO "+tableName+"(email,password) VALUES (?, ?)")
 	//Check for error
 	if err != nil {
 		log.Fatal(err)
@@ -60,7 +73,7 @@ func NewRepoMySql(db *sql.DB, tableName string) *RepoSql {
 	newRepo.addUserStatement = addUser
 
 	//get user statement
-	getUser, err := db.Prepare("SELECT * FROM " + tableName + " where id = ?")
+	getUser, err := sqlDialect.Prepare(db, "SELECT id, email, password, activation, version FROM "+tableName+" where id = ?")
 	//Check for error
 	if err != nil {
 		log.Fatal(err)
@@ -69,7 +82,7 @@ func NewRepoMySql(db *sql.DB, tableName string) *RepoSql {
 	newRepo.getUserStatement = getUser
 
 	//get calc statement
-	getUserByEmail, err := db.Prepare("SELECT * FROM " + tableName + " where email like ?")
+	getUserByEmail, err := sqlDialect.Prepare(db, "SELECT id, email, password, activation, version FROM "+tableName+" where email like ?")
 	//Check for error
 	if err != nil {
 		log.Fatal(err)
@@ -77,8 +90,8 @@ func NewRepoMySql(db *sql.DB, tableName string) *RepoSql {
 	//Store it
 	newRepo.getUserByEmailStatement = getUserByEmail
 
-	//update the user
-	updateStatement, err := db.Prepare("UPDATE  " + tableName + " SET email = ?, password = ? WHERE id = ?")
+	//update the user if no one else has since it was read
+	updateStatement, err := sqlDialect.Prepare(db, "UPDATE  "+tableName+" SET email = ?, password = ?, version = version + 1 WHERE id = ? AND version = ?")
 
 	//Check for error
 	if err != nil {
@@ -88,7 +101,7 @@ func NewRepoMySql(db *sql.DB, tableName string) *RepoSql {
 	newRepo.updateUserStatement = updateStatement
 
 	//Activate User statemetn
-	activateStatement, err := db.Prepare("UPDATE  " + tableName + " SET activation = ? WHERE id = ?")
+	activateStatement, err := sqlDialect.Prepare(db, "UPDATE  "+tableName+" SET activation = ? WHERE id = ?")
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -96,7 +109,7 @@ func NewRepoMySql(db *sql.DB, tableName string) *RepoSql {
 	newRepo.activateStatement = activateStatement
 
 	//update the user
-	listAllUsers, err := db.Prepare("SELECT id, activation FROM " + tableName)
+	listAllUsers, err := sqlDialect.Prepare(db, "SELECT id, activation FROM "+tableName)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -107,83 +120,30 @@ func NewRepoMySql(db *sql.DB, tableName string) *RepoSql {
 
 }
 
-//Provide a method to make a new UserRepoSql
-func NewRepoPostgresSql(db *sql.DB, tableName string) *RepoSql {
-
-	//Define a new repo
-	newRepo := RepoSql{
-		db:        db,
-		tableName: tableName,
-	}
-
-	//Create the table if it is not already there
-	//Create a table
-	_, err := db.Exec("CREATE TABLE IF NOT EXISTS " + tableName + "(id SERIAL PRIMARY KEY, email TEXT NOT NULL, password TEXT NOT NULL, activation Date)")
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	//Add calc data to table
-	addUser, err := db.Prepare("INSERT INTO " + tableName + "(email,password) VALUES ($1, $2)")
-	//Check for error
-	if err != nil {
-		log.Fatal(err)
-	}
-	////Store it
-	newRepo.addUserStatement = addUser
-
-	//get calc statement
-	getUser, err := db.Prepare("SELECT * FROM " + tableName + " where id = $1")
-	//Check for error
-	if err != nil {
-		log.Fatal(err)
-	}
-	////Store it
-	newRepo.getUserStatement = getUser
-
-	//get calc statement
-	getUserByEmail, err := db.Prepare("SELECT * FROM " + tableName + " where email like $1")
-	//Check for error
-	if err != nil {
-		log.Fatal(err)
-	}
-	//Store it
-	newRepo.getUserByEmailStatement = getUserByEmail
-
-	//update the user
-	updateStatement, err := db.Prepare("UPDATE  " + tableName + " SET email = $1, password = $2 WHERE id = $3")
-
-	//Check for error
-	if err != nil {
-		log.Fatal(err)
-	}
-	//Store it
-	newRepo.updateUserStatement = updateStatement
-
-	//Activate User statemetn
-	activateStatement, err := db.Prepare("UPDATE  " + tableName + " SET activation = $1 WHERE id = $2")
-	if err != nil {
-		log.Fatal(err)
-	}
-	//Store it
-	newRepo.activateStatement = activateStatement
-
-	//update the user
-	listAllUsers, err := db.Prepare("SELECT id, activation FROM " + tableName)
-	if err != nil {
-		log.Fatal(err)
-	}
-	newRepo.listAllUsersStatement = listAllUsers
-
-	//Return a point
-	return &newRepo
-
+/**
+Limit how long each query can run.  Zero, the default, only stops a query when its context ends
+*/
+func (repo *RepoSql) SetQueryTimeout(timeout time.Duration) {
+	repo.queryTimeout = timeout
 }
 
 /**
 Look up the user and return if they were found
 */
 func (repo *RepoSql) GetUserByEmail(email string) (User, error) {
+	return repo.GetUserByEmailContext(context.Background(), email)
+}
+
+/**
+Look up the user and return if they were found
+*/
+func (repo *RepoSql) GetUserByEmailContext(ctx context.Context, email string) (User, error) {
+	//Trace the query
+	ctx, span := tracing.StartSqlSpan(ctx, "users.RepoSql.GetUserByEmail", repo.tableName)
+	defer span.End()
+	ctx, cancel := dialect.WithTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
 	//Clean up the string
 	email = strings.TrimSpace(strings.ToLower(email))
 
@@ -194,26 +154,43 @@ func (repo *RepoSql) GetUserByEmail(email string) (User, error) {
 	var activationDate utils.NullTime
 
 	//Get the value //id int NOT NULL AUTO_INCREMENT, email TEXT, password TEXT, PRIMARY KEY (id)
-	err := repo.getUserByEmailStatement.QueryRow(email).Scan(&user.Id_, &user.Email_, &user.password_, &activationDate)
+	err := transaction.Stmt(ctx, repo.db, repo.getUserByEmailStatement).QueryRowContext(ctx, email).Scan(&user.Id_, &user.Email_, &user.password_, &activationDate, &user.version_)
 
 	//Use a useful error
 	if err == sql.ErrNoRows {
-		err = errors.New("login_email_not_found")
+		err = ErrEmailNotFound
 		return nil, err
 	}
+	if err != nil {
+		tracing.RecordError(span, err)
+		return nil, dialect.QueryError(err)
+	}
 
 	//Store if this is activated
 	user.activated_ = activationDate.Valid
 	user.passwordlogin_ = len(user.password_) > 0
 
 	//Return the user calcs
-	return &user, err
+	return &user, nil
 }
 
 /**
 Look up the user by id and return if they were found
 */
 func (repo *RepoSql) GetUser(id int) (User, error) {
+	return repo.GetUserContext(context.Background(), id)
+}
+
+/**
+Look up the user by id and return if they were found
+*/
+func (repo *RepoSql) GetUserContext(ctx context.Context, id int) (User, error) {
+	//Trace the query
+	ctx, span := tracing.StartSqlSpan(ctx, "users.RepoSql.GetUser", repo.tableName)
+	defer span.End()
+	ctx, cancel := dialect.WithTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
 	//var dataResult string
 	var user BasicUser
 
@@ -221,11 +198,14 @@ func (repo *RepoSql) GetUser(id int) (User, error) {
 	var activationDate utils.NullTime
 
 	//Get the value //id int NOT NULL AUTO_INCREMENT, email TEXT, password TEXT, PRIMARY KEY (id)
-	err := repo.getUserStatement.QueryRow(id).Scan(&user.Id_, &user.Email_, &user.password_, &activationDate)
+	err := transaction.Stmt(ctx, repo.db, repo.getUserStatement).QueryRowContext(ctx, id).Scan(&user.Id_, &user.Email_, &user.password_, &activationDate, &user.version_)
 
 	//Use a useful error
 	if err == sql.ErrNoRows {
-		err = errors.New("login_user_id_not_found")
+		err = ErrUserIdNotFound
+	} else if err != nil {
+		tracing.RecordError(span, err)
+		err = dialect.QueryError(err)
 	}
 
 	//Store if this is activated
@@ -240,45 +220,48 @@ func (repo *RepoSql) GetUser(id int) (User, error) {
 List all of the users
 */
 func (repo *RepoSql) ListAllUsers() ([]int, error) {
-	//Put in the list
-	list := make([]int, 0)
-
-	//Get the value //id int NOT NULL AUTO_INCREMENT, email TEXT, password TEXT, PRIMARY KEY (id)
-	rows, err := repo.listAllUsersStatement.Query()
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer rows.Close()
-	for rows.Next() {
-		var id int
-		//Store the sql time
-		var activationDate utils.NullTime
-
-		err := rows.Scan(&id, &activationDate)
-		if err != nil {
-			return nil, err
-		}
-
-		//Append the row
-		list = append(list, id)
-
-	}
-	err = rows.Err()
+	return repo.ListAllUsersContext(context.Background())
+}
 
-	return list, err
+/**
+List all of the users
+*/
+func (repo *RepoSql) ListAllUsersContext(ctx context.Context) ([]int, error) {
+	return repo.listUsers(ctx, "users.RepoSql.ListAllUsers", false)
 }
 
 /**
 List all of the users
 */
 func (repo *RepoSql) ListAllActiveUsers() ([]int, error) {
+	return repo.ListAllActiveUsersContext(context.Background())
+}
+
+/**
+List all of the users
+*/
+func (repo *RepoSql) ListAllActiveUsersContext(ctx context.Context) ([]int, error) {
+	return repo.listUsers(ctx, "users.RepoSql.ListAllActiveUsers", true)
+}
+
+/**
+List the users, only the activated ones if asked
+*/
+func (repo *RepoSql) listUsers(ctx context.Context, spanName string, activeOnly bool) ([]int, error) {
+	//Trace the query
+	ctx, span := tracing.StartSqlSpan(ctx, spanName, repo.tableName)
+	defer span.End()
+	ctx, cancel := dialect.WithTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
 	//Put in the list
 	list := make([]int, 0)
 
 	//Get the value //id int NOT NULL AUTO_INCREMENT, email TEXT, password TEXT, PRIMARY KEY (id)
-	rows, err := repo.listAllUsersStatement.Query()
+	rows, err := transaction.Stmt(ctx, repo.db, repo.listAllUsersStatement).QueryContext(ctx)
+	tracing.RecordError(span, err)
 	if err != nil {
-		log.Fatal(err)
+		return nil, dialect.QueryError(err)
 	}
 	defer rows.Close()
 	for rows.Next() {
@@ -292,55 +275,109 @@ func (repo *RepoSql) ListAllActiveUsers() ([]int, error) {
 		}
 
 		//Append the row
-		if activationDate.Valid {
+		if activationDate.Valid || !activeOnly {
 			list = append(list, id)
 		}
 	}
 	err = rows.Err()
 
-	return list, err
+	return list, dialect.QueryError(err)
 }
 
 /**
 Add the user to the database
 */
 func (repo *RepoSql) AddUser(newUser User) (User, error) {
+	return repo.AddUserContext(context.Background(), newUser)
+}
+
+/**
+Add the user to the database
+*/
+func (repo *RepoSql) AddUserContext(ctx context.Context, newUser User) (User, error) {
+	//Trace the query
+	ctx, span := tracing.StartSqlSpan(ctx, "users.RepoSql.AddUser", repo.tableName)
+	defer span.End()
+	queryCtx, cancel := dialect.WithTimeout(ctx, repo.queryTimeout)
+	defer cancel()
 
 	//Add the info
 	//execute the statement//(userId,name,input,flow)
-	_, err := repo.addUserStatement.Exec(newUser.Email(), newUser.Password())
+	_, err := transaction.Stmt(queryCtx, repo.db, repo.addUserStatement).ExecContext(queryCtx, newUser.Email(), newUser.Password())
+	tracing.RecordError(span, err)
 
 	//Check for error
 	if err != nil {
-		return newUser, err
+		return newUser, dialect.QueryError(err)
 	}
 
 	//Now look up the person by email
-	return repo.GetUserByEmail(newUser.Email())
+	return repo.GetUserByEmailContext(ctx, newUser.Email())
 
 }
 
 /**
-Update the user table.  No checks are made here,
+Update the user table.  The user must still be at its version or ErrVersionConflict is returned, and
+ErrUserIdNotFound is returned if there is no user with the id
 */
 func (repo *RepoSql) UpdateUser(user User) (User, error) {
+	return repo.UpdateUserContext(context.Background(), user)
+}
+
+/**
+Update the user table.  The user must still be at its version or ErrVersionConflict is returned, and
+ErrUserIdNotFound is returned if there is no user with the id
+*/
+func (repo *RepoSql) UpdateUserContext(ctx context.Context, user User) (User, error) {
+	//Trace the query
+	ctx, span := tracing.StartSqlSpan(ctx, "users.RepoSql.UpdateUser", repo.tableName)
+	defer span.End()
+	ctx, cancel := dialect.WithTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
 	//Update the user statement
 	//Just update the info
-	//execute the statement//"UPDATE  " + tableName + " SET email = ?, password = ? WHERE id = ?"
-	_, err := repo.updateUserStatement.Exec(user.Email(), user.Password(), user.Id())
+	//execute the statement//"UPDATE  " + tableName + " SET email = ?, password = ?, version = version + 1 WHERE id = ? AND version = ?"
+	result, err := transaction.Stmt(ctx, repo.db, repo.updateUserStatement).ExecContext(ctx, user.Email(), user.Password(), user.Id(), user.Version())
+	tracing.RecordError(span, err)
+	if err != nil {
+		return user, dialect.QueryError(err)
+	}
 
-	//Check for error
+	//If nothing was updated someone else got there first
+	updated, err := result.RowsAffected()
 	if err != nil {
-		log.Fatal(err)
+		return user, dialect.QueryError(err)
+	}
+	if updated == 0 {
+		//Unless the user is gone
+		if _, err := repo.GetUserContext(ctx, user.Id()); err != nil {
+			return user, err
+		}
+		return user, ErrVersionConflict
 	}
 
-	return user, err
+	user.SetVersion(user.Version() + 1)
+	return user, nil
 }
 
 /**
 Update the user table.  No checks are made here,
 */
 func (repo *RepoSql) ActivateUser(user User) error {
+	return repo.ActivateUserContext(context.Background(), user)
+}
+
+/**
+Update the user table.  No checks are made here,
+*/
+func (repo *RepoSql) ActivateUserContext(ctx context.Context, user User) error {
+	//Trace the query
+	ctx, span := tracing.StartSqlSpan(ctx, "users.RepoSql.ActivateUser", repo.tableName)
+	defer span.End()
+	ctx, cancel := dialect.WithTimeout(ctx, repo.queryTimeout)
+	defer cancel()
+
 	//Get the current time
 	actTime := utils.NullTime{
 		Time:  time.Now(),
@@ -348,14 +385,10 @@ func (repo *RepoSql) ActivateUser(user User) error {
 	}
 
 	//Just update the info//"UPDATE  " + tableName + " SET activation = $1 WHERE id = $2")
-	_, err := repo.activateStatement.Exec(actTime, user.Id())
-
-	//Check for error
-	if err != nil {
-		log.Fatal(err)
-	}
+	_, err := transaction.Stmt(ctx, repo.db, repo.activateStatement).ExecContext(ctx, actTime, user.Id())
+	tracing.RecordError(span, err)
 
-	return err
+	return dialect.QueryError(err)
 }
 
 /**
@@ -0,0 +1,182 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package users_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/reaction-eng/restlib/apierror"
+	"github.com/reaction-eng/restlib/dialect"
+	"github.com/reaction-eng/restlib/users"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+/**
+Build a repo on a new sqlite database
+*/
+func newSqliteRepo(t *testing.T) *users.RepoSql {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "users.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	repo := users.NewRepoSql(db, dialect.Sqlite, "users")
+	t.Cleanup(repo.CleanUp)
+	return repo
+}
+
+/**
+Perform the testing
+*/
+func TestRepoSql(t *testing.T) {
+	repo := newSqliteRepo(t)
+
+	//Add a user
+	newUser := repo.NewEmptyUser()
+	newUser.SetEmail("bob@example.com")
+	newUser.SetPassword("hashed")
+	added, err := repo.AddUser(newUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added.Id() <= 0 || added.Email() != "bob@example.com" || added.Activated() || !added.PasswordLogin() {
+		t.Errorf("recived %+v, expected a new inactive user", added)
+	}
+
+	//Look them up
+	found, err := repo.GetUser(added.Id())
+	if err != nil || found.Email() != "bob@example.com" {
+		t.Errorf("recived %v %v, expected bob", found, err)
+	}
+	if found, err := repo.GetUserByEmail(" BOB@example.com"); err != nil || found.Id() != added.Id() {
+		t.Errorf("recived %v %v, expected the email to be cleaned up", found, err)
+	}
+	if _, err := repo.GetUserByEmail("nobody@example.com"); err != users.ErrEmailNotFound {
+		t.Errorf("recived %v, expected %v", err, users.ErrEmailNotFound)
+	}
+	if _, err := repo.GetUser(1000); err != users.ErrUserIdNotFound {
+		t.Errorf("recived %v, expected %v", err, users.ErrUserIdNotFound)
+	}
+
+	//Update and activate them
+	found.SetPassword("changed")
+	if _, err := repo.UpdateUser(found); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.ActivateUser(found); err != nil {
+		t.Fatal(err)
+	}
+	found, _ = repo.GetUser(added.Id())
+	if found.Password() != "changed" || !found.Activated() {
+		t.Errorf("recived %+v, expected an active user with the new password", found)
+	}
+
+	//Add an inactive user and list them
+	other := repo.NewEmptyUser()
+	other.SetEmail("alice@example.com")
+	other, _ = repo.AddUser(other)
+	all, _ := repo.ListAllUsers()
+	active, _ := repo.ListAllActiveUsers()
+	if len(all) != 2 || len(active) != 1 || active[0] != added.Id() {
+		t.Errorf("recived %v and %v, expected two users with one active", all, active)
+	}
+}
+
+/**
+Perform the testing
+*/
+func TestRepoSqlContext(t *testing.T) {
+	repo := newSqliteRepo(t)
+
+	newUser := repo.NewEmptyUser()
+	newUser.SetEmail("bob@example.com")
+	added, err := repo.AddUserContext(context.Background(), newUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//A query past its deadline is a timeout
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	if _, err := repo.GetUserContext(expired, added.Id()); !errors.Is(err, apierror.ErrTimeout) {
+		t.Errorf("recived %v, expected %v", err, apierror.ErrTimeout)
+	}
+	if _, err := repo.ListAllUsersContext(expired); !errors.Is(err, apierror.ErrTimeout) {
+		t.Errorf("recived %v, expected %v", err, apierror.ErrTimeout)
+	}
+
+	//A client that went away is not
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := repo.GetUserByEmailContext(canceled, "bob@example.com"); !errors.Is(err, context.Canceled) {
+		t.Errorf("recived %v, expected %v", err, context.Canceled)
+	}
+
+	//The configured timeout is used when the context has none
+	repo.SetQueryTimeout(time.Minute)
+	if found, err := repo.GetUserContext(context.Background(), added.Id()); err != nil || found.Id() != added.Id() {
+		t.Errorf("recived %v %v, expected bob", found, err)
+	}
+}
+
+/**
+Perform the testing
+*/
+func TestRepoVersion(t *testing.T) {
+
+	//Each repo must stop a stale update
+	var repos = []struct {
+		name string
+		repo users.Repo
+	}{
+		{"sql", newSqliteRepo(t)},
+		{"memory", users.NewRepoMemory()},
+	}
+
+	for _, rr := range repos {
+		newUser := rr.repo.NewEmptyUser()
+		newUser.SetEmail("bob@example.com")
+		added, err := rr.repo.AddUser(newUser)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		//Two copies of the same version
+		first, _ := rr.repo.GetUser(added.Id())
+		second, _ := rr.repo.GetUser(added.Id())
+		if first.Version() != 1 {
+			t.Errorf("recived %d for %s, expected new users to be at 1", first.Version(), rr.name)
+		}
+
+		//The first one wins
+		first.SetPassword("first")
+		if updated, err := rr.repo.UpdateUser(first); err != nil || updated.Version() != 2 {
+			t.Errorf("recived %v %v for %s, expected version 2", updated, err, rr.name)
+		}
+		second.SetPassword("second")
+		if _, err := rr.repo.UpdateUser(second); err != users.ErrVersionConflict {
+			t.Errorf("recived %v for %s, expected %v", err, rr.name, users.ErrVersionConflict)
+		}
+
+		//Only the first was saved
+		found, _ := rr.repo.GetUser(added.Id())
+		if found.Password() != "first" || found.Version() != 2 {
+			t.Errorf("recived %+v for %s, expected the first update", found, rr.name)
+		}
+
+		//A missing user is not a conflict
+		missing := rr.repo.NewEmptyUser()
+		missing.SetId(added.Id() + 100)
+		missing.SetVersion(1)
+		if _, err := rr.repo.UpdateUser(missing); err != users.ErrUserIdNotFound {
+			t.Errorf("recived %v for %s, expected %v", err, rr.name, users.ErrUserIdNotFound)
+		}
+	}
+}
+
@@ -0,0 +1,102 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package users
+
+import (
+	"net/http"
+	"time"
+)
+
+/**
+Define the cookie used to hold the jwt for browser sessions.  The cookie is always HttpOnly
+*/
+type SessionCookie struct {
+	//The name of the cookie, defaults to session
+	Name string
+
+	//Optional domain and path, the path defaults to /
+	Domain string
+	Path   string
+
+	//How long the cookie lasts, 0 makes it a browser session cookie
+	MaxAge time.Duration
+
+	//Defaults to lax if not set
+	SameSite http.SameSite
+
+	//Set the cookie without the Secure flag, only use for local development
+	Insecure bool
+
+	//Remove the token from the login response so scripts never see it
+	HideToken bool
+}
+
+/**
+Set the session cookie on each login
+*/
+func (helper *Helper) UseSessionCookie(cookie SessionCookie) {
+	//Fill in the defaults
+	if len(cookie.Name) == 0 {
+		cookie.Name = "session"
+	}
+	if len(cookie.Path) == 0 {
+		cookie.Path = "/"
+	}
+	if cookie.SameSite == 0 {
+		cookie.SameSite = http.SameSiteLaxMode
+	}
+
+	helper.sessionCookie = &cookie
+}
+
+/**
+Get the session cookie settings, nil if cookies are not used
+*/
+func (helper *Helper) SessionCookie() *SessionCookie {
+	return helper.sessionCookie
+}
+
+/**
+Set the cookie with the token in the logged in user
+*/
+func (helper *Helper) setSessionCookie(w http.ResponseWriter, user User) {
+	if helper.sessionCookie == nil {
+		return
+	}
+
+	//Set the cookie
+	http.SetCookie(w, helper.sessionCookie.build(user.Token(), int(helper.sessionCookie.MaxAge.Seconds())))
+
+	//Remove it from the body
+	if helper.sessionCookie.HideToken {
+		user.SetToken("")
+	}
+}
+
+/**
+Tell the browser to remove the cookie
+*/
+func (helper *Helper) clearSessionCookie(w http.ResponseWriter) {
+	if helper.sessionCookie == nil {
+		return
+	}
+
+	http.SetCookie(w, helper.sessionCookie.build("", -1))
+}
+
+/**
+Build the http cookie
+*/
+func (cookie *SessionCookie) build(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     cookie.Name,
+		Value:    value,
+		Domain:   cookie.Domain,
+		Path:     cookie.Path,
+		MaxAge:   maxAge,
+		Secure:   !cookie.Insecure,
+		HttpOnly: true,
+		SameSite: cookie.SameSite,
+	}
+}
@@ -26,4 +26,8 @@ type User interface {
 
 	//Check to see if the user can login with a password
 	PasswordLogin() bool
+
+	//Return the version, which goes up by one each time the user is updated
+	Version() int
+	SetVersion(version int)
 }
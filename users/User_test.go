@@ -4,12 +4,18 @@
 package users_test
 
 import (
+	"database/sql"
+	"encoding/json"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/reaction-eng/restlib/dialect"
 	"github.com/reaction-eng/restlib/middleware"
 	"github.com/reaction-eng/restlib/passwords"
 	"github.com/reaction-eng/restlib/routing"
 	"github.com/reaction-eng/restlib/users"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -32,7 +38,7 @@ func TestUserRoutes(t *testing.T) {
 		expectedCode int
 	}{ //Now define with
 		{"GET", "/api/users", http.StatusOK},
-		{"PUT", "/users/", http.StatusForbidden},
+		{"PUT", "/users/", http.StatusUnauthorized},
 	}
 
 	//Now run over each test as a logged out user
@@ -133,3 +139,88 @@ func getDefaultEnv(t *testing.T) *routingEnv {
 	return &env
 
 }
+
+/**
+Perform the testing
+*/
+func TestSessionCookie(t *testing.T) {
+	//Build a config string
+	configString := "{\"token_password\": \"RvUP*b7fj9JPJ0*OQ9FlCW%Gg7vNTJWfvV7aQf@u9gWuYQ!S@e9SegAYjh!G%V7btMuGC8g29$qOw\"}"
+
+	//Add an active user, the memory repo can't activate users
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "users.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	userRepo := users.NewRepoSql(db, dialect.Sqlite, "users")
+	defer userRepo.CleanUp()
+	passHelper := passwords.NewBasicHelper(configString)
+	user := users.BasicUser{}
+	user.SetEmail("one@example.com")
+	user.SetPassword(passHelper.HashPassword("123456"))
+	added, err := userRepo.AddUser(&user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := userRepo.ActivateUser(added); err != nil {
+		t.Fatal(err)
+	}
+
+	//Use the session cookie and hide the token from scripts
+	helper := users.NewUserHelper(userRepo, nil, passHelper)
+	helper.UseSessionCookie(users.SessionCookie{HideToken: true})
+	router := routing.NewRouter(nil, nil, nil, users.NewHandler(helper, false))
+	router.Use(middleware.MakeJwtMiddlewareFunc(router, userRepo, nil, passHelper, helper.SessionCookie()))
+
+	//Send a request with any cookies
+	serve := func(method string, path string, body string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	//Login
+	rec := serve("POST", "/users/login", `{"email":"one@example.com","password":"123456"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("recived status code %d, expected %d", rec.Code, http.StatusOK)
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "session" || len(cookies[0].Value) == 0 || !cookies[0].HttpOnly || !cookies[0].Secure {
+		t.Fatalf("recived cookies %v, expected a secure HttpOnly session cookie", cookies)
+	}
+	loggedIn := users.BasicUser{}
+	if err := json.NewDecoder(rec.Body).Decode(&loggedIn); err != nil || len(loggedIn.Token()) > 0 {
+		t.Errorf("recived token %s, expected it to be hidden", loggedIn.Token())
+	}
+
+	//The cookie alone is enough to get the user
+	if rec := serve("GET", "/users/", "", cookies[0]); rec.Code != http.StatusOK {
+		t.Errorf("recived status code %d with the cookie, expected %d", rec.Code, http.StatusOK)
+	}
+	if rec := serve("GET", "/users/", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("recived status code %d without the cookie, expected %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	//Updates are decoded strictly
+	if rec := serve("PUT", "/users/", `{"email":"one@example.com","admin":true}`, cookies[0]); rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), `"field":"admin"`) {
+		t.Errorf("recived status code %d with %s, expected the unknown field to be rejected", rec.Code, rec.Body.String())
+	}
+	if rec := serve("PUT", "/users/", `{"email":"one@example.com"}`, cookies[0]); rec.Code != http.StatusAccepted {
+		t.Errorf("recived status code %d with %s, expected %d", rec.Code, rec.Body.String(), http.StatusAccepted)
+	}
+
+	//Logout tells the browser to remove the cookie
+	rec = serve("POST", "/users/logout", "", cookies[0])
+	if rec.Code != http.StatusOK {
+		t.Errorf("recived status code %d, expected %d", rec.Code, http.StatusOK)
+	}
+	cleared := rec.Result().Cookies()
+	if len(cleared) != 1 || cleared[0].Name != "session" || len(cleared[0].Value) > 0 || cleared[0].MaxAge >= 0 {
+		t.Errorf("recived cookies %v, expected the session cookie to be removed", cleared)
+	}
+}
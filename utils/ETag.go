@@ -0,0 +1,51 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package utils
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+/**
+Get the strong ETag for a version of a resource, i.e. "3"
+*/
+func ETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+/**
+Set the ETag header for the version.  It must be called before the status is written
+*/
+func SetETag(w http.ResponseWriter, version int) {
+	w.Header().Set("ETag", ETag(version))
+}
+
+/**
+Check if the request wants the version of the resource.  A missing If-Match header or * matches any version.  Weak
+tags never match since If-Match uses the strong comparison.
+*/
+func IfMatch(r *http.Request, version int) bool {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if len(header) == 0 || header == "*" {
+		return true
+	}
+
+	//Check each of the listed tags
+	etag := ETag(version)
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimSpace(tag) == etag {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+Check to see if the client sent an If-Match header
+*/
+func HasIfMatch(r *http.Request) bool {
+	return len(strings.TrimSpace(r.Header.Get("If-Match"))) > 0
+}
@@ -0,0 +1,52 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package utils_test
+
+import (
+	"github.com/reaction-eng/restlib/utils"
+	"net/http/httptest"
+	"testing"
+)
+
+/**
+Perform the testing
+*/
+func TestIfMatch(t *testing.T) {
+
+	//Define the list of headers we are testing against version 3
+	var headers = []struct {
+		header   string
+		expected bool
+	}{
+		{"", true},
+		{"*", true},
+		{`"3"`, true},
+		{`"2"`, false},
+		{`"1", "3"`, true},
+		{`W/"3"`, false},
+		{`3`, false},
+	}
+
+	//March over each header
+	for _, test := range headers {
+		req := httptest.NewRequest("PUT", "/", nil)
+		if len(test.header) > 0 {
+			req.Header.Set("If-Match", test.header)
+		}
+
+		if matched := utils.IfMatch(req, 3); matched != test.expected {
+			t.Errorf("recived %t for %s, expected %t", matched, test.header, test.expected)
+		}
+		if utils.HasIfMatch(req) != (len(test.header) > 0) {
+			t.Errorf("recived the wrong HasIfMatch for %s", test.header)
+		}
+	}
+
+	//The header is quoted
+	rec := httptest.NewRecorder()
+	utils.SetETag(rec, 3)
+	if etag := rec.Header().Get("ETag"); etag != `"3"` {
+		t.Errorf("recived %s, expected %s", etag, `"3"`)
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package utils
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/reaction-eng/restlib/apierror"
+)
+
+//Prefix used to build the problem type from the error code
+const problemTypePrefix = "urn:restlib:problem:"
+
+/**
+Define an RFC 7807 problem details response
+*/
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+
+	//Extension member so clients can branch on the code
+	Code string `json:"code"`
+
+	//Extension member listing each invalid field
+	Errors []apierror.FieldError `json:"errors,omitempty"`
+}
+
+/**
+Build the problem for any error.  Untyped errors are reported as internal errors without leaking the message.
+*/
+func NewProblem(err error) Problem {
+	//Get the typed version
+	apiErr := apierror.From(err)
+
+	//Log anything unexpected since the detail is not returned
+	if apiErr.Status >= http.StatusInternalServerError {
+		log.Println("Internal error: ", err)
+	}
+
+	//Client errors can show what went wrong
+	detail := apiErr.Detail
+	if len(detail) == 0 && apiErr.Status < http.StatusInternalServerError && apiErr.Unwrap() != nil {
+		detail = apiErr.Unwrap().Error()
+	}
+
+	return Problem{
+		Type:   problemTypePrefix + apiErr.Code,
+		Title:  apiErr.Title(),
+		Status: apiErr.Status,
+		Detail: detail,
+		Code:   apiErr.Code,
+		Errors: apiErr.Fields,
+	}
+}
+
+/**
+Provide a support method to return an error as application/problem+json
+*/
+func ReturnError(w http.ResponseWriter, err error) {
+	//Build the problem
+	problem := NewProblem(err)
+
+	//Set the problem type
+	w.Header().Set("Content-Type", "application/problem+json; charset=UTF-8")
+
+	//Pass in the code
+	w.WriteHeader(problem.Status)
+
+	//Now encode the json object
+	if err := json.NewEncoder(w).Encode(problem); err != nil {
+		panic(err)
+	}
+}
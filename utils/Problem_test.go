@@ -0,0 +1,75 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package utils_test
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/reaction-eng/restlib/apierror"
+	"github.com/reaction-eng/restlib/utils"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+/**
+Perform the testing
+*/
+func TestReturnError(t *testing.T) {
+	fields := []apierror.FieldError{{Field: "settings.zoom", Code: "max", Message: "must be at most 10"}}
+
+	//Define the list of errors and the body we expect
+	var tests = []struct {
+		err      error
+		expected string
+	}{
+		{
+			apierror.ErrNotFound,
+			`{"type":"urn:restlib:problem:not_found","title":"Not Found","status":404,"code":"not_found"}`,
+		},
+		{
+			apierror.ErrMalformedRequest.Wrap(errors.New("unexpected EOF")),
+			`{"type":"urn:restlib:problem:malformed_request","title":"Bad Request","status":400,"detail":"unexpected EOF","code":"malformed_request"}`,
+		},
+		{
+			apierror.ErrValidation.WithDetail("check the fields").WithFields(fields),
+			`{"type":"urn:restlib:problem:validation_failed","title":"Unprocessable Entity","status":422,"detail":"check the fields","code":"validation_failed","errors":[{"field":"settings.zoom","code":"max","message":"must be at most 10"}]}`,
+		},
+		{
+			//Internal errors should not leak the message
+			errors.New("password=secret"),
+			`{"type":"urn:restlib:problem:internal_error","title":"Internal Server Error","status":500,"code":"internal_error"}`,
+		},
+	}
+
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		utils.ReturnError(rec, test.err)
+
+		if contentType := rec.Header().Get("Content-Type"); contentType != "application/problem+json; charset=UTF-8" {
+			t.Errorf("recived %s, expected application/problem+json", contentType)
+		}
+		if rec.Code != apierror.StatusOf(test.err) {
+			t.Errorf("recived %d, expected %d", rec.Code, apierror.StatusOf(test.err))
+		}
+
+		//Compare the decoded json so the order does not matter
+		var recived, expected map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &recived); err != nil {
+			t.Fatal(err)
+		}
+		json.Unmarshal([]byte(test.expected), &expected)
+		recivedJson, _ := json.Marshal(recived)
+		expectedJson, _ := json.Marshal(expected)
+		if string(recivedJson) != string(expectedJson) {
+			t.Errorf("recived %s, expected %s", recivedJson, expectedJson)
+		}
+	}
+
+	//The status in the body always matches the response
+	problem := utils.NewProblem(apierror.ErrTooManyRequests)
+	if problem.Status != http.StatusTooManyRequests || problem.Code != "rate_limited" {
+		t.Errorf("recived %+v, expected a rate limited problem", problem)
+	}
+}
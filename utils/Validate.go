@@ -0,0 +1,367 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/reaction-eng/restlib/apierror"
+)
+
+//The largest body accepted by DecodeAndValidate
+const DefaultMaxBodyBytes int64 = 1 << 20
+
+//Returned when reading past the limit of a LimitBody reader
+var ErrBodyTooLarge = errors.New("request body too large")
+
+/**
+Limit the body to maxBytes.  Reading past the limit returns ErrBodyTooLarge
+*/
+func LimitBody(body io.Reader, maxBytes int64) io.Reader {
+	return &limitedBody{
+		body:      body,
+		remaining: maxBytes,
+	}
+}
+
+/**
+Reader that stops at the limit
+*/
+type limitedBody struct {
+	body      io.Reader
+	remaining int64
+}
+
+func (limited *limitedBody) Read(p []byte) (int, error) {
+	if limited.remaining < 0 {
+		return 0, ErrBodyTooLarge
+	}
+
+	//Read one byte past the limit to find out if there is more
+	if int64(len(p)) > limited.remaining+1 {
+		p = p[:limited.remaining+1]
+	}
+	n, err := limited.body.Read(p)
+	if int64(n) > limited.remaining {
+		n = int(limited.remaining)
+		limited.remaining = -1
+		return n, ErrBodyTooLarge
+	}
+	limited.remaining -= int64(n)
+	return n, err
+}
+
+/**
+Decode the json body into the dst struct and validate it against the validate struct tags.  The body size is limited
+to DefaultMaxBodyBytes and unknown fields are rejected.
+*/
+func DecodeAndValidate(r *http.Request, dst interface{}) error {
+	return DecodeAndValidateLimit(r, dst, DefaultMaxBodyBytes)
+}
+
+/**
+Decode the json body into the dst struct and validate it.  The body can be at most maxBytes long.
+*/
+func DecodeAndValidateLimit(r *http.Request, dst interface{}, maxBytes int64) error {
+	//Limit how much we read
+	body := LimitBody(r.Body, maxBytes)
+
+	//Be strict about what we accept
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+
+	//Decode the single object
+	if err := decoder.Decode(dst); err != nil {
+		return decodeError(err)
+	}
+
+	//Make sure there is nothing after it
+	if decoder.More() {
+		return apierror.ErrMalformedRequest.WithDetail("request body must contain a single json object")
+	}
+
+	//Now check the tags
+	return Validate(dst)
+}
+
+/**
+Convert the json decode errors into typed errors
+*/
+func decodeError(err error) error {
+	//Check for the body being too big
+	if errors.Is(err, ErrBodyTooLarge) {
+		return apierror.ErrRequestTooLarge.Wrap(err)
+	}
+
+	//Check for a wrong type
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return apierror.ErrValidation.WithFields([]apierror.FieldError{{
+			Field:   typeErr.Field,
+			Code:    "type",
+			Message: "must be of type " + typeErr.Type.String(),
+		}})
+	}
+
+	//The decoder does not have a typed error for unknown fields
+	if strings.HasPrefix(err.Error(), "json: unknown field ") {
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), "\"")
+		return apierror.ErrValidation.WithFields([]apierror.FieldError{{
+			Field:   field,
+			Code:    "unknown",
+			Message: "is not an allowed field",
+		}})
+	}
+
+	//An empty body
+	if err == io.EOF {
+		return apierror.ErrMalformedRequest.WithDetail("request body must not be empty")
+	}
+
+	return apierror.ErrMalformedRequest.Wrap(err)
+}
+
+/**
+Validate the struct using the validate tags.  The supported rules are required, email, min=, max= and enum=a|b|c.
+Min and max limit the length of strings, slices and maps and the value of numbers.  Nested structs and the structs
+inside of slices, arrays and maps are checked, i.e. items.0.name.  The rules only apply to the tagged field itself, not
+to each of its elements.  An unknown rule is returned as an internal error.
+*/
+func Validate(obj interface{}) error {
+	//Build the list of errors
+	fields := make([]apierror.FieldError, 0)
+
+	//Check the object
+	if err := validateValue(reflect.ValueOf(obj), "", &fields); err != nil {
+		return apierror.ErrInternal.Wrap(err)
+	}
+
+	//If there are any errors return them
+	if len(fields) > 0 {
+		return apierror.ErrValidation.WithFields(fields)
+	}
+	return nil
+}
+
+/**
+Recursively march over the struct fields and the elements of any slices, arrays and maps
+*/
+func validateValue(value reflect.Value, path string, fields *[]apierror.FieldError) error {
+	//Get the real value
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return nil
+		}
+		value = value.Elem()
+	}
+
+	switch value.Kind() {
+	case reflect.Struct:
+		return validateStruct(value, path, fields)
+	case reflect.Slice, reflect.Array:
+		//Only march over the elements if they could have tags
+		if !mayHaveRules(value.Type().Elem()) {
+			return nil
+		}
+		for i := 0; i < value.Len(); i++ {
+			if err := validateValue(value.Index(i), joinFieldPath(path, strconv.Itoa(i)), fields); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if !mayHaveRules(value.Type().Elem()) {
+			return nil
+		}
+		iter := value.MapRange()
+		for iter.Next() {
+			if err := validateValue(iter.Value(), joinFieldPath(path, fmt.Sprint(iter.Key().Interface())), fields); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+/**
+Check each of the tagged fields in the struct and then its children
+*/
+func validateStruct(value reflect.Value, path string, fields *[]apierror.FieldError) error {
+	//March over each field
+	valueType := value.Type()
+	for i := 0; i < valueType.NumField(); i++ {
+		field := valueType.Field(i)
+
+		//Skip private fields
+		if field.PkgPath != "" {
+			continue
+		}
+
+		//Get the name used in the json
+		fieldPath := joinFieldPath(path, jsonFieldName(field))
+
+		//Check each rule
+		if tag, found := field.Tag.Lookup("validate"); found {
+			for _, rule := range strings.Split(tag, ",") {
+				fieldErr, err := checkRule(value.Field(i), strings.TrimSpace(rule))
+				if err != nil {
+					return fmt.Errorf("field %s of %s: %w", field.Name, valueType, err)
+				}
+				if fieldErr != nil {
+					fieldErr.Field = fieldPath
+					*fields = append(*fields, *fieldErr)
+
+					//Only report the first failed rule per field
+					break
+				}
+			}
+		}
+
+		//Check any children
+		if err := validateValue(value.Field(i), fieldPath, fields); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/**
+Check to see if values of the type could hold a struct with tags
+*/
+func mayHaveRules(valueType reflect.Type) bool {
+	for valueType.Kind() == reflect.Ptr {
+		valueType = valueType.Elem()
+	}
+
+	switch valueType.Kind() {
+	case reflect.Struct, reflect.Interface:
+		return true
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return mayHaveRules(valueType.Elem())
+	}
+	return false
+}
+
+/**
+Check a single rule against a value, return nil if it passes.  An error is returned if the rule itself is invalid.
+*/
+func checkRule(value reflect.Value, rule string) (*apierror.FieldError, error) {
+	//Split the rule from the arg
+	name := rule
+	arg := ""
+	if loc := strings.Index(rule, "="); loc >= 0 {
+		name = rule[:loc]
+		arg = rule[loc+1:]
+	}
+
+	switch name {
+	case "":
+		return nil, nil
+	case "required":
+		if value.IsZero() {
+			return &apierror.FieldError{Code: "required", Message: "is required"}, nil
+		}
+	case "email":
+		//Empty values are handled by required
+		if value.Kind() == reflect.String && value.Len() > 0 {
+			address, err := mail.ParseAddress(value.String())
+			if err != nil || address.Address != value.String() {
+				return &apierror.FieldError{Code: "email", Message: "must be a valid email address"}, nil
+			}
+		}
+	case "min", "max":
+		//Get the limit
+		limit, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid validate rule %s", rule)
+		}
+
+		//Get the size of the value, empty strings are handled by required
+		size, isLength, ok := valueSize(value)
+		if !ok || (value.Kind() == reflect.String && value.Len() == 0) {
+			return nil, nil
+		}
+
+		//Build the message
+		unit := ""
+		if isLength {
+			unit = " characters"
+			if value.Kind() != reflect.String {
+				unit = " items"
+			}
+		}
+
+		if name == "min" && size < limit {
+			return &apierror.FieldError{Code: "min", Message: fmt.Sprintf("must be at least %s%s", arg, unit)}, nil
+		}
+		if name == "max" && size > limit {
+			return &apierror.FieldError{Code: "max", Message: fmt.Sprintf("must be at most %s%s", arg, unit)}, nil
+		}
+	case "enum":
+		//Only check strings
+		if value.Kind() == reflect.String && value.Len() > 0 {
+			allowed := strings.Split(arg, "|")
+			for _, option := range allowed {
+				if value.String() == option {
+					return nil, nil
+				}
+			}
+			return &apierror.FieldError{Code: "enum", Message: "must be one of " + strings.Join(allowed, ", ")}, nil
+		}
+	default:
+		return nil, fmt.Errorf("unknown validate rule %s", rule)
+	}
+
+	return nil, nil
+}
+
+/**
+Get the size of the value for min and max checks
+*/
+func valueSize(value reflect.Value) (size float64, isLength bool, ok bool) {
+	switch value.Kind() {
+	case reflect.String:
+		return float64(len([]rune(value.String()))), true, true
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return float64(value.Len()), true, true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int()), false, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint()), false, true
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), false, true
+	}
+	return 0, false, false
+}
+
+/**
+Get the name of the field as it appears in the json
+*/
+func jsonFieldName(field reflect.StructField) string {
+	if tag := field.Tag.Get("json"); len(tag) > 0 {
+		if name := strings.Split(tag, ",")[0]; len(name) > 0 && name != "-" {
+			return name
+		}
+	}
+	return field.Name
+}
+
+/**
+Build the dotted path to the field
+*/
+func joinFieldPath(path string, name string) string {
+	if len(path) == 0 {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,149 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package utils_test
+
+import (
+	"github.com/reaction-eng/restlib/apierror"
+	"github.com/reaction-eng/restlib/utils"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+/**
+Struct used to check each of the rules
+*/
+type validateTestStruct struct {
+	Email  string `json:"email" validate:"required,email"`
+	Name   string `json:"name" validate:"min=2,max=5"`
+	Role   string `json:"role" validate:"enum=admin|user"`
+	Nested struct {
+		Count int `json:"count" validate:"max=3"`
+	} `json:"nested"`
+	Items []struct {
+		Name string `json:"name" validate:"required"`
+	} `json:"items" validate:"max=2"`
+	Lookup map[string]*struct {
+		Count int `json:"count" validate:"max=3"`
+	} `json:"lookup"`
+}
+
+/**
+Perform the testing
+*/
+func TestDecodeAndValidate(t *testing.T) {
+
+	//Define the list of bodies we are testing
+	var bodies = []struct {
+		name           string
+		body           string
+		expectedCode   string
+		expectedFields []string
+	}{
+		{"valid", `{"email":"a@b.com","name":"abc","role":"user","nested":{"count":1}}`, "", nil},
+		{"empty", ``, "malformed_request", nil},
+		{"malformed", `{"email":`, "malformed_request", nil},
+		{"trailing", `{"email":"a@b.com"}{}`, "malformed_request", nil},
+		{"missing", `{}`, "validation_failed", []string{"email"}},
+		{"bad email", `{"email":"not an email"}`, "validation_failed", []string{"email"}},
+		{"length", `{"email":"a@b.com","name":"a"}`, "validation_failed", []string{"name"}},
+		{"enum", `{"email":"a@b.com","role":"root"}`, "validation_failed", []string{"role"}},
+		{"nested", `{"email":"a@b.com","nested":{"count":4}}`, "validation_failed", []string{"nested.count"}},
+		{"slice", `{"email":"a@b.com","items":[{"name":"a"},{}]}`, "validation_failed", []string{"items.1.name"}},
+		{"slice length", `{"email":"a@b.com","items":[{"name":"a"},{"name":"b"},{"name":"c"}]}`, "validation_failed", []string{"items"}},
+		{"map", `{"email":"a@b.com","lookup":{"a":{"count":4},"b":null}}`, "validation_failed", []string{"lookup.a.count"}},
+		{"unknown", `{"email":"a@b.com","extra":true}`, "validation_failed", []string{"extra"}},
+		{"type", `{"email":"a@b.com","name":3}`, "validation_failed", []string{"name"}},
+		{"too large", `{"email":"` + strings.Repeat("a", int(utils.DefaultMaxBodyBytes)) + `"}`, "request_too_large", nil},
+	}
+
+	for _, tt := range bodies {
+		t.Run(tt.name, func(t *testing.T) {
+			//Build the request
+			req, err := http.NewRequest("POST", "/", strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			//Decode it
+			err = utils.DecodeAndValidate(req, &validateTestStruct{})
+
+			//Check the result
+			if len(tt.expectedCode) == 0 {
+				if err != nil {
+					t.Errorf("unexpected error %v", err)
+				}
+				return
+			}
+			if !apierror.HasCode(err, tt.expectedCode) {
+				t.Fatalf("recived error %v, expected code %s", err, tt.expectedCode)
+			}
+
+			//Check the fields
+			fields := apierror.From(err).Fields
+			if len(fields) != len(tt.expectedFields) {
+				t.Fatalf("recived fields %v, expected %v", fields, tt.expectedFields)
+			}
+			for i, field := range fields {
+				if field.Field != tt.expectedFields[i] {
+					t.Errorf("recived field %s, expected %s", field.Field, tt.expectedFields[i])
+				}
+			}
+		})
+	}
+}
+
+/**
+Perform the testing
+*/
+func TestValidateBadRule(t *testing.T) {
+	//Define the list of structs with rules that can't be checked
+	var objs = []interface{}{
+		&struct {
+			Name string `validate:"required,uuid"`
+		}{Name: "abc"},
+		&struct {
+			Name string `validate:"min=two"`
+		}{Name: "abc"},
+		&struct {
+			Items []struct {
+				Name string `validate:"unique"`
+			}
+		}{Items: []struct {
+			Name string `validate:"unique"`
+		}{{Name: "abc"}}},
+	}
+
+	//The bad rule is a server error, not a panic or a client error
+	for _, obj := range objs {
+		if err := utils.Validate(obj); apierror.StatusOf(err) != http.StatusInternalServerError {
+			t.Errorf("recived %v for %+v, expected an internal error", err, obj)
+		}
+	}
+}
+
+/**
+Perform the testing
+*/
+func TestLimitBody(t *testing.T) {
+
+	//Define the list of bodies we are testing against a limit of 4
+	var bodies = []struct {
+		body        string
+		expected    string
+		expectedErr error
+	}{
+		{"abc", "abc", nil},
+		{"abcd", "abcd", nil},
+		{"abcde", "abcd", utils.ErrBodyTooLarge},
+	}
+
+	for _, tt := range bodies {
+		read, err := ioutil.ReadAll(utils.LimitBody(strings.NewReader(tt.body), 4))
+		if string(read) != tt.expected || err != tt.expectedErr {
+			t.Errorf("recived %s %v, expected %s %v", read, err, tt.expected, tt.expectedErr)
+		}
+	}
+}
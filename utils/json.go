@@ -8,6 +8,14 @@ import (
 	"net/http"
 )
 
+/**
+The body returned by ReturnJsonStatus
+*/
+type JsonStatus struct {
+	Message string `json:"message"`
+	Status  bool   `json:"status"`
+}
+
 /**
 Provide a support method to return json
 */
@@ -24,7 +32,7 @@ Provide a support method to return json
 func ReturnJsonStatus(w http.ResponseWriter, statusCode int, status bool, message string) {
 
 	//Now just pass it
-	ReturnJson(w, statusCode, map[string]interface{}{"status": status, "message": message})
+	ReturnJson(w, statusCode, JsonStatus{Message: message, Status: status})
 
 }
 
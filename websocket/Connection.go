@@ -0,0 +1,157 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package websocket
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	gorillaws "github.com/gorilla/websocket"
+)
+
+const (
+	//How long a write can take
+	writeWait = 10 * time.Second
+
+	//How long to wait for the pong after a ping
+	pongWait = 60 * time.Second
+
+	//How often to ping, must be less than the pongWait
+	pingPeriod = pongWait * 9 / 10
+
+	//The largest message the client can send
+	maxMessageSize = 64 * 1024
+
+	//How many messages can be waiting to be sent before the client is dropped
+	sendBufferSize = 64
+)
+
+/**
+A single connected client
+*/
+type Connection struct {
+	//Keep the hub this belongs to
+	hub *Hub
+
+	//The underlying socket
+	conn *gorillaws.Conn
+
+	//The logged in user
+	userId int
+
+	//The messages waiting to be sent
+	send chan []byte
+
+	//The topics this connection is subscribed to, protected by the hub lock
+	topics map[string]bool
+
+	//Only close once
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+/**
+Get the user that opened this connection
+*/
+func (connection *Connection) UserId() int {
+	return connection.userId
+}
+
+/**
+Queue a message for this connection.  If the client can't keep up it is dropped
+*/
+func (connection *Connection) Send(message Message) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	connection.sendBytes(data)
+	return nil
+}
+
+/**
+Queue the raw message
+*/
+func (connection *Connection) sendBytes(data []byte) {
+	select {
+	case <-connection.done:
+	case connection.send <- data:
+	default:
+		//The client is too slow
+		connection.close(gorillaws.CloseTryAgainLater)
+	}
+}
+
+/**
+Close the connection with the code, it is removed from the hub.  Use CloseNoStatusReceived to close without sending
+a close frame, i.e. when the socket is already broken
+*/
+func (connection *Connection) close(code int) {
+	connection.closeOnce.Do(func() {
+		connection.hub.remove(connection)
+		close(connection.done)
+
+		//Let the client know why
+		if code != gorillaws.CloseNoStatusReceived {
+			connection.conn.WriteControl(gorillaws.CloseMessage, gorillaws.FormatCloseMessage(code, ""), time.Now().Add(writeWait))
+		}
+		connection.conn.Close()
+	})
+}
+
+/**
+Read each message from the client until it goes away
+*/
+func (connection *Connection) readPump() {
+	defer connection.close(gorillaws.CloseNormalClosure)
+
+	//Keep the connection alive as long as the client answers the pings
+	connection.conn.SetReadLimit(maxMessageSize)
+	connection.conn.SetReadDeadline(time.Now().Add(pongWait))
+	connection.conn.SetPongHandler(func(string) error {
+		return connection.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		message := Message{}
+		if err := connection.conn.ReadJSON(&message); err != nil {
+			//Ignore messages we can't read, stop on anything else
+			switch err.(type) {
+			case *json.SyntaxError, *json.UnmarshalTypeError:
+				continue
+			}
+			return
+		}
+
+		connection.hub.handleMessage(connection, message)
+	}
+}
+
+/**
+Write each queued message and ping the client
+*/
+func (connection *Connection) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-connection.done:
+			return
+		case data := <-connection.send:
+			connection.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := connection.conn.WriteMessage(gorillaws.TextMessage, data); err != nil {
+				connection.close(gorillaws.CloseNoStatusReceived)
+				return
+			}
+		case <-ticker.C:
+			if err := connection.conn.WriteControl(gorillaws.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				connection.close(gorillaws.CloseNoStatusReceived)
+				return
+			}
+		}
+	}
+}
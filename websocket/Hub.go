@@ -0,0 +1,357 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	gorillaws "github.com/gorilla/websocket"
+	"github.com/reaction-eng/restlib/apierror"
+	"github.com/reaction-eng/restlib/auth"
+	"github.com/reaction-eng/restlib/notification"
+	"github.com/reaction-eng/restlib/routing"
+	"github.com/reaction-eng/restlib/users"
+	"github.com/reaction-eng/restlib/utils"
+)
+
+//Returned when the hub has been closed
+var ErrHubClosed = errors.New("the websocket hub is closed")
+
+/**
+Track each of the connections by user and topic
+*/
+type Hub struct {
+	//Upgrade the http requests
+	upgrader gorillaws.Upgrader
+
+	//The path the clients connect to
+	pattern string
+
+	//Keep track of the connections
+	lock   sync.RWMutex
+	users  map[int]map[*Connection]bool
+	topics map[string]map[*Connection]bool
+	closed bool
+
+	//Optional handler for any message that is not a subscription
+	OnMessage func(connection *Connection, message Message)
+
+	//Decide if the connection can subscribe to the topic.  If nil users can only subscribe to their own topics, see
+	//OwnsTopic
+	CanSubscribe func(connection *Connection, topic string) bool
+}
+
+/**
+Get the topic owned by the user, i.e. user.12.  Any topic under it, i.e. user.12.alerts, is also owned by the user
+*/
+func UserTopic(userId int) string {
+	return "user." + strconv.Itoa(userId)
+}
+
+/**
+Check to see if the topic belongs to the user on the connection.  This is the default for Hub.CanSubscribe
+*/
+func OwnsTopic(connection *Connection, topic string) bool {
+	userTopic := UserTopic(connection.UserId())
+	return topic == userTopic || strings.HasPrefix(topic, userTopic+".")
+}
+
+/**
+Build a new hub that accepts connections at the pattern, i.e. /ws.  If checkOrigin is nil only same origin
+connections are allowed
+*/
+func NewHub(pattern string, checkOrigin func(r *http.Request) bool) *Hub {
+	return &Hub{
+		upgrader: gorillaws.Upgrader{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 1024,
+			CheckOrigin:     checkOrigin,
+		},
+		pattern: pattern,
+		users:   make(map[int]map[*Connection]bool),
+		topics:  make(map[string]map[*Connection]bool),
+	}
+}
+
+/**
+Function used to get routes
+*/
+func (hub *Hub) GetRoutes() []routing.Route {
+	return []routing.Route{
+		{
+			Name:        "WebSocket Connect",
+			Method:      "GET",
+			Pattern:     hub.pattern,
+			HandlerFunc: hub.handleConnect,
+			Description: "Upgrade to a websocket for real time messages.  Send {\"type\": \"subscribe\", \"topic\": \"name\"} to join a topic, topics that are not allowed get a subscribe_denied message.",
+			Tags:        []string{"realtime"},
+		},
+	}
+}
+
+/**
+Upgrade the request and start serving the connection
+*/
+func (hub *Hub) handleConnect(w http.ResponseWriter, r *http.Request) {
+	//We have gone through the auth, so we should know the id of the logged in user
+	loggedInUser, ok := auth.UserId(r.Context())
+	if !ok {
+		utils.ReturnError(w, apierror.ErrUnauthorized)
+		return
+	}
+
+	//Upgrade the request, the error is already returned to the client
+	conn, err := hub.upgrader.Upgrade(w, r, responseProtocol(r))
+	if err != nil {
+		return
+	}
+
+	connection := &Connection{
+		hub:    hub,
+		conn:   conn,
+		userId: loggedInUser,
+		send:   make(chan []byte, sendBufferSize),
+		topics: make(map[string]bool),
+		done:   make(chan struct{}),
+	}
+
+	//Add it to the hub
+	if err := hub.add(connection); err != nil {
+		connection.close(gorillaws.CloseGoingAway)
+		return
+	}
+
+	go connection.writePump()
+	go connection.readPump()
+}
+
+/**
+Browsers fail the connection if none of the requested protocols are returned.  The token is passed as the first
+protocol, so return the next one if there is one.
+*/
+func responseProtocol(r *http.Request) http.Header {
+	protocols := gorillaws.Subprotocols(r)
+	if len(protocols) == 0 {
+		return nil
+	}
+
+	protocol := protocols[0]
+	for _, requested := range protocols {
+		if !strings.Contains(requested, "_Space_") {
+			protocol = requested
+			break
+		}
+	}
+
+	return http.Header{"Sec-Websocket-Protocol": {protocol}}
+}
+
+/**
+Add the connection to the hub
+*/
+func (hub *Hub) add(connection *Connection) error {
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+
+	if hub.closed {
+		return ErrHubClosed
+	}
+
+	if hub.users[connection.userId] == nil {
+		hub.users[connection.userId] = make(map[*Connection]bool)
+	}
+	hub.users[connection.userId][connection] = true
+
+	return nil
+}
+
+/**
+Remove the connection and all of its subscriptions
+*/
+func (hub *Hub) remove(connection *Connection) {
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+
+	delete(hub.users[connection.userId], connection)
+	if len(hub.users[connection.userId]) == 0 {
+		delete(hub.users, connection.userId)
+	}
+
+	for topic := range connection.topics {
+		delete(hub.topics[topic], connection)
+		if len(hub.topics[topic]) == 0 {
+			delete(hub.topics, topic)
+		}
+	}
+}
+
+/**
+Handle a message from the client
+*/
+func (hub *Hub) handleMessage(connection *Connection, message Message) {
+	switch message.Type {
+	case TypeSubscribe:
+		if len(message.Topic) == 0 {
+			return
+		}
+
+		//Make sure the user can see the topic
+		canSubscribe := hub.CanSubscribe
+		if canSubscribe == nil {
+			canSubscribe = OwnsTopic
+		}
+		if !canSubscribe(connection, message.Topic) {
+			connection.Send(Message{Type: TypeSubscribeDenied, Topic: message.Topic})
+			return
+		}
+
+		hub.subscribe(connection, message.Topic)
+	case TypeUnsubscribe:
+		hub.unsubscribe(connection, message.Topic)
+	default:
+		if hub.OnMessage != nil {
+			hub.OnMessage(connection, message)
+		}
+	}
+}
+
+/**
+Start sending messages for the topic to the connection.  Nothing is done if the connection was already removed
+*/
+func (hub *Hub) subscribe(connection *Connection, topic string) {
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+
+	//The connection may have been closed while the message was being handled
+	if !hub.users[connection.userId][connection] {
+		return
+	}
+
+	if hub.topics[topic] == nil {
+		hub.topics[topic] = make(map[*Connection]bool)
+	}
+	hub.topics[topic][connection] = true
+	connection.topics[topic] = true
+}
+
+/**
+Stop sending messages for the topic to the connection
+*/
+func (hub *Hub) unsubscribe(connection *Connection, topic string) {
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+
+	delete(hub.topics[topic], connection)
+	if len(hub.topics[topic]) == 0 {
+		delete(hub.topics, topic)
+	}
+	delete(connection.topics, topic)
+}
+
+/**
+Send the message to every connection
+*/
+func (hub *Hub) Broadcast(message Message) error {
+	hub.lock.RLock()
+	connections := make([]*Connection, 0)
+	for _, userConnections := range hub.users {
+		for connection := range userConnections {
+			connections = append(connections, connection)
+		}
+	}
+	hub.lock.RUnlock()
+
+	return sendAll(connections, message)
+}
+
+/**
+Send the message to every connection subscribed to the topic
+*/
+func (hub *Hub) Publish(topic string, message Message) error {
+	message.Topic = topic
+
+	hub.lock.RLock()
+	connections := make([]*Connection, 0, len(hub.topics[topic]))
+	for connection := range hub.topics[topic] {
+		connections = append(connections, connection)
+	}
+	hub.lock.RUnlock()
+
+	return sendAll(connections, message)
+}
+
+/**
+Send the message to every connection the user has open
+*/
+func (hub *Hub) SendToUser(userId int, message Message) error {
+	hub.lock.RLock()
+	connections := make([]*Connection, 0, len(hub.users[userId]))
+	for connection := range hub.users[userId] {
+		connections = append(connections, connection)
+	}
+	hub.lock.RUnlock()
+
+	return sendAll(connections, message)
+}
+
+/**
+Check to see if the user has any open connections
+*/
+func (hub *Hub) Connected(userId int) bool {
+	hub.lock.RLock()
+	defer hub.lock.RUnlock()
+
+	return len(hub.users[userId]) > 0
+}
+
+/**
+Send the notification to the user so the hub can be used as a notification.Notifier
+*/
+func (hub *Hub) Notify(notification notification.Notification, user users.User) error {
+	return hub.SendToUser(user.Id(), Message{
+		Type: TypeNotification,
+		Data: notification,
+	})
+}
+
+/**
+Close every connection and stop accepting new ones
+*/
+func (hub *Hub) Close() {
+	hub.lock.Lock()
+	hub.closed = true
+	connections := make([]*Connection, 0)
+	for _, userConnections := range hub.users {
+		for connection := range userConnections {
+			connections = append(connections, connection)
+		}
+	}
+	hub.lock.Unlock()
+
+	for _, connection := range connections {
+		connection.close(gorillaws.CloseGoingAway)
+	}
+}
+
+/**
+Marshal the message once and queue it on each connection
+*/
+func sendAll(connections []*Connection, message Message) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("could not marshal websocket message: %v", err)
+		return err
+	}
+
+	for _, connection := range connections {
+		connection.sendBytes(data)
+	}
+	return nil
+}
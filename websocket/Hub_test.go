@@ -0,0 +1,168 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package websocket_test
+
+import (
+	gorillaws "github.com/gorilla/websocket"
+	"github.com/reaction-eng/restlib/auth"
+	"github.com/reaction-eng/restlib/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+/**
+Perform the testing
+*/
+func TestHub(t *testing.T) {
+	hub := websocket.NewHub("/ws", nil)
+	defer hub.Close()
+
+	//Anyone can get the news
+	hub.CanSubscribe = func(connection *websocket.Connection, topic string) bool {
+		return topic == "news" || websocket.OwnsTopic(connection, topic)
+	}
+
+	//Fake the jwt middleware by taking the user from the query
+	route := hub.GetRoutes()[0]
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userId, _ := strconv.Atoi(r.URL.Query().Get("user"))
+		route.HandlerFunc(w, r.WithContext(auth.WithIdentity(r.Context(), &auth.Identity{UserId: userId})))
+	}))
+	defer server.Close()
+
+	//Connect two users
+	dial := func(userId int) *gorillaws.Conn {
+		url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?user=" + strconv.Itoa(userId)
+		conn, _, err := gorillaws.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+	one := dial(1)
+	defer one.Close()
+	two := dial(2)
+	defer two.Close()
+
+	//Read the next message
+	read := func(conn *gorillaws.Conn) websocket.Message {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		message := websocket.Message{}
+		if err := conn.ReadJSON(&message); err != nil {
+			t.Fatal(err)
+		}
+		return message
+	}
+
+	//Subscribe user one to a topic and wait for it to register
+	if err := one.WriteJSON(websocket.Message{Type: websocket.TypeSubscribe, Topic: "news"}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	//Check the topic
+	hub.Publish("news", websocket.Message{Type: "update", Data: "hello"})
+	if message := read(one); message.Topic != "news" || message.Data != "hello" {
+		t.Errorf("recived %v, expected the news update", message)
+	}
+
+	//Check sending to a single user
+	hub.SendToUser(2, websocket.Message{Type: "direct"})
+	if message := read(two); message.Type != "direct" {
+		t.Errorf("recived %v, expected the direct message", message)
+	}
+
+	//Check the broadcast
+	hub.Broadcast(websocket.Message{Type: "all"})
+	if message := read(one); message.Type != "all" {
+		t.Errorf("recived %v, expected the broadcast", message)
+	}
+	if message := read(two); message.Type != "all" {
+		t.Errorf("recived %v, expected the broadcast", message)
+	}
+	if !hub.Connected(1) || hub.Connected(3) {
+		t.Errorf("expected only users one and two to be connected")
+	}
+}
+
+/**
+Perform the testing
+*/
+func TestHubCanSubscribe(t *testing.T) {
+	//Use the default, users only get their own topics
+	hub := websocket.NewHub("/ws", nil)
+	defer hub.Close()
+
+	route := hub.GetRoutes()[0]
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route.HandlerFunc(w, r.WithContext(auth.WithIdentity(r.Context(), &auth.Identity{UserId: 1})))
+	}))
+	defer server.Close()
+
+	conn, _, err := gorillaws.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	//Define the list of topics we are testing
+	var topics = []struct {
+		topic   string
+		allowed bool
+	}{
+		{"user.1", true},
+		{"user.1.alerts", true},
+		{"user.12", false},
+		{"user.2", false},
+		{"news", false},
+	}
+
+	for _, tt := range topics {
+		if err := conn.WriteJSON(websocket.Message{Type: websocket.TypeSubscribe, Topic: tt.topic}); err != nil {
+			t.Fatal(err)
+		}
+
+		//Only the denied ones get an answer
+		if !tt.allowed {
+			conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			message := websocket.Message{}
+			if err := conn.ReadJSON(&message); err != nil {
+				t.Fatal(err)
+			}
+			if message.Type != websocket.TypeSubscribeDenied || message.Topic != tt.topic {
+				t.Errorf("recived %v, expected %s to be denied", message, tt.topic)
+			}
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	//Only the allowed topics should be delivered, so send the denied ones first
+	for _, tt := range topics {
+		if !tt.allowed {
+			hub.Publish(tt.topic, websocket.Message{Type: "update", Data: tt.topic})
+		}
+	}
+	for _, tt := range topics {
+		if tt.allowed {
+			hub.Publish(tt.topic, websocket.Message{Type: "update", Data: tt.topic})
+		}
+	}
+	for _, tt := range topics {
+		if !tt.allowed {
+			continue
+		}
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		message := websocket.Message{}
+		if err := conn.ReadJSON(&message); err != nil {
+			t.Fatal(err)
+		}
+		if message.Topic != tt.topic {
+			t.Errorf("recived %v, expected the update for %s", message, tt.topic)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+// Copyright 2019 Reaction Engineering International. All rights reserved.
+// Use of this source code is governed by the MIT license in the file LICENSE.txt.
+
+package websocket
+
+/**
+Define the message types used by the hub
+*/
+const (
+	//Sent by the client to start or stop getting messages for a topic
+	TypeSubscribe   = "subscribe"
+	TypeUnsubscribe = "unsubscribe"
+
+	//Sent by the server for each notification
+	TypeNotification = "notification"
+
+	//Sent by the server when the connection is not allowed to subscribe to the topic
+	TypeSubscribeDenied = "subscribe_denied"
+)
+
+/**
+Define the json message sent in both directions
+*/
+type Message struct {
+	Type  string      `json:"type"`
+	Topic string      `json:"topic,omitempty"`
+	Data  interface{} `json:"data,omitempty"`
+}